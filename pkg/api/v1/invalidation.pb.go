@@ -0,0 +1,318 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.26.0
+// 	protoc        v3.19.2
+// source: invalidation.proto
+
+package v1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type InvalidationOp int32
+
+const (
+	InvalidationOp_INVALIDATION_OP_SYNC   InvalidationOp = 0
+	InvalidationOp_INVALIDATION_OP_DELETE InvalidationOp = 1
+	InvalidationOp_INVALIDATION_OP_CLEAR  InvalidationOp = 2
+	InvalidationOp_INVALIDATION_OP_TAG    InvalidationOp = 3
+)
+
+// Enum value maps for InvalidationOp.
+var (
+	InvalidationOp_name = map[int32]string{
+		0: "INVALIDATION_OP_SYNC",
+		1: "INVALIDATION_OP_DELETE",
+		2: "INVALIDATION_OP_CLEAR",
+		3: "INVALIDATION_OP_TAG",
+	}
+	InvalidationOp_value = map[string]int32{
+		"INVALIDATION_OP_SYNC":   0,
+		"INVALIDATION_OP_DELETE": 1,
+		"INVALIDATION_OP_CLEAR":  2,
+		"INVALIDATION_OP_TAG":    3,
+	}
+)
+
+func (x InvalidationOp) Enum() *InvalidationOp {
+	p := new(InvalidationOp)
+	*p = x
+	return p
+}
+
+func (x InvalidationOp) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (InvalidationOp) Descriptor() protoreflect.EnumDescriptor {
+	return file_invalidation_proto_enumTypes[0].Descriptor()
+}
+
+func (InvalidationOp) Type() protoreflect.EnumType {
+	return &file_invalidation_proto_enumTypes[0]
+}
+
+func (x InvalidationOp) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use InvalidationOp.Descriptor instead.
+func (InvalidationOp) EnumDescriptor() ([]byte, []int) {
+	return file_invalidation_proto_rawDescGZIP(), []int{0}
+}
+
+type InvalidationSubscribeRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Subscriber string `protobuf:"bytes,1,opt,name=subscriber,proto3" json:"subscriber,omitempty"`
+}
+
+func (x *InvalidationSubscribeRequest) Reset() {
+	*x = InvalidationSubscribeRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_invalidation_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *InvalidationSubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvalidationSubscribeRequest) ProtoMessage() {}
+
+func (x *InvalidationSubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_invalidation_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvalidationSubscribeRequest.ProtoReflect.Descriptor instead.
+func (*InvalidationSubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_invalidation_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *InvalidationSubscribeRequest) GetSubscriber() string {
+	if x != nil {
+		return x.Subscriber
+	}
+	return ""
+}
+
+type InvalidationMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Source string         `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Epoch  int64          `protobuf:"varint,2,opt,name=epoch,proto3" json:"epoch,omitempty"`
+	Seq    uint64         `protobuf:"varint,3,opt,name=seq,proto3" json:"seq,omitempty"`
+	Op     InvalidationOp `protobuf:"varint,4,opt,name=op,proto3,enum=v1.InvalidationOp" json:"op,omitempty"`
+	Key    string         `protobuf:"bytes,5,opt,name=key,proto3" json:"key,omitempty"`
+}
+
+func (x *InvalidationMessage) Reset() {
+	*x = InvalidationMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_invalidation_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *InvalidationMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InvalidationMessage) ProtoMessage() {}
+
+func (x *InvalidationMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_invalidation_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InvalidationMessage.ProtoReflect.Descriptor instead.
+func (*InvalidationMessage) Descriptor() ([]byte, []int) {
+	return file_invalidation_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InvalidationMessage) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *InvalidationMessage) GetEpoch() int64 {
+	if x != nil {
+		return x.Epoch
+	}
+	return 0
+}
+
+func (x *InvalidationMessage) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *InvalidationMessage) GetOp() InvalidationOp {
+	if x != nil {
+		return x.Op
+	}
+	return InvalidationOp_INVALIDATION_OP_SYNC
+}
+
+func (x *InvalidationMessage) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+var File_invalidation_proto protoreflect.FileDescriptor
+
+var file_invalidation_proto_rawDesc = []byte{
+	0x0a, 0x12, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x12, 0x02, 0x76, 0x31, 0x22, 0x3e, 0x0a, 0x1c, 0x49, 0x6e, 0x76, 0x61,
+	0x6c, 0x69, 0x64, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62,
+	0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x73, 0x75, 0x62, 0x73,
+	0x63, 0x72, 0x69, 0x62, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x73, 0x75,
+	0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x72, 0x22, 0x8b, 0x01, 0x0a, 0x13, 0x49, 0x6e, 0x76,
+	0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65,
+	0x12, 0x16, 0x0a, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x65, 0x70, 0x6f, 0x63,
+	0x68, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x65, 0x70, 0x6f, 0x63, 0x68, 0x12, 0x10,
+	0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x73, 0x65, 0x71,
+	0x12, 0x22, 0x0a, 0x02, 0x6f, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x12, 0x2e, 0x76,
+	0x31, 0x2e, 0x49, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4f, 0x70,
+	0x52, 0x02, 0x6f, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x2a, 0x7a, 0x0a, 0x0e, 0x49, 0x6e, 0x76, 0x61, 0x6c, 0x69,
+	0x64, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4f, 0x70, 0x12, 0x18, 0x0a, 0x14, 0x49, 0x4e, 0x56, 0x41,
+	0x4c, 0x49, 0x44, 0x41, 0x54, 0x49, 0x4f, 0x4e, 0x5f, 0x4f, 0x50, 0x5f, 0x53, 0x59, 0x4e, 0x43,
+	0x10, 0x00, 0x12, 0x1a, 0x0a, 0x16, 0x49, 0x4e, 0x56, 0x41, 0x4c, 0x49, 0x44, 0x41, 0x54, 0x49,
+	0x4f, 0x4e, 0x5f, 0x4f, 0x50, 0x5f, 0x44, 0x45, 0x4c, 0x45, 0x54, 0x45, 0x10, 0x01, 0x12, 0x19,
+	0x0a, 0x15, 0x49, 0x4e, 0x56, 0x41, 0x4c, 0x49, 0x44, 0x41, 0x54, 0x49, 0x4f, 0x4e, 0x5f, 0x4f,
+	0x50, 0x5f, 0x43, 0x4c, 0x45, 0x41, 0x52, 0x10, 0x02, 0x12, 0x17, 0x0a, 0x13, 0x49, 0x4e, 0x56,
+	0x41, 0x4c, 0x49, 0x44, 0x41, 0x54, 0x49, 0x4f, 0x4e, 0x5f, 0x4f, 0x50, 0x5f, 0x54, 0x41, 0x47,
+	0x10, 0x03, 0x32, 0x61, 0x0a, 0x13, 0x49, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x4a, 0x0a, 0x09, 0x53, 0x75, 0x62,
+	0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x12, 0x20, 0x2e, 0x76, 0x31, 0x2e, 0x49, 0x6e, 0x76, 0x61,
+	0x6c, 0x69, 0x64, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62,
+	0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x17, 0x2e, 0x76, 0x31, 0x2e, 0x49, 0x6e,
+	0x76, 0x61, 0x6c, 0x69, 0x64, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67,
+	0x65, 0x22, 0x00, 0x30, 0x01, 0x42, 0x25, 0x5a, 0x23, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e,
+	0x63, 0x6f, 0x6d, 0x2f, 0x62, 0x68, 0x6f, 0x6a, 0x70, 0x75, 0x72, 0x2f, 0x63, 0x61, 0x63, 0x68,
+	0x65, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x61, 0x70, 0x69, 0x2f, 0x76, 0x31, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_invalidation_proto_rawDescOnce sync.Once
+	file_invalidation_proto_rawDescData = file_invalidation_proto_rawDesc
+)
+
+func file_invalidation_proto_rawDescGZIP() []byte {
+	file_invalidation_proto_rawDescOnce.Do(func() {
+		file_invalidation_proto_rawDescData = protoimpl.X.CompressGZIP(file_invalidation_proto_rawDescData)
+	})
+	return file_invalidation_proto_rawDescData
+}
+
+var file_invalidation_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_invalidation_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_invalidation_proto_goTypes = []interface{}{
+	(InvalidationOp)(0),                  // 0: v1.InvalidationOp
+	(*InvalidationSubscribeRequest)(nil), // 1: v1.InvalidationSubscribeRequest
+	(*InvalidationMessage)(nil),          // 2: v1.InvalidationMessage
+}
+var file_invalidation_proto_depIdxs = []int32{
+	0, // 0: v1.InvalidationMessage.op:type_name -> v1.InvalidationOp
+	1, // 1: v1.InvalidationService.Subscribe:input_type -> v1.InvalidationSubscribeRequest
+	2, // 2: v1.InvalidationService.Subscribe:output_type -> v1.InvalidationMessage
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_invalidation_proto_init() }
+func file_invalidation_proto_init() {
+	if File_invalidation_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_invalidation_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*InvalidationSubscribeRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_invalidation_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*InvalidationMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_invalidation_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_invalidation_proto_goTypes,
+		DependencyIndexes: file_invalidation_proto_depIdxs,
+		EnumInfos:         file_invalidation_proto_enumTypes,
+		MessageInfos:      file_invalidation_proto_msgTypes,
+	}.Build()
+	File_invalidation_proto = out.File
+	file_invalidation_proto_rawDesc = nil
+	file_invalidation_proto_goTypes = nil
+	file_invalidation_proto_depIdxs = nil
+}
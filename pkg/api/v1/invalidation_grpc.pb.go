@@ -0,0 +1,134 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package v1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// InvalidationServiceClient is the client API for InvalidationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type InvalidationServiceClient interface {
+	// Subscribe streams the cache invalidations published by this node. The first message
+	// is a SYNC carrying the latest sequence number, so that a subscriber that reconnects
+	// can find out whether it missed anything.
+	Subscribe(ctx context.Context, in *InvalidationSubscribeRequest, opts ...grpc.CallOption) (InvalidationService_SubscribeClient, error)
+}
+
+type invalidationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewInvalidationServiceClient(cc grpc.ClientConnInterface) InvalidationServiceClient {
+	return &invalidationServiceClient{cc}
+}
+
+func (c *invalidationServiceClient) Subscribe(ctx context.Context, in *InvalidationSubscribeRequest, opts ...grpc.CallOption) (InvalidationService_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &InvalidationService_ServiceDesc.Streams[0], "/v1.InvalidationService/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &invalidationServiceSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type InvalidationService_SubscribeClient interface {
+	Recv() (*InvalidationMessage, error)
+	grpc.ClientStream
+}
+
+type invalidationServiceSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *invalidationServiceSubscribeClient) Recv() (*InvalidationMessage, error) {
+	m := new(InvalidationMessage)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// InvalidationServiceServer is the server API for InvalidationService service.
+// All implementations must embed UnimplementedInvalidationServiceServer
+// for forward compatibility
+type InvalidationServiceServer interface {
+	// Subscribe streams the cache invalidations published by this node. The first message
+	// is a SYNC carrying the latest sequence number, so that a subscriber that reconnects
+	// can find out whether it missed anything.
+	Subscribe(*InvalidationSubscribeRequest, InvalidationService_SubscribeServer) error
+	mustEmbedUnimplementedInvalidationServiceServer()
+}
+
+// UnimplementedInvalidationServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedInvalidationServiceServer struct {
+}
+
+func (UnimplementedInvalidationServiceServer) Subscribe(*InvalidationSubscribeRequest, InvalidationService_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedInvalidationServiceServer) mustEmbedUnimplementedInvalidationServiceServer() {}
+
+// UnsafeInvalidationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to InvalidationServiceServer will
+// result in compilation errors.
+type UnsafeInvalidationServiceServer interface {
+	mustEmbedUnimplementedInvalidationServiceServer()
+}
+
+func RegisterInvalidationServiceServer(s grpc.ServiceRegistrar, srv InvalidationServiceServer) {
+	s.RegisterService(&InvalidationService_ServiceDesc, srv)
+}
+
+func _InvalidationService_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(InvalidationSubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(InvalidationServiceServer).Subscribe(m, &invalidationServiceSubscribeServer{stream})
+}
+
+type InvalidationService_SubscribeServer interface {
+	Send(*InvalidationMessage) error
+	grpc.ServerStream
+}
+
+type invalidationServiceSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *invalidationServiceSubscribeServer) Send(m *InvalidationMessage) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// InvalidationService_ServiceDesc is the grpc.ServiceDesc for InvalidationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var InvalidationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "v1.InvalidationService",
+	HandlerType: (*InvalidationServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _InvalidationService_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "invalidation.proto",
+}
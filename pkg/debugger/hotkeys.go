@@ -0,0 +1,132 @@
+package debugger
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/bhojpur/cache/pkg/engine"
+	templates "github.com/bhojpur/cache/templates"
+)
+
+// defaultHotKeys is the number of hot keys shown when the request doesn't
+// ask for a number.
+const defaultHotKeys = 20
+
+// NewHotKeysHandler returns an HTTP handler listing the hottest keys of the
+// named caches. The "cache" parameter selects a cache, "k" the number of keys
+// shown, and "format=json" returns the keys as JSON instead of an HTML page.
+func NewHotKeysHandler(caches map[string]engine.HotKeysReporter) http.Handler {
+	return &hotKeysHandler{caches}
+}
+
+type hotKeysHandler struct {
+	caches map[string]engine.HotKeysReporter
+}
+
+type hotKeysPage struct {
+	Caches []string
+	Cache  string
+	K      int
+	Keys   []engine.HotKey
+}
+
+func (h *hotKeysHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	page := hotKeysPage{K: defaultHotKeys}
+	for name := range h.caches {
+		page.Caches = append(page.Caches, name)
+	}
+	sort.Strings(page.Caches)
+
+	if r.FormValue("k") != "" {
+		k, err := strconv.Atoi(r.FormValue("k"))
+		if err != nil || k <= 0 {
+			templates.Error(w, fmt.Errorf("invalid number of keys: %q", r.FormValue("k")))
+			return
+		}
+		page.K = k
+	}
+	page.Cache = r.FormValue("cache")
+	if page.Cache == "" && len(page.Caches) > 0 {
+		page.Cache = page.Caches[0]
+	}
+	if page.Cache != "" {
+		cache, ok := h.caches[page.Cache]
+		if !ok {
+			templates.Error(w, fmt.Errorf("unknown cache: %q", page.Cache))
+			return
+		}
+		page.Keys = cache.HotKeys(page.K)
+	}
+
+	if r.FormValue("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page.Keys)
+		return
+	}
+	if err := hotKeysTemplate.Execute(w, &page); err != nil {
+		templates.Error(w, err)
+	}
+}
+
+var hotKeysTemplate = template.Must(template.New("hotkeys").Parse(`<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <title>Bhojpur Cache - Hot Keys</title>
+    <style>
+      table { border-collapse:collapse; }
+      table, th, td { border: 1px solid black; }
+      th, td { min-width: 100px; padding: 2px 5px; }
+    </style>
+  </head>
+
+  <body>
+    <p>
+      {{range .Caches}}<a href="?cache={{.}}&k={{$.K}}">{{.}}</a> {{end}}
+    </p>
+
+    <h3>Hot keys of {{.Cache}}</h3>
+    <table>
+      <thead>
+        <tr><th>#</th><th>Key</th><th>Hash</th><th>Count</th><th>Error</th></tr>
+      </thead>
+      <tbody>
+        {{range $i, $key := .Keys}}
+        <tr>
+          <td>{{$i}}</td>
+          <td>{{if $key.Key}}{{$key.Key}}{{else}}<i>unknown</i>{{end}}</td>
+          <td>{{printf "%016x" $key.KeyHash}}</td>
+          <td>{{$key.Count}}</td>
+          <td>{{$key.Error}}</td>
+        </tr>
+        {{else}}
+        <tr><td colspan="5">No hot keys tracked.</td></tr>
+        {{end}}
+      </tbody>
+    </table>
+  </body>
+</html>`))
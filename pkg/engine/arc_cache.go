@@ -0,0 +1,304 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// ARCCache implements the Adaptive Replacement Cache (Megiddo and Modha). It
+// keeps two resident lists, T1 for entries seen once recently and T2 for
+// entries seen at least twice, plus the ghost histories B1 and B2 of the keys
+// recently evicted from each. Hits on the ghost histories move the target size
+// of T1 up or down, so the cache continuously balances recency against
+// frequency for the current workload. This implementation accounts sizes in
+// cost units rather than entries.
+
+import (
+	"container/list"
+	"sync"
+)
+
+var _ Cache = &ARCCache{}
+
+// ARCCache is an Adaptive Replacement Cache implementation. Like LRUCache, the
+// capacity is not the number of items but the total cost of every item.
+type ARCCache struct {
+	mu sync.Mutex
+
+	table segmentTable
+	t1    *segment
+	t2    *segment
+	b1    *segment
+	b2    *segment
+	cost  func(interface{}) int64
+
+	onRemove RemovalListener
+
+	// p is the adaptive target size of t1
+	p        int64
+	capacity int64
+	statsCounters
+}
+
+// NewARCCache creates a new empty ARC cache with the given capacity.
+func NewARCCache(capacity int64, cost func(interface{}) int64) *ARCCache {
+	return &ARCCache{
+		table:    make(segmentTable),
+		t1:       newSegment(),
+		t2:       newSegment(),
+		b1:       newSegment(),
+		b2:       newSegment(),
+		cost:     cost,
+		capacity: capacity,
+	}
+}
+
+// Get returns a value from the cache. Any hit moves the entry to the front of T2.
+func (c *ARCCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element := c.table[key]
+	if element == nil {
+		c.recordGet(false)
+		return nil, false
+	}
+	switch element.Value.(*segmentEntry).seg {
+	case c.t1:
+		c.table.move(element, c.t2)
+	case c.t2:
+		c.t2.moveToFront(element)
+	default:
+		// Ghost entries have no value
+		c.recordGet(false)
+		return nil, false
+	}
+	c.recordGet(true)
+	return element.Value.(*segmentEntry).value, true
+}
+
+// Set sets a value in the cache.
+func (c *ARCCache) Set(key string, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sets++
+	size := c.cost(value)
+	element := c.table[key]
+	if element == nil {
+		c.table.add(key, value, size, c.t1)
+		c.replace(false)
+		c.trimGhosts()
+		// the ARC cache cannot fail to insert items; it always returns true
+		return true
+	}
+
+	e := element.Value.(*segmentEntry)
+	inB2 := false
+	switch e.seg {
+	case c.t1:
+		c.onRemove.notify(key, e.value, Replaced)
+		element = c.table.move(element, c.t2)
+	case c.t2:
+		c.onRemove.notify(key, e.value, Replaced)
+		c.t2.moveToFront(element)
+	case c.b1:
+		// A recency miss: T1 was too small, so grow its target.
+		c.p = min64(c.capacity, c.p+c.delta(c.b2, c.b1, size))
+		element = c.table.move(element, c.t2)
+	case c.b2:
+		// A frequency miss: T2 was too small, so shrink T1's target.
+		c.p = max64(0, c.p-c.delta(c.b1, c.b2, size))
+		element = c.table.move(element, c.t2)
+		inB2 = true
+	}
+	e.value = value
+	c.t2.resize(element, size)
+	c.replace(inB2)
+	c.trimGhosts()
+	return true
+}
+
+// Delete removes an entry from the cache
+func (c *ARCCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element := c.table[key]; element != nil {
+		resident := c.isResident(element)
+		if e := c.table.drop(element); resident {
+			c.onRemove.notify(e.key, e.value, Deleted)
+		}
+	}
+}
+
+// Clear will clear the entire cache, including the ghost histories.
+func (c *ARCCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.t2.notify(c.onRemove, Cleared)
+	c.t1.notify(c.onRemove, Cleared)
+	c.table = make(segmentTable)
+	c.t1.clear()
+	c.t2.clear()
+	c.b1.clear()
+	c.b2.clear()
+	c.p = 0
+}
+
+// SetRemovalListener sets the listener notified whenever an entry leaves the cache.
+func (c *ARCCache) SetRemovalListener(listener RemovalListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRemove = listener
+}
+
+// Wait is a no-op in the ARC cache
+func (c *ARCCache) Wait() {}
+
+// Len returns the size of the cache (in entries)
+func (c *ARCCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t1.Len() + c.t2.Len()
+}
+
+// Evictions returns the number of evictions
+func (c *ARCCache) Evictions() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictions
+}
+
+// UsedCapacity returns the size of the cache (in bytes)
+func (c *ARCCache) UsedCapacity() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t1.size + c.t2.size
+}
+
+// MaxCapacity returns the cache maximum capacity.
+func (c *ARCCache) MaxCapacity() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capacity
+}
+
+// SetCapacity will set the capacity of the cache, shrinking it if needed.
+func (c *ARCCache) SetCapacity(capacity int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity = capacity
+	c.p = min64(c.p, capacity)
+	c.replace(false)
+	c.trimGhosts()
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (c *ARCCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats(c.t1.size+c.t2.size, c.capacity)
+}
+
+// ForEach yields all the values for the cache, frequently used entries (T2)
+// first, each list ordered from most recently used to least recently used.
+func (c *ARCCache) ForEach(callback func(value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.t2.forEach(callback) {
+		c.t1.forEach(callback)
+	}
+}
+
+// Items returns all the entries of the cache, in the same order as ForEach.
+func (c *ARCCache) Items() []Item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	items := make([]Item, 0, c.t1.Len()+c.t2.Len())
+	items = c.t2.appendItems(items)
+	items = c.t1.appendItems(items)
+	return items
+}
+
+// delta is the amount by which the target size of T1 adapts after a ghost hit
+// of the given size: the hit is weighted by the relative size of the other ghost list.
+func (c *ARCCache) delta(other, hit *segment, size int64) int64 {
+	if size < 1 {
+		size = 1
+	}
+	if hit.size > 0 && other.size > hit.size {
+		return size * (other.size / hit.size)
+	}
+	return size
+}
+
+// replace evicts resident entries into the ghost histories until the cache fits
+// its capacity. T1 is evicted while it is above its target size.
+func (c *ARCCache) replace(inB2 bool) {
+	for c.t1.size+c.t2.size > c.capacity {
+		if c.t1.Len() > 0 && (c.t1.size > c.p || (inB2 && c.t1.size == c.p) || c.t2.Len() == 0) {
+			c.demote(c.t1.back(), c.b1)
+		} else {
+			c.demote(c.t2.back(), c.b2)
+		}
+		c.evictions++
+	}
+}
+
+// trimGhosts bounds the history: T1 and B1 together never exceed the capacity
+// and the whole directory never exceeds twice the capacity.
+func (c *ARCCache) trimGhosts() {
+	for c.b1.Len() > 0 && c.t1.size+c.b1.size > c.capacity {
+		c.table.drop(c.b1.back())
+	}
+	for c.b2.Len() > 0 && c.t1.size+c.t2.size+c.b1.size+c.b2.size > 2*c.capacity {
+		c.table.drop(c.b2.back())
+	}
+}
+
+// isResident reports whether the element holds a value, rather than being a ghost.
+func (c *ARCCache) isResident(element *list.Element) bool {
+	seg := element.Value.(*segmentEntry).seg
+	return seg == c.t1 || seg == c.t2
+}
+
+func (c *ARCCache) demote(element *list.Element, ghost *segment) {
+	element = c.table.move(element, ghost)
+	e := element.Value.(*segmentEntry)
+	c.onRemove.notify(e.key, e.value, Evicted)
+	e.value = nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
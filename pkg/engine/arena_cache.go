@@ -0,0 +1,568 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/bhojpur/cache/pkg/hack"
+)
+
+var _ Cache = &ArenaCache{}
+
+// PolicyArena selects the ArenaCache, which stores byte slices off the heap
+// scanned by the garbage collector.
+const PolicyArena = "arena"
+
+const (
+	// DefaultArenaSlabSize is the size of the slabs of an ArenaCache, unless
+	// told otherwise.
+	DefaultArenaSlabSize = 1 << 20
+	// arenaMinChunk is the size of the smallest chunks.
+	arenaMinChunk = 64
+	// arenaMaxSlabSize keeps the offsets in a slab within an int32.
+	arenaMaxSlabSize = 1 << 30
+)
+
+// ArenaOptions are the options of the arena engine.
+type ArenaOptions struct {
+	// SlabSize is the size of the slabs, in bytes.
+	SlabSize int `json:"slab_size,omitempty" desc:"size of the slabs, in bytes; values must fit in a slab"`
+}
+
+func init() {
+	Register(Engine{
+		Name:        PolicyArena,
+		Description: "LRU eviction per size class, with byte slices stored in slabs outside the heap scanned by the GC",
+		Options:     func() interface{} { return &ArenaOptions{} },
+		New: func(cfg *Config, options interface{}) (Cache, error) {
+			if cfg.MaxMemoryUsage == 0 {
+				return &nullCache{onRemove: cfg.OnRemove}, nil
+			}
+			cache := NewArenaCache(cfg.MaxMemoryUsage, options.(*ArenaOptions).SlabSize)
+			if cfg.OnRemove != nil {
+				cache.SetRemovalListener(cfg.OnRemove)
+			}
+			return cache, nil
+		},
+	})
+}
+
+// ArenaCache is an LRU cache of byte slices which copies every key and value
+// into large preallocated slabs. The slabs are split into chunks of a few
+// size classes, and each entry takes the smallest chunk that fits its key and
+// value. The index of the cache only holds offsets into the slabs, so the
+// garbage collector sees a few large allocations without pointers instead of
+// an object per entry.
+//
+// Like memcached, every size class evicts its own least recently used entries
+// once all the slabs allowed by the capacity are taken. A class without any
+// entry takes a slab from the class holding the most slabs.
+//
+// Set accepts []byte and string values, and Get returns a copy of the value as
+// a []byte. The capacity is the memory of the slabs, in bytes, and values
+// larger than a slab are rejected. Keys are told apart by a 64-bit hash, so
+// setting a key evicts another key with the same hash.
+type ArenaCache struct {
+	mu sync.Mutex
+
+	seed        hack.HashSeed
+	table       map[uint64]int32
+	entries     []arenaEntry
+	freeEntries []int32
+
+	slabSize  int
+	slabs     [][]byte
+	owners    []int32 // class of every slab, or -1 for released slabs
+	freeSlabs []int32
+	liveSlabs int
+	maxSlabs  int
+	classes   []arenaClass
+
+	onRemove RemovalListener
+
+	size     int64
+	capacity int64
+	statsCounters
+}
+
+// arenaEntry is an entry of an ArenaCache. It holds no pointers, so the
+// entries are not scanned by the garbage collector.
+type arenaEntry struct {
+	hash     uint64
+	chunk    arenaChunk
+	keyLen   int32
+	valueLen int32
+	class    int32
+	// prev and next link the entries of a class from the most recently used
+	// to the least recently used, and are -1 at the ends.
+	prev, next int32
+}
+
+// arenaChunk locates a chunk in the slabs.
+type arenaChunk struct {
+	slab, offset int32
+}
+
+type arenaClass struct {
+	size       int32
+	free       []arenaChunk
+	head, tail int32
+	slabs      int
+}
+
+// NewArenaCache creates a new empty cache whose slabs take up to capacity
+// bytes. A slabSize of zero uses DefaultArenaSlabSize; slabs are made smaller
+// to fit in a smaller capacity.
+func NewArenaCache(capacity int64, slabSize int) *ArenaCache {
+	if slabSize <= 0 {
+		slabSize = DefaultArenaSlabSize
+	}
+	if capacity > 0 && int64(slabSize) > capacity {
+		slabSize = int(capacity)
+	}
+	if slabSize < arenaMinChunk {
+		slabSize = arenaMinChunk
+	}
+	if slabSize > arenaMaxSlabSize {
+		slabSize = arenaMaxSlabSize
+	}
+	c := &ArenaCache{
+		seed:     hack.NewHashSeed(),
+		slabSize: slabSize,
+	}
+	for size := arenaMinChunk; ; size = (size*5/4 + 7) &^ 7 {
+		if size >= slabSize {
+			c.classes = append(c.classes, arenaClass{size: int32(slabSize)})
+			break
+		}
+		c.classes = append(c.classes, arenaClass{size: int32(size)})
+	}
+	c.reset()
+	c.setCapacity(capacity)
+	return c
+}
+
+// reset empties the cache, dropping its slabs.
+func (c *ArenaCache) reset() {
+	c.table = make(map[uint64]int32)
+	c.entries = nil
+	c.freeEntries = nil
+	c.slabs = nil
+	c.owners = nil
+	c.freeSlabs = nil
+	c.liveSlabs = 0
+	for i := range c.classes {
+		c.classes[i] = arenaClass{size: c.classes[i].size, head: -1, tail: -1}
+	}
+	c.size = 0
+}
+
+// Get returns a copy of the value of a key, as a []byte, and marks the entry
+// as most recently used.
+func (c *ArenaCache) Get(key string) (interface{}, bool) {
+	value, ok := c.GetBytes(key, nil)
+	if !ok {
+		return nil, false
+	}
+	return value, true
+}
+
+// GetBytes appends the value of a key to dst, and marks the entry as most
+// recently used. It allows looking up values without allocating.
+func (c *ArenaCache) GetBytes(key string, dst []byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	idx, ok := c.find(key)
+	c.recordGet(ok)
+	if !ok {
+		return dst, false
+	}
+	c.unlink(idx)
+	c.pushFront(idx)
+	return append(dst, c.value(idx)...), true
+}
+
+// Set copies a []byte or string value into the cache. It returns false when
+// the value has another type, or does not fit in a slab.
+func (c *ArenaCache) Set(key string, value interface{}) bool {
+	var data []byte
+	var ok bool
+	switch v := value.(type) {
+	case []byte:
+		data, ok = v, true
+	case string:
+		data, ok = hack.StringBytes(v), true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sets++
+	if !ok {
+		c.rejected++
+		return false
+	}
+	return c.set(key, data)
+}
+
+func (c *ArenaCache) set(key string, data []byte) bool {
+	class := c.classFor(len(key) + len(data))
+	hash := hack.Strhash(key, c.seed)
+	if idx, ok := c.table[hash]; ok {
+		sameKey := c.key(idx) == key
+		if e := &c.entries[idx]; sameKey && e.class == class {
+			c.onRemove.notify(key, c.copyValue(idx), Replaced)
+			copy(c.slabs[e.chunk.slab][int(e.chunk.offset)+int(e.keyLen):], data)
+			e.valueLen = int32(len(data))
+			c.unlink(idx)
+			c.pushFront(idx)
+			return true
+		}
+		if sameKey {
+			c.remove(idx, Replaced)
+		} else {
+			c.remove(idx, Evicted)
+		}
+	}
+	if class < 0 {
+		c.rejected++
+		return false
+	}
+	chunk, ok := c.alloc(class)
+	if !ok {
+		c.rejected++
+		return false
+	}
+
+	var idx int32
+	if n := len(c.freeEntries); n > 0 {
+		idx = c.freeEntries[n-1]
+		c.freeEntries = c.freeEntries[:n-1]
+	} else {
+		idx = int32(len(c.entries))
+		c.entries = append(c.entries, arenaEntry{})
+	}
+	c.entries[idx] = arenaEntry{
+		hash:     hash,
+		chunk:    chunk,
+		keyLen:   int32(len(key)),
+		valueLen: int32(len(data)),
+		class:    class,
+	}
+	slab := c.slabs[chunk.slab][chunk.offset:]
+	copy(slab[copy(slab, key):], data)
+	c.table[hash] = idx
+	c.pushFront(idx)
+	c.size += int64(c.classes[class].size)
+	return true
+}
+
+// Delete removes an entry from the cache.
+func (c *ArenaCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if idx, ok := c.find(key); ok {
+		c.remove(idx, Deleted)
+	}
+}
+
+// Clear empties the cache, and releases all its slabs.
+func (c *ArenaCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.onRemove != nil {
+		c.forEach(func(idx int32) bool {
+			c.onRemove(hack.StringClone(c.key(idx)), c.copyValue(idx), Cleared)
+			return true
+		})
+	}
+	c.reset()
+}
+
+// Len returns the number of entries in the cache.
+func (c *ArenaCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.table)
+}
+
+// SetCapacity sets the memory the slabs may take. When it shrinks, the
+// entries of the released slabs are evicted.
+func (c *ArenaCache) SetCapacity(capacity int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setCapacity(capacity)
+}
+
+func (c *ArenaCache) setCapacity(capacity int64) {
+	c.capacity = capacity
+	c.maxSlabs = int(capacity / int64(c.slabSize))
+	for c.liveSlabs > c.maxSlabs {
+		c.releaseSlab(c.slabOf(c.largestClass(-1)))
+	}
+}
+
+// SetRemovalListener sets the listener notified whenever an entry leaves the cache.
+func (c *ArenaCache) SetRemovalListener(listener RemovalListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRemove = listener
+}
+
+// Wait is a no-op in the arena cache
+func (c *ArenaCache) Wait() {}
+
+// UsedCapacity returns the size of the chunks holding entries, in bytes.
+func (c *ArenaCache) UsedCapacity() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+// MaxCapacity returns the memory the slabs may take, in bytes.
+func (c *ArenaCache) MaxCapacity() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capacity
+}
+
+// Evictions returns the number of evictions
+func (c *ArenaCache) Evictions() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictions
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (c *ArenaCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats(c.size, c.capacity)
+}
+
+// ForEach yields a copy of every value in the cache, from the smallest size
+// class to the largest, and from the most recently used to the least recently
+// used within a class.
+func (c *ArenaCache) ForEach(callback func(value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.forEach(func(idx int32) bool {
+		return callback(c.copyValue(idx))
+	})
+}
+
+func (c *ArenaCache) forEach(callback func(idx int32) bool) {
+	for i := range c.classes {
+		for idx := c.classes[i].head; idx >= 0; idx = c.entries[idx].next {
+			if !callback(idx) {
+				return
+			}
+		}
+	}
+}
+
+// classFor returns the smallest class whose chunks hold n bytes, or -1.
+func (c *ArenaCache) classFor(n int) int32 {
+	i := sort.Search(len(c.classes), func(i int) bool {
+		return int(c.classes[i].size) >= n
+	})
+	if i == len(c.classes) {
+		return -1
+	}
+	return int32(i)
+}
+
+// find returns the entry of a key.
+func (c *ArenaCache) find(key string) (int32, bool) {
+	idx, ok := c.table[hack.Strhash(key, c.seed)]
+	if !ok || c.key(idx) != key {
+		return 0, false
+	}
+	return idx, true
+}
+
+// key returns the key of an entry. It shares the memory of the slab.
+func (c *ArenaCache) key(idx int32) string {
+	e := &c.entries[idx]
+	return hack.String(c.slabs[e.chunk.slab][e.chunk.offset : e.chunk.offset+e.keyLen])
+}
+
+// value returns the value of an entry. It shares the memory of the slab.
+func (c *ArenaCache) value(idx int32) []byte {
+	e := &c.entries[idx]
+	start := e.chunk.offset + e.keyLen
+	return c.slabs[e.chunk.slab][start : start+e.valueLen]
+}
+
+func (c *ArenaCache) copyValue(idx int32) []byte {
+	return append([]byte(nil), c.value(idx)...)
+}
+
+func (c *ArenaCache) pushFront(idx int32) {
+	e := &c.entries[idx]
+	class := &c.classes[e.class]
+	e.prev, e.next = -1, class.head
+	if class.head >= 0 {
+		c.entries[class.head].prev = idx
+	} else {
+		class.tail = idx
+	}
+	class.head = idx
+}
+
+func (c *ArenaCache) unlink(idx int32) {
+	e := &c.entries[idx]
+	class := &c.classes[e.class]
+	if e.prev >= 0 {
+		c.entries[e.prev].next = e.next
+	} else {
+		class.head = e.next
+	}
+	if e.next >= 0 {
+		c.entries[e.next].prev = e.prev
+	} else {
+		class.tail = e.prev
+	}
+}
+
+// remove deletes an entry and frees its chunk.
+func (c *ArenaCache) remove(idx int32, reason RemovalReason) {
+	if c.onRemove != nil {
+		c.onRemove(hack.StringClone(c.key(idx)), c.copyValue(idx), reason)
+	}
+	if reason == Evicted {
+		c.evictions++
+	}
+	c.unlink(idx)
+	e := &c.entries[idx]
+	class := &c.classes[e.class]
+	class.free = append(class.free, e.chunk)
+	c.size -= int64(class.size)
+	delete(c.table, e.hash)
+	c.freeEntries = append(c.freeEntries, idx)
+}
+
+// alloc returns a free chunk of a class, evicting entries if needed.
+func (c *ArenaCache) alloc(class int32) (arenaChunk, bool) {
+	for {
+		cls := &c.classes[class]
+		if n := len(cls.free); n > 0 {
+			chunk := cls.free[n-1]
+			cls.free = cls.free[:n-1]
+			return chunk, true
+		}
+		switch {
+		case c.liveSlabs < c.maxSlabs:
+			c.newSlab(class)
+		case cls.tail >= 0:
+			c.remove(cls.tail, Evicted)
+		default:
+			victim := c.largestClass(class)
+			if victim < 0 {
+				return arenaChunk{}, false
+			}
+			c.releaseSlab(c.slabOf(victim))
+		}
+	}
+}
+
+// newSlab allocates a slab to a class, and frees all its chunks.
+func (c *ArenaCache) newSlab(class int32) {
+	var slab int32
+	if n := len(c.freeSlabs); n > 0 {
+		slab = c.freeSlabs[n-1]
+		c.freeSlabs = c.freeSlabs[:n-1]
+		c.slabs[slab] = make([]byte, c.slabSize)
+		c.owners[slab] = class
+	} else {
+		slab = int32(len(c.slabs))
+		c.slabs = append(c.slabs, make([]byte, c.slabSize))
+		c.owners = append(c.owners, class)
+	}
+	c.liveSlabs++
+
+	cls := &c.classes[class]
+	cls.slabs++
+	// The chunks are pushed backwards, so that they are used in order.
+	for offset := (c.slabSize/int(cls.size) - 1) * int(cls.size); offset >= 0; offset -= int(cls.size) {
+		cls.free = append(cls.free, arenaChunk{slab: slab, offset: int32(offset)})
+	}
+}
+
+// largestClass returns the class holding the most slabs, other than the given
+// one, or -1 when no other class holds any.
+func (c *ArenaCache) largestClass(except int32) int32 {
+	largest := int32(-1)
+	for i := range c.classes {
+		if int32(i) == except || c.classes[i].slabs == 0 {
+			continue
+		}
+		if largest < 0 || c.classes[i].slabs > c.classes[largest].slabs {
+			largest = int32(i)
+		}
+	}
+	return largest
+}
+
+// slabOf returns the slab of a class to release: the slab of its least
+// recently used entry, or any of its slabs when it has no entries.
+func (c *ArenaCache) slabOf(class int32) int32 {
+	if tail := c.classes[class].tail; tail >= 0 {
+		return c.entries[tail].chunk.slab
+	}
+	for slab, owner := range c.owners {
+		if owner == class {
+			return int32(slab)
+		}
+	}
+	panic("engine: arena class without slabs")
+}
+
+// releaseSlab evicts the entries of a slab, and drops it.
+func (c *ArenaCache) releaseSlab(slab int32) {
+	class := c.owners[slab]
+	cls := &c.classes[class]
+	for idx := cls.head; idx >= 0; {
+		next := c.entries[idx].next
+		if c.entries[idx].chunk.slab == slab {
+			c.remove(idx, Evicted)
+		}
+		idx = next
+	}
+	free := cls.free[:0]
+	for _, chunk := range cls.free {
+		if chunk.slab != slab {
+			free = append(free, chunk)
+		}
+	}
+	cls.free = free
+	cls.slabs--
+
+	c.slabs[slab] = nil
+	c.owners[slab] = -1
+	c.freeSlabs = append(c.freeSlabs, slab)
+	c.liveSlabs--
+}
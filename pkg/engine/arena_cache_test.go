@@ -0,0 +1,169 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// arenaValue returns a value of n bytes set to b.
+func arenaValue(n int, b byte) []byte {
+	value := make([]byte, n)
+	for i := range value {
+		value[i] = b
+	}
+	return value
+}
+
+func TestArenaCacheGetSet(t *testing.T) {
+	cache := NewArenaCache(1<<20, 0)
+
+	require.True(t, cache.Set("a", []byte("hello")))
+	require.True(t, cache.Set("b", "world"))
+	require.False(t, cache.Set("c", 42))
+
+	v, ok := cache.Get("a")
+	require.True(t, ok)
+	require.Equal(t, []byte("hello"), v)
+	// The values returned are copies.
+	v.([]byte)[0] = 'j'
+	v, _ = cache.Get("a")
+	require.Equal(t, []byte("hello"), v)
+
+	buf, ok := cache.GetBytes("b", []byte("hello "))
+	require.True(t, ok)
+	require.Equal(t, "hello world", string(buf))
+
+	_, ok = cache.Get("c")
+	require.False(t, ok)
+	cache.Delete("a")
+	_, ok = cache.Get("a")
+	require.False(t, ok)
+	require.Equal(t, 1, cache.Len())
+
+	stats := cache.Stats()
+	require.Equal(t, uint64(3), stats.Hits)
+	require.Equal(t, uint64(2), stats.Misses)
+	require.Equal(t, uint64(1), stats.SetsRejected)
+
+	// Values larger than a slab are rejected.
+	require.False(t, cache.Set("big", make([]byte, DefaultArenaSlabSize)))
+}
+
+func TestArenaCacheReplace(t *testing.T) {
+	var removed []string
+	cache := NewArenaCache(1<<20, 0)
+	cache.SetRemovalListener(func(key string, value interface{}, reason RemovalReason) {
+		removed = append(removed, fmt.Sprintf("%s=%s:%v", key, value, reason))
+	})
+
+	cache.Set("a", "1")
+	// In the same class, and in another one.
+	cache.Set("a", "2")
+	cache.Set("a", string(arenaValue(1000, '3')))
+	v, ok := cache.Get("a")
+	require.True(t, ok)
+	require.Equal(t, arenaValue(1000, '3'), v)
+	cache.Set("a", "4")
+	v, _ = cache.Get("a")
+	require.Equal(t, []byte("4"), v)
+	require.Equal(t, 1, cache.Len())
+	require.Equal(t, int64(arenaMinChunk), cache.UsedCapacity())
+
+	cache.Clear()
+	require.Equal(t, []string{"a=1:replaced", "a=2:replaced", "a=" + string(arenaValue(1000, '3')) + ":replaced", "a=4:cleared"}, removed)
+	require.Equal(t, 0, cache.Len())
+	require.Equal(t, int64(0), cache.UsedCapacity())
+}
+
+func TestArenaCacheEvicts(t *testing.T) {
+	// A single slab of 4 chunks of 64 bytes.
+	cache := NewArenaCache(256, 0)
+	for i := 0; i < 4; i++ {
+		require.True(t, cache.Set(fmt.Sprintf("key%d", i), arenaValue(50, byte(i))))
+	}
+	cache.Get("key0")
+	require.True(t, cache.Set("key4", arenaValue(50, 4)))
+	require.Equal(t, 4, cache.Len())
+	require.Equal(t, int64(1), cache.Evictions())
+	_, ok := cache.Get("key1")
+	require.False(t, ok)
+	_, ok = cache.Get("key0")
+	require.True(t, ok)
+
+	// A value of another class takes the slab of the first.
+	require.True(t, cache.Set("large", arenaValue(200, 5)))
+	require.Equal(t, 1, cache.Len())
+	require.Equal(t, int64(5), cache.Evictions())
+	v, ok := cache.Get("large")
+	require.True(t, ok)
+	require.Equal(t, arenaValue(200, 5), v)
+
+	// Shrinking the capacity releases the slab.
+	cache.SetCapacity(0)
+	require.Equal(t, 0, cache.Len())
+	require.False(t, cache.Set("key0", arenaValue(50, 0)))
+	cache.SetCapacity(256)
+	require.True(t, cache.Set("key0", arenaValue(50, 0)))
+}
+
+func TestArenaCacheRandom(t *testing.T) {
+	// The cache never returns a stale or corrupted value.
+	rng := rand.New(rand.NewSource(1))
+	cache := NewArenaCache(16*1024, 4096)
+	latest := make(map[string][]byte)
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("key%d", rng.Intn(200))
+		switch rng.Intn(4) {
+		case 0:
+			cache.Delete(key)
+			delete(latest, key)
+		case 1, 2:
+			value := arenaValue(rng.Intn(1500), byte(i))
+			if cache.Set(key, value) {
+				latest[key] = value
+			} else {
+				delete(latest, key)
+			}
+		default:
+			if v, ok := cache.Get(key); ok {
+				require.Equal(t, latest[key], v)
+			}
+		}
+		require.LessOrEqual(t, cache.liveSlabs, 4)
+	}
+	require.LessOrEqual(t, cache.UsedCapacity(), int64(16*1024))
+}
+
+func TestArenaCacheEngine(t *testing.T) {
+	cache, err := NewCache(&Config{Engine: PolicyArena, MaxMemoryUsage: 1 << 16, Options: "slab_size: 4096"})
+	require.NoError(t, err)
+	require.Equal(t, 4096, cache.(*ArenaCache).slabSize)
+
+	cache, err = NewCache(&Config{Engine: PolicyArena})
+	require.NoError(t, err)
+	_, ok := cache.(*nullCache)
+	require.True(t, ok)
+}
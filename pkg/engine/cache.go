@@ -37,49 +37,291 @@ type Cache interface {
 
 	Len() int
 	Evictions() int64
+	// Stats returns a snapshot of the hit, miss, write, eviction and capacity
+	// statistics of the cache.
+	Stats() Stats
 	UsedCapacity() int64
 	MaxCapacity() int64
 	SetCapacity(int64)
 }
 
+// KeyIterator is implemented by caches that can enumerate their keys. The
+// Ristretto cache only implements it usefully when it keeps its keys.
+type KeyIterator interface {
+	// ForEachKV yields every key and value in the cache, until the callback
+	// returns false.
+	ForEachKV(callback func(key string, value interface{}) bool)
+	// Keys returns the keys in the cache that start with the given prefix.
+	Keys(prefix string) []string
+}
+
+// PrefixDeleter is implemented by caches that can remove every key starting
+// with a prefix.
+type PrefixDeleter interface {
+	// DeletePrefix removes the entries whose key starts with the prefix, and
+	// returns how many were removed.
+	DeletePrefix(prefix string) int
+}
+
+// MultiCache is implemented by caches that look up and set many keys at once,
+// paying their locking and buffering costs once per batch. GetMulti and
+// SetMulti work with any Cache.
+type MultiCache interface {
+	// GetMulti returns the value of every key, and whether it was found, in
+	// the order of the keys.
+	GetMulti(keys []string) (values []interface{}, found []bool)
+	// SetMulti sets every item, and returns whether each one was set, in the
+	// order of the items.
+	SetMulti(items []Item) []bool
+}
+
+// GetMulti looks up several keys in the cache, in a single batch when the
+// cache is a MultiCache.
+func GetMulti(cache Cache, keys []string) ([]interface{}, []bool) {
+	if multi, ok := cache.(MultiCache); ok {
+		return multi.GetMulti(keys)
+	}
+	values, found := make([]interface{}, len(keys)), make([]bool, len(keys))
+	for i, key := range keys {
+		values[i], found[i] = cache.Get(key)
+	}
+	return values, found
+}
+
+// SetMulti sets several items in the cache, in a single batch when the cache
+// is a MultiCache.
+func SetMulti(cache Cache, items []Item) []bool {
+	if multi, ok := cache.(MultiCache); ok {
+		return multi.SetMulti(items)
+	}
+	results := make([]bool, len(items))
+	for i, item := range items {
+		results[i] = cache.Set(item.Key, item.Value)
+	}
+	return results
+}
+
+// ConditionalCache is implemented by caches that can change a key atomically
+// depending on its current value. Every method is atomic with respect to the
+// other writes to the same key.
+type ConditionalCache interface {
+	// SetIfAbsent sets the value of a key only if it is not in the cache, and
+	// returns whether it was set.
+	SetIfAbsent(key string, value interface{}) bool
+	// Replace sets the value of a key only if it is already in the cache, and
+	// returns whether it was set.
+	Replace(key string, value interface{}) bool
+	// CompareAndSwap sets the value of a key to new only if its current value
+	// is old, comparing them with ==, and returns whether it was set. Values
+	// whose type is not comparable, such as []byte, maps and slices, never
+	// match, so CompareAndSwap returns false for them instead of panicking;
+	// use Compute to update such values.
+	CompareAndSwap(key string, old, new interface{}) bool
+	// Compute calls fn with the current value of a key and whether it was
+	// found, and sets the key to the value fn returns, or deletes it if fn
+	// returns false. It returns the new value and whether the key is in the
+	// cache. fn must not use the cache.
+	Compute(key string, fn func(value interface{}, found bool) (interface{}, bool)) (interface{}, bool)
+}
+
+// HotKeysReporter is implemented by caches that track their most accessed
+// keys. Only the LFU cache does, when Config.HotKeys is set.
+type HotKeysReporter interface {
+	// HotKeys returns up to k of the most accessed keys, hottest first.
+	HotKeys(k int) []HotKey
+}
+
 type cachedObject interface {
 	CachedSize(alloc bool) int64
 }
 
+// Names of the eviction policies that can be selected with Config.Policy.
+const (
+	// PolicyLRU evicts the least recently used entry.
+	PolicyLRU = "lru"
+	// PolicyLFU uses Ristretto's TinyLFU admission with Sampled LFU eviction.
+	PolicyLFU = "lfu"
+	// PolicyARC uses the Adaptive Replacement Cache.
+	PolicyARC = "arc"
+	// Policy2Q uses the 2Q algorithm.
+	Policy2Q = "2q"
+	// PolicySLRU uses a Segmented LRU.
+	PolicySLRU = "slru"
+	// PolicyWTinyLFU uses an LRU admission window in front of a Segmented LRU
+	// guarded by a TinyLFU admission filter.
+	PolicyWTinyLFU = "wtinylfu"
+)
+
 // NewDefaultCacheImpl returns the default cache implementation for Vitess. The options in the
 // Config struct control the memory and entry limits for the cache, and the underlying cache
-// implementation.
+// implementation. It panics when the config selects an unknown engine or has invalid
+// options; use NewCache to get an error instead.
 func NewDefaultCacheImpl(cfg *Config) Cache {
-	switch {
-	case cfg == nil:
-		return &nullCache{}
-
-	case cfg.LFU:
-		if cfg.MaxEntries == 0 || cfg.MaxMemoryUsage == 0 {
-			return &nullCache{}
-		}
-		return NewRistrettoCache(cfg.MaxEntries, cfg.MaxMemoryUsage, func(val interface{}) int64 {
-			return val.(cachedObject).CachedSize(true)
-		})
-
-	default:
-		if cfg.MaxEntries == 0 {
-			return &nullCache{}
-		}
-		return NewLRUCache(cfg.MaxEntries, func(_ interface{}) int64 {
-			return 1
-		})
+	cache, err := NewCache(cfg)
+	if err != nil {
+		panic(err)
 	}
+	return cache
 }
 
-// Config is the configuration options for a cache instance
+// LRUOptions are the options of the LRU engine.
+type LRUOptions struct {
+	// Shards overrides Config.Shards.
+	Shards int `json:"shards,omitempty" desc:"number of independently locked segments"`
+}
+
+// LFUOptions are the options of the LFU engine.
+type LFUOptions struct {
+	// KeepKeys is ORed with Config.KeepKeys.
+	KeepKeys bool `json:"keep_keys,omitempty" desc:"store the original keys, to iterate and snapshot them"`
+	// HotKeys overrides Config.HotKeys.
+	HotKeys int `json:"hot_keys,omitempty" desc:"number of hot keys to track"`
+}
+
+func init() {
+	Register(Engine{
+		Name:        PolicyLFU,
+		Description: "TinyLFU admission with Sampled LFU eviction, bounded by memory usage",
+		Options:     func() interface{} { return &LFUOptions{} },
+		New: func(cfg *Config, options interface{}) (Cache, error) {
+			if cfg.MaxEntries == 0 || cfg.MaxMemoryUsage == 0 {
+				return &nullCache{onRemove: cfg.OnRemove}, nil
+			}
+			opts := options.(*LFUOptions)
+			hotKeys := cfg.HotKeys
+			if opts.HotKeys != 0 {
+				hotKeys = opts.HotKeys
+			}
+			return newRistrettoCache(cfg.MaxEntries, cfg.MaxMemoryUsage, cfg.cost(), cfg.KeepKeys || opts.KeepKeys, hotKeys, cfg.OnRemove), nil
+		},
+	})
+	Register(policyEngine(PolicyLRU, "least recently used eviction, optionally sharded",
+		func() interface{} { return &LRUOptions{} },
+		func(cfg *Config, capacity int64, cost func(interface{}) int64, options interface{}) listenedCache {
+			shards := cfg.Shards
+			if opts := options.(*LRUOptions); opts.Shards != 0 {
+				shards = opts.Shards
+			}
+			if shards > 1 {
+				return NewShardedLRUCache(capacity, shards, cost)
+			}
+			return NewLRUCache(capacity, cost)
+		}))
+	Register(policyEngine(PolicyARC, "Adaptive Replacement Cache", nil,
+		func(_ *Config, capacity int64, cost func(interface{}) int64, _ interface{}) listenedCache {
+			return NewARCCache(capacity, cost)
+		}))
+	Register(policyEngine(Policy2Q, "2Q algorithm", nil,
+		func(_ *Config, capacity int64, cost func(interface{}) int64, _ interface{}) listenedCache {
+			return NewTwoQueueCache(capacity, cost)
+		}))
+	Register(policyEngine(PolicySLRU, "Segmented LRU", nil,
+		func(_ *Config, capacity int64, cost func(interface{}) int64, _ interface{}) listenedCache {
+			return NewSLRUCache(capacity, cost)
+		}))
+	Register(policyEngine(PolicyWTinyLFU, "LRU admission window in front of a Segmented LRU guarded by TinyLFU", nil,
+		func(cfg *Config, capacity int64, cost func(interface{}) int64, _ interface{}) listenedCache {
+			return NewWTinyLFUCache(cfg.MaxEntries, capacity, cost)
+		}))
+}
+
+// listenedCache is a Cache that supports removal listeners
+type listenedCache interface {
+	Cache
+	SetRemovalListener(listener RemovalListener)
+}
+
+// policyEngine returns an engine creating a synchronous cache implementation.
+// The cache is bounded by MaxEntries entries, or by MaxMemoryUsage bytes when
+// BoundByMemory is set.
+func policyEngine(name, description string, options func() interface{}, newCache func(cfg *Config, capacity int64, cost func(interface{}) int64, options interface{}) listenedCache) Engine {
+	return Engine{
+		Name:        name,
+		Description: description,
+		Options:     options,
+		New: func(cfg *Config, options interface{}) (Cache, error) {
+			if cfg.MaxEntries == 0 {
+				return &nullCache{onRemove: cfg.OnRemove}, nil
+			}
+			capacity, cost := cfg.MaxEntries, func(_ interface{}) int64 {
+				return 1
+			}
+			if cfg.BoundByMemory && cfg.MaxMemoryUsage > 0 {
+				capacity, cost = cfg.MaxMemoryUsage, cfg.cost()
+			}
+			cache := newCache(cfg, capacity, cost, options)
+			if cfg.OnRemove != nil {
+				cache.SetRemovalListener(cfg.OnRemove)
+			}
+			return cache, nil
+		},
+	}
+}
+
+// Config is the configuration options for a cache instance. It can be decoded
+// from YAML or JSON with ParseConfig.
 type Config struct {
 	// MaxEntries is the estimated amount of entries that the cache will hold at capacity
-	MaxEntries int64
-	// MaxMemoryUsage is the maximum amount of memory the cache can handle
-	MaxMemoryUsage int64
+	MaxEntries int64 `json:"max_entries,omitempty"`
+	// MaxMemoryUsage is the maximum amount of memory the cache can handle. The LFU
+	// cache is always bounded by it; the other policies only when BoundByMemory is set.
+	MaxMemoryUsage int64 `json:"max_memory_usage,omitempty"`
+	// BoundByMemory makes the LRU, ARC, 2Q, SLRU and W-TinyLFU caches bounded by
+	// MaxMemoryUsage, using the estimated size of their values, instead of by
+	// MaxEntries.
+	BoundByMemory bool `json:"bound_by_memory,omitempty"`
+	// Cost estimates the memory used by a value, in bytes. It defaults to EstimateSize.
+	Cost func(value interface{}) int64 `json:"-"`
 	// LFU toggles whether to use a new cache implementation with a TinyLFU admission policy
-	LFU bool
+	LFU bool `json:"lfu,omitempty"`
+	// Policy selects the eviction policy by name (see the Policy constants). When it is
+	// empty, the policy is chosen by the LFU flag.
+	Policy string `json:"policy,omitempty"`
+	// Engine selects a registered engine by name (see Register). The built-in
+	// engines are named after the Policy constants. It takes precedence over
+	// Policy and LFU.
+	Engine string `json:"engine,omitempty"`
+	// Options are the options of the engine. They are either a value of the
+	// options type of the engine, or YAML or JSON, as a string or []byte,
+	// decoded into it. Unknown options are an error.
+	Options interface{} `json:"options,omitempty"`
+	// OnRemove, if set, is notified whenever an entry leaves the cache, with the
+	// reason it was removed. It is called synchronously; use an
+	// AsyncRemovalListener to deliver notifications in the background.
+	OnRemove RemovalListener `json:"-"`
+	// KeepKeys makes the LFU cache store the original key of every entry, so that
+	// it can implement KeyIterator and PrefixDeleter, and be snapshotted. The
+	// other policies always keep their keys.
+	KeepKeys bool `json:"keep_keys,omitempty"`
+	// HotKeys is the number of keys whose accesses the LFU cache counts to
+	// report the hottest keys; see HotKeysReporter. The keys are only named
+	// when KeepKeys is set. Zero disables it.
+	HotKeys int `json:"hot_keys,omitempty"`
+	// Shards is the number of independently locked segments the LRU cache is split
+	// into. Values lower than 2 use a single LRUCache guarded by one lock.
+	Shards int `json:"shards,omitempty"`
+}
+
+// policy returns the name of the engine selected by the config
+func (cfg *Config) policy() string {
+	if cfg.Engine != "" {
+		return cfg.Engine
+	}
+	if cfg.Policy != "" {
+		return cfg.Policy
+	}
+	if cfg.LFU {
+		return PolicyLFU
+	}
+	return PolicyLRU
+}
+
+// cost returns the function used to estimate the size of the values in the cache
+func (cfg *Config) cost() func(interface{}) int64 {
+	if cfg.Cost != nil {
+		return cfg.Cost
+	}
+	return EstimateSize
 }
 
 // DefaultConfig is the default configuration for a cache instance in Vitess
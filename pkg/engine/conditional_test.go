@@ -0,0 +1,112 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalCache(t *testing.T) {
+	unit := func(_ interface{}) int64 { return 1 }
+	caches := map[string]ConditionalCache{
+		"LRU":        NewLRUCache(100, unit),
+		"ShardedLRU": NewShardedLRUCache(100, 8, unit),
+		"LFU":        NewRistrettoCache(100, 100, unit),
+	}
+	incr := func(value interface{}, found bool) (interface{}, bool) {
+		if !found {
+			return 1, true
+		}
+		return value.(int) + 1, true
+	}
+	for name, cache := range caches {
+		t.Run(name, func(t *testing.T) {
+			c := cache.(Cache)
+
+			require.False(t, cache.Replace("a", 1))
+			require.True(t, cache.SetIfAbsent("a", 1))
+			require.False(t, cache.SetIfAbsent("a", 2))
+			require.True(t, cache.Replace("a", 3))
+			require.False(t, cache.CompareAndSwap("a", 1, 4))
+			require.True(t, cache.CompareAndSwap("a", 3, 4))
+			c.Wait()
+			v, ok := c.Get("a")
+			require.True(t, ok)
+			require.Equal(t, 4, v)
+
+			// Uncomparable values never match instead of panicking.
+			value := []byte("v")
+			require.True(t, cache.SetIfAbsent("b", value))
+			require.False(t, cache.CompareAndSwap("b", value, []byte("w")))
+			require.False(t, cache.CompareAndSwap("b", map[string]int{}, 1))
+			c.Wait()
+			v, ok = c.Get("b")
+			require.True(t, ok)
+			require.Equal(t, value, v)
+			boxed := struct{ v interface{} }{[]int{1}}
+			_, ok = cache.Compute("b", func(interface{}, bool) (interface{}, bool) { return boxed, true })
+			require.True(t, ok)
+			require.False(t, cache.CompareAndSwap("b", boxed, 1))
+
+			v, ok = cache.Compute("a", func(interface{}, bool) (interface{}, bool) { return nil, false })
+			require.False(t, ok)
+			require.Nil(t, v)
+			c.Wait()
+			_, ok = c.Get("a")
+			require.False(t, ok)
+
+			// Concurrent increments are never lost.
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						cache.Compute("n", incr)
+					}
+				}()
+			}
+			wg.Wait()
+			c.Wait()
+			v, ok = c.Get("n")
+			require.True(t, ok)
+			require.Equal(t, 200, v)
+		})
+	}
+}
+
+func TestLRUCacheConditionalNotifies(t *testing.T) {
+	var removed []RemovalReason
+	cache := NewLRUCache(2, func(_ interface{}) int64 { return 1 })
+	cache.SetRemovalListener(func(key string, value interface{}, reason RemovalReason) {
+		removed = append(removed, reason)
+	})
+
+	cache.SetIfAbsent("a", 1)
+	cache.Replace("a", 2)
+	cache.CompareAndSwap("a", 2, 3)
+	cache.Compute("a", func(interface{}, bool) (interface{}, bool) { return nil, false })
+	require.Equal(t, []RemovalReason{Replaced, Replaced, Deleted}, removed)
+	require.Equal(t, uint64(3), cache.Stats().Sets)
+}
@@ -0,0 +1,231 @@
+package distributed
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/bhojpur/cache/pkg/engine"
+)
+
+var (
+	// ErrNoGetter is returned by NewGroup when no Getter is given.
+	ErrNoGetter = errors.New("distributed: nil Getter")
+	// ErrGetterPanicked is returned to the callers waiting on a load whose
+	// Getter panicked. The caller that ran the Getter gets the panic.
+	ErrGetterPanicked = errors.New("distributed: Getter panicked")
+)
+
+// A Getter loads the value of a key from the backing store. It is only called
+// on the peer that owns the key.
+type Getter interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetterFunc implements Getter with a function.
+type GetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+// Get calls f(ctx, key).
+func (f GetterFunc) Get(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// PeerGetter fetches the value of a key from a remote peer.
+type PeerGetter interface {
+	Get(ctx context.Context, group string, key string) ([]byte, error)
+}
+
+// PeerPicker finds the peer that owns a key. It returns false when the key is
+// owned by the current peer, or when there are no peers.
+type PeerPicker interface {
+	PickPeer(key string) (PeerGetter, bool)
+}
+
+// GroupConfig holds the caches used by a Group.
+type GroupConfig struct {
+	// Cache holds the keys owned by this peer. It defaults to a null cache, in
+	// which case every Get for an owned key calls the Getter.
+	Cache engine.Cache
+	// HotCache optionally keeps copies of keys owned by other peers, so that
+	// popular remote keys don't need a network round trip. It should be much
+	// smaller than Cache.
+	HotCache engine.Cache
+	// Peers finds the owner of a key. Without it the group behaves like a
+	// local read-through cache.
+	Peers PeerPicker
+}
+
+// GroupStats are the counters kept by a Group.
+type GroupStats struct {
+	Gets           int64 // any Get request, including from peers
+	CacheHits      int64 // either cache was good
+	PeerLoads      int64 // remote loads that succeeded
+	PeerErrors     int64 // remote loads that failed
+	Loads          int64 // gets - cacheHits
+	LoadsDeduped   int64 // after singleflight
+	LocalLoads     int64 // total good local loads
+	LocalLoadErrs  int64 // total bad local loads
+	ServerRequests int64 // gets that came over the network from peers
+}
+
+// Group is a named, read-through cache whose keys are spread over a set of
+// peers. Values are byte slices that must not be modified by the caller.
+type Group struct {
+	name   string
+	getter Getter
+	peers  PeerPicker
+
+	mainCache engine.Cache
+	hotCache  engine.Cache
+
+	loadGroup  flightGroup // loads of local gets
+	serveGroup flightGroup // loads of gets served for other peers
+	stats      GroupStats
+}
+
+// NewGroup creates a Group that loads missing keys through the given Getter.
+func NewGroup(name string, getter Getter, cfg *GroupConfig) (*Group, error) {
+	if getter == nil {
+		return nil, ErrNoGetter
+	}
+	if cfg == nil {
+		cfg = &GroupConfig{}
+	}
+	g := &Group{
+		name:      name,
+		getter:    getter,
+		peers:     cfg.Peers,
+		mainCache: cfg.Cache,
+		hotCache:  cfg.HotCache,
+	}
+	if g.mainCache == nil {
+		g.mainCache = engine.NewDefaultCacheImpl(nil)
+	}
+	return g, nil
+}
+
+// Name returns the name of the group.
+func (g *Group) Name() string {
+	return g.name
+}
+
+// Get returns the value of the key, looking it up in the local caches first,
+// then asking the owner of the key, and finally loading it locally.
+func (g *Group) Get(ctx context.Context, key string) ([]byte, error) {
+	return g.get(ctx, key, true)
+}
+
+// get looks the key up, forwarding misses to the owner only if remote is true.
+// Requests served for other peers are never forwarded again, so peers with
+// diverging views of the ring cannot bounce a key between each other. They
+// also don't join the local loads, which may be waiting on the very peer that
+// sent the request.
+func (g *Group) get(ctx context.Context, key string, remote bool) ([]byte, error) {
+	atomic.AddInt64(&g.stats.Gets, 1)
+	if value, ok := g.lookupCache(key); ok {
+		atomic.AddInt64(&g.stats.CacheHits, 1)
+		return value, nil
+	}
+	atomic.AddInt64(&g.stats.Loads, 1)
+	flights := &g.loadGroup
+	if !remote {
+		flights = &g.serveGroup
+	}
+	return flights.Do(key, func() ([]byte, error) {
+		// Another caller may have filled the cache while we waited for
+		// the flight group.
+		if value, ok := g.lookupCache(key); ok {
+			atomic.AddInt64(&g.stats.CacheHits, 1)
+			return value, nil
+		}
+		atomic.AddInt64(&g.stats.LoadsDeduped, 1)
+		if remote && g.peers != nil {
+			if peer, ok := g.peers.PickPeer(key); ok {
+				value, err := peer.Get(ctx, g.name, key)
+				if err == nil {
+					atomic.AddInt64(&g.stats.PeerLoads, 1)
+					if g.hotCache != nil {
+						g.hotCache.Set(key, value)
+					}
+					return value, nil
+				}
+				// Fall back to loading the key locally when the owner
+				// cannot be reached.
+				atomic.AddInt64(&g.stats.PeerErrors, 1)
+			}
+		}
+		value, err := g.getter.Get(ctx, key)
+		if err != nil {
+			atomic.AddInt64(&g.stats.LocalLoadErrs, 1)
+			return nil, err
+		}
+		atomic.AddInt64(&g.stats.LocalLoads, 1)
+		g.mainCache.Set(key, value)
+		return value, nil
+	})
+}
+
+func (g *Group) lookupCache(key string) ([]byte, bool) {
+	if value, ok := g.mainCache.Get(key); ok {
+		return value.([]byte), true
+	}
+	if g.hotCache != nil {
+		if value, ok := g.hotCache.Get(key); ok {
+			return value.([]byte), true
+		}
+	}
+	return nil, false
+}
+
+// Remove drops the key from the local caches of this peer only.
+func (g *Group) Remove(key string) {
+	g.mainCache.Delete(key)
+	if g.hotCache != nil {
+		g.hotCache.Delete(key)
+	}
+}
+
+// Stats returns a snapshot of the group counters.
+func (g *Group) Stats() GroupStats {
+	return GroupStats{
+		Gets:           atomic.LoadInt64(&g.stats.Gets),
+		CacheHits:      atomic.LoadInt64(&g.stats.CacheHits),
+		PeerLoads:      atomic.LoadInt64(&g.stats.PeerLoads),
+		PeerErrors:     atomic.LoadInt64(&g.stats.PeerErrors),
+		Loads:          atomic.LoadInt64(&g.stats.Loads),
+		LoadsDeduped:   atomic.LoadInt64(&g.stats.LoadsDeduped),
+		LocalLoads:     atomic.LoadInt64(&g.stats.LocalLoads),
+		LocalLoadErrs:  atomic.LoadInt64(&g.stats.LocalLoadErrs),
+		ServerRequests: atomic.LoadInt64(&g.stats.ServerRequests),
+	}
+}
+
+// MainCache returns the cache holding the keys owned by this peer.
+func (g *Group) MainCache() engine.Cache {
+	return g.mainCache
+}
+
+// HotCache returns the cache holding copies of remote keys, or nil.
+func (g *Group) HotCache() engine.Cache {
+	return g.hotCache
+}
@@ -0,0 +1,306 @@
+package distributed
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/cache/pkg/engine"
+	"github.com/bhojpur/cache/pkg/file/types"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func bytesSize(value interface{}) int64 {
+	return int64(len(value.([]byte)))
+}
+
+// testPeer is a peer of an in-process cluster listening on loopback.
+type testPeer struct {
+	pool  *GRPCPool
+	group *Group
+	loads int64
+}
+
+// startCluster starts n peers serving a group that loads "<key>@<peer>" from
+// the peer that owns the key.
+func startCluster(t *testing.T, n int, hot bool) []*testPeer {
+	t.Helper()
+	var nodes []*types.NodeID
+	var listeners []net.Listener
+	for i := 0; i < n; i++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		node, err := types.NewNodeID("127.0.0.1", lis.Addr().(*net.TCPAddr).Port)
+		require.NoError(t, err)
+		nodes = append(nodes, node)
+		listeners = append(listeners, lis)
+	}
+
+	peers := make([]*testPeer, n)
+	for i, node := range nodes {
+		peer := &testPeer{pool: NewGRPCPool(NodeAddr(node), nil)}
+		cfg := &GroupConfig{Cache: engine.NewLRUCache(1024, bytesSize)}
+		if hot {
+			cfg.HotCache = engine.NewLRUCache(256, bytesSize)
+		}
+		self := peer.pool.Self()
+		group, err := peer.pool.NewGroup("test", GetterFunc(func(_ context.Context, key string) ([]byte, error) {
+			atomic.AddInt64(&peer.loads, 1)
+			if key == "missing" {
+				return nil, errors.New("not found")
+			}
+			return []byte(key + "@" + self), nil
+		}), cfg)
+		require.NoError(t, err)
+		peer.group = group
+		require.NoError(t, peer.pool.SetNodes(nodes...))
+
+		srv := grpc.NewServer()
+		peer.pool.Register(srv)
+		go srv.Serve(listeners[i])
+		t.Cleanup(func() {
+			srv.Stop()
+			peer.pool.Close()
+		})
+		peers[i] = peer
+	}
+	return peers
+}
+
+func TestGroupFetchesFromOwner(t *testing.T) {
+	peers := startCluster(t, 3, false)
+	ctx := context.Background()
+	ring := NewRing(DefaultReplicas, nil)
+	for _, peer := range peers {
+		ring.Add(peer.pool.Self())
+	}
+
+	const numKeys = 60
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("key%d", i)
+		owner := ring.Get(key)
+		for _, peer := range peers {
+			value, err := peer.group.Get(ctx, key)
+			require.NoError(t, err)
+			require.Equal(t, key+"@"+owner, string(value))
+		}
+	}
+
+	// Every key was loaded exactly once, by its owner.
+	var loads int64
+	for _, peer := range peers {
+		loads += atomic.LoadInt64(&peer.loads)
+		stats := peer.group.Stats()
+		require.Equal(t, stats.LocalLoads, atomic.LoadInt64(&peer.loads))
+		require.Zero(t, stats.PeerErrors)
+	}
+	require.Equal(t, int64(numKeys), loads)
+}
+
+func TestGroupHotCache(t *testing.T) {
+	peers := startCluster(t, 2, true)
+	ctx := context.Background()
+
+	// Find a key owned by the second peer and read it from the first one.
+	var key string
+	for i := 0; ; i++ {
+		key = fmt.Sprintf("key%d", i)
+		if _, ok := peers[0].pool.PickPeer(key); ok {
+			break
+		}
+	}
+	value, err := peers[0].group.Get(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, key+"@"+peers[1].pool.Self(), string(value))
+
+	hot, ok := peers[0].group.HotCache().Get(key)
+	require.True(t, ok)
+	require.Equal(t, value, hot)
+	_, ok = peers[0].group.MainCache().Get(key)
+	require.False(t, ok)
+
+	// The second read is served from the hot cache.
+	_, err = peers[0].group.Get(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), peers[0].group.Stats().PeerLoads)
+	require.Equal(t, int64(1), peers[1].group.Stats().ServerRequests)
+}
+
+func TestGroupErrors(t *testing.T) {
+	peers := startCluster(t, 2, false)
+	ctx := context.Background()
+	for _, peer := range peers {
+		_, err := peer.group.Get(ctx, "missing")
+		require.Error(t, err)
+	}
+
+	_, err := NewGroup("nil", nil, nil)
+	require.Equal(t, ErrNoGetter, err)
+}
+
+func TestGroupFallsBackWhenOwnerIsDown(t *testing.T) {
+	pool := NewGRPCPool("127.0.0.1:1", nil)
+	defer pool.Close()
+	// Nothing listens on the other peer, so every remote load fails.
+	require.NoError(t, pool.Set("127.0.0.1:1", "127.0.0.1:2"))
+	var loads int64
+	group, err := pool.NewGroup("test", GetterFunc(func(_ context.Context, key string) ([]byte, error) {
+		atomic.AddInt64(&loads, 1)
+		return []byte(key), nil
+	}), nil)
+	require.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		value, err := group.Get(context.Background(), fmt.Sprintf("key%d", i))
+		require.NoError(t, err)
+		require.Equal(t, fmt.Sprintf("key%d", i), string(value))
+	}
+	require.Equal(t, int64(20), loads)
+	require.NotZero(t, group.Stats().PeerErrors)
+}
+
+func TestGroupDeduplicatesLoads(t *testing.T) {
+	var loads int64
+	release := make(chan struct{})
+	group, err := NewGroup("local", GetterFunc(func(_ context.Context, key string) ([]byte, error) {
+		atomic.AddInt64(&loads, 1)
+		<-release
+		return []byte(key), nil
+	}), &GroupConfig{Cache: engine.NewLRUCache(1024, bytesSize)})
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := group.Get(context.Background(), "key")
+			require.NoError(t, err)
+			require.Equal(t, "key", string(value))
+		}()
+	}
+	for atomic.LoadInt64(&group.stats.Loads) < 10 {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+	require.Equal(t, int64(1), loads)
+}
+
+// peerFunc picks a peer for every key.
+type peerFunc func(ctx context.Context, group string, key string) ([]byte, error)
+
+func (f peerFunc) PickPeer(string) (PeerGetter, bool) { return f, true }
+
+func (f peerFunc) Get(ctx context.Context, group string, key string) ([]byte, error) {
+	return f(ctx, group, key)
+}
+
+func TestGroupServedGetsDontJoinLocalLoads(t *testing.T) {
+	// Both peers think the other one owns the key, and both get it at once.
+	var groups [2]*Group
+	var forwarding sync.WaitGroup
+	forwarding.Add(2)
+	for i := range groups {
+		other := &groups[1-i]
+		self := fmt.Sprint(i)
+		group, err := NewGroup("test", GetterFunc(func(_ context.Context, key string) ([]byte, error) {
+			return []byte(key + "@" + self), nil
+		}), &GroupConfig{Peers: peerFunc(func(ctx context.Context, _ string, key string) ([]byte, error) {
+			forwarding.Done()
+			forwarding.Wait()
+			return (*other).get(ctx, key, false)
+		})})
+		require.NoError(t, err)
+		groups[i] = group
+	}
+
+	results := make(chan string, 2)
+	for i := range groups {
+		go func(g *Group) {
+			value, err := g.Get(context.Background(), "key")
+			require.NoError(t, err)
+			results <- string(value)
+		}(groups[i])
+	}
+	got := map[string]bool{}
+	for range groups {
+		select {
+		case value := <-results:
+			got[value] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("served get waiting on a local load")
+		}
+	}
+	require.Equal(t, map[string]bool{"key@0": true, "key@1": true}, got)
+}
+
+func TestGroupGetterPanic(t *testing.T) {
+	var loads int64
+	started := make(chan struct{})
+	release := make(chan struct{})
+	group, err := NewGroup("local", GetterFunc(func(_ context.Context, key string) ([]byte, error) {
+		if atomic.AddInt64(&loads, 1) == 1 {
+			close(started)
+			<-release
+			panic("boom")
+		}
+		return []byte(key), nil
+	}), nil)
+	require.NoError(t, err)
+
+	go func() {
+		defer func() { recover() }()
+		group.Get(context.Background(), "key")
+	}()
+	<-started
+	waiter := make(chan error, 1)
+	go func() {
+		_, err := group.Get(context.Background(), "key")
+		waiter <- err
+	}()
+	for atomic.LoadInt64(&group.stats.Loads) < 2 {
+		runtime.Gosched()
+	}
+	close(release)
+
+	select {
+	case err := <-waiter:
+		// The waiter may also have started a load of its own after the panic.
+		if err != nil {
+			require.Equal(t, ErrGetterPanicked, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiter blocked by a panicking Getter")
+	}
+	value, err := group.Get(context.Background(), "key")
+	require.NoError(t, err)
+	require.Equal(t, "key", string(value))
+}
@@ -0,0 +1,236 @@
+package distributed
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	v1 "github.com/bhojpur/cache/pkg/api/v1"
+	"github.com/bhojpur/cache/pkg/file/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ PeerPicker = &GRPCPool{}
+var _ v1.PeerServiceServer = &GRPCPool{}
+
+// GRPCPoolOptions are the configurations of a GRPCPool.
+type GRPCPoolOptions struct {
+	// Replicas is the number of virtual nodes per peer on the ring.
+	// If zero, it defaults to DefaultReplicas.
+	Replicas int
+	// HashFn is the hash function of the ring. It defaults to CRC-32.
+	HashFn HashFunc
+	// DialOptions are used when connecting to other peers. They default to
+	// an insecure connection.
+	DialOptions []grpc.DialOption
+}
+
+// GRPCPool is the set of peers of the current process. It picks the owner of
+// a key on a consistent-hash ring, connects to the other peers over gRPC, and
+// serves the groups of this peer to them through the PeerService.
+type GRPCPool struct {
+	v1.UnimplementedPeerServiceServer
+
+	self string
+	opts GRPCPoolOptions
+
+	mu      sync.RWMutex
+	ring    *Ring
+	clients map[string]*grpcGetter
+	groups  map[string]*Group
+}
+
+// NewGRPCPool creates a pool for the peer listening at the given address,
+// which must be spelled the same way in the peer list given to Set.
+func NewGRPCPool(self string, opts *GRPCPoolOptions) *GRPCPool {
+	p := &GRPCPool{
+		self:    self,
+		ring:    NewRing(0, nil),
+		clients: make(map[string]*grpcGetter),
+		groups:  make(map[string]*Group),
+	}
+	if opts != nil {
+		p.opts = *opts
+	}
+	if len(p.opts.DialOptions) == 0 {
+		p.opts.DialOptions = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	return p
+}
+
+// NodeAddr returns the gRPC address of a node.
+func NodeAddr(node *types.NodeID) string {
+	return net.JoinHostPort(node.IP, strconv.Itoa(node.Port))
+}
+
+// Self returns the address of the current peer.
+func (p *GRPCPool) Self() string {
+	return p.self
+}
+
+// Set replaces the set of peers. The list should include the current peer.
+// Connections to peers that are no longer in the list are closed.
+func (p *GRPCPool) Set(peers ...string) error {
+	ring := NewRing(p.opts.Replicas, p.opts.HashFn)
+	ring.Add(peers...)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clients := make(map[string]*grpcGetter, len(peers))
+	for _, peer := range peers {
+		if peer == p.self {
+			continue
+		}
+		if c, ok := p.clients[peer]; ok {
+			clients[peer] = c
+			continue
+		}
+		conn, err := grpc.Dial(peer, p.opts.DialOptions...)
+		if err != nil {
+			for addr, c := range clients {
+				if _, ok := p.clients[addr]; !ok {
+					c.conn.Close()
+				}
+			}
+			return err
+		}
+		clients[peer] = &grpcGetter{conn: conn, client: v1.NewPeerServiceClient(conn)}
+	}
+	for addr, c := range p.clients {
+		if _, ok := clients[addr]; !ok {
+			c.conn.Close()
+		}
+	}
+	p.ring = ring
+	p.clients = clients
+	return nil
+}
+
+// SetNodes replaces the set of peers with the given nodes.
+func (p *GRPCPool) SetNodes(nodes ...*types.NodeID) error {
+	peers := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		peers = append(peers, NodeAddr(node))
+	}
+	return p.Set(peers...)
+}
+
+// PickPeer returns the peer that owns the key, unless it's the current peer.
+func (p *GRPCPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	peer := p.ring.Get(key)
+	if peer == "" || peer == p.self {
+		return nil, false
+	}
+	c, ok := p.clients[peer]
+	return c, ok
+}
+
+// NewGroup creates a Group that uses this pool to find its peers and registers
+// it so that other peers can fetch its keys.
+func (p *GRPCPool) NewGroup(name string, getter Getter, cfg *GroupConfig) (*Group, error) {
+	var c GroupConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	c.Peers = p
+	g, err := NewGroup(name, getter, &c)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.groups[name] = g
+	p.mu.Unlock()
+	return g, nil
+}
+
+// Group returns the group registered with the given name, or nil.
+func (p *GRPCPool) Group(name string) *Group {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.groups[name]
+}
+
+// RemoveGroup unregisters the group with the given name.
+func (p *GRPCPool) RemoveGroup(name string) {
+	p.mu.Lock()
+	delete(p.groups, name)
+	p.mu.Unlock()
+}
+
+// Register registers the PeerService of the pool with a gRPC server.
+func (p *GRPCPool) Register(srv *grpc.Server) {
+	v1.RegisterPeerServiceServer(srv, p)
+}
+
+// Get serves a key of one of the groups of this peer to another peer.
+func (p *GRPCPool) Get(ctx context.Context, req *v1.PeerGetRequest) (*v1.PeerGetResponse, error) {
+	g := p.Group(req.Group)
+	if g == nil {
+		return nil, status.Errorf(codes.NotFound, "no such group: %s", req.Group)
+	}
+	atomic.AddInt64(&g.stats.ServerRequests, 1)
+	value, err := g.get(ctx, req.Key, false)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, status.Error(codes.Unknown, err.Error())
+	}
+	return &v1.PeerGetResponse{Value: value}, nil
+}
+
+// Close closes the connections to all the other peers.
+func (p *GRPCPool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var err error
+	for addr, c := range p.clients {
+		if cerr := c.conn.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(p.clients, addr)
+	}
+	p.ring = NewRing(p.opts.Replicas, p.opts.HashFn)
+	return err
+}
+
+// grpcGetter fetches keys from a remote peer through its PeerService.
+type grpcGetter struct {
+	conn   *grpc.ClientConn
+	client v1.PeerServiceClient
+}
+
+func (g *grpcGetter) Get(ctx context.Context, group string, key string) ([]byte, error) {
+	res, err := g.client.Get(ctx, &v1.PeerGetRequest{Group: group, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return res.Value, nil
+}
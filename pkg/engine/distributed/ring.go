@@ -0,0 +1,101 @@
+package distributed
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package distributed spreads the keys of a cache over several peers, in the
+// style of groupcache. Every key is owned by exactly one peer, chosen with a
+// consistent-hash ring; the other peers fetch it from the owner on a miss
+// instead of loading it from the backing store themselves.
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the number of virtual nodes placed on the ring for every peer.
+const DefaultReplicas = 50
+
+// HashFunc maps data to a position on the ring. It must return the same value
+// on every peer, so it cannot depend on per-process seeds.
+type HashFunc func(data []byte) uint32
+
+// Ring is a consistent-hash ring. Every node is placed on the ring several
+// times ("virtual nodes") to even out the share of keys each node owns, and a
+// key belongs to the first node found clockwise from its own hash.
+//
+// A Ring is not safe for concurrent modification; build a new one to change
+// the set of nodes.
+type Ring struct {
+	hash     HashFunc
+	replicas int
+	keys     []uint32
+	nodes    map[uint32]string
+}
+
+// NewRing creates an empty ring with the given number of virtual nodes per
+// node. A nil hash function defaults to CRC-32 (IEEE).
+func NewRing(replicas int, fn HashFunc) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	if fn == nil {
+		fn = crc32.ChecksumIEEE
+	}
+	return &Ring{
+		hash:     fn,
+		replicas: replicas,
+		nodes:    make(map[uint32]string),
+	}
+}
+
+// IsEmpty returns true if there are no nodes on the ring.
+func (r *Ring) IsEmpty() bool {
+	return len(r.keys) == 0
+}
+
+// Add places the given nodes on the ring.
+func (r *Ring) Add(nodes ...string) {
+	for _, node := range nodes {
+		for i := 0; i < r.replicas; i++ {
+			h := r.hash([]byte(strconv.Itoa(i) + node))
+			if _, ok := r.nodes[h]; !ok {
+				r.keys = append(r.keys, h)
+			}
+			r.nodes[h] = node
+		}
+	}
+	sort.Slice(r.keys, func(i, j int) bool { return r.keys[i] < r.keys[j] })
+}
+
+// Get returns the node that owns the given key, or an empty string if the
+// ring is empty.
+func (r *Ring) Get(key string) string {
+	if r.IsEmpty() {
+		return ""
+	}
+	h := r.hash([]byte(key))
+	idx := sort.Search(len(r.keys), func(i int) bool { return r.keys[i] >= h })
+	if idx == len(r.keys) {
+		idx = 0
+	}
+	return r.nodes[r.keys[idx]]
+}
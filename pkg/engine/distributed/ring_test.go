@@ -0,0 +1,101 @@
+package distributed
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRingPlacement(t *testing.T) {
+	// Use the virtual node index and the node name as their own positions,
+	// so that the placement is easy to follow.
+	ring := NewRing(3, func(data []byte) uint32 {
+		i, err := strconv.Atoi(string(data))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(i)
+	})
+	require.True(t, ring.IsEmpty())
+	require.Equal(t, "", ring.Get("1"))
+
+	// Nodes 2, 4, 6 give virtual nodes 2, 4, 6, 12, 14, 16, 22, 24, 26.
+	ring.Add("6", "4", "2")
+	cases := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "4",
+		"27": "2",
+	}
+	for key, node := range cases {
+		require.Equal(t, node, ring.Get(key), "key %s", key)
+	}
+
+	// Node 8 gives virtual nodes 8, 18, 28; only 27 moves.
+	ring.Add("8")
+	cases["27"] = "8"
+	for key, node := range cases {
+		require.Equal(t, node, ring.Get(key), "key %s", key)
+	}
+}
+
+func TestRingConsistency(t *testing.T) {
+	a := NewRing(DefaultReplicas, nil)
+	b := NewRing(DefaultReplicas, nil)
+	a.Add("peer-a", "peer-b", "peer-c")
+	b.Add("peer-c", "peer-a", "peer-b")
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		require.Equal(t, a.Get(key), b.Get(key))
+	}
+}
+
+func TestRingBalanceAndMovement(t *testing.T) {
+	const numKeys = 10000
+	ring := NewRing(DefaultReplicas, nil)
+	ring.Add("peer-a", "peer-b", "peer-c")
+
+	before := make(map[string]string, numKeys)
+	counts := make(map[string]int)
+	for i := 0; i < numKeys; i++ {
+		key := fmt.Sprintf("key%d", i)
+		before[key] = ring.Get(key)
+		counts[before[key]]++
+	}
+	for node, n := range counts {
+		require.InDelta(t, numKeys/3, n, numKeys/6, "node %s owns %d keys", node, n)
+	}
+
+	// Adding a fourth peer must only move keys to the new peer.
+	ring.Add("peer-d")
+	moved := 0
+	for key, node := range before {
+		if now := ring.Get(key); now != node {
+			require.Equal(t, "peer-d", now)
+			moved++
+		}
+	}
+	require.InDelta(t, numKeys/4, moved, numKeys/8)
+}
@@ -0,0 +1,297 @@
+package invalidation
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package invalidation keeps the caches of several replicas coherent by
+// broadcasting Delete, Clear and tag invalidations between them.
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bhojpur/cache/pkg/engine"
+)
+
+// ErrClosed is returned when publishing on a closed bus or transport.
+var ErrClosed = errors.New("invalidation: closed")
+
+// Op is the kind of an invalidation message.
+type Op int32
+
+const (
+	// OpSync carries no invalidation; it tells subscribers the latest sequence
+	// number of the source so that they can detect missed messages.
+	OpSync Op = iota
+	// OpDelete removes a single key.
+	OpDelete
+	// OpClear removes every key.
+	OpClear
+	// OpTag removes every key carrying a tag.
+	OpTag
+)
+
+// String returns a human readable name for the op.
+func (op Op) String() string {
+	switch op {
+	case OpSync:
+		return "sync"
+	case OpDelete:
+		return "delete"
+	case OpClear:
+		return "clear"
+	case OpTag:
+		return "tag"
+	default:
+		return fmt.Sprintf("op(%d)", int32(op))
+	}
+}
+
+// Message is an invalidation published by a bus. Every bus numbers its
+// messages from 1 within an epoch, which grows when the bus is recreated.
+type Message struct {
+	Source string
+	Epoch  int64
+	Seq    uint64
+	Op     Op
+	// Key is the key of an OpDelete, or the tag of an OpTag.
+	Key string
+}
+
+// Transport carries messages between the buses of several replicas.
+type Transport interface {
+	// Publish sends a message to the other replicas.
+	Publish(msg *Message) error
+	// Messages returns the messages received from the other replicas. The
+	// channel is closed when the transport is closed.
+	Messages() <-chan *Message
+	// Close releases the resources of the transport.
+	Close() error
+}
+
+// TagInvalidator is implemented by caches that can invalidate the entries
+// carrying a tag. Caches that don't implement it are cleared instead when a
+// tag invalidation arrives.
+type TagInvalidator interface {
+	InvalidateTag(tag string)
+}
+
+// sourceState is the position of the bus in the stream of a source.
+type sourceState struct {
+	epoch int64
+	seq   uint64
+}
+
+// BusStats are the counters kept by a Bus.
+type BusStats struct {
+	Published int64 // messages published by this bus
+	Received  int64 // messages received from other replicas
+	Gaps      int64 // times missed messages were detected
+}
+
+// Bus broadcasts invalidations of the local caches to the other replicas,
+// and applies the invalidations they publish to the local caches.
+//
+// Messages from every source are expected in sequence. When a gap shows up,
+// for instance after a subscriber reconnects, the bus can't know what it
+// missed and clears every subscribed cache.
+type Bus struct {
+	id        string
+	epoch     int64
+	transport Transport
+
+	pubMu sync.Mutex
+	seq   uint64
+
+	mu      sync.Mutex
+	caches  map[int]engine.Cache
+	nextID  int
+	sources map[string]sourceState
+	stats   BusStats
+	closed  bool
+
+	done chan struct{}
+}
+
+// NewBus creates a bus with the given replica id on top of a transport, and
+// starts applying the messages received from it.
+func NewBus(id string, transport Transport) *Bus {
+	b := &Bus{
+		id:        id,
+		epoch:     time.Now().UnixNano(),
+		transport: transport,
+		caches:    make(map[int]engine.Cache),
+		sources:   make(map[string]sourceState),
+		done:      make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// ID returns the replica id of the bus.
+func (b *Bus) ID() string {
+	return b.id
+}
+
+// Subscribe adds a local cache to the bus. The returned function removes it.
+func (b *Bus) Subscribe(cache engine.Cache) (unsubscribe func()) {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.caches[id] = cache
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		delete(b.caches, id)
+		b.mu.Unlock()
+	}
+}
+
+// Delete removes the key from the subscribed caches of every replica.
+func (b *Bus) Delete(key string) error {
+	return b.publish(OpDelete, key)
+}
+
+// Clear clears the subscribed caches of every replica.
+func (b *Bus) Clear() error {
+	return b.publish(OpClear, "")
+}
+
+// InvalidateTag removes the entries carrying the tag from the subscribed
+// caches of every replica.
+func (b *Bus) InvalidateTag(tag string) error {
+	return b.publish(OpTag, tag)
+}
+
+// publish applies the invalidation locally and sends it to the other
+// replicas. Sequence numbers are assigned under pubMu so that they reach the
+// transport in order.
+func (b *Bus) publish(op Op, key string) error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return ErrClosed
+	}
+	b.apply(op, key)
+	b.stats.Published++
+	b.mu.Unlock()
+
+	b.pubMu.Lock()
+	defer b.pubMu.Unlock()
+	b.seq++
+	return b.transport.Publish(&Message{
+		Source: b.id,
+		Epoch:  b.epoch,
+		Seq:    b.seq,
+		Op:     op,
+		Key:    key,
+	})
+}
+
+// apply runs an invalidation on the subscribed caches. It must be called with
+// mu held.
+func (b *Bus) apply(op Op, key string) {
+	for _, cache := range b.caches {
+		switch op {
+		case OpDelete:
+			cache.Delete(key)
+		case OpClear:
+			cache.Clear()
+		case OpTag:
+			if tc, ok := cache.(TagInvalidator); ok {
+				tc.InvalidateTag(key)
+			} else {
+				cache.Clear()
+			}
+		}
+	}
+}
+
+func (b *Bus) run() {
+	defer close(b.done)
+	for msg := range b.transport.Messages() {
+		b.receive(msg)
+	}
+}
+
+// receive applies a message from another replica, clearing the caches first
+// if messages from its source went missing.
+func (b *Bus) receive(msg *Message) {
+	if msg.Source == b.id {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stats.Received++
+
+	state, known := b.sources[msg.Source]
+	switch {
+	case known && msg.Epoch < state.epoch:
+		// A late message from before the source was restarted.
+		return
+	case known && msg.Epoch > state.epoch:
+		// The source was restarted and numbers its messages from 1 again.
+		state = sourceState{epoch: msg.Epoch}
+	}
+	if !known {
+		// There is nothing to compare the first message of a source with.
+		b.sources[msg.Source] = sourceState{epoch: msg.Epoch, seq: msg.Seq}
+		b.apply(msg.Op, msg.Key)
+		return
+	}
+
+	expected := state.seq + 1
+	if msg.Op == OpSync {
+		expected = state.seq
+	}
+	switch {
+	case msg.Seq < expected:
+		// Already seen.
+		return
+	case msg.Seq > expected:
+		b.stats.Gaps++
+		b.apply(OpClear, "")
+	}
+	b.sources[msg.Source] = sourceState{epoch: msg.Epoch, seq: msg.Seq}
+	b.apply(msg.Op, msg.Key)
+}
+
+// Stats returns a snapshot of the bus counters.
+func (b *Bus) Stats() BusStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
+
+// Close closes the transport and waits for the received messages to be applied.
+func (b *Bus) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+	err := b.transport.Close()
+	<-b.done
+	return err
+}
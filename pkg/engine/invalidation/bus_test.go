@@ -0,0 +1,203 @@
+package invalidation
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bhojpur/cache/pkg/engine"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestCache() *engine.LRUCache {
+	return engine.NewLRUCache(100, func(_ interface{}) int64 { return 1 })
+}
+
+// tagCache records the tags it was asked to invalidate.
+type tagCache struct {
+	*engine.LRUCache
+	mu   sync.Mutex
+	tags []string
+}
+
+func (c *tagCache) InvalidateTag(tag string) {
+	c.mu.Lock()
+	c.tags = append(c.tags, tag)
+	c.mu.Unlock()
+}
+
+func (c *tagCache) invalidated() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.tags...)
+}
+
+func eventuallyMissing(t *testing.T, cache engine.Cache, key string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		_, ok := cache.Get(key)
+		return !ok
+	}, time.Second, time.Millisecond)
+}
+
+func TestBusBroadcast(t *testing.T) {
+	hub := NewChannelHub(0)
+	var buses []*Bus
+	var caches []*engine.LRUCache
+	for _, id := range []string{"a", "b", "c"} {
+		bus := NewBus(id, hub.Connect())
+		defer bus.Close()
+		cache := newTestCache()
+		bus.Subscribe(cache)
+		cache.Set("k1", 1)
+		cache.Set("k2", 2)
+		buses = append(buses, bus)
+		caches = append(caches, cache)
+	}
+
+	require.NoError(t, buses[0].Delete("k1"))
+	for _, cache := range caches {
+		eventuallyMissing(t, cache, "k1")
+		_, ok := cache.Get("k2")
+		require.True(t, ok)
+	}
+
+	require.NoError(t, buses[1].Clear())
+	for _, cache := range caches {
+		require.Eventually(t, func() bool { return cache.Len() == 0 }, time.Second, time.Millisecond)
+	}
+	require.Equal(t, int64(1), buses[0].Stats().Published)
+	require.Eventually(t, func() bool { return buses[2].Stats().Received == 2 }, time.Second, time.Millisecond)
+	require.Zero(t, buses[2].Stats().Gaps)
+}
+
+func TestBusInvalidateTag(t *testing.T) {
+	hub := NewChannelHub(0)
+	a := NewBus("a", hub.Connect())
+	defer a.Close()
+	b := NewBus("b", hub.Connect())
+	defer b.Close()
+
+	tagged := &tagCache{LRUCache: newTestCache()}
+	tagged.Set("k", 1)
+	plain := newTestCache()
+	plain.Set("k", 1)
+	b.Subscribe(tagged)
+	b.Subscribe(plain)
+
+	require.NoError(t, a.InvalidateTag("tenant-1"))
+	require.Eventually(t, func() bool { return len(tagged.invalidated()) == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, []string{"tenant-1"}, tagged.invalidated())
+	// The cache that knows about tags keeps its other entries, the plain one
+	// can only be cleared.
+	require.Equal(t, 1, tagged.Len())
+	require.Eventually(t, func() bool { return plain.Len() == 0 }, time.Second, time.Millisecond)
+}
+
+func TestBusUnsubscribe(t *testing.T) {
+	hub := NewChannelHub(0)
+	a := NewBus("a", hub.Connect())
+	defer a.Close()
+	cache := newTestCache()
+	cache.Set("k", 1)
+	unsubscribe := a.Subscribe(cache)
+	unsubscribe()
+	require.NoError(t, a.Delete("k"))
+	_, ok := cache.Get("k")
+	require.True(t, ok)
+}
+
+func TestBusGapClearsCaches(t *testing.T) {
+	hub := NewChannelHub(0)
+	raw := hub.Connect()
+	defer raw.Close()
+	b := NewBus("b", hub.Connect())
+	defer b.Close()
+	cache := newTestCache()
+	b.Subscribe(cache)
+
+	send := func(msg *Message) {
+		received := b.Stats().Received
+		require.NoError(t, raw.Publish(msg))
+		require.Eventually(t, func() bool { return b.Stats().Received == received+1 }, time.Second, time.Millisecond)
+	}
+
+	cache.Set("k1", 1)
+	cache.Set("k2", 2)
+	send(&Message{Source: "a", Epoch: 1, Seq: 1, Op: OpDelete, Key: "k1"})
+	require.Equal(t, 1, cache.Len())
+
+	// A duplicate is ignored.
+	cache.Set("k1", 1)
+	send(&Message{Source: "a", Epoch: 1, Seq: 1, Op: OpDelete, Key: "k1"})
+	require.Equal(t, 2, cache.Len())
+
+	// A restarted source numbers its messages from 1 again.
+	send(&Message{Source: "a", Epoch: 2, Seq: 1, Op: OpDelete, Key: "k1"})
+	require.Equal(t, 1, cache.Len())
+	require.Zero(t, b.Stats().Gaps)
+
+	// A late message from the previous epoch is dropped.
+	cache.Set("k1", 1)
+	send(&Message{Source: "a", Epoch: 1, Seq: 5, Op: OpDelete, Key: "k2"})
+	require.Equal(t, 2, cache.Len())
+	send(&Message{Source: "a", Epoch: 2, Seq: 2, Op: OpDelete, Key: "k1"})
+	require.Equal(t, 1, cache.Len())
+	require.Zero(t, b.Stats().Gaps)
+
+	// Message 3 went missing.
+	cache.Set("k1", 1)
+	send(&Message{Source: "a", Epoch: 2, Seq: 4, Op: OpDelete, Key: "k1"})
+	require.Equal(t, 0, cache.Len())
+	require.Equal(t, int64(1), b.Stats().Gaps)
+
+	// A sync message reporting the latest sequence number is not a gap...
+	cache.Set("k1", 1)
+	send(&Message{Source: "a", Epoch: 2, Seq: 4, Op: OpSync})
+	require.Equal(t, 1, cache.Len())
+	// ... unless messages were published after the last one we saw.
+	send(&Message{Source: "a", Epoch: 2, Seq: 6, Op: OpSync})
+	require.Equal(t, 0, cache.Len())
+	require.Equal(t, int64(2), b.Stats().Gaps)
+}
+
+func TestChannelTransportDropsWhenFull(t *testing.T) {
+	hub := NewChannelHub(1)
+	a := NewBus("a", hub.Connect())
+	defer a.Close()
+	slow := hub.Connect()
+	defer slow.Close()
+
+	require.NoError(t, a.Delete("k1"))
+	require.NoError(t, a.Delete("k2"))
+	msg := <-slow.Messages()
+	require.Equal(t, uint64(1), msg.Seq)
+	select {
+	case msg := <-slow.Messages():
+		t.Fatalf("unexpected message %v", msg)
+	default:
+	}
+
+	require.NoError(t, a.Close())
+	require.Equal(t, ErrClosed, a.Delete("k3"))
+}
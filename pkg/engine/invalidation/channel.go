@@ -0,0 +1,104 @@
+package invalidation
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sync"
+)
+
+// DefaultBufferSize is the number of received messages a transport queues
+// before it starts dropping them.
+const DefaultBufferSize = 1024
+
+// ChannelHub connects the ChannelTransports of buses living in the same
+// process, mostly for tests.
+type ChannelHub struct {
+	mu         sync.RWMutex
+	transports map[*ChannelTransport]struct{}
+	bufferSize int
+}
+
+// NewChannelHub creates a hub whose transports queue up to bufferSize
+// received messages each. Zero means DefaultBufferSize.
+func NewChannelHub(bufferSize int) *ChannelHub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	return &ChannelHub{
+		transports: make(map[*ChannelTransport]struct{}),
+		bufferSize: bufferSize,
+	}
+}
+
+// Connect returns a new transport attached to the hub.
+func (h *ChannelHub) Connect() *ChannelTransport {
+	t := &ChannelTransport{
+		hub: h,
+		ch:  make(chan *Message, h.bufferSize),
+	}
+	h.mu.Lock()
+	h.transports[t] = struct{}{}
+	h.mu.Unlock()
+	return t
+}
+
+// ChannelTransport is an in-process Transport. Messages are delivered to
+// every other transport of the hub; when the queue of a receiver is full the
+// message is dropped for it, and its bus notices the gap on the next message.
+type ChannelTransport struct {
+	hub *ChannelHub
+	ch  chan *Message
+}
+
+// Publish delivers the message to the other transports of the hub.
+func (t *ChannelTransport) Publish(msg *Message) error {
+	t.hub.mu.RLock()
+	defer t.hub.mu.RUnlock()
+	if _, ok := t.hub.transports[t]; !ok {
+		return ErrClosed
+	}
+	for other := range t.hub.transports {
+		if other == t {
+			continue
+		}
+		select {
+		case other.ch <- msg:
+		default:
+		}
+	}
+	return nil
+}
+
+// Messages returns the messages published by the other transports of the hub.
+func (t *ChannelTransport) Messages() <-chan *Message {
+	return t.ch
+}
+
+// Close detaches the transport from the hub.
+func (t *ChannelTransport) Close() error {
+	t.hub.mu.Lock()
+	defer t.hub.mu.Unlock()
+	if _, ok := t.hub.transports[t]; ok {
+		delete(t.hub.transports, t)
+		close(t.ch)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package invalidation
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	v1 "github.com/bhojpur/cache/pkg/api/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var _ Transport = &GRPCTransport{}
+var _ v1.InvalidationServiceServer = &GRPCTransport{}
+
+// GRPCTransportOptions are the configurations of a GRPCTransport.
+type GRPCTransportOptions struct {
+	// BufferSize is the number of messages queued for every subscriber, and
+	// for the local bus. Zero means DefaultBufferSize.
+	BufferSize int
+	// RetryInterval is the delay before resubscribing to a peer after its
+	// stream broke. Zero means one second.
+	RetryInterval time.Duration
+	// DialOptions are used when connecting to other peers. They default to
+	// an insecure connection.
+	DialOptions []grpc.DialOption
+}
+
+// GRPCTransport carries invalidations between cachesvr nodes. Every node
+// serves the messages it publishes through the InvalidationService, and
+// subscribes to the service of every other node.
+//
+// A subscriber that can't keep up is disconnected rather than allowed to
+// grow its queue. When it resubscribes, the first message of the stream
+// carries the latest sequence number of the node, which lets its bus detect
+// what it missed.
+type GRPCTransport struct {
+	v1.UnimplementedInvalidationServiceServer
+
+	self     string
+	opts     GRPCTransportOptions
+	incoming chan *Message
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu          sync.Mutex
+	subscribers map[chan *v1.InvalidationMessage]struct{}
+	peers       map[string]struct{}
+	last        *v1.InvalidationMessage
+	closed      bool
+}
+
+// NewGRPCTransport creates a transport for the node with the given address.
+// Register it with a gRPC server, and Connect it to the other nodes.
+func NewGRPCTransport(self string, opts *GRPCTransportOptions) *GRPCTransport {
+	t := &GRPCTransport{
+		self:        self,
+		subscribers: make(map[chan *v1.InvalidationMessage]struct{}),
+		peers:       make(map[string]struct{}),
+	}
+	if opts != nil {
+		t.opts = *opts
+	}
+	if t.opts.BufferSize <= 0 {
+		t.opts.BufferSize = DefaultBufferSize
+	}
+	if t.opts.RetryInterval <= 0 {
+		t.opts.RetryInterval = time.Second
+	}
+	if len(t.opts.DialOptions) == 0 {
+		t.opts.DialOptions = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	t.incoming = make(chan *Message, t.opts.BufferSize)
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	return t
+}
+
+// Register registers the InvalidationService of the transport with a gRPC server.
+func (t *GRPCTransport) Register(srv *grpc.Server) {
+	v1.RegisterInvalidationServiceServer(srv, t)
+}
+
+// Connect subscribes to the invalidations published by the given nodes. The
+// subscriptions are kept alive until the transport is closed.
+func (t *GRPCTransport) Connect(peers ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	for _, peer := range peers {
+		if _, ok := t.peers[peer]; ok || peer == t.self {
+			continue
+		}
+		t.peers[peer] = struct{}{}
+		t.wg.Add(1)
+		go t.follow(peer)
+	}
+}
+
+// follow subscribes to a peer, resubscribing whenever the stream breaks.
+func (t *GRPCTransport) follow(peer string) {
+	defer t.wg.Done()
+	conn, err := grpc.DialContext(t.ctx, peer, t.opts.DialOptions...)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	client := v1.NewInvalidationServiceClient(conn)
+	for {
+		stream, err := client.Subscribe(t.ctx, &v1.InvalidationSubscribeRequest{Subscriber: t.self}, grpc.WaitForReady(true))
+		if err == nil {
+			for {
+				msg, err := stream.Recv()
+				if err != nil {
+					break
+				}
+				select {
+				case t.incoming <- fromProto(msg):
+				case <-t.ctx.Done():
+					return
+				}
+			}
+		}
+		select {
+		case <-t.ctx.Done():
+			return
+		case <-time.After(t.opts.RetryInterval):
+		}
+	}
+}
+
+// Subscribe streams the messages published by this node to another node.
+func (t *GRPCTransport) Subscribe(req *v1.InvalidationSubscribeRequest, stream v1.InvalidationService_SubscribeServer) error {
+	ch := make(chan *v1.InvalidationMessage, t.opts.BufferSize)
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return status.Error(codes.Unavailable, "transport closed")
+	}
+	if t.last != nil {
+		ch <- &v1.InvalidationMessage{
+			Source: t.last.Source,
+			Epoch:  t.last.Epoch,
+			Seq:    t.last.Seq,
+			Op:     v1.InvalidationOp_INVALIDATION_OP_SYNC,
+		}
+	}
+	t.subscribers[ch] = struct{}{}
+	t.mu.Unlock()
+
+	defer func() {
+		t.mu.Lock()
+		delete(t.subscribers, ch)
+		t.mu.Unlock()
+	}()
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return status.Errorf(codes.ResourceExhausted, "subscriber %s fell behind", req.Subscriber)
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case <-t.ctx.Done():
+			return status.Error(codes.Unavailable, "transport closed")
+		}
+	}
+}
+
+// Publish queues the message for every subscribed node. Nodes whose queue is
+// full are disconnected.
+func (t *GRPCTransport) Publish(msg *Message) error {
+	pb := toProto(msg)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return ErrClosed
+	}
+	t.last = pb
+	for ch := range t.subscribers {
+		select {
+		case ch <- pb:
+		default:
+			delete(t.subscribers, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
+
+// Messages returns the messages received from the other nodes.
+func (t *GRPCTransport) Messages() <-chan *Message {
+	return t.incoming
+}
+
+// Close stops serving subscribers and drops the subscriptions to other nodes.
+func (t *GRPCTransport) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+	t.cancel()
+	t.wg.Wait()
+	close(t.incoming)
+	return nil
+}
+
+func toProto(msg *Message) *v1.InvalidationMessage {
+	return &v1.InvalidationMessage{
+		Source: msg.Source,
+		Epoch:  msg.Epoch,
+		Seq:    msg.Seq,
+		Op:     v1.InvalidationOp(msg.Op),
+		Key:    msg.Key,
+	}
+}
+
+func fromProto(msg *v1.InvalidationMessage) *Message {
+	return &Message{
+		Source: msg.Source,
+		Epoch:  msg.Epoch,
+		Seq:    msg.Seq,
+		Op:     Op(msg.Op),
+		Key:    msg.Key,
+	}
+}
@@ -0,0 +1,112 @@
+package invalidation
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+// grpcNode is a cachesvr node serving its invalidations on loopback.
+type grpcNode struct {
+	addr      string
+	transport *GRPCTransport
+	lis       net.Listener
+	server    *grpc.Server
+}
+
+func (n *grpcNode) serve(t *testing.T) {
+	if n.lis == nil {
+		var err error
+		n.lis, err = net.Listen("tcp", n.addr)
+		require.NoError(t, err)
+	}
+	n.server = grpc.NewServer()
+	n.transport.Register(n.server)
+	go n.server.Serve(n.lis)
+}
+
+func (n *grpcNode) stop() {
+	n.server.Stop()
+	n.lis.Close()
+	n.lis = nil
+}
+
+func startNode(t *testing.T) *grpcNode {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	n := &grpcNode{addr: lis.Addr().String(), lis: lis}
+	n.transport = NewGRPCTransport(n.addr, &GRPCTransportOptions{RetryInterval: 10 * time.Millisecond})
+	n.serve(t)
+	return n
+}
+
+func TestGRPCTransport(t *testing.T) {
+	n0, n1 := startNode(t), startNode(t)
+	defer n1.stop()
+	n0.transport.Connect(n1.addr)
+	n1.transport.Connect(n0.addr)
+
+	b0 := NewBus("n0", n0.transport)
+	defer b0.Close()
+	b1 := NewBus("n1", n1.transport)
+	defer b1.Close()
+	c0, c1 := newTestCache(), newTestCache()
+	b0.Subscribe(c0)
+	b1.Subscribe(c1)
+
+	// Wait for both subscriptions to be established.
+	subscribed := func(n *grpcNode) func() bool {
+		return func() bool {
+			n.transport.mu.Lock()
+			defer n.transport.mu.Unlock()
+			return len(n.transport.subscribers) == 1
+		}
+	}
+	require.Eventually(t, subscribed(n0), time.Second, time.Millisecond)
+	require.Eventually(t, subscribed(n1), time.Second, time.Millisecond)
+
+	c1.Set("k1", 1)
+	c1.Set("k2", 2)
+	require.NoError(t, b0.Delete("k1"))
+	eventuallyMissing(t, c1, "k1")
+	c0.Set("k2", 2)
+	require.NoError(t, b1.Delete("k2"))
+	eventuallyMissing(t, c0, "k2")
+
+	// n1 loses its connection to n0 and misses an invalidation.
+	n0.stop()
+	require.Eventually(t, func() bool { return !subscribed(n0)() }, time.Second, time.Millisecond)
+	c1.Set("k3", 3)
+	require.NoError(t, b0.Delete("k2"))
+	require.Equal(t, 1, c1.Len())
+
+	// When n1 resubscribes, the sync message shows the gap and n1 clears
+	// everything it holds.
+	n0.serve(t)
+	defer n0.stop()
+	require.Eventually(t, func() bool { return c1.Len() == 0 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, int64(1), b1.Stats().Gaps)
+}
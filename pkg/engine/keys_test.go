@@ -0,0 +1,87 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyIteration(t *testing.T) {
+	caches := map[string]Cache{
+		"lru":         NewLRUCache(100, func(_ interface{}) int64 { return 1 }),
+		"sharded-lru": NewShardedLRUCache(100, 4, func(_ interface{}) int64 { return 1 }),
+		"lfu":         NewDefaultCacheImpl(&Config{MaxEntries: 100, MaxMemoryUsage: 1 << 20, LFU: true, KeepKeys: true}),
+	}
+	for name, cache := range caches {
+		t.Run(name, func(t *testing.T) {
+			rec := &removalRecorder{}
+			if lc, ok := cache.(listenedCache); ok {
+				lc.SetRemovalListener(rec.listen)
+			}
+			for _, key := range []string{"user:1", "user:2", "order:1", "order:2", "order:3"} {
+				cache.Set(key, key)
+			}
+			cache.Wait()
+
+			iter := cache.(KeyIterator)
+			seen := make(map[string]interface{})
+			iter.ForEachKV(func(key string, value interface{}) bool {
+				seen[key] = value
+				return true
+			})
+			require.Len(t, seen, 5)
+			for key, value := range seen {
+				require.Equal(t, key, value)
+			}
+
+			keys := iter.Keys("order:")
+			sort.Strings(keys)
+			require.Equal(t, []string{"order:1", "order:2", "order:3"}, keys)
+
+			require.Equal(t, 3, cache.(PrefixDeleter).DeletePrefix("order:"))
+			cache.Wait()
+			require.Empty(t, iter.Keys("order:"))
+			require.Equal(t, 2, cache.Len())
+			if _, ok := cache.(listenedCache); ok {
+				require.Equal(t, 3, rec.count(Deleted))
+			}
+		})
+	}
+}
+
+func TestLRUKeysOrder(t *testing.T) {
+	cache := NewLRUCache(100, func(_ interface{}) int64 { return 1 })
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Set("c", 3)
+	cache.Get("a")
+	require.Equal(t, []string{"a", "c", "b"}, cache.Keys(""))
+
+	var visited []string
+	cache.ForEachKV(func(key string, _ interface{}) bool {
+		visited = append(visited, key)
+		return len(visited) < 2
+	})
+	require.Equal(t, []string{"a", "c"}, visited)
+}
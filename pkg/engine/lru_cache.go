@@ -28,16 +28,21 @@ package engine
 
 import (
 	"container/list"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/bhojpur/cache/pkg/hack"
 )
 
 var _ Cache = &LRUCache{}
+var _ MultiCache = &LRUCache{}
+var _ ConditionalCache = &LRUCache{}
 
 // LRUCache is a typical LRU cache implementation.  If the cache
 // reaches the capacity, the least recently used item is deleted from
 // the cache. Note the capacity is not the number of items, but the
-// total sum of the CachedSize() of each item.
+// total sum of the cost of each item, as returned by the cost function.
 type LRUCache struct {
 	mu sync.Mutex
 
@@ -46,15 +51,11 @@ type LRUCache struct {
 	table map[string]*list.Element
 	cost  func(interface{}) int64
 
-	size      int64
-	capacity  int64
-	evictions int64
-}
+	onRemove RemovalListener
 
-// Item is what is stored in the cache
-type Item struct {
-	Key   string
-	Value interface{}
+	size     int64
+	capacity int64
+	statsCounters
 }
 
 type entry struct {
@@ -79,13 +80,35 @@ func NewLRUCache(capacity int64, cost func(interface{}) int64) *LRUCache {
 func (lru *LRUCache) Get(key string) (v interface{}, ok bool) {
 	lru.mu.Lock()
 	defer lru.mu.Unlock()
+	return lru.get(key)
+}
 
-	element := lru.table[key]
-	if element == nil {
-		return nil, false
+// GetMulti returns the values of several keys, and whether each was found,
+// marking the entries found as most recently used. The cache is locked once
+// for the whole batch.
+func (lru *LRUCache) GetMulti(keys []string) ([]interface{}, []bool) {
+	values, found := make([]interface{}, len(keys)), make([]bool, len(keys))
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+
+	for i, key := range keys {
+		values[i], found[i] = lru.get(key)
 	}
-	lru.moveToFront(element)
-	return element.Value.(*entry).value, true
+	return values, found
+}
+
+// SetMulti sets several values in the cache, locking it once for the whole
+// batch. Every item is always set.
+func (lru *LRUCache) SetMulti(items []Item) []bool {
+	results := make([]bool, len(items))
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+
+	for i, item := range items {
+		lru.set(item.Key, item.Value)
+		results[i] = true
+	}
+	return results
 }
 
 // Set sets a value in the cache.
@@ -93,15 +116,104 @@ func (lru *LRUCache) Set(key string, value interface{}) bool {
 	lru.mu.Lock()
 	defer lru.mu.Unlock()
 
+	lru.set(key, value)
+	// the LRU cache cannot fail to insert items; it always returns true
+	return true
+}
+
+// get looks up a key with the lock held.
+func (lru *LRUCache) get(key string) (interface{}, bool) {
+	element := lru.table[key]
+	if element == nil {
+		lru.recordGet(false)
+		return nil, false
+	}
+	lru.recordGet(true)
+	lru.moveToFront(element)
+	return element.Value.(*entry).value, true
+}
+
+// set sets a value with the lock held.
+func (lru *LRUCache) set(key string, value interface{}) {
+	lru.sets++
 	if element := lru.table[key]; element != nil {
 		lru.updateInplace(element, value)
 	} else {
 		lru.addNew(key, value)
 	}
-	// the LRU cache cannot fail to insert items; it always returns true
+}
+
+// SetIfAbsent sets a value in the cache only if the key is not in it, and
+// returns whether it was set.
+func (lru *LRUCache) SetIfAbsent(key string, value interface{}) bool {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+
+	if lru.table[key] != nil {
+		return false
+	}
+	lru.sets++
+	lru.addNew(key, value)
+	return true
+}
+
+// Replace sets a value in the cache only if the key is already in it, and
+// returns whether it was set.
+func (lru *LRUCache) Replace(key string, value interface{}) bool {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+
+	element := lru.table[key]
+	if element == nil {
+		return false
+	}
+	lru.sets++
+	lru.updateInplace(element, value)
+	return true
+}
+
+// CompareAndSwap sets the value of a key to new only if its current value is
+// old, and returns whether it was set. Values of uncomparable types, such as
+// []byte, never match.
+func (lru *LRUCache) CompareAndSwap(key string, old, new interface{}) bool {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+
+	element := lru.table[key]
+	if element == nil || !hack.Equal(element.Value.(*entry).value, old) {
+		return false
+	}
+	lru.sets++
+	lru.updateInplace(element, new)
 	return true
 }
 
+// Compute sets the value of a key to the result of fn, called with the lock
+// held and the current value of the key, or deletes the key if fn returns
+// false. It returns the new value and whether the key is in the cache.
+func (lru *LRUCache) Compute(key string, fn func(value interface{}, found bool) (interface{}, bool)) (interface{}, bool) {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+
+	var value interface{}
+	element := lru.table[key]
+	if element != nil {
+		value = element.Value.(*entry).value
+	}
+	value, keep := fn(value, element != nil)
+	switch {
+	case keep && element != nil:
+		lru.sets++
+		lru.updateInplace(element, value)
+	case keep:
+		lru.sets++
+		lru.addNew(key, value)
+	case element != nil:
+		lru.remove(element)
+	}
+	return value, keep
+}
+
 // Delete removes an entry from the cache, and returns if the entry existed.
 func (lru *LRUCache) delete(key string) bool {
 	lru.mu.Lock()
@@ -111,11 +223,17 @@ func (lru *LRUCache) delete(key string) bool {
 	if element == nil {
 		return false
 	}
+	lru.remove(element)
+	return true
+}
 
+// remove deletes an element with the lock held.
+func (lru *LRUCache) remove(element *list.Element) {
+	v := element.Value.(*entry)
 	lru.list.Remove(element)
-	delete(lru.table, key)
-	lru.size -= element.Value.(*entry).size
-	return true
+	delete(lru.table, v.key)
+	lru.size -= v.size
+	lru.onRemove.notify(v.key, v.value, Deleted)
 }
 
 // Delete removes an entry from the cache
@@ -128,6 +246,12 @@ func (lru *LRUCache) Clear() {
 	lru.mu.Lock()
 	defer lru.mu.Unlock()
 
+	if lru.onRemove != nil {
+		for e := lru.list.Front(); e != nil; e = e.Next() {
+			v := e.Value.(*entry)
+			lru.onRemove(v.key, v.value, Cleared)
+		}
+	}
 	lru.list.Init()
 	lru.table = make(map[string]*list.Element)
 	lru.size = 0
@@ -151,6 +275,13 @@ func (lru *LRUCache) SetCapacity(capacity int64) {
 	lru.checkCapacity()
 }
 
+// SetRemovalListener sets the listener notified whenever an entry leaves the cache.
+func (lru *LRUCache) SetRemovalListener(listener RemovalListener) {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+	lru.onRemove = listener
+}
+
 // Wait is a no-op in the LRU cache
 func (lru *LRUCache) Wait() {}
 
@@ -173,6 +304,13 @@ func (lru *LRUCache) Evictions() int64 {
 	return lru.evictions
 }
 
+// Stats returns a snapshot of the cache statistics.
+func (lru *LRUCache) Stats() Stats {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+	return lru.stats(lru.size, lru.capacity)
+}
+
 // ForEach yields all the values for the cache, ordered from most recently
 // used to least recently used.
 func (lru *LRUCache) ForEach(callback func(value interface{}) bool) {
@@ -201,9 +339,72 @@ func (lru *LRUCache) Items() []Item {
 	return items
 }
 
+// ForEachKV yields all the keys and values for the cache, ordered from most
+// recently used to least recently used.
+func (lru *LRUCache) ForEachKV(callback func(key string, value interface{}) bool) {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+
+	for e := lru.list.Front(); e != nil; e = e.Next() {
+		v := e.Value.(*entry)
+		if !callback(v.key, v.value) {
+			break
+		}
+	}
+}
+
+// Keys returns the keys in the cache that start with the given prefix, ordered
+// from most recently used to least recently used.
+func (lru *LRUCache) Keys(prefix string) []string {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+
+	var keys []string
+	for e := lru.list.Front(); e != nil; e = e.Next() {
+		if key := e.Value.(*entry).key; strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// DeletePrefix removes all the entries whose key starts with the given prefix,
+// and returns how many were removed.
+func (lru *LRUCache) DeletePrefix(prefix string) int {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+
+	var deleted int
+	for e := lru.list.Front(); e != nil; {
+		next := e.Next()
+		if v := e.Value.(*entry); strings.HasPrefix(v.key, prefix) {
+			lru.list.Remove(e)
+			delete(lru.table, v.key)
+			lru.size -= v.size
+			lru.onRemove.notify(v.key, v.value, Deleted)
+			deleted++
+		}
+		e = next
+	}
+	return deleted
+}
+
+// appendEntries appends a copy of every entry to the given slice, ordered from
+// most recently used to least recently used.
+func (lru *LRUCache) appendEntries(entries []entry) []entry {
+	lru.mu.Lock()
+	defer lru.mu.Unlock()
+
+	for e := lru.list.Front(); e != nil; e = e.Next() {
+		entries = append(entries, *e.Value.(*entry))
+	}
+	return entries
+}
+
 func (lru *LRUCache) updateInplace(element *list.Element, value interface{}) {
 	valueSize := lru.cost(value)
 	sizeDiff := valueSize - element.Value.(*entry).size
+	lru.onRemove.notify(element.Value.(*entry).key, element.Value.(*entry).value, Replaced)
 	element.Value.(*entry).value = value
 	element.Value.(*entry).size = valueSize
 	lru.size += sizeDiff
@@ -233,5 +434,6 @@ func (lru *LRUCache) checkCapacity() {
 		delete(lru.table, delValue.key)
 		lru.size -= delValue.size
 		lru.evictions++
+		lru.onRemove.notify(delValue.key, delValue.value, Evicted)
 	}
 }
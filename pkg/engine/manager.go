@@ -0,0 +1,313 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNamespaceExists is returned when creating a namespace whose name is taken.
+	ErrNamespaceExists = errors.New("engine: namespace already exists")
+	// ErrBudgetExhausted is returned when the minimum capacities of the
+	// namespaces would exceed the budget of the manager.
+	ErrBudgetExhausted = errors.New("engine: memory budget exhausted")
+)
+
+// hitRateFloor is added to the hit rate of every namespace when sharing the
+// budget, so that namespaces without traffic, or with a cold cache, still
+// get enough room to warm up.
+const hitRateFloor = 0.1
+
+// NamespaceConfig is the configuration of a namespace of a Manager.
+type NamespaceConfig struct {
+	// Config selects the cache implementation. Its MaxMemoryUsage and
+	// BoundByMemory are ignored: the capacity of the namespace is always in
+	// bytes, and it is decided by the manager.
+	Config
+	// Weight is the relative importance of the namespace when sharing the
+	// budget. It defaults to 1.
+	Weight float64
+	// MinCapacity is the capacity the namespace keeps whatever its hit rate.
+	MinCapacity int64
+}
+
+// NamespaceStats are the statistics of a namespace of a Manager.
+type NamespaceStats struct {
+	Stats
+	Weight   float64
+	Capacity int64
+}
+
+type namespace struct {
+	cache    Cache
+	weight   float64
+	min      int64
+	capacity int64
+	// score is the share of the budget the namespace asked for at the last
+	// rebalance where it saw any lookups.
+	score float64
+	// hits and misses seen at the last rebalance
+	hits, misses uint64
+}
+
+// Manager creates named caches that share a single memory budget, in bytes.
+// Every namespace starts with a share of the budget proportional to its
+// weight; Rebalance then moves capacity towards the namespaces that make the
+// best use of it, according to their weight and their hit rate since the
+// previous rebalance.
+type Manager struct {
+	budget int64
+
+	mu         sync.Mutex
+	namespaces map[string]*namespace
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewManager creates a manager for the given memory budget, in bytes.
+func NewManager(budget int64) *Manager {
+	return &Manager{
+		budget:     budget,
+		namespaces: make(map[string]*namespace),
+	}
+}
+
+// Budget returns the memory budget shared by the namespaces.
+func (m *Manager) Budget() int64 {
+	return m.budget
+}
+
+// Create creates a namespace with the given configuration, and rebalances
+// the budget between all the namespaces.
+func (m *Manager) Create(name string, cfg *NamespaceConfig) (Cache, error) {
+	var nc NamespaceConfig
+	if cfg != nil {
+		nc = *cfg
+	}
+	if nc.Weight <= 0 {
+		nc.Weight = 1
+	}
+	if nc.MaxEntries == 0 {
+		nc.MaxEntries = DefaultConfig.MaxEntries
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.namespaces[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrNamespaceExists, name)
+	}
+	min := nc.MinCapacity
+	for _, ns := range m.namespaces {
+		min += ns.min
+	}
+	if min > m.budget {
+		return nil, ErrBudgetExhausted
+	}
+
+	// The cache is created with the whole budget so that the policy is set up
+	// for byte accounting; it is still empty, and the rebalance shrinks it
+	// before the other namespaces are allowed to grow.
+	nc.MaxMemoryUsage = m.budget
+	nc.BoundByMemory = true
+	ns := &namespace{
+		cache:    NewDefaultCacheImpl(&nc.Config),
+		weight:   nc.Weight,
+		min:      nc.MinCapacity,
+		capacity: m.budget,
+		score:    nc.Weight,
+	}
+	m.namespaces[name] = ns
+	m.rebalance(false)
+	return ns.cache, nil
+}
+
+// Get returns the cache of the namespace with the given name.
+func (m *Manager) Get(name string) (Cache, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ns, ok := m.namespaces[name]
+	if !ok {
+		return nil, false
+	}
+	return ns.cache, true
+}
+
+// Drop removes the namespace with the given name, clears or closes its cache
+// and gives its capacity back to the other namespaces. It reports whether the
+// namespace existed.
+func (m *Manager) Drop(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ns, ok := m.namespaces[name]
+	if !ok {
+		return false
+	}
+	delete(m.namespaces, name)
+	// Caches with background workers, such as the LFU one, are closed so that
+	// their goroutines and buffers are released as well.
+	if c, ok := ns.cache.(interface{ Close() }); ok {
+		c.Close()
+	} else {
+		ns.cache.Clear()
+	}
+	m.rebalance(false)
+	return true
+}
+
+// Names returns the names of the namespaces, sorted.
+func (m *Manager) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.namespaces))
+	for name := range m.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stats returns the statistics of every namespace.
+func (m *Manager) Stats() map[string]NamespaceStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make(map[string]NamespaceStats, len(m.namespaces))
+	for name, ns := range m.namespaces {
+		stats[name] = NamespaceStats{
+			Stats:    ns.cache.Stats(),
+			Weight:   ns.weight,
+			Capacity: ns.capacity,
+		}
+	}
+	return stats
+}
+
+// Rebalance shares the budget between the namespaces according to their
+// weight and their hit rate since the previous rebalance.
+func (m *Manager) Rebalance() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rebalance(true)
+}
+
+// rebalance gives every namespace its minimum capacity, and splits the rest
+// of the budget in proportion to weight * (hitRateFloor + hit rate). When
+// measureHitRate is false, or a namespace saw no lookups since the last
+// rebalance, the namespace keeps its previous score; new namespaces are
+// scored by weight alone. Namespaces are shrunk before others grow, so that
+// the budget is never exceeded.
+func (m *Manager) rebalance(measureHitRate bool) {
+	if len(m.namespaces) == 0 {
+		return
+	}
+	var minTotal int64
+	var scoreTotal float64
+	scores := make(map[*namespace]float64, len(m.namespaces))
+	for _, ns := range m.namespaces {
+		if measureHitRate {
+			stats := ns.cache.Stats()
+			hits, misses := stats.Hits-ns.hits, stats.Misses-ns.misses
+			ns.hits, ns.misses = stats.Hits, stats.Misses
+			if hits+misses > 0 {
+				ns.score = ns.weight * (hitRateFloor + hitRatio(hits, misses))
+			}
+		}
+		scores[ns] = ns.score
+		scoreTotal += ns.score
+		minTotal += ns.min
+	}
+
+	spare := m.budget - minTotal
+	if spare < 0 {
+		spare = 0
+	}
+	targets := make(map[*namespace]int64, len(m.namespaces))
+	var assigned int64
+	for ns, score := range scores {
+		targets[ns] = ns.min + int64(float64(spare)*score/scoreTotal)
+		assigned += targets[ns]
+	}
+	// Rounding can only leave bytes unassigned; give them to the namespace
+	// with the highest score.
+	if rest := m.budget - assigned; rest > 0 {
+		var best *namespace
+		for ns := range scores {
+			if best == nil || scores[ns] > scores[best] {
+				best = ns
+			}
+		}
+		targets[best] += rest
+	}
+
+	for ns, target := range targets {
+		if target < ns.capacity {
+			ns.cache.SetCapacity(target)
+			ns.capacity = target
+		}
+	}
+	for ns, target := range targets {
+		if target > ns.capacity {
+			ns.cache.SetCapacity(target)
+			ns.capacity = target
+		}
+	}
+}
+
+// Start rebalances the budget periodically in the background, until Close is called.
+func (m *Manager) Start(interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		return
+	}
+	m.stop = make(chan struct{})
+	m.done = make(chan struct{})
+	go func(stop, done chan struct{}) {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Rebalance()
+			case <-stop:
+				return
+			}
+		}
+	}(m.stop, m.done)
+}
+
+// Close stops the background rebalancing started by Start.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,154 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func totalCapacity(m *Manager) int64 {
+	var total int64
+	for _, stats := range m.Stats() {
+		total += stats.Capacity
+	}
+	return total
+}
+
+func TestManagerCreateAndDrop(t *testing.T) {
+	m := NewManager(1000)
+	users, err := m.Create("users", &NamespaceConfig{Config: Config{MaxEntries: 100}})
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), users.MaxCapacity())
+
+	_, err = m.Create("users", nil)
+	require.True(t, errors.Is(err, ErrNamespaceExists))
+
+	orders, err := m.Create("orders", &NamespaceConfig{Config: Config{MaxEntries: 100}, Weight: 3})
+	require.NoError(t, err)
+	require.Equal(t, int64(250), users.MaxCapacity())
+	require.Equal(t, int64(750), orders.MaxCapacity())
+	require.Equal(t, []string{"orders", "users"}, m.Names())
+
+	cache, ok := m.Get("orders")
+	require.True(t, ok)
+	require.Equal(t, orders, cache)
+
+	orders.Set("key", "value")
+	require.True(t, m.Drop("orders"))
+	require.False(t, m.Drop("orders"))
+	require.Zero(t, orders.Len())
+	require.Equal(t, int64(1000), users.MaxCapacity())
+	_, ok = m.Get("orders")
+	require.False(t, ok)
+}
+
+func TestManagerDropClosesCache(t *testing.T) {
+	m := NewManager(1 << 20)
+	lfu, err := m.Create("lfu", &NamespaceConfig{Config: Config{MaxEntries: 100, LFU: true}})
+	require.NoError(t, err)
+	require.True(t, lfu.Set("key", "value"))
+	require.True(t, m.Drop("lfu"))
+	require.Zero(t, lfu.Len())
+	require.False(t, lfu.Set("key", "value"))
+}
+
+func TestManagerRebalancesByHitRate(t *testing.T) {
+	m := NewManager(100000)
+	hot, err := m.Create("hot", &NamespaceConfig{Config: Config{MaxEntries: 1000}})
+	require.NoError(t, err)
+	cold, err := m.Create("cold", &NamespaceConfig{Config: Config{MaxEntries: 1000}, MinCapacity: 10000})
+	require.NoError(t, err)
+
+	hot.Set("key", "value")
+	for i := 0; i < 100; i++ {
+		hot.Get("key")
+		cold.Get(fmt.Sprintf("missing%d", i))
+	}
+	m.Rebalance()
+
+	stats := m.Stats()
+	require.Greater(t, stats["hot"].Capacity, stats["cold"].Capacity)
+	require.GreaterOrEqual(t, stats["cold"].Capacity, int64(10000))
+	require.Equal(t, int64(100000), totalCapacity(m))
+	require.Equal(t, uint64(100), stats["cold"].Misses)
+
+	// Without traffic, the namespaces keep their share.
+	m.Rebalance()
+	require.Equal(t, stats["cold"].Capacity, m.Stats()["cold"].Capacity)
+
+	// Only the lookups since the previous rebalance count.
+	for i := 0; i < 100; i++ {
+		hot.Get(fmt.Sprintf("missing%d", i))
+		cold.Get(fmt.Sprintf("missing%d", i))
+	}
+	m.Rebalance()
+	stats = m.Stats()
+	require.Equal(t, int64(10000)+45000, stats["cold"].Capacity)
+}
+
+func TestManagerEnforcesBudget(t *testing.T) {
+	m := NewManager(1 << 20)
+	var caches []Cache
+	for i, policy := range []string{PolicyLRU, PolicyLFU, PolicyARC, PolicyWTinyLFU} {
+		cache, err := m.Create(policy, &NamespaceConfig{Config: Config{MaxEntries: 1000, Policy: policy}, Weight: float64(i + 1)})
+		require.NoError(t, err)
+		caches = append(caches, cache)
+	}
+	require.Equal(t, int64(1<<20), totalCapacity(m))
+
+	for _, cache := range caches {
+		for i := 0; i < 5000; i++ {
+			cache.Set(fmt.Sprintf("key%d", i), make([]byte, 512))
+		}
+		cache.Wait()
+	}
+	var used int64
+	for _, stats := range m.Stats() {
+		require.LessOrEqual(t, stats.UsedCapacity, stats.Capacity)
+		used += stats.UsedCapacity
+	}
+	require.LessOrEqual(t, used, m.Budget())
+
+	_, err := m.Create("greedy", &NamespaceConfig{MinCapacity: 2 << 20})
+	require.Equal(t, ErrBudgetExhausted, err)
+}
+
+func TestManagerBackgroundRebalance(t *testing.T) {
+	m := NewManager(1000)
+	a, err := m.Create("a", nil)
+	require.NoError(t, err)
+	_, err = m.Create("b", nil)
+	require.NoError(t, err)
+
+	a.Set("key", "value")
+	a.Get("key")
+	m.Start(time.Millisecond)
+	defer m.Close()
+	require.Eventually(t, func() bool {
+		return m.Stats()["a"].Capacity > 500
+	}, time.Second, time.Millisecond)
+	m.Close()
+}
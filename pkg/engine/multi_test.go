@@ -0,0 +1,72 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetSetMulti(t *testing.T) {
+	unit := func(_ interface{}) int64 { return 1 }
+	caches := map[string]Cache{
+		"LRU":        NewLRUCache(100, unit),
+		"ShardedLRU": NewShardedLRUCache(100, 8, unit),
+		"LFU":        NewDefaultCacheImpl(&Config{MaxEntries: 100, MaxMemoryUsage: 1 << 20, LFU: true, Cost: unit}),
+		"ARC":        NewARCCache(100, unit),
+	}
+	for name, cache := range caches {
+		t.Run(name, func(t *testing.T) {
+			items := make([]Item, 10)
+			for i := range items {
+				items[i] = Item{Key: fmt.Sprintf("key%d", i), Value: i}
+			}
+			require.Equal(t, []bool{true, true, true, true, true, true, true, true, true, true}, SetMulti(cache, items))
+			cache.Wait()
+
+			values, found := GetMulti(cache, []string{"key3", "missing", "key0", "key9"})
+			require.Equal(t, []interface{}{3, nil, 0, 9}, values)
+			require.Equal(t, []bool{true, false, true, true}, found)
+
+			// Updates are applied too.
+			require.Equal(t, []bool{true}, SetMulti(cache, []Item{{Key: "key3", Value: 33}}))
+			cache.Wait()
+			v, ok := cache.Get("key3")
+			require.True(t, ok)
+			require.Equal(t, 33, v)
+		})
+	}
+}
+
+func TestLRUCacheMultiEvicts(t *testing.T) {
+	cache := NewLRUCache(2, func(_ interface{}) int64 { return 1 })
+	cache.SetMulti([]Item{{Key: "a", Value: 1}, {Key: "b", Value: 2}, {Key: "c", Value: 3}})
+	require.Equal(t, 2, cache.Len())
+	require.Equal(t, int64(1), cache.Evictions())
+
+	_, found := cache.GetMulti([]string{"a", "b", "c"})
+	require.Equal(t, []bool{false, true, true}, found)
+	stats := cache.Stats()
+	require.Equal(t, uint64(2), stats.Hits)
+	require.Equal(t, uint64(1), stats.Misses)
+}
@@ -20,19 +20,35 @@ package engine
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
+import (
+	"sync/atomic"
+)
+
 // nullCache is a no-op cache that does not store items
-type nullCache struct{}
+type nullCache struct {
+	onRemove RemovalListener
+	misses   uint64
+	rejected uint64
+}
 
 // Get never returns anything on the nullCache
 func (n *nullCache) Get(_ string) (interface{}, bool) {
+	atomic.AddUint64(&n.misses, 1)
 	return nil, false
 }
 
-// Set is a no-op in the nullCache
-func (n *nullCache) Set(_ string, _ interface{}) bool {
+// Set is a no-op in the nullCache; every value is rejected
+func (n *nullCache) Set(key string, val interface{}) bool {
+	atomic.AddUint64(&n.rejected, 1)
+	n.onRemove.notify(key, val, Rejected)
 	return false
 }
 
+// SetRemovalListener sets the listener notified of every rejected Set
+func (n *nullCache) SetRemovalListener(listener RemovalListener) {
+	n.onRemove = listener
+}
+
 // ForEach iterates the nullCache, which is always empty
 func (n *nullCache) ForEach(_ func(interface{}) bool) {}
 
@@ -65,3 +81,12 @@ func (n *nullCache) SetCapacity(_ int64) {}
 func (n *nullCache) Evictions() int64 {
 	return 0
 }
+
+// Stats returns the statistics of the nullCache: every Get is a miss and
+// every Set is rejected
+func (n *nullCache) Stats() Stats {
+	return Stats{
+		Misses:       atomic.LoadUint64(&n.misses),
+		SetsRejected: atomic.LoadUint64(&n.rejected),
+	}
+}
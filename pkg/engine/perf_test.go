@@ -21,6 +21,9 @@ package engine
 // THE SOFTWARE.
 
 import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
 	"testing"
 )
 
@@ -38,3 +41,141 @@ func BenchmarkGet(b *testing.B) {
 		_ = val
 	}
 }
+
+func benchmarkParallelGet(b *testing.B, cache Cache) {
+	const numKeys = 1024
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		cache.Set(keys[i], make([]byte, 10))
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, ok := cache.Get(keys[i%numKeys]); !ok {
+				panic("error")
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkParallelGet(b *testing.B) {
+	b.Run("LRU", func(b *testing.B) {
+		benchmarkParallelGet(b, NewLRUCache(64*1024, func(_ interface{}) int64 { return 1 }))
+	})
+	for _, shards := range []int{4, 16, 64} {
+		b.Run(fmt.Sprintf("ShardedLRU-%d", shards), func(b *testing.B) {
+			benchmarkParallelGet(b, NewShardedLRUCache(64*1024, shards, func(_ interface{}) int64 { return 1 }))
+		})
+	}
+}
+
+func benchmarkMulti(b *testing.B, cache Cache, batch int) {
+	keys := make([]string, batch)
+	items := make([]Item, batch)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		items[i] = Item{Key: keys[i], Value: make([]byte, 10)}
+	}
+	SetMulti(cache, items)
+	cache.Wait()
+	b.ResetTimer()
+
+	b.Run("GetLoop", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, key := range keys {
+				cache.Get(key)
+			}
+		}
+	})
+	b.Run("GetMulti", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			GetMulti(cache, keys)
+		}
+	})
+	b.Run("ParallelGetLoop", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				for _, key := range keys {
+					cache.Get(key)
+				}
+			}
+		})
+	})
+	b.Run("ParallelGetMulti", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				GetMulti(cache, keys)
+			}
+		})
+	})
+	b.Run("SetLoop", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, item := range items {
+				cache.Set(item.Key, item.Value)
+			}
+		}
+	})
+	b.Run("SetMulti", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			SetMulti(cache, items)
+		}
+	})
+}
+
+func BenchmarkMulti(b *testing.B) {
+	const batch = 32
+	unit := func(_ interface{}) int64 { return 1 }
+	b.Run("LRU", func(b *testing.B) {
+		benchmarkMulti(b, NewLRUCache(64*1024, unit), batch)
+	})
+	b.Run("ShardedLRU-16", func(b *testing.B) {
+		benchmarkMulti(b, NewShardedLRUCache(64*1024, 16, unit), batch)
+	})
+	b.Run("LFU", func(b *testing.B) {
+		benchmarkMulti(b, NewRistrettoCache(64*1024, 64*1024*1024, unit), batch)
+	})
+}
+
+// BenchmarkGCPause measures the garbage collections of a heap holding a large
+// cache of byte slices: ns/op is the time of a full collection, and
+// pause-ns/gc the time the program was stopped for it.
+func BenchmarkGCPause(b *testing.B) {
+	const entries, size = 500000, 100
+	fill := func(cache Cache) {
+		for i := 0; i < entries; i++ {
+			cache.Set(fmt.Sprintf("key%d", i), make([]byte, size))
+		}
+	}
+	for _, bench := range []struct {
+		name  string
+		cache func() Cache
+	}{
+		{"LRU", func() Cache {
+			return NewLRUCache(1<<30, func(v interface{}) int64 { return int64(len(v.([]byte))) })
+		}},
+		{"Arena", func() Cache {
+			return NewArenaCache(1<<30, 0)
+		}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			cache := bench.cache()
+			fill(cache)
+			runtime.GC()
+
+			var before, after debug.GCStats
+			debug.ReadGCStats(&before)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+			b.StopTimer()
+			debug.ReadGCStats(&after)
+
+			b.ReportMetric(float64((after.PauseTotal-before.PauseTotal).Nanoseconds())/float64(b.N), "pause-ns/gc")
+			runtime.KeepAlive(cache)
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// policyCaches returns a constructor for every synchronous Cache implementation
+// that must satisfy the shared conformance tests.
+func policyCaches() map[string]func(capacity int64) Cache {
+	return map[string]func(capacity int64) Cache{
+		PolicyLRU: func(capacity int64) Cache {
+			return NewLRUCache(capacity, cacheValueSize)
+		},
+		"sharded-lru": func(capacity int64) Cache {
+			return NewShardedLRUCache(capacity, 4, cacheValueSize)
+		},
+		PolicyARC: func(capacity int64) Cache {
+			return NewARCCache(capacity, cacheValueSize)
+		},
+		Policy2Q: func(capacity int64) Cache {
+			return NewTwoQueueCache(capacity, cacheValueSize)
+		},
+		PolicySLRU: func(capacity int64) Cache {
+			return NewSLRUCache(capacity, cacheValueSize)
+		},
+		PolicyWTinyLFU: func(capacity int64) Cache {
+			return NewWTinyLFUCache(capacity, capacity, cacheValueSize)
+		},
+	}
+}
+
+func TestPolicyConformance(t *testing.T) {
+	for name, newCache := range policyCaches() {
+		newCache := newCache
+		t.Run(name, func(t *testing.T) {
+			t.Run("SetGetDelete", func(t *testing.T) {
+				cache := newCache(100)
+				value := &CacheValue{1}
+				require.True(t, cache.Set("key", value))
+
+				v, ok := cache.Get("key")
+				require.True(t, ok)
+				require.Equal(t, value, v)
+				require.Equal(t, 1, cache.Len())
+				require.Equal(t, int64(1), cache.UsedCapacity())
+
+				cache.Delete("key")
+				_, ok = cache.Get("key")
+				require.False(t, ok)
+				require.Equal(t, 0, cache.Len())
+				require.Equal(t, int64(0), cache.UsedCapacity())
+			})
+
+			t.Run("UpdateInPlace", func(t *testing.T) {
+				cache := newCache(100)
+				cache.Set("key", &CacheValue{1})
+				updated := &CacheValue{10}
+				cache.Set("key", updated)
+
+				v, ok := cache.Get("key")
+				require.True(t, ok)
+				require.Equal(t, updated, v)
+				require.Equal(t, 1, cache.Len())
+				require.Equal(t, int64(10), cache.UsedCapacity())
+			})
+
+			t.Run("Clear", func(t *testing.T) {
+				cache := newCache(100)
+				for i := 0; i < 10; i++ {
+					cache.Set(fmt.Sprintf("key%d", i), &CacheValue{1})
+				}
+				cache.Clear()
+				require.Equal(t, 0, cache.Len())
+				require.Equal(t, int64(0), cache.UsedCapacity())
+				_, ok := cache.Get("key0")
+				require.False(t, ok)
+			})
+
+			t.Run("CapacityIsObeyed", func(t *testing.T) {
+				cache := newCache(20)
+				const inserted = 200
+				for i := 0; i < inserted; i++ {
+					cache.Set(fmt.Sprintf("key%d", i), &CacheValue{1})
+					require.LessOrEqual(t, cache.UsedCapacity(), int64(20))
+				}
+				require.Equal(t, int64(cache.Len()), cache.UsedCapacity())
+				require.Equal(t, int64(inserted-cache.Len()), cache.Evictions())
+				require.Equal(t, int64(20), cache.MaxCapacity())
+			})
+
+			t.Run("SetCapacityShrinks", func(t *testing.T) {
+				cache := newCache(100)
+				for i := 0; i < 50; i++ {
+					cache.Set(fmt.Sprintf("key%d", i), &CacheValue{1})
+				}
+				cache.SetCapacity(10)
+				require.LessOrEqual(t, cache.UsedCapacity(), int64(10))
+				require.Equal(t, int64(10), cache.MaxCapacity())
+			})
+
+			t.Run("ForEach", func(t *testing.T) {
+				cache := newCache(100)
+				for i := 0; i < 10; i++ {
+					cache.Set(fmt.Sprintf("key%d", i), &CacheValue{1})
+				}
+				var all int
+				cache.ForEach(func(_ interface{}) bool {
+					all++
+					return true
+				})
+				require.Equal(t, cache.Len(), all)
+
+				var some int
+				cache.ForEach(func(_ interface{}) bool {
+					some++
+					return some < 3
+				})
+				require.Equal(t, 3, some)
+			})
+		})
+	}
+}
+
+func TestPolicyScanResistance(t *testing.T) {
+	policies := policyCaches()
+	for _, name := range []string{PolicyARC, Policy2Q, PolicySLRU, PolicyWTinyLFU} {
+		newCache := policies[name]
+		t.Run(name, func(t *testing.T) {
+			cache := newCache(100)
+			const hot = 20
+			for round := 0; round < 4; round++ {
+				for i := 0; i < hot; i++ {
+					key := fmt.Sprintf("hot%d", i)
+					if _, ok := cache.Get(key); !ok {
+						cache.Set(key, &CacheValue{1})
+					}
+				}
+			}
+			// A scan of one-off keys, much larger than the cache.
+			for i := 0; i < 1000; i++ {
+				cache.Set(fmt.Sprintf("scan%d", i), &CacheValue{1})
+			}
+
+			var kept int
+			for i := 0; i < hot; i++ {
+				if _, ok := cache.Get(fmt.Sprintf("hot%d", i)); ok {
+					kept++
+				}
+			}
+			require.Equal(t, hot, kept, "the scan flushed the frequently used keys")
+		})
+	}
+}
+
+func TestARCAdaptsToGhostHits(t *testing.T) {
+	cache := NewARCCache(10, cacheValueSize)
+	// Fill half of the cache with frequently used entries (T2).
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("frequent%d", i)
+		cache.Set(key, &CacheValue{1})
+		cache.Get(key)
+	}
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), &CacheValue{1})
+	}
+	require.Equal(t, int64(0), cache.p)
+
+	// key4 was evicted from T1 recently, so setting it again is a hit on B1,
+	// which should make room for more recent entries.
+	require.Equal(t, cache.b1, cache.table["key4"].Value.(*segmentEntry).seg)
+	cache.Set("key4", &CacheValue{1})
+	require.Greater(t, cache.p, int64(0))
+	_, ok := cache.Get("key4")
+	require.True(t, ok)
+}
+
+func TestTwoQueueGhostPromotesToFrequent(t *testing.T) {
+	cache := NewTwoQueueCache(8, cacheValueSize)
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), &CacheValue{1})
+	}
+	element := cache.table["key0"]
+	require.NotNil(t, element)
+	require.Equal(t, cache.ghost, element.Value.(*segmentEntry).seg)
+
+	cache.Set("key0", &CacheValue{1})
+	element = cache.table["key0"]
+	require.Equal(t, cache.frequent, element.Value.(*segmentEntry).seg)
+}
+
+func TestWTinyLFURejectionKeepsEarlierVictims(t *testing.T) {
+	// One unit of window and ten units of main cache.
+	cache := NewWTinyLFUCache(100, 11, cacheValueSize)
+	cache.Set("cold", &CacheValue{5})
+	cache.Set("hot", &CacheValue{5})
+	cache.RecordFrequency("hot", 10)
+
+	// The candidate is more frequent than "cold" but less than "hot", and it
+	// needs both of them gone to fit.
+	cache.RecordFrequency("big", 2)
+	cache.Set("big", &CacheValue{10})
+
+	for _, key := range []string{"cold", "hot"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("%s was evicted for a rejected candidate", key)
+		}
+	}
+	if _, ok := cache.Get("big"); ok {
+		t.Error("big was admitted over a more frequent victim")
+	}
+	if got := cache.Evictions(); got != 1 {
+		t.Errorf("cache.Evictions() = %v, expected 1", got)
+	}
+}
+
+func TestNewDefaultCacheImplPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		verify func(cache Cache) bool
+	}{
+		{PolicyLRU, func(cache Cache) bool { _, ok := cache.(*LRUCache); return ok }},
+		{PolicyARC, func(cache Cache) bool { _, ok := cache.(*ARCCache); return ok }},
+		{Policy2Q, func(cache Cache) bool { _, ok := cache.(*TwoQueueCache); return ok }},
+		{PolicySLRU, func(cache Cache) bool { _, ok := cache.(*SLRUCache); return ok }},
+		{PolicyWTinyLFU, func(cache Cache) bool { _, ok := cache.(*WTinyLFUCache); return ok }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			cache := NewDefaultCacheImpl(&Config{MaxEntries: 100, Policy: tt.policy})
+			require.True(t, tt.verify(cache))
+
+			cache = NewDefaultCacheImpl(&Config{MaxEntries: 0, Policy: tt.policy})
+			_, ok := cache.(*nullCache)
+			require.True(t, ok)
+		})
+	}
+
+	require.Panics(t, func() {
+		NewDefaultCacheImpl(&Config{MaxEntries: 100, Policy: "mru"})
+	})
+}
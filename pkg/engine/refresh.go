@@ -0,0 +1,414 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrNoLoader is returned by NewRefreshingCache when no Loader is given.
+	ErrNoLoader = errors.New("engine: nil Loader")
+	// ErrInvalidTTL is returned by NewRefreshingCache when SoftTTL is not positive.
+	ErrInvalidTTL = errors.New("engine: SoftTTL must be positive")
+	// ErrInvalidHardTTL is returned by NewRefreshingCache when HardTTL is set
+	// but shorter than SoftTTL.
+	ErrInvalidHardTTL = errors.New("engine: HardTTL must not be shorter than SoftTTL")
+	// ErrLoaderPanicked is returned to the callers waiting on a load whose
+	// Loader panicked. The caller that ran the Loader gets the panic.
+	ErrLoaderPanicked = errors.New("engine: Loader panicked")
+)
+
+// Loader loads the value of a key from the backing store.
+type Loader func(key string) (interface{}, error)
+
+// RefreshOptions are the expiry options of a RefreshingCache.
+type RefreshOptions struct {
+	// Loader loads missing and stale values. It is required.
+	Loader Loader
+	// SoftTTL is how long a loaded value stays fresh. After it, Get still
+	// returns the value but refreshes it in the background.
+	SoftTTL time.Duration
+	// HardTTL is how long a loaded value may be served at all. After it, Get
+	// loads the value again before returning. It defaults to twice SoftTTL,
+	// and must not be shorter than it.
+	HardTTL time.Duration
+	// Beta enables XFetch probabilistic early refreshes when it is positive.
+	// Reads of a fresh value trigger a refresh with a probability that grows
+	// as the soft deadline gets closer and with the time the value took to
+	// load; 1.0 is the usual setting, larger values refresh earlier.
+	Beta float64
+}
+
+// RefreshStats counts the stale reads and background refreshes of a
+// RefreshingCache.
+type RefreshStats struct {
+	// StaleHits is the number of reads served a value past its soft deadline.
+	StaleHits uint64
+	// Refreshes is the number of background refreshes started because a value
+	// was stale.
+	Refreshes uint64
+	// EarlyRefreshes is the number of background refreshes started by XFetch
+	// before the soft deadline.
+	EarlyRefreshes uint64
+	// RefreshFailures is the number of background refreshes whose load failed.
+	RefreshFailures uint64
+}
+
+// refreshEntry is the value stored in the underlying cache.
+type refreshEntry struct {
+	value interface{}
+	soft  time.Time
+	hard  time.Time
+	// delta is how long the value took to load, used by XFetch.
+	delta time.Duration
+}
+
+// loadCall is an in-flight synchronous load of a key.
+type loadCall struct {
+	wg    sync.WaitGroup
+	entry *refreshEntry
+	err   error
+}
+
+// RefreshingCache is a read-through cache with soft and hard expiry. Once a
+// value passes its soft deadline it is served stale while a single
+// background refresh runs through the Loader; once it passes its hard
+// deadline it is loaded again before being returned. Concurrent loads and
+// refreshes of the same key are collapsed into one call to the Loader.
+type RefreshingCache struct {
+	cache    Cache
+	opts     RefreshOptions
+	onRemove RemovalListener
+	now      func() time.Time
+
+	mu         sync.Mutex
+	loading    map[string]*loadCall
+	refreshing map[string]struct{}
+	pending    sync.WaitGroup
+	// inflight counts the loads and refreshes running for a key, and
+	// generations is bumped whenever such a key is set, deleted or cleared,
+	// so that a load finishing afterwards doesn't store an outdated value.
+	inflight    map[string]int
+	generations map[string]uint64
+
+	staleHits       uint64
+	refreshes       uint64
+	earlyRefreshes  uint64
+	refreshFailures uint64
+	loadSuccesses   uint64
+	loadFailures    uint64
+	totalLoadTime   int64
+}
+
+// NewRefreshingCache creates a cache with the given configuration whose
+// values expire according to the given options. The OnRemove listener and the
+// Cost function of the configuration, if any, receive the original values.
+func NewRefreshingCache(cfg *Config, opts RefreshOptions) (*RefreshingCache, error) {
+	if opts.Loader == nil {
+		return nil, ErrNoLoader
+	}
+	if opts.SoftTTL <= 0 {
+		return nil, ErrInvalidTTL
+	}
+	if opts.HardTTL == 0 {
+		opts.HardTTL = 2 * opts.SoftTTL
+	}
+	if opts.HardTTL < opts.SoftTTL {
+		return nil, ErrInvalidHardTTL
+	}
+	c := &RefreshingCache{
+		opts:        opts,
+		now:         time.Now,
+		loading:     make(map[string]*loadCall),
+		refreshing:  make(map[string]struct{}),
+		inflight:    make(map[string]int),
+		generations: make(map[string]uint64),
+	}
+	var config Config
+	if cfg != nil {
+		config = *cfg
+	}
+	c.onRemove = config.OnRemove
+	if config.OnRemove != nil {
+		config.OnRemove = func(key string, value interface{}, reason RemovalReason) {
+			c.onRemove(key, value.(*refreshEntry).value, reason)
+		}
+	}
+	if cost := config.Cost; cost != nil {
+		config.Cost = func(value interface{}) int64 {
+			return cost(value.(*refreshEntry).value)
+		}
+	}
+	c.cache = NewDefaultCacheImpl(&config)
+	return c, nil
+}
+
+// Get returns the value of the key, loading it if it is missing or past its
+// hard deadline. Values past their soft deadline are returned as they are,
+// and refreshed in the background.
+func (c *RefreshingCache) Get(key string) (interface{}, error) {
+	if v, ok := c.cache.Get(key); ok {
+		e := v.(*refreshEntry)
+		now := c.now()
+		switch {
+		case !now.Before(e.hard):
+			// Too old to be served; load it below.
+		case !now.Before(e.soft):
+			atomic.AddUint64(&c.staleHits, 1)
+			if c.refresh(key) {
+				atomic.AddUint64(&c.refreshes, 1)
+			}
+			return e.value, nil
+		case c.refreshEarly(e, now):
+			if c.refresh(key) {
+				atomic.AddUint64(&c.earlyRefreshes, 1)
+			}
+			return e.value, nil
+		default:
+			return e.value, nil
+		}
+	}
+
+	c.mu.Lock()
+	if call, ok := c.loading[key]; ok {
+		c.mu.Unlock()
+		call.wg.Wait()
+		if call.err != nil {
+			return nil, call.err
+		}
+		return call.entry.value, nil
+	}
+	call := &loadCall{err: ErrLoaderPanicked}
+	call.wg.Add(1)
+	c.loading[key] = call
+	gen := c.begin(key)
+	c.mu.Unlock()
+
+	c.loadCall(key, call, gen)
+	if call.err != nil {
+		return nil, call.err
+	}
+	return call.entry.value, nil
+}
+
+// loadCall runs a synchronous load and releases the callers waiting on it,
+// even if the Loader panics.
+func (c *RefreshingCache) loadCall(key string, call *loadCall, gen uint64) {
+	defer func() {
+		c.mu.Lock()
+		delete(c.loading, key)
+		c.end(key)
+		c.mu.Unlock()
+		call.wg.Done()
+	}()
+	call.entry, call.err = c.load(key)
+	if call.err == nil {
+		c.store(key, call.entry, gen)
+	}
+}
+
+// GetIfPresent returns the value of the key if it is cached and not past its
+// hard deadline, without loading or refreshing it.
+func (c *RefreshingCache) GetIfPresent(key string) (interface{}, bool) {
+	v, ok := c.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	e := v.(*refreshEntry)
+	if !c.now().Before(e.hard) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// Set stores a value that was loaded by the caller, with fresh deadlines.
+// Loads of the key in flight don't overwrite it.
+func (c *RefreshingCache) Set(key string, value interface{}) bool {
+	c.invalidate(key)
+	return c.cache.Set(key, c.newEntry(value, 0))
+}
+
+// Delete removes an entry from the cache. Loads of the key in flight don't
+// store their value.
+func (c *RefreshingCache) Delete(key string) {
+	c.invalidate(key)
+	c.cache.Delete(key)
+}
+
+// Clear removes every entry from the cache. Loads in flight don't store
+// their value.
+func (c *RefreshingCache) Clear() {
+	c.mu.Lock()
+	for key := range c.inflight {
+		c.generations[key]++
+	}
+	c.mu.Unlock()
+	c.cache.Clear()
+}
+
+// Len returns the number of entries in the cache, including expired ones
+// which haven't been removed yet.
+func (c *RefreshingCache) Len() int {
+	return c.cache.Len()
+}
+
+// Wait waits for the background refreshes in flight and for the pending
+// operations on the underlying cache.
+func (c *RefreshingCache) Wait() {
+	c.pending.Wait()
+	c.cache.Wait()
+}
+
+// Stats returns the statistics of the underlying cache, including the
+// statistics of the loads done through the Loader.
+func (c *RefreshingCache) Stats() Stats {
+	stats := c.cache.Stats()
+	stats.LoadSuccesses = atomic.LoadUint64(&c.loadSuccesses)
+	stats.LoadFailures = atomic.LoadUint64(&c.loadFailures)
+	stats.TotalLoadTime = time.Duration(atomic.LoadInt64(&c.totalLoadTime))
+	return stats
+}
+
+// RefreshStats returns the stale read and background refresh counters.
+func (c *RefreshingCache) RefreshStats() RefreshStats {
+	return RefreshStats{
+		StaleHits:       atomic.LoadUint64(&c.staleHits),
+		Refreshes:       atomic.LoadUint64(&c.refreshes),
+		EarlyRefreshes:  atomic.LoadUint64(&c.earlyRefreshes),
+		RefreshFailures: atomic.LoadUint64(&c.refreshFailures),
+	}
+}
+
+// refreshEarly decides whether to refresh a fresh value ahead of its soft
+// deadline, following the XFetch algorithm: the value is refreshed when
+// now - delta * beta * ln(rand()) reaches the deadline.
+func (c *RefreshingCache) refreshEarly(e *refreshEntry, now time.Time) bool {
+	if c.opts.Beta <= 0 || e.delta <= 0 {
+		return false
+	}
+	gap := -float64(e.delta) * c.opts.Beta * math.Log(1-rand.Float64())
+	return !now.Add(time.Duration(gap)).Before(e.soft)
+}
+
+// refresh starts a background refresh of the key, unless one is already
+// running or a synchronous load is in flight. It reports whether it started one.
+func (c *RefreshingCache) refresh(key string) bool {
+	c.mu.Lock()
+	if _, ok := c.refreshing[key]; ok {
+		c.mu.Unlock()
+		return false
+	}
+	if _, ok := c.loading[key]; ok {
+		c.mu.Unlock()
+		return false
+	}
+	c.refreshing[key] = struct{}{}
+	gen := c.begin(key)
+	c.pending.Add(1)
+	c.mu.Unlock()
+
+	go func() {
+		defer c.pending.Done()
+		defer func() {
+			// A panicking Loader fails the refresh instead of crashing the
+			// process; the stale value is kept until the hard deadline.
+			if recover() != nil {
+				atomic.AddUint64(&c.refreshFailures, 1)
+			}
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.end(key)
+			c.mu.Unlock()
+		}()
+		e, err := c.load(key)
+		if err != nil {
+			// Keep serving the stale value until the hard deadline.
+			atomic.AddUint64(&c.refreshFailures, 1)
+		} else {
+			c.store(key, e, gen)
+		}
+	}()
+	return true
+}
+
+// begin registers a load of the key and returns the generation of the key.
+// It must be called with c.mu held.
+func (c *RefreshingCache) begin(key string) uint64 {
+	c.inflight[key]++
+	return c.generations[key]
+}
+
+// end unregisters a load of the key. It must be called with c.mu held.
+func (c *RefreshingCache) end(key string) {
+	if c.inflight[key]--; c.inflight[key] == 0 {
+		delete(c.inflight, key)
+		delete(c.generations, key)
+	}
+}
+
+// invalidate bumps the generation of the key if it is being loaded.
+func (c *RefreshingCache) invalidate(key string) {
+	c.mu.Lock()
+	if _, ok := c.inflight[key]; ok {
+		c.generations[key]++
+	}
+	c.mu.Unlock()
+}
+
+// store caches a loaded entry, unless the key was set, deleted or cleared
+// since the load started.
+func (c *RefreshingCache) store(key string, e *refreshEntry, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generations[key] == gen {
+		c.cache.Set(key, e)
+	}
+}
+
+// load calls the Loader and records its statistics.
+func (c *RefreshingCache) load(key string) (*refreshEntry, error) {
+	start := c.now()
+	value, err := c.opts.Loader(key)
+	elapsed := c.now().Sub(start)
+	atomic.AddInt64(&c.totalLoadTime, int64(elapsed))
+	if err != nil {
+		atomic.AddUint64(&c.loadFailures, 1)
+		return nil, err
+	}
+	atomic.AddUint64(&c.loadSuccesses, 1)
+	return c.newEntry(value, elapsed), nil
+}
+
+func (c *RefreshingCache) newEntry(value interface{}, delta time.Duration) *refreshEntry {
+	now := c.now()
+	return &refreshEntry{
+		value: value,
+		soft:  now.Add(c.opts.SoftTTL),
+		hard:  now.Add(c.opts.HardTTL),
+		delta: delta,
+	}
+}
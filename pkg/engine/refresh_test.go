@@ -0,0 +1,367 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// countingLoader returns "<key>#<n>" for the n-th load of a key.
+type countingLoader struct {
+	mu    sync.Mutex
+	loads map[string]int
+	fail  bool
+}
+
+func (l *countingLoader) load(key string) (interface{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fail {
+		return nil, errors.New("backend down")
+	}
+	if l.loads == nil {
+		l.loads = make(map[string]int)
+	}
+	l.loads[key]++
+	return fmt.Sprintf("%s#%d", key, l.loads[key]), nil
+}
+
+func (l *countingLoader) count(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.loads[key]
+}
+
+func newTestRefreshingCache(t *testing.T, opts RefreshOptions) (*RefreshingCache, *fakeClock) {
+	cache, err := NewRefreshingCache(&Config{MaxEntries: 100}, opts)
+	require.NoError(t, err)
+	clock := &fakeClock{now: time.Unix(1000, 0)}
+	cache.now = clock.Now
+	return cache, clock
+}
+
+func TestRefreshingCacheExpiry(t *testing.T) {
+	loader := &countingLoader{}
+	cache, clock := newTestRefreshingCache(t, RefreshOptions{
+		Loader:  loader.load,
+		SoftTTL: time.Minute,
+		HardTTL: 5 * time.Minute,
+	})
+
+	v, err := cache.Get("k")
+	require.NoError(t, err)
+	require.Equal(t, "k#1", v)
+
+	// Fresh values come from the cache.
+	clock.Advance(30 * time.Second)
+	v, err = cache.Get("k")
+	require.NoError(t, err)
+	require.Equal(t, "k#1", v)
+	require.Equal(t, 1, loader.count("k"))
+
+	// Stale values are served while a refresh runs in the background.
+	clock.Advance(time.Minute)
+	v, err = cache.Get("k")
+	require.NoError(t, err)
+	require.Equal(t, "k#1", v)
+	cache.Wait()
+	require.Equal(t, 2, loader.count("k"))
+	v, _ = cache.Get("k")
+	require.Equal(t, "k#2", v)
+
+	// Past the hard deadline the value is loaded before returning.
+	clock.Advance(10 * time.Minute)
+	_, ok := cache.GetIfPresent("k")
+	require.False(t, ok)
+	v, err = cache.Get("k")
+	require.NoError(t, err)
+	require.Equal(t, "k#3", v)
+
+	require.Equal(t, RefreshStats{StaleHits: 1, Refreshes: 1}, cache.RefreshStats())
+	stats := cache.Stats()
+	require.Equal(t, uint64(3), stats.LoadSuccesses)
+	require.Zero(t, stats.LoadFailures)
+}
+
+func TestRefreshingCacheSingleRefresh(t *testing.T) {
+	var loads int64
+	release := make(chan struct{})
+	cache, clock := newTestRefreshingCache(t, RefreshOptions{
+		Loader: func(key string) (interface{}, error) {
+			if atomic.AddInt64(&loads, 1) > 1 {
+				<-release
+			}
+			return key, nil
+		},
+		SoftTTL: time.Minute,
+	})
+	_, err := cache.Get("k")
+	require.NoError(t, err)
+
+	clock.Advance(90 * time.Second)
+	for i := 0; i < 10; i++ {
+		v, err := cache.Get("k")
+		require.NoError(t, err)
+		require.Equal(t, "k", v)
+	}
+	close(release)
+	cache.Wait()
+	require.Equal(t, int64(2), atomic.LoadInt64(&loads))
+	require.Equal(t, RefreshStats{StaleHits: 10, Refreshes: 1}, cache.RefreshStats())
+}
+
+func TestRefreshingCacheConcurrentMisses(t *testing.T) {
+	var loads int64
+	release := make(chan struct{})
+	cache, _ := newTestRefreshingCache(t, RefreshOptions{
+		Loader: func(key string) (interface{}, error) {
+			atomic.AddInt64(&loads, 1)
+			<-release
+			return key, nil
+		},
+		SoftTTL: time.Minute,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := cache.Get("k")
+			require.NoError(t, err)
+			require.Equal(t, "k", v)
+		}()
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt64(&loads) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	require.LessOrEqual(t, atomic.LoadInt64(&loads), int64(2))
+}
+
+func TestRefreshingCacheFailures(t *testing.T) {
+	loader := &countingLoader{}
+	cache, clock := newTestRefreshingCache(t, RefreshOptions{
+		Loader:  loader.load,
+		SoftTTL: time.Minute,
+		HardTTL: 2 * time.Minute,
+	})
+	_, err := cache.Get("k")
+	require.NoError(t, err)
+
+	loader.mu.Lock()
+	loader.fail = true
+	loader.mu.Unlock()
+
+	// A failed refresh keeps the stale value.
+	clock.Advance(90 * time.Second)
+	v, err := cache.Get("k")
+	require.NoError(t, err)
+	require.Equal(t, "k#1", v)
+	cache.Wait()
+	require.Equal(t, uint64(1), cache.RefreshStats().RefreshFailures)
+
+	// A failed synchronous load is returned to the caller.
+	clock.Advance(time.Minute)
+	_, err = cache.Get("k")
+	require.Error(t, err)
+	require.Equal(t, uint64(2), cache.Stats().LoadFailures)
+
+	_, err = NewRefreshingCache(nil, RefreshOptions{SoftTTL: time.Minute})
+	require.Equal(t, ErrNoLoader, err)
+	_, err = NewRefreshingCache(nil, RefreshOptions{Loader: loader.load})
+	require.Equal(t, ErrInvalidTTL, err)
+	_, err = NewRefreshingCache(nil, RefreshOptions{Loader: loader.load, SoftTTL: time.Minute, HardTTL: time.Second})
+	require.Equal(t, ErrInvalidHardTTL, err)
+}
+
+func TestRefreshingCacheLoaderPanic(t *testing.T) {
+	var panicked int32
+	cache, _ := newTestRefreshingCache(t, RefreshOptions{
+		Loader: func(key string) (interface{}, error) {
+			if atomic.CompareAndSwapInt32(&panicked, 0, 1) {
+				panic("backend exploded")
+			}
+			return key, nil
+		},
+		SoftTTL: time.Minute,
+	})
+	require.Panics(t, func() { cache.Get("k") })
+
+	// The panic released the key, so the next load runs instead of waiting.
+	done := make(chan interface{})
+	go func() {
+		v, _ := cache.Get("k")
+		done <- v
+	}()
+	select {
+	case v := <-done:
+		require.Equal(t, "k", v)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Get blocked after the Loader panicked")
+	}
+}
+
+func TestRefreshingCacheRefreshPanic(t *testing.T) {
+	var loads int32
+	cache, clock := newTestRefreshingCache(t, RefreshOptions{
+		Loader: func(key string) (interface{}, error) {
+			if atomic.AddInt32(&loads, 1) == 2 {
+				panic("backend exploded")
+			}
+			return key, nil
+		},
+		SoftTTL: time.Minute,
+		HardTTL: 2 * time.Minute,
+	})
+	_, err := cache.Get("k")
+	require.NoError(t, err)
+
+	// The panic fails the refresh and keeps the stale value.
+	clock.Advance(90 * time.Second)
+	v, err := cache.Get("k")
+	require.NoError(t, err)
+	require.Equal(t, "k", v)
+	cache.Wait()
+	require.Equal(t, uint64(1), cache.RefreshStats().RefreshFailures)
+
+	// The key is no longer marked as refreshing, so the next refresh runs.
+	_, err = cache.Get("k")
+	require.NoError(t, err)
+	cache.Wait()
+	require.Equal(t, int32(3), atomic.LoadInt32(&loads))
+	require.Equal(t, uint64(2), cache.RefreshStats().Refreshes)
+}
+
+func TestRefreshingCacheDeleteDuringRefresh(t *testing.T) {
+	loader := &countingLoader{}
+	release := make(chan struct{})
+	cache, clock := newTestRefreshingCache(t, RefreshOptions{
+		Loader: func(key string) (interface{}, error) {
+			if loader.count(key) > 0 {
+				<-release
+			}
+			return loader.load(key)
+		},
+		SoftTTL: time.Minute,
+	})
+	_, err := cache.Get("k")
+	require.NoError(t, err)
+
+	// The refresh started by the stale read finishes after the key is
+	// deleted, and must not bring it back.
+	clock.Advance(90 * time.Second)
+	v, err := cache.Get("k")
+	require.NoError(t, err)
+	require.Equal(t, "k#1", v)
+	cache.Delete("k")
+	close(release)
+	cache.Wait()
+	require.Equal(t, 2, loader.count("k"))
+	_, ok := cache.GetIfPresent("k")
+	require.False(t, ok)
+
+	// Once nothing is in flight, loads store their values again.
+	v, err = cache.Get("k")
+	require.NoError(t, err)
+	require.Equal(t, "k#3", v)
+	_, ok = cache.GetIfPresent("k")
+	require.True(t, ok)
+}
+
+func TestRefreshingCacheXFetch(t *testing.T) {
+	var clock *fakeClock
+	loader := func(key string) (interface{}, error) {
+		// Every load takes ten seconds of the fake clock.
+		clock.Advance(10 * time.Second)
+		return key, nil
+	}
+	cache, c := newTestRefreshingCache(t, RefreshOptions{
+		Loader:  loader,
+		SoftTTL: time.Minute,
+		Beta:    1,
+	})
+	clock = c
+	_, err := cache.Get("k")
+	require.NoError(t, err)
+
+	// Long before the deadline, early refreshes are very unlikely.
+	for i := 0; i < 20; i++ {
+		cache.Get("k")
+	}
+	cache.Wait()
+	require.LessOrEqual(t, cache.RefreshStats().EarlyRefreshes, uint64(1))
+
+	// Just before the deadline they become likely, and never hit a stale read.
+	clock.Advance(59 * time.Second)
+	for i := 0; i < 100 && cache.RefreshStats().EarlyRefreshes == 0; i++ {
+		cache.Get("k")
+		cache.Wait()
+	}
+	require.NotZero(t, cache.RefreshStats().EarlyRefreshes)
+	require.Zero(t, cache.RefreshStats().StaleHits)
+}
+
+func TestRefreshingCacheUnwrapsValues(t *testing.T) {
+	var removed []interface{}
+	cache, err := NewRefreshingCache(&Config{
+		MaxEntries:     100,
+		MaxMemoryUsage: 1000,
+		BoundByMemory:  true,
+		Cost:           func(v interface{}) int64 { return int64(len(v.(string))) },
+		OnRemove: func(_ string, value interface{}, _ RemovalReason) {
+			removed = append(removed, value)
+		},
+	}, RefreshOptions{
+		Loader:  func(key string) (interface{}, error) { return key, nil },
+		SoftTTL: time.Minute,
+	})
+	require.NoError(t, err)
+	cache.Set("key", "value")
+	require.Equal(t, int64(5), cache.Stats().UsedCapacity)
+	cache.Delete("key")
+	require.Equal(t, []interface{}{"value"}, removed)
+}
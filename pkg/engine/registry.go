@@ -0,0 +1,194 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Engine is a cache implementation registered by name, so that a Config can
+// select it with its Engine field.
+type Engine struct {
+	// Name selects the engine in Config.Engine.
+	Name string
+	// Description tells what the engine does, for listings of the engines.
+	Description string
+	// Options, if set, returns a pointer to the default options of the engine.
+	// The options of the Config are decoded into it before New is called; the
+	// json and desc tags of its fields name and describe the options.
+	Options func() interface{}
+	// New creates a cache from the config and the decoded options, which are
+	// nil when the engine has no Options.
+	New func(cfg *Config, options interface{}) (Cache, error)
+}
+
+// Argument describes an option of an engine.
+type Argument struct {
+	Name        string
+	Description string
+}
+
+var (
+	enginesMu sync.RWMutex
+	engines   = make(map[string]Engine)
+)
+
+// Register makes an engine available by name. It panics if the name is empty
+// or already registered, or if New is nil. It is usually called from the init
+// function of the package implementing the engine.
+func Register(e Engine) {
+	if e.Name == "" || e.New == nil {
+		panic("engine: Register needs a name and a New function")
+	}
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+
+	if _, dup := engines[e.Name]; dup {
+		panic(fmt.Sprintf("engine: Register called twice for engine %q", e.Name))
+	}
+	engines[e.Name] = e
+}
+
+// LookupEngine returns the engine registered with the given name.
+func LookupEngine(name string) (Engine, bool) {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	e, ok := engines[name]
+	return e, ok
+}
+
+// Engines returns the registered engines, sorted by name.
+func Engines() []Engine {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+
+	list := make([]Engine, 0, len(engines))
+	for _, e := range engines {
+		list = append(list, e)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Arguments describes the options of the engine, from the exported fields of
+// its options type.
+func (e Engine) Arguments() []Argument {
+	if e.Options == nil {
+		return nil
+	}
+	t := reflect.TypeOf(e.Options())
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var args []Argument
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		args = append(args, Argument{Name: name, Description: field.Tag.Get("desc")})
+	}
+	return args
+}
+
+// decodeOptions returns the options of the engine given in a Config.
+func (e Engine) decodeOptions(options interface{}) (interface{}, error) {
+	if e.Options == nil {
+		if options != nil {
+			return nil, fmt.Errorf("engine: %q takes no options", e.Name)
+		}
+		return nil, nil
+	}
+	opts := e.Options()
+	var data []byte
+	switch o := options.(type) {
+	case nil:
+		return opts, nil
+	case string:
+		data = []byte(o)
+	case []byte:
+		data = o
+	case json.RawMessage:
+		data = o
+	default:
+		t := reflect.TypeOf(opts)
+		switch reflect.TypeOf(o) {
+		case t:
+			return o, nil
+		case t.Elem():
+			v := reflect.New(t.Elem())
+			v.Elem().Set(reflect.ValueOf(o))
+			return v.Interface(), nil
+		}
+		// Generic values, such as the maps decoded by ParseConfig, are
+		// converted through JSON.
+		var err error
+		if data, err = json.Marshal(o); err != nil {
+			return nil, fmt.Errorf("engine: invalid options for %q: %v", e.Name, err)
+		}
+	}
+	if err := yaml.UnmarshalStrict(data, opts); err != nil {
+		return nil, fmt.Errorf("engine: invalid options for %q: %v", e.Name, err)
+	}
+	return opts, nil
+}
+
+// NewCache creates the cache selected by the config, from the registered
+// engines. A nil config returns a cache that stores nothing.
+func NewCache(cfg *Config) (Cache, error) {
+	if cfg == nil {
+		return &nullCache{}, nil
+	}
+	name := cfg.policy()
+	e, ok := LookupEngine(name)
+	if !ok {
+		return nil, fmt.Errorf("engine: unknown cache engine %q", name)
+	}
+	opts, err := e.decodeOptions(cfg.Options)
+	if err != nil {
+		return nil, err
+	}
+	return e.New(cfg, opts)
+}
+
+// ParseConfig decodes a Config from YAML or JSON. The options of the engine
+// are decoded when the cache is created.
+func ParseConfig(data []byte) (*Config, error) {
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("engine: invalid config: %v", err)
+	}
+	return &cfg, nil
+}
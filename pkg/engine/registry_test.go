@@ -0,0 +1,138 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type testEngineOptions struct {
+	Prefix string `json:"prefix" desc:"prefix of every key"`
+	Limit  int    `json:"limit,omitempty"`
+}
+
+// prefixCache is a custom engine prefixing every key.
+type prefixCache struct {
+	*LRUCache
+	prefix string
+}
+
+func (c *prefixCache) Set(key string, value interface{}) bool {
+	return c.LRUCache.Set(c.prefix+key, value)
+}
+
+func (c *prefixCache) Get(key string) (interface{}, bool) {
+	return c.LRUCache.Get(c.prefix + key)
+}
+
+func init() {
+	Register(Engine{
+		Name:        "test-prefix",
+		Description: "LRU prefixing its keys",
+		Options:     func() interface{} { return &testEngineOptions{Limit: 10} },
+		New: func(cfg *Config, options interface{}) (Cache, error) {
+			opts := options.(*testEngineOptions)
+			return &prefixCache{NewLRUCache(int64(opts.Limit), func(interface{}) int64 { return 1 }), opts.Prefix}, nil
+		},
+	})
+}
+
+func TestRegisterEngine(t *testing.T) {
+	e, ok := LookupEngine("test-prefix")
+	require.True(t, ok)
+	require.Equal(t, []Argument{{"prefix", "prefix of every key"}, {"limit", ""}}, e.Arguments())
+
+	var names []string
+	for _, e := range Engines() {
+		names = append(names, e.Name)
+	}
+	require.Equal(t, []string{Policy2Q, PolicyARC, PolicyArena, PolicyLFU, PolicyLRU, PolicySLRU, "test-prefix", PolicyWTinyLFU}, names)
+
+	lru, _ := LookupEngine(PolicyLRU)
+	require.Equal(t, []Argument{{"shards", "number of independently locked segments"}}, lru.Arguments())
+	arc, _ := LookupEngine(PolicyARC)
+	require.Nil(t, arc.Arguments())
+
+	require.Panics(t, func() { Register(Engine{Name: PolicyLRU, New: e.New}) })
+	require.Panics(t, func() { Register(Engine{Name: "nameless"}) })
+}
+
+func TestNewCacheOptions(t *testing.T) {
+	for name, options := range map[string]interface{}{
+		"yaml":    "prefix: p/\nlimit: 2\n",
+		"json":    []byte(`{"prefix": "p/", "limit": 2}`),
+		"pointer": &testEngineOptions{Prefix: "p/", Limit: 2},
+		"value":   testEngineOptions{Prefix: "p/", Limit: 2},
+		"map":     map[string]interface{}{"prefix": "p/", "limit": 2},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cache, err := NewCache(&Config{Engine: "test-prefix", Options: options})
+			require.NoError(t, err)
+			cache.Set("a", 1)
+			require.Equal(t, []string{"p/a"}, cache.(*prefixCache).Keys(""))
+			require.Equal(t, int64(2), cache.MaxCapacity())
+		})
+	}
+
+	// The options not given keep their defaults.
+	cache, err := NewCache(&Config{Engine: "test-prefix", Options: "prefix: p/"})
+	require.NoError(t, err)
+	require.Equal(t, int64(10), cache.MaxCapacity())
+
+	_, err = NewCache(&Config{Engine: "test-prefix", Options: "prefx: p/"})
+	require.Error(t, err)
+	_, err = NewCache(&Config{Engine: PolicyARC, MaxEntries: 10, Options: "size: 1"})
+	require.Error(t, err)
+	_, err = NewCache(&Config{Engine: "mru"})
+	require.EqualError(t, err, `engine: unknown cache engine "mru"`)
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]byte(`
+engine: lru
+max_entries: 100
+max_memory_usage: 1000
+bound_by_memory: true
+options:
+  shards: 4
+`))
+	require.NoError(t, err)
+	require.Equal(t, PolicyLRU, cfg.Engine)
+	require.Equal(t, int64(100), cfg.MaxEntries)
+	require.True(t, cfg.BoundByMemory)
+	cache, err := NewCache(cfg)
+	require.NoError(t, err)
+	require.Len(t, cache.(*ShardedLRUCache).shards, 4)
+	require.Equal(t, int64(1000), cache.MaxCapacity())
+
+	cfg, err = ParseConfig([]byte(`{"engine": "lfu", "max_entries": 100, "max_memory_usage": 1000, "options": {"keep_keys": true}}`))
+	require.NoError(t, err)
+	cache, err = NewCache(cfg)
+	require.NoError(t, err)
+	cache.Set("a", 1)
+	cache.Wait()
+	require.Equal(t, []string{"a"}, cache.(KeyIterator).Keys(""))
+
+	_, err = ParseConfig([]byte("max_entrys: 100"))
+	require.Error(t, err)
+}
@@ -0,0 +1,108 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sync"
+
+	"github.com/bhojpur/cache/pkg/engine/ristretto"
+)
+
+// RemovalReason describes why an entry left a cache.
+type RemovalReason = ristretto.RemovalReason
+
+const (
+	// Evicted means the entry was removed by the policy to make room for others.
+	Evicted = ristretto.Evicted
+	// Expired means the entry outlived its time to live.
+	Expired = ristretto.Expired
+	// Replaced means the value was overwritten by a Set on the same key.
+	Replaced = ristretto.Replaced
+	// Deleted means the entry was removed by an explicit Delete.
+	Deleted = ristretto.Deleted
+	// Cleared means the entry was removed because the whole cache was cleared.
+	Cleared = ristretto.Cleared
+	// Rejected means the value was never admitted by the cache.
+	Rejected = ristretto.Rejected
+)
+
+// RemovalListener is notified whenever an entry leaves a cache. Listeners are
+// called synchronously while the cache holds its internal locks, so they must
+// be fast and must not call back into the same cache; wrap them with
+// NewAsyncRemovalListener to run them in the background instead.
+type RemovalListener func(key string, value interface{}, reason RemovalReason)
+
+// removal is a queued removal notification
+type removal struct {
+	key    string
+	value  interface{}
+	reason RemovalReason
+}
+
+// AsyncRemovalListener delivers removal notifications to a RemovalListener
+// from a background goroutine, through a bounded queue. When the queue is full,
+// the cache blocks until the listener catches up, so no notification is lost.
+type AsyncRemovalListener struct {
+	listener RemovalListener
+	queue    chan removal
+	done     chan struct{}
+	once     sync.Once
+}
+
+// NewAsyncRemovalListener starts delivering notifications to the given listener
+// through a queue holding up to size pending notifications.
+func NewAsyncRemovalListener(listener RemovalListener, size int) *AsyncRemovalListener {
+	a := &AsyncRemovalListener{
+		listener: listener,
+		queue:    make(chan removal, size),
+		done:     make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+func (a *AsyncRemovalListener) run() {
+	defer close(a.done)
+	for r := range a.queue {
+		a.listener(r.key, r.value, r.reason)
+	}
+}
+
+// Notify queues a removal notification; it is the RemovalListener to hand to a cache.
+func (a *AsyncRemovalListener) Notify(key string, value interface{}, reason RemovalReason) {
+	a.queue <- removal{key, value, reason}
+}
+
+// Close stops accepting notifications and blocks until all the queued ones
+// have been delivered. The caches using Notify must not be used afterwards.
+func (a *AsyncRemovalListener) Close() {
+	a.once.Do(func() {
+		close(a.queue)
+	})
+	<-a.done
+}
+
+// notify calls the listener if there is one
+func (l RemovalListener) notify(key string, value interface{}, reason RemovalReason) {
+	if l != nil {
+		l(key, value, reason)
+	}
+}
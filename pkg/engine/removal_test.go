@@ -0,0 +1,145 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type recordedRemoval struct {
+	key    string
+	reason RemovalReason
+}
+
+type removalRecorder struct {
+	mu       sync.Mutex
+	removals []recordedRemoval
+}
+
+func (r *removalRecorder) listen(key string, _ interface{}, reason RemovalReason) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removals = append(r.removals, recordedRemoval{key, reason})
+}
+
+func (r *removalRecorder) count(reason RemovalReason) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int
+	for _, removal := range r.removals {
+		if removal.reason == reason {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *removalRecorder) has(key string, reason RemovalReason) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, removal := range r.removals {
+		if removal.key == key && removal.reason == reason {
+			return true
+		}
+	}
+	return false
+}
+
+func TestRemovalListenerConformance(t *testing.T) {
+	for name, newCache := range policyCaches() {
+		newCache := newCache
+		t.Run(name, func(t *testing.T) {
+			rec := &removalRecorder{}
+			cache := newCache(10).(listenedCache)
+			cache.SetRemovalListener(rec.listen)
+
+			cache.Set("key", &CacheValue{1})
+			cache.Set("key", &CacheValue{1})
+			require.True(t, rec.has("key", Replaced))
+
+			cache.Delete("key")
+			require.True(t, rec.has("key", Deleted))
+			cache.Delete("key")
+			require.Equal(t, 1, rec.count(Deleted))
+
+			const inserted = 50
+			for i := 0; i < inserted; i++ {
+				cache.Set(fmt.Sprintf("key%d", i), &CacheValue{1})
+			}
+			// Every entry that is no longer resident was reported exactly once.
+			removed := rec.count(Evicted) + rec.count(Rejected)
+			require.Equal(t, inserted-cache.Len(), removed)
+			require.Equal(t, int64(removed), cache.Evictions())
+
+			resident := cache.Len()
+			cache.Clear()
+			require.Equal(t, resident, rec.count(Cleared))
+		})
+	}
+}
+
+func TestNullCacheRejects(t *testing.T) {
+	rec := &removalRecorder{}
+	cache := NewDefaultCacheImpl(&Config{MaxEntries: 0, OnRemove: rec.listen})
+	require.False(t, cache.Set("key", 1))
+	require.True(t, rec.has("key", Rejected))
+}
+
+type cachedString string
+
+func (s cachedString) CachedSize(_ bool) int64 {
+	return int64(len(s))
+}
+
+func TestRistrettoRemovalListener(t *testing.T) {
+	rec := &removalRecorder{}
+	cache := NewDefaultCacheImpl(&Config{MaxEntries: 100, MaxMemoryUsage: 1 << 20, LFU: true, OnRemove: rec.listen})
+	cache.Set("key", cachedString("value"))
+	cache.Wait()
+	cache.Set("key", cachedString("other"))
+	require.True(t, rec.has("key", Replaced))
+
+	cache.Delete("key")
+	require.True(t, rec.has("key", Deleted))
+
+	cache.Set("key2", cachedString("value"))
+	cache.Wait()
+	cache.Clear()
+	require.True(t, rec.has("key2", Cleared))
+}
+
+func TestAsyncRemovalListener(t *testing.T) {
+	rec := &removalRecorder{}
+	async := NewAsyncRemovalListener(rec.listen, 4)
+
+	cache := NewLRUCache(5, cacheValueSize)
+	cache.SetRemovalListener(async.Notify)
+	for i := 0; i < 100; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), &CacheValue{1})
+	}
+	async.Close()
+	require.Equal(t, 95, rec.count(Evicted))
+	require.True(t, rec.has("key0", Evicted))
+}
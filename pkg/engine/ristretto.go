@@ -25,20 +25,40 @@ import (
 )
 
 var _ Cache = &ristretto.Cache{}
+var _ HotKeysReporter = &ristretto.Cache{}
+var _ MultiCache = &ristretto.Cache{}
+var _ ConditionalCache = &ristretto.Cache{}
+
+// Item is what is stored in the cache
+type Item = ristretto.KeyValue
+
+// HotKey is a frequently accessed key, with its estimated access count.
+type HotKey = ristretto.HotKey
+
+// The TinyLFU paper recommends to allocate 10x times the max entries amount as counters
+// for the admission policy; since our caches are small and we're very interested on admission
+// accuracy, we're a bit more greedy than 10x
+const counterRatio = 12
 
 // NewRistrettoCache returns a Cache implementation based on Ristretto
 func NewRistrettoCache(maxEntries, maxCost int64, cost func(interface{}) int64) *ristretto.Cache {
-	// The TinyLFU paper recommends to allocate 10x times the max entries amount as counters
-	// for the admission policy; since our caches are small and we're very interested on admission
-	// accuracy, we're a bit more greedy than 10x
-	const CounterRatio = 12
+	return newRistrettoCache(maxEntries, maxCost, cost, false, 0, nil)
+}
 
+func newRistrettoCache(maxEntries, maxCost int64, cost func(interface{}) int64, keepKeys bool, hotKeys int, onRemove RemovalListener) *ristretto.Cache {
 	config := ristretto.Config{
-		NumCounters: maxEntries * CounterRatio,
+		NumCounters: maxEntries * counterRatio,
 		MaxCost:     maxCost,
 		BufferItems: 64,
 		Metrics:     true,
 		Cost:        cost,
+		KeepKeys:    keepKeys,
+		HotKeys:     hotKeys,
+	}
+	if onRemove != nil {
+		config.OnRemove = func(item *ristretto.Item, reason RemovalReason) {
+			onRemove(item.OriginalKey, item.Value, reason)
+		}
 	}
 	cache, err := ristretto.NewCache(&config)
 	if err != nil {
@@ -53,7 +53,7 @@ func TestM_NumberOfWrongs(t *testing.T) {
 
 	cnt := 0
 	for i := range wordlist1 {
-		hash := hack.RuntimeMemhash(wordlist1[i], 0)
+		hash := hack.Memhash(wordlist1[i], hack.HashSeed{})
 		if !bf.AddIfNotHas(hash) {
 			cnt++
 		}
@@ -71,7 +71,7 @@ func BenchmarkM_New(b *testing.B) {
 func BenchmarkM_Clear(b *testing.B) {
 	bf = NewBloomFilter(n*10, 7)
 	for i := range wordlist1 {
-		hash := hack.RuntimeMemhash(wordlist1[i], 0)
+		hash := hack.Memhash(wordlist1[i], hack.HashSeed{})
 		bf.Add(hash)
 	}
 	b.ResetTimer()
@@ -85,7 +85,7 @@ func BenchmarkM_Add(b *testing.B) {
 	b.ResetTimer()
 	for r := 0; r < b.N; r++ {
 		for i := range wordlist1 {
-			hash := hack.RuntimeMemhash(wordlist1[i], 0)
+			hash := hack.Memhash(wordlist1[i], hack.HashSeed{})
 			bf.Add(hash)
 		}
 	}
@@ -96,7 +96,7 @@ func BenchmarkM_Has(b *testing.B) {
 	b.ResetTimer()
 	for r := 0; r < b.N; r++ {
 		for i := range wordlist1 {
-			hash := hack.RuntimeMemhash(wordlist1[i], 0)
+			hash := hack.Memhash(wordlist1[i], hack.HashSeed{})
 			bf.Has(hash)
 		}
 	}
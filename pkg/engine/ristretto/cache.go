@@ -28,6 +28,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -37,18 +38,65 @@ import (
 )
 
 var (
-	// TODO: find the optimal value for this or make it configurable
+	// setBufSize is the default number of pending writes queued in setBuf,
+	// used when Config.SetBufferItems is zero.
 	setBufSize = 32 * 1024
 )
 
-func defaultStringHash(key string) (uint64, uint64) {
-	const Seed1 = uint64(0x1122334455667788)
-	const Seed2 = uint64(0x8877665544332211)
-	return hack.RuntimeStrhash(key, Seed1), hack.RuntimeStrhash(key, Seed2)
+// defaultTrackedAdmissions is the default value of Config.TrackedAdmissions.
+const defaultTrackedAdmissions = 100000
+
+// newStringHash returns the default KeyToHash function of a cache. Every cache
+// hashes its keys with its own random seeds, so that the keys colliding in one
+// cache cannot be predicted to flood it.
+func newStringHash() func(string) (uint64, uint64) {
+	seed1, seed2 := hack.NewHashSeed(), hack.NewHashSeed()
+	return func(key string) (uint64, uint64) {
+		return hack.Strhash(key, seed1), hack.Strhash(key, seed2)
+	}
 }
 
 type itemCallback func(*Item)
 
+// RemovalReason describes why a value left the cache.
+type RemovalReason int
+
+const (
+	// Evicted means the value was removed by the policy to make room for others.
+	Evicted RemovalReason = iota
+	// Expired means the value outlived its time to live.
+	Expired
+	// Replaced means the value was overwritten by a Set on the same key.
+	Replaced
+	// Deleted means the value was removed by an explicit Delete.
+	Deleted
+	// Cleared means the value was removed because the whole cache was cleared.
+	Cleared
+	// Rejected means the value was never admitted by the policy, including
+	// when it was still queued as the cache was cleared or closed.
+	Rejected
+)
+
+// String returns the name of the removal reason.
+func (r RemovalReason) String() string {
+	switch r {
+	case Evicted:
+		return "evicted"
+	case Expired:
+		return "expired"
+	case Replaced:
+		return "replaced"
+	case Deleted:
+		return "deleted"
+	case Cleared:
+		return "cleared"
+	case Rejected:
+		return "rejected"
+	default:
+		return "unknown"
+	}
+}
+
 // CacheItemSize is the overhead in bytes for every stored cache item
 var CacheItemSize = hack.RuntimeAllocSize(int64(unsafe.Sizeof(storeItem{})))
 
@@ -72,12 +120,27 @@ type Cache struct {
 	onReject itemCallback
 	// onExit is called whenever a value goes out of scope from the cache.
 	onExit func(interface{})
+	// onRemove is called with the reason whenever a value leaves the cache.
+	onRemove func(*Item, RemovalReason)
+	// keepKeys dictates whether the original string keys are kept in the store.
+	keepKeys bool
+	// syncSets makes every set wait for the policy decision.
+	syncSets bool
+	// trackedAdmissions is the maximum number of admission timestamps kept.
+	trackedAdmissions int
+	// hotKeys counts the accesses of the hottest keys, when enabled.
+	hotKeys *hotKeys
+	// keyRecorder names the hot keys which are not in the store.
+	keyRecorder KeyRecorder
 	// KeyToHash function is used to customize the key hashing algorithm.
 	// Each key will be hashed using the provided function. If keyToHash value
 	// is not set, the default keyToHash function is used.
 	keyToHash func(string) (uint64, uint64)
 	// stop is used to stop the processItems goroutine.
 	stop chan struct{}
+	// closing is closed when Close starts, releasing the synchronous writes
+	// waiting for room in setBuf or for the policy.
+	closing chan struct{}
 	// indicates whether cache is closed.
 	isClosed bool
 	// cost calculates cost from a value.
@@ -129,9 +192,19 @@ type Config struct {
 	// used to do manual memory deallocation. Would also be called on eviction
 	// and rejection of the value.
 	OnExit func(val interface{})
+	// OnRemove is called whenever a value leaves the cache, together with the
+	// reason it was removed. Setting it makes the cache keep the original key
+	// of every item so it can be reported in Item.OriginalKey.
+	OnRemove func(item *Item, reason RemovalReason)
+	// KeepKeys makes the cache store the original string key alongside every
+	// item, which is required by ForEachKV, Keys and DeletePrefix. It costs
+	// one string per item, and is implied by OnRemove.
+	KeepKeys bool
 	// KeyToHash function is used to customize the key hashing algorithm.
 	// Each key will be hashed using the provided function. If keyToHash value
-	// is not set, the default keyToHash function is used.
+	// is not set, the keys are hashed with hash/maphash and random seeds, or
+	// with the hash function of the Go runtime when built with the
+	// runtimehash tag.
 	KeyToHash func(string) (uint64, uint64)
 	// Cost evaluates a value and outputs a corresponding cost. This function
 	// is ran after Set is called for a new item or an item update with a cost
@@ -142,6 +215,78 @@ type Config struct {
 	// cost passed to set is not using bytes as units. Keep in mind that setting
 	// this to true will increase the memory usage.
 	IgnoreInternalCost bool
+	// SyncSets makes Set and SetWithCost wait until the policy has decided
+	// whether to keep the item, instead of queueing it and returning right
+	// away. Writes are then never dropped because the buffer is full.
+	SyncSets bool
+	// SetBufferItems is the number of writes that can be queued for the
+	// policy before asynchronous sets start being dropped. It defaults to 32k.
+	SetBufferItems int64
+	// HotKeys is the number of keys whose accesses are counted to report the
+	// hottest keys with HotKeys. Tracking a few times more keys than the
+	// number reported makes the report more accurate. Zero disables it.
+	HotKeys int
+	// KeyRecorder, if set with HotKeys, is given every key looked up by Get
+	// and names the hot keys which are not in the cache, or all of them when
+	// the cache doesn't keep its keys.
+	KeyRecorder KeyRecorder
+	// TrackedAdmissions is the maximum number of admission timestamps kept
+	// when Metrics is set, to report how long keys live. It defaults to 100k.
+	TrackedAdmissions int
+}
+
+// SetStatus is the outcome of a synchronous set.
+type SetStatus int
+
+const (
+	// SetAdmitted means the item was new and the policy accepted it.
+	SetAdmitted SetStatus = iota
+	// SetRejected means the item was new and the policy refused it.
+	SetRejected
+	// SetDropped means the item never reached the policy, because the cache
+	// is closed.
+	SetDropped
+	// SetUpdated means the key was already in the cache and its value was replaced.
+	SetUpdated
+	// SetExists means the key was already in the cache when a conditional set
+	// reached the policy, so the item was not added.
+	SetExists
+)
+
+// String returns a human readable name for the status.
+func (s SetStatus) String() string {
+	switch s {
+	case SetAdmitted:
+		return "admitted"
+	case SetRejected:
+		return "rejected"
+	case SetDropped:
+		return "dropped"
+	case SetUpdated:
+		return "updated"
+	case SetExists:
+		return "exists"
+	default:
+		return fmt.Sprintf("SetStatus(%d)", int(s))
+	}
+}
+
+// SetResult is the result of a synchronous set.
+type SetResult struct {
+	Status SetStatus
+	// Victims are the items the policy evicted while making room for the new
+	// item. The policy may evict some items and still reject the new one.
+	Victims []*Item
+}
+
+// setWaiter lets a synchronous set wait for the policy decision.
+type setWaiter struct {
+	done   chan struct{}
+	result SetResult
+}
+
+func newSetWaiter() *setWaiter {
+	return &setWaiter{done: make(chan struct{})}
 }
 
 type itemFlag byte
@@ -160,6 +305,17 @@ type Item struct {
 	Value    interface{}
 	Cost     int64
 	wg       *sync.WaitGroup
+	waiter   *setWaiter
+	// batch holds the items of a SetMulti, pushed to the Set buffer at once.
+	batch []*Item
+	// cond makes a new item conditional on the key being absent.
+	cond *condition
+	// queuedAt is when the item was pushed to the Set buffer, only recorded
+	// when metrics are enabled.
+	queuedAt time.Time
+	// OriginalKey is the key the item was set with. It is only populated
+	// when the cache keeps the original keys.
+	OriginalKey string
 }
 
 // NewCache returns a new Cache instance and any configuration errors, if any.
@@ -173,15 +329,32 @@ func NewCache(config *Config) (*Cache, error) {
 		return nil, errors.New("BufferItems can't be zero")
 	}
 	policy := newPolicy(config.NumCounters, config.MaxCost)
+	bufSize := config.SetBufferItems
+	if bufSize <= 0 {
+		bufSize = int64(setBufSize)
+	}
+	trackedAdmissions := config.TrackedAdmissions
+	if trackedAdmissions <= 0 {
+		trackedAdmissions = defaultTrackedAdmissions
+	}
 	cache := &Cache{
 		store:              newStore(),
 		policy:             policy,
 		getBuf:             newRingBuffer(policy, config.BufferItems),
-		setBuf:             make(chan *Item, setBufSize),
+		setBuf:             make(chan *Item, bufSize),
+		syncSets:           config.SyncSets,
+		trackedAdmissions:  trackedAdmissions,
 		keyToHash:          config.KeyToHash,
 		stop:               make(chan struct{}),
+		closing:            make(chan struct{}),
 		cost:               config.Cost,
 		ignoreInternalCost: config.IgnoreInternalCost,
+		keepKeys:           config.KeepKeys || config.OnRemove != nil,
+	}
+	cache.onRemove = func(item *Item, reason RemovalReason) {
+		if config.OnRemove != nil && item.Value != nil {
+			config.OnRemove(item, reason)
+		}
 	}
 	cache.onExit = func(val interface{}) {
 		if config.OnExit != nil && val != nil {
@@ -201,11 +374,16 @@ func NewCache(config *Config) (*Cache, error) {
 		cache.onExit(item.Value)
 	}
 	if cache.keyToHash == nil {
-		cache.keyToHash = defaultStringHash
+		cache.keyToHash = newStringHash()
 	}
 	if config.Metrics {
 		cache.collectMetrics()
 	}
+	if config.HotKeys > 0 {
+		cache.hotKeys = newHotKeys(config.HotKeys, config.NumCounters)
+		cache.keyRecorder = config.KeyRecorder
+		policy.TrackHotKeys(cache.hotKeys)
+	}
 	// NOTE: benchmarks seem to show that performance decreases the more
 	//       goroutines we have running cache.processItems(), so 1 should
 	//       usually be sufficient
@@ -232,6 +410,9 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 		return nil, false
 	}
 	keyHash, conflictHash := c.keyToHash(key)
+	if c.keyRecorder != nil {
+		c.keyRecorder.Record(key, keyHash)
+	}
 	c.getBuf.Push(keyHash)
 	value, ok := c.store.Get(keyHash, conflictHash)
 	if ok {
@@ -260,7 +441,137 @@ func (c *Cache) SetWithCost(key string, value interface{}, cost int64) bool {
 	if c == nil || c.isClosed {
 		return false
 	}
+	if c.syncSets {
+		status := c.SetWithCostSync(key, value, cost).Status
+		return status == SetAdmitted || status == SetUpdated
+	}
 
+	i := c.newSetItem(key, value, cost)
+	// Attempt to send item to policy.
+	select {
+	case c.setBuf <- i:
+		return true
+	default:
+		if i.flag == itemUpdate {
+			// Return true if this was an update operation since we've already
+			// updated the store. For all the other operations (set/delete), we
+			// return false which means the item was not inserted.
+			return true
+		}
+		c.Metrics.add(dropSets, i.Key, 1)
+		return false
+	}
+}
+
+// KeyValue is a key and its value, as given to SetMulti.
+type KeyValue struct {
+	Key   string
+	Value interface{}
+}
+
+// GetMulti looks up several keys at once. It returns the value of every key
+// and whether it was found, in the order of the keys. The accesses are
+// pushed to the admission policy as a single batch.
+func (c *Cache) GetMulti(keys []string) ([]interface{}, []bool) {
+	values, found := make([]interface{}, len(keys)), make([]bool, len(keys))
+	if c == nil || c.isClosed {
+		return values, found
+	}
+	// The hashes are copied into the Get buffer, so small batches can use the stack.
+	var buf [64]uint64
+	hashes := buf[:0]
+	if len(keys) > len(buf) {
+		hashes = make([]uint64, 0, len(keys))
+	}
+	for idx, key := range keys {
+		keyHash, conflictHash := c.keyToHash(key)
+		if c.keyRecorder != nil {
+			c.keyRecorder.Record(key, keyHash)
+		}
+		hashes = append(hashes, keyHash)
+		values[idx], found[idx] = c.store.Get(keyHash, conflictHash)
+		if found[idx] {
+			c.Metrics.add(hit, keyHash, 1)
+		} else {
+			c.Metrics.add(miss, keyHash, 1)
+		}
+	}
+	c.getBuf.PushBatch(hashes)
+	return values, found
+}
+
+// SetMulti sets several items at once, with the cost computed by Config.Cost.
+// It returns, in the order of the items, whether each one was queued or
+// updated, with the same meaning as the result of Set. The new items are
+// pushed to the Set buffer as a single batch, so that either all of them or
+// none are dropped when the buffer is full. With Config.SyncSets, SetMulti
+// waits for the policy to process the whole batch.
+func (c *Cache) SetMulti(items []KeyValue) []bool {
+	results := make([]bool, len(items))
+	if c == nil || c.isClosed || len(items) == 0 {
+		return results
+	}
+	batch := make([]*Item, len(items))
+	for idx, kv := range items {
+		i := c.newSetItem(kv.Key, kv.Value, 0)
+		if c.syncSets {
+			i.waiter = newSetWaiter()
+		}
+		batch[idx] = i
+	}
+
+	if c.syncSets {
+		if !c.push(&Item{batch: batch}) {
+			for idx, i := range batch {
+				results[idx] = i.flag == itemUpdate
+			}
+			return results
+		}
+		for idx, i := range batch {
+			status := c.wait(i).Status
+			results[idx] = status == SetAdmitted || status == SetUpdated
+		}
+		return results
+	}
+	select {
+	case c.setBuf <- &Item{batch: batch}:
+		for idx := range results {
+			results[idx] = true
+		}
+	default:
+		for idx, i := range batch {
+			// Updates are already applied to the store.
+			results[idx] = i.flag == itemUpdate
+			if !results[idx] {
+				c.Metrics.add(dropSets, i.Key, 1)
+			}
+		}
+	}
+	return results
+}
+
+// SetSync works like Set, but waits until the policy has decided whether to
+// keep the item and returns its decision, together with the items it evicted.
+func (c *Cache) SetSync(key string, value interface{}) SetResult {
+	return c.SetWithCostSync(key, value, 0)
+}
+
+// SetWithCostSync works like SetWithCost, but waits until the policy has decided
+// whether to keep the item and returns its decision, together with the items it
+// evicted. Unlike SetWithCost, it waits for room in the buffer instead of
+// dropping the item when the buffer is full, unless the cache is closed.
+func (c *Cache) SetWithCostSync(key string, value interface{}, cost int64) SetResult {
+	if c == nil || c.isClosed {
+		return SetResult{Status: SetDropped}
+	}
+	i := c.newSetItem(key, value, cost)
+	i.waiter = newSetWaiter()
+	return c.pushAndWait(i)
+}
+
+// newSetItem builds the item for a set. If the key is already in the store, its
+// value is replaced right away and the item becomes a cost update.
+func (c *Cache) newSetItem(key string, value interface{}, cost int64) *Item {
 	keyHash, conflictHash := c.keyToHash(key)
 	i := &Item{
 		flag:     itemNew,
@@ -269,37 +580,89 @@ func (c *Cache) SetWithCost(key string, value interface{}, cost int64) bool {
 		Value:    value,
 		Cost:     cost,
 	}
+	if c.keepKeys {
+		i.OriginalKey = key
+	}
+	if c.Metrics != nil {
+		i.queuedAt = time.Now()
+	}
 	// cost is eventually updated. The expiration must also be immediately updated
 	// to prevent items from being prematurely removed from the map.
 	if prev, ok := c.store.Update(i); ok {
+		c.onRemove(&Item{Key: keyHash, Conflict: conflictHash, Value: prev, OriginalKey: key}, Replaced)
 		c.onExit(prev)
 		i.flag = itemUpdate
 	}
-	// Attempt to send item to policy.
+	return i
+}
+
+// done reports the policy decision to a synchronous set waiting on the item.
+func (i *Item) done(status SetStatus, victims []*Item) {
+	if i.waiter == nil {
+		return
+	}
+	i.waiter.result = SetResult{Status: status, Victims: victims}
+	close(i.waiter.done)
+}
+
+// pushAndWait pushes an item to the Set buffer and waits for the policy. The
+// item is dropped if the cache is closed while it waits for either.
+func (c *Cache) pushAndWait(i *Item) SetResult {
+	if !c.push(i) {
+		if i.flag == itemUpdate {
+			// The value is already set in the store.
+			return SetResult{Status: SetUpdated}
+		}
+		return SetResult{Status: SetDropped}
+	}
+	return c.wait(i)
+}
+
+// push pushes an item to the Set buffer, waiting for room unless the cache is
+// closed first. It returns whether the item was pushed.
+func (c *Cache) push(i *Item) bool {
 	select {
 	case c.setBuf <- i:
 		return true
-	default:
-		if i.flag == itemUpdate {
-			// Return true if this was an update operation since we've already
-			// updated the store. For all the other operations (set/delete), we
-			// return false which means the item was not inserted.
-			return true
-		}
-		c.Metrics.add(dropSets, keyHash, 1)
+	case <-c.closing:
 		return false
 	}
 }
 
+// wait waits for the policy decision on a pushed item. If the cache is closed
+// first, the item may never be processed and is reported as dropped.
+func (c *Cache) wait(i *Item) SetResult {
+	select {
+	case <-i.waiter.done:
+		return i.waiter.result
+	case <-c.closing:
+		select {
+		case <-i.waiter.done:
+			return i.waiter.result
+		default:
+			return SetResult{Status: SetDropped}
+		}
+	}
+}
+
 // Delete deletes the key-value item from the cache if it exists.
 func (c *Cache) Delete(key string) {
 	if c == nil || c.isClosed {
 		return
 	}
+	c.del(key)
+}
+
+// del removes the key from the store and queues its removal from the policy.
+// It reports whether the key was found in the store.
+func (c *Cache) del(key string) bool {
 	keyHash, conflictHash := c.keyToHash(key)
 	// Delete immediately.
-	_, prev := c.store.Del(keyHash, conflictHash)
-	c.onExit(prev)
+	prev, ok := c.store.Del(keyHash, conflictHash)
+	if ok {
+		c.onRemove(&Item{Key: keyHash, Conflict: prev.conflict, Value: prev.value, OriginalKey: key}, Deleted)
+		c.onExit(prev.value)
+	}
 	// If we've set an item, it would be applied slightly later.
 	// So we must push the same item to `setBuf` with the deletion flag.
 	// This ensures that if a set is followed by a delete, it will be
@@ -309,21 +672,32 @@ func (c *Cache) Delete(key string) {
 		Key:      keyHash,
 		Conflict: conflictHash,
 	}
+	return ok
 }
 
-// Close stops all goroutines and closes all channels.
+// Close stops all goroutines and releases the synchronous writes still waiting
+// for the policy.
 func (c *Cache) Close() {
 	if c == nil || c.isClosed {
 		return
 	}
+	close(c.closing)
 	c.Clear()
 
 	// Block until processItems goroutine is returned.
 	c.stop <- struct{}{}
+	c.shutdown()
+}
+
+// shutdown closes the cache once processItems has returned. Items queued
+// since the last drain will never be processed, so their waiters are released
+// with SetDropped. setBuf is left open, since writers which checked isClosed
+// before Close may still push to it.
+func (c *Cache) shutdown() {
 	close(c.stop)
-	close(c.setBuf)
-	c.policy.Close()
 	c.isClosed = true
+	c.drain()
+	c.policy.Close()
 }
 
 // Clear empties the hashmap and zeroes all policy counters. Note that this is
@@ -336,8 +710,44 @@ func (c *Cache) Clear() {
 	// Block until processItems goroutine is returned.
 	c.stop <- struct{}{}
 
-	// Clear out the setBuf channel.
-loop:
+	c.drain()
+
+	// Clear value hashmap and policy data.
+	c.policy.Clear()
+	c.hotKeys.clear()
+	c.store.Clear(func(i *Item) {
+		c.onRemove(i, Cleared)
+		c.onEvict(i)
+	})
+	// Only reset metrics if they're enabled.
+	if c.Metrics != nil {
+		c.Metrics.Clear()
+	}
+	// Restart processItems goroutine.
+	go c.processItems()
+}
+
+// drain empties the setBuf channel while processItems is stopped, releasing
+// the goroutines waiting on the queued items. New items were never stored, so
+// they are reported to OnRemove as Rejected rather than Cleared; the entries
+// in the store are reported by Clear.
+func (c *Cache) drain() {
+	drop := func(i *Item) {
+		if i.cond != nil {
+			// The condition was never checked.
+			i.done(SetDropped, nil)
+			return
+		}
+		if i.flag != itemUpdate {
+			// In itemUpdate, the value is already set in the store.  So, no need to call
+			// onEvict here.
+			c.onRemove(i, Rejected)
+			c.onEvict(i)
+			i.done(SetDropped, nil)
+		} else {
+			i.done(SetUpdated, nil)
+		}
+	}
 	for {
 		select {
 		case i := <-c.setBuf:
@@ -345,25 +755,17 @@ loop:
 				i.wg.Done()
 				continue
 			}
-			if i.flag != itemUpdate {
-				// In itemUpdate, the value is already set in the store.  So, no need to call
-				// onEvict here.
-				c.onEvict(i)
+			if i.batch != nil {
+				for _, item := range i.batch {
+					drop(item)
+				}
+				continue
 			}
+			drop(i)
 		default:
-			break loop
+			return
 		}
 	}
-
-	// Clear value hashmap and policy data.
-	c.policy.Clear()
-	c.store.Clear(c.onEvict)
-	// Only reset metrics if they're enabled.
-	if c.Metrics != nil {
-		c.Metrics.Clear()
-	}
-	// Restart processItems goroutine.
-	go c.processItems()
 }
 
 // Len returns the size of the cache (in entries)
@@ -407,6 +809,46 @@ func (c *Cache) Evictions() int64 {
 	return int64(c.Metrics.KeysEvicted())
 }
 
+// Frequency returns the access frequency of the key estimated by the TinyLFU
+// admission policy.
+func (c *Cache) Frequency(key string) int64 {
+	if c == nil || c.isClosed {
+		return 0
+	}
+	keyHash, _ := c.keyToHash(key)
+	return c.policy.Frequency(keyHash)
+}
+
+// RecordFrequency records count accesses to the key in the TinyLFU admission
+// policy, as if it had been read count times. It is used to warm up the
+// policy, for instance when restoring a snapshot.
+func (c *Cache) RecordFrequency(key string, count int64) {
+	if c == nil || c.isClosed {
+		return
+	}
+	keyHash, _ := c.keyToHash(key)
+	c.policy.RecordFrequency(keyHash, count)
+}
+
+// Stats returns a snapshot of the cache statistics. Hit, miss and set counters
+// are only kept when the cache was created with Metrics enabled.
+func (c *Cache) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+	return Stats{
+		Hits:         c.Metrics.Hits(),
+		Misses:       c.Metrics.Misses(),
+		HitRatio:     c.Metrics.Ratio(),
+		Sets:         c.Metrics.KeysAdded() + c.Metrics.KeysUpdated(),
+		SetsRejected: c.Metrics.SetsRejected(),
+		SetsDropped:  c.Metrics.SetsDropped(),
+		Evictions:    c.Metrics.KeysEvicted(),
+		UsedCapacity: c.UsedCapacity(),
+		MaxCapacity:  c.MaxCapacity(),
+	}
+}
+
 // ForEach yields all the values currently stored in the cache to the given callback.
 // The callback may return `false` to stop the iteration early.
 func (c *Cache) ForEach(forEach func(interface{}) bool) {
@@ -416,10 +858,75 @@ func (c *Cache) ForEach(forEach func(interface{}) bool) {
 	c.store.ForEach(forEach)
 }
 
+// KeepsKeys returns true if the cache stores the original keys of its items.
+func (c *Cache) KeepsKeys() bool {
+	return c != nil && c.keepKeys
+}
+
+// ForEachKV yields all the keys and values currently stored in the cache to the
+// given callback. The callback may return `false` to stop the iteration early.
+// It yields nothing unless the cache keeps the original keys.
+func (c *Cache) ForEachKV(forEach func(key string, value interface{}) bool) {
+	if !c.KeepsKeys() {
+		return
+	}
+	c.store.ForEachKV(forEach)
+}
+
+// Keys returns the keys currently stored in the cache that start with the given
+// prefix, in no particular order. It returns nothing unless the cache keeps the
+// original keys.
+func (c *Cache) Keys(prefix string) []string {
+	var keys []string
+	c.ForEachKV(func(key string, _ interface{}) bool {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// DeletePrefix deletes all the items whose key starts with the given prefix, and
+// returns how many were deleted. Items set concurrently may be missed. It
+// deletes nothing unless the cache keeps the original keys.
+func (c *Cache) DeletePrefix(prefix string) int {
+	if c == nil || c.isClosed {
+		return 0
+	}
+	var deleted int
+	for _, key := range c.Keys(prefix) {
+		if c.del(key) {
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// HotKeys returns up to k of the most accessed keys, hottest first, or nil
+// when the cache isn't configured to track them. Keys are only named when
+// the cache keeps its keys or has a KeyRecorder. The counts come from the
+// same sampled access stream as the admission policy, so accesses dropped
+// under contention are not counted.
+func (c *Cache) HotKeys(k int) []HotKey {
+	if c == nil || c.isClosed {
+		return nil
+	}
+	keys := c.hotKeys.top(k)
+	for i := range keys {
+		if key, ok := c.store.OriginalKey(keys[i].KeyHash); ok {
+			keys[i].Key = key
+		} else if c.keyRecorder != nil {
+			keys[i].Key, _ = c.keyRecorder.Key(keys[i].KeyHash)
+		}
+	}
+	return keys
+}
+
 // processItems is ran by goroutines processing the Set buffer.
 func (c *Cache) processItems() {
 	startTs := make(map[uint64]time.Time)
-	numToKeep := 100000 // TODO: Make this configurable via options.
+	numToKeep := c.trackedAdmissions
 
 	trackAdmission := func(key uint64) {
 		if c.Metrics == nil {
@@ -435,13 +942,82 @@ func (c *Cache) processItems() {
 			}
 		}
 	}
+	// trackRemoval records how long a key lived, from its admission to its
+	// eviction or deletion. Keys admitted before the cache started tracking
+	// them, or forgotten to bound the memory used, are not recorded.
+	trackRemoval := func(key uint64, evicted bool) {
+		if ts, ok := startTs[key]; ok {
+			c.Metrics.trackLifetime(time.Since(ts), evicted)
+			delete(startTs, key)
+		}
+	}
 	onEvict := func(i *Item) {
-		delete(startTs, i.Key)
+		trackRemoval(i.Key, true)
 		if c.onEvict != nil {
 			c.onEvict(i)
 		}
 	}
 
+	var process func(i *Item)
+	process = func(i *Item) {
+		if i.cond != nil {
+			add, next := c.applyCondition(i)
+			if next != nil {
+				process(next)
+			}
+			if !add {
+				return
+			}
+		}
+		// Calculate item cost value if new or update.
+		if i.Cost == 0 && c.cost != nil && i.flag != itemDelete {
+			i.Cost = c.cost(i.Value)
+		}
+		if !c.ignoreInternalCost {
+			// Add the cost of internally storing the object.
+			i.Cost += CacheItemSize
+		}
+
+		switch i.flag {
+		case itemNew:
+			if !i.queuedAt.IsZero() {
+				c.Metrics.trackAdmissionLatency(time.Since(i.queuedAt))
+			}
+			victims, added := c.policy.Add(i.Key, i.Cost)
+			if added {
+				c.store.Set(i)
+				c.Metrics.add(keyAdd, i.Key, 1)
+				trackAdmission(i.Key)
+			} else {
+				c.onRemove(i, Rejected)
+				c.onReject(i)
+			}
+			for _, victim := range victims {
+				si, _ := c.store.Del(victim.Key, 0)
+				victim.Conflict, victim.Value, victim.OriginalKey = si.conflict, si.value, si.original
+				c.onRemove(victim, Evicted)
+				onEvict(victim)
+			}
+			if added {
+				i.done(SetAdmitted, victims)
+			} else {
+				i.done(SetRejected, victims)
+			}
+
+		case itemUpdate:
+			c.policy.Update(i.Key, i.Cost)
+			i.done(SetUpdated, nil)
+
+		case itemDelete:
+			c.policy.Del(i.Key) // Deals with metrics updates.
+			trackRemoval(i.Key, false)
+			if si, ok := c.store.Del(i.Key, i.Conflict); ok {
+				c.onRemove(&Item{Key: si.key, Conflict: si.conflict, Value: si.value, OriginalKey: si.original}, Deleted)
+				c.onExit(si.value)
+			}
+		}
+	}
+
 	for {
 		select {
 		case i := <-c.setBuf:
@@ -449,38 +1025,13 @@ func (c *Cache) processItems() {
 				i.wg.Done()
 				continue
 			}
-			// Calculate item cost value if new or update.
-			if i.Cost == 0 && c.cost != nil && i.flag != itemDelete {
-				i.Cost = c.cost(i.Value)
-			}
-			if !c.ignoreInternalCost {
-				// Add the cost of internally storing the object.
-				i.Cost += CacheItemSize
-			}
-
-			switch i.flag {
-			case itemNew:
-				victims, added := c.policy.Add(i.Key, i.Cost)
-				if added {
-					c.store.Set(i)
-					c.Metrics.add(keyAdd, i.Key, 1)
-					trackAdmission(i.Key)
-				} else {
-					c.onReject(i)
-				}
-				for _, victim := range victims {
-					victim.Conflict, victim.Value = c.store.Del(victim.Key, 0)
-					onEvict(victim)
+			if i.batch != nil {
+				for _, item := range i.batch {
+					process(item)
 				}
-
-			case itemUpdate:
-				c.policy.Update(i.Key, i.Cost)
-
-			case itemDelete:
-				c.policy.Del(i.Key) // Deals with metrics updates.
-				_, val := c.store.Del(i.Key, i.Conflict)
-				c.onExit(val)
+				continue
 			}
+			process(i)
 		case <-c.stop:
 			return
 		}
@@ -547,13 +1098,56 @@ func stringFor(t metricType) string {
 	}
 }
 
+// Stats is a point-in-time summary of the statistics of a cache. It is shared
+// by every cache engine, so that statistics can be collected the same way no
+// matter which implementation is configured.
+type Stats struct {
+	// Hits is the number of Get calls that found a value.
+	Hits uint64
+	// Misses is the number of Get calls that did not find a value.
+	Misses uint64
+	// HitRatio is Hits over all the Get calls.
+	HitRatio float64
+	// Sets is the number of Set calls that stored or updated a value.
+	Sets uint64
+	// SetsRejected is the number of Set calls rejected by the admission policy.
+	SetsRejected uint64
+	// SetsDropped is the number of Set calls dropped under contention.
+	SetsDropped uint64
+	// Evictions is the number of entries evicted to make room for others.
+	Evictions uint64
+	// UsedCapacity is the cost currently used by the cache.
+	UsedCapacity int64
+	// MaxCapacity is the maximum cost the cache can hold.
+	MaxCapacity int64
+	// LoadSuccesses is the number of values successfully loaded on a miss.
+	LoadSuccesses uint64
+	// LoadFailures is the number of loads on a miss that returned an error.
+	LoadFailures uint64
+	// TotalLoadTime is the time spent loading values on misses.
+	TotalLoadTime time.Duration
+}
+
 // Metrics is a snapshot of performance statistics for the lifetime of a cache instance.
 type Metrics struct {
 	all [doNotUse][]*uint64
+
+	mu sync.RWMutex
+	// life tracks how long keys stay in the cache, until evicted or deleted.
+	life *HistogramData
+	// victimAge tracks how long evicted keys stayed in the cache.
+	victimAge *HistogramData
+	// admission tracks how long new items wait in the Set buffer before the
+	// policy decides whether to admit them.
+	admission *HistogramData
 }
 
 func newMetrics() *Metrics {
-	s := &Metrics{}
+	s := &Metrics{
+		life:      newHistogramData(time.Millisecond, 25),
+		victimAge: newHistogramData(time.Millisecond, 25),
+		admission: newHistogramData(time.Microsecond, 20),
+	}
 	for i := 0; i < doNotUse; i++ {
 		s.all[i] = make([]*uint64, 256)
 		slice := s.all[i]
@@ -644,6 +1238,62 @@ func (p *Metrics) GetsKept() uint64 {
 	return p.get(keepGets)
 }
 
+func (p *Metrics) trackLifetime(d time.Duration, evicted bool) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.life.Update(d)
+	if evicted {
+		p.victimAge.Update(d)
+	}
+	p.mu.Unlock()
+}
+
+func (p *Metrics) trackAdmissionLatency(d time.Duration) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.admission.Update(d)
+	p.mu.Unlock()
+}
+
+// LifeExpectancy is the distribution of how long keys stayed in the cache,
+// from their admission to their eviction or deletion. Only the most recently
+// admitted keys are tracked, up to Config.TrackedAdmissions of them.
+func (p *Metrics) LifeExpectancy() *HistogramData {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.life.Copy()
+}
+
+// VictimAges is the distribution of the ages of the keys evicted by the
+// policy. When most victims are much younger than the keys removed by
+// deletes, MaxCost is likely too small for the working set.
+func (p *Metrics) VictimAges() *HistogramData {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.victimAge.Copy()
+}
+
+// AdmissionLatency is the distribution of the time new items waited in the
+// Set buffer before the policy decided whether to admit them.
+func (p *Metrics) AdmissionLatency() *HistogramData {
+	if p == nil {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.admission.Copy()
+}
+
 // Ratio is the number of Hits over all accesses (Hits + Misses). This is the
 // percentage of successful Get calls.
 func (p *Metrics) Ratio() float64 {
@@ -667,6 +1317,11 @@ func (p *Metrics) Clear() {
 			atomic.StoreUint64(p.all[i][j], 0)
 		}
 	}
+	p.mu.Lock()
+	p.life.Clear()
+	p.victimAge.Clear()
+	p.admission.Clear()
+	p.mu.Unlock()
 }
 
 // String returns a string representation of the metrics.
@@ -680,6 +1335,11 @@ func (p *Metrics) String() string {
 		fmt.Fprintf(&buf, "%s: %d ", stringFor(t), p.get(t))
 	}
 	fmt.Fprintf(&buf, "gets-total: %d ", p.get(hit)+p.get(miss))
-	fmt.Fprintf(&buf, "hit-ratio: %.2f", p.Ratio())
+	fmt.Fprintf(&buf, "hit-ratio: %.2f ", p.Ratio())
+	p.mu.RLock()
+	fmt.Fprintf(&buf, "life-expectancy: {%s} ", p.life)
+	fmt.Fprintf(&buf, "victim-ages: {%s} ", p.victimAge)
+	fmt.Fprintf(&buf, "admission-latency: {%s}", p.admission)
+	p.mu.RUnlock()
 	return buf.String()
 }
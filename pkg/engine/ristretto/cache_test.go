@@ -23,6 +23,7 @@ package ristretto
 import (
 	"fmt"
 	"math/rand"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -57,6 +58,60 @@ func TestCacheKeyToHash(t *testing.T) {
 	require.Equal(t, 3, keyToHashCount)
 }
 
+func TestCacheKeyHashSeeds(t *testing.T) {
+	c1, err := NewCache(&Config{NumCounters: 10, MaxCost: 10, BufferItems: 64})
+	require.NoError(t, err)
+	defer c1.Close()
+	c2, err := NewCache(&Config{NumCounters: 10, MaxCost: 10, BufferItems: 64})
+	require.NoError(t, err)
+	defer c2.Close()
+
+	key1, conflict1 := c1.keyToHash("key")
+	key2, conflict2 := c2.keyToHash("key")
+	require.NotEqual(t, key1, key2)
+	require.NotEqual(t, conflict1, conflict2)
+	require.NotEqual(t, key1, conflict1)
+	key, conflict := c1.keyToHash("key")
+	require.Equal(t, key1, key)
+	require.Equal(t, conflict1, conflict)
+}
+
+func TestCacheKeyCollisions(t *testing.T) {
+	// Every key has the same key hash, and only the conflict hash tells them
+	// apart.
+	c, err := NewCache(&Config{
+		NumCounters:        10,
+		MaxCost:            10,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+		KeyToHash: func(key string) (uint64, uint64) {
+			return 1, uint64(len(key))
+		},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.True(t, c.SetWithCost("a", 1, 1))
+	c.Wait()
+	_, ok := c.Get("bb")
+	require.False(t, ok)
+
+	// A colliding key does not overwrite the value of another.
+	c.SetWithCost("bb", 2, 1)
+	c.Wait()
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	_, ok = c.Get("bb")
+	require.False(t, ok)
+
+	// Nor does deleting it delete the other.
+	c.Delete("bb")
+	c.Wait()
+	_, ok = c.Get("a")
+	require.True(t, ok)
+}
+
 func TestCacheMaxCost(t *testing.T) {
 	charset := "abcdefghijklmnopqrstuvwxyz0123456789"
 	key := func() string {
@@ -134,6 +189,38 @@ func TestUpdateMaxCost(t *testing.T) {
 	c.Delete("1")
 }
 
+func TestSetCapacityConcurrentGets(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     10,
+		BufferItems: 1,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				c.Get(strconv.Itoa(i % 50))
+			}
+		}
+	}()
+	for _, capacity := range []int64{100, 1000, 10, 10000} {
+		c.SetCapacity(capacity)
+		require.Equal(t, capacity, c.MaxCapacity())
+	}
+	close(stop)
+	wg.Wait()
+	require.Equal(t, int64(100*1000), c.policy.(*defaultPolicy).admit.resetAt)
+}
+
 func TestNewCache(t *testing.T) {
 	_, err := NewCache(&Config{
 		NumCounters: 0,
@@ -232,6 +319,54 @@ func TestDelAfterClose(t *testing.T) {
 	c.Delete("1")
 }
 
+func TestCacheOnRemove(t *testing.T) {
+	m := &sync.Mutex{}
+	removed := make(map[string]RemovalReason)
+	c, err := NewCache(&Config{
+		NumCounters:        100,
+		MaxCost:            3,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+		OnRemove: func(item *Item, reason RemovalReason) {
+			m.Lock()
+			defer m.Unlock()
+			removed[item.OriginalKey] = reason
+		},
+	})
+	require.NoError(t, err)
+	reason := func(key string) (RemovalReason, bool) {
+		m.Lock()
+		defer m.Unlock()
+		r, ok := removed[key]
+		return r, ok
+	}
+
+	require.True(t, c.SetWithCost("replaced", 1, 1))
+	c.Wait()
+	require.True(t, c.SetWithCost("replaced", 2, 1))
+	r, ok := reason("replaced")
+	require.True(t, ok)
+	require.Equal(t, Replaced, r)
+
+	require.True(t, c.SetWithCost("deleted", 1, 1))
+	c.Wait()
+	c.Delete("deleted")
+	r, _ = reason("deleted")
+	require.Equal(t, Deleted, r)
+
+	require.True(t, c.SetWithCost("huge", 1, 10))
+	c.Wait()
+	r, _ = reason("huge")
+	require.Equal(t, Rejected, r)
+
+	require.True(t, c.SetWithCost("cleared", 1, 1))
+	c.Wait()
+	c.Clear()
+	r, _ = reason("cleared")
+	require.Equal(t, Cleared, r)
+	require.Equal(t, "cleared", r.String())
+}
+
 func TestCacheProcessItems(t *testing.T) {
 	m := &sync.Mutex{}
 	evicted := make(map[uint64]struct{})
@@ -254,7 +389,7 @@ func TestCacheProcessItems(t *testing.T) {
 	var key uint64
 	var conflict uint64
 
-	key, conflict = defaultStringHash("1")
+	key, conflict = c.keyToHash("1")
 	c.setBuf <- &Item{
 		flag:     itemNew,
 		Key:      key,
@@ -266,7 +401,7 @@ func TestCacheProcessItems(t *testing.T) {
 	require.True(t, c.policy.Has(key))
 	require.Equal(t, int64(1), c.policy.Cost(key))
 
-	key, conflict = defaultStringHash("1")
+	key, conflict = c.keyToHash("1")
 	c.setBuf <- &Item{
 		flag:     itemUpdate,
 		Key:      key,
@@ -277,20 +412,20 @@ func TestCacheProcessItems(t *testing.T) {
 	time.Sleep(wait)
 	require.Equal(t, int64(2), c.policy.Cost(key))
 
-	key, conflict = defaultStringHash("1")
+	key, conflict = c.keyToHash("1")
 	c.setBuf <- &Item{
 		flag:     itemDelete,
 		Key:      key,
 		Conflict: conflict,
 	}
 	time.Sleep(wait)
-	key, conflict = defaultStringHash("1")
+	key, conflict = c.keyToHash("1")
 	val, ok := c.store.Get(key, conflict)
 	require.False(t, ok)
 	require.Nil(t, val)
 	require.False(t, c.policy.Has(1))
 
-	key, conflict = defaultStringHash("2")
+	key, conflict = c.keyToHash("2")
 	c.setBuf <- &Item{
 		flag:     itemNew,
 		Key:      key,
@@ -298,7 +433,7 @@ func TestCacheProcessItems(t *testing.T) {
 		Value:    2,
 		Cost:     3,
 	}
-	key, conflict = defaultStringHash("3")
+	key, conflict = c.keyToHash("3")
 	c.setBuf <- &Item{
 		flag:     itemNew,
 		Key:      key,
@@ -306,7 +441,7 @@ func TestCacheProcessItems(t *testing.T) {
 		Value:    3,
 		Cost:     3,
 	}
-	key, conflict = defaultStringHash("4")
+	key, conflict = c.keyToHash("4")
 	c.setBuf <- &Item{
 		flag:     itemNew,
 		Key:      key,
@@ -314,7 +449,7 @@ func TestCacheProcessItems(t *testing.T) {
 		Value:    3,
 		Cost:     3,
 	}
-	key, conflict = defaultStringHash("5")
+	key, conflict = c.keyToHash("5")
 	c.setBuf <- &Item{
 		flag:     itemNew,
 		Key:      key,
@@ -327,11 +462,9 @@ func TestCacheProcessItems(t *testing.T) {
 	require.NotEqual(t, 0, len(evicted))
 	m.Unlock()
 
-	defer func() {
-		require.NotNil(t, recover())
-	}()
 	c.Close()
-	c.setBuf <- &Item{flag: itemNew}
+	// setBuf is left open, so that writers racing with Close do not panic.
+	require.NotPanics(t, func() { c.setBuf <- &Item{flag: itemNew} })
 }
 
 func TestCacheGet(t *testing.T) {
@@ -344,7 +477,7 @@ func TestCacheGet(t *testing.T) {
 	})
 	require.NoError(t, err)
 
-	key, conflict := defaultStringHash("1")
+	key, conflict := c.keyToHash("1")
 	i := Item{
 		Key:      key,
 		Conflict: conflict,
@@ -398,13 +531,13 @@ func TestCacheSet(t *testing.T) {
 	retrySet(t, c, "1", 1, 1)
 
 	c.SetWithCost("1", 2, 2)
-	val, ok := c.store.Get(defaultStringHash("1"))
+	val, ok := c.store.Get(c.keyToHash("1"))
 	require.True(t, ok)
 	require.Equal(t, 2, val.(int))
 
 	c.stop <- struct{}{}
 	for i := 0; i < setBufSize; i++ {
-		key, conflict := defaultStringHash("1")
+		key, conflict := c.keyToHash("1")
 		c.setBuf <- &Item{
 			flag:     itemUpdate,
 			Key:      key,
@@ -508,6 +641,43 @@ func TestCacheMetrics(t *testing.T) {
 	require.Equal(t, uint64(10), m.KeysAdded())
 }
 
+func TestCacheMetricsLifetimes(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters:        100,
+		MaxCost:            2,
+		IgnoreInternalCost: true,
+		BufferItems:        64,
+		Metrics:            true,
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, SetAdmitted, c.SetWithCostSync("a", 1, 1).Status)
+	require.Equal(t, SetAdmitted, c.SetWithCostSync("b", 2, 1).Status)
+	time.Sleep(10 * time.Millisecond)
+	c.Delete("a")
+	c.Wait()
+
+	// "c" takes the room left by "a", "d" evicts "b".
+	c.RecordFrequency("c", 5)
+	c.RecordFrequency("d", 5)
+	require.Equal(t, SetAdmitted, c.SetWithCostSync("c", 3, 1).Status)
+	res := c.SetWithCostSync("d", 4, 1)
+	require.Equal(t, SetAdmitted, res.Status)
+	require.Len(t, res.Victims, 1)
+
+	m := c.Metrics
+	life, victims := m.LifeExpectancy(), m.VictimAges()
+	require.Equal(t, int64(2), life.Count)
+	require.GreaterOrEqual(t, int64(life.Min), int64(10*time.Millisecond))
+	require.Equal(t, int64(1), victims.Count)
+	require.Equal(t, int64(4), m.AdmissionLatency().Count)
+	require.Contains(t, m.String(), "victim-ages: {count: 1 ")
+
+	c.Clear()
+	require.Equal(t, int64(0), m.LifeExpectancy().Count)
+	require.Equal(t, int64(0), m.AdmissionLatency().Count)
+}
+
 func TestMetrics(t *testing.T) {
 	newMetrics()
 }
@@ -527,6 +697,9 @@ func TestNilMetrics(t *testing.T) {
 	} {
 		require.Equal(t, uint64(0), f())
 	}
+	require.Nil(t, m.LifeExpectancy())
+	require.Nil(t, m.VictimAges())
+	require.Nil(t, m.AdmissionLatency())
 }
 
 func TestMetricsAddGet(t *testing.T) {
@@ -689,3 +862,297 @@ func newTestCache() (*Cache, error) {
 		Metrics:     true,
 	})
 }
+
+func TestCacheRecordFrequency(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     10,
+		BufferItems: 64,
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(0), c.Frequency("key"))
+	c.RecordFrequency("key", 4)
+	require.Equal(t, int64(4), c.Frequency("key"))
+	c.RecordFrequency("key", 100)
+	require.Equal(t, int64(16), c.Frequency("key"))
+	c.Close()
+	require.Equal(t, int64(0), c.Frequency("key"))
+}
+
+func TestCacheKeepKeys(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters:        100,
+		MaxCost:            100,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+		KeepKeys:           true,
+	})
+	require.NoError(t, err)
+	require.True(t, c.KeepsKeys())
+	for _, key := range []string{"user:1", "user:2", "user:3", "order:1"} {
+		require.True(t, c.SetWithCost(key, key+"-value", 1))
+	}
+	c.Wait()
+
+	kv := make(map[string]interface{})
+	c.ForEachKV(func(key string, value interface{}) bool {
+		kv[key] = value
+		return true
+	})
+	require.Equal(t, map[string]interface{}{
+		"user:1":  "user:1-value",
+		"user:2":  "user:2-value",
+		"user:3":  "user:3-value",
+		"order:1": "order:1-value",
+	}, kv)
+
+	keys := c.Keys("user:")
+	sort.Strings(keys)
+	require.Equal(t, []string{"user:1", "user:2", "user:3"}, keys)
+
+	require.Equal(t, 3, c.DeletePrefix("user:"))
+	c.Wait()
+	require.Empty(t, c.Keys("user:"))
+	require.Equal(t, []string{"order:1"}, c.Keys(""))
+	require.Equal(t, 1, c.Len())
+}
+
+func TestCacheWithoutKeys(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters:        100,
+		MaxCost:            100,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+	})
+	require.NoError(t, err)
+	require.False(t, c.KeepsKeys())
+	c.SetWithCost("key", "value", 1)
+	c.Wait()
+	require.Empty(t, c.Keys(""))
+	require.Zero(t, c.DeletePrefix(""))
+	_, ok := c.Get("key")
+	require.True(t, ok)
+}
+
+func TestCacheSetSync(t *testing.T) {
+	var evicted []string
+	c, err := NewCache(&Config{
+		NumCounters:        100,
+		MaxCost:            3,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+		KeepKeys:           true,
+		OnEvict: func(item *Item) {
+			evicted = append(evicted, item.OriginalKey)
+		},
+	})
+	require.NoError(t, err)
+
+	// Admitted items are visible without calling Wait.
+	for _, key := range []string{"a", "b", "c"} {
+		res := c.SetWithCostSync(key, key, 1)
+		require.Equal(t, SetAdmitted, res.Status)
+		require.Empty(t, res.Victims)
+		_, ok := c.Get(key)
+		require.True(t, ok)
+	}
+
+	res := c.SetWithCostSync("a", "a2", 1)
+	require.Equal(t, SetUpdated, res.Status)
+
+	// Make "d" popular enough to be admitted over one of the others.
+	for _, key := range []string{"a", "b", "c"} {
+		c.RecordFrequency(key, 2)
+	}
+	c.RecordFrequency("d", 10)
+	res = c.SetWithCostSync("d", "d", 1)
+	require.Equal(t, SetAdmitted, res.Status)
+	require.Len(t, res.Victims, 1)
+	require.Equal(t, evicted, []string{res.Victims[0].OriginalKey})
+	require.NotNil(t, res.Victims[0].Value)
+
+	// A cold item does not make it in.
+	res = c.SetWithCostSync("e", "e", 1)
+	require.Equal(t, SetRejected, res.Status)
+	_, ok := c.Get("e")
+	require.False(t, ok)
+
+	// Items bigger than the cache are always rejected.
+	require.Equal(t, SetRejected, c.SetWithCostSync("big", "big", 10).Status)
+
+	var nilCache *Cache
+	require.Equal(t, SetDropped, nilCache.SetSync("a", "a").Status)
+	require.Equal(t, "dropped", SetDropped.String())
+}
+
+func TestCacheCloseReleasesSyncSets(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters:        100,
+		MaxCost:            10,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+		SyncSets:           true,
+	})
+	require.NoError(t, err)
+
+	// Stop processItems, as Close does, with sets still to be queued.
+	c.stop <- struct{}{}
+	results := make(chan SetStatus, 3)
+	go func() { results <- c.SetSync("a", 1).Status }()
+	go func() {
+		ok := c.SetMulti([]KeyValue{{"b", 2}, {"c", 3}})
+		if ok[0] || ok[1] {
+			results <- SetAdmitted
+			return
+		}
+		results <- SetDropped
+	}()
+	go func() { results <- c.SetSync("d", 4).Status }()
+	// Take the items out and put them back, so that the sets are known to
+	// have been queued before the cache is closed.
+	var queued []*Item
+	for len(queued) < 3 {
+		queued = append(queued, <-c.setBuf)
+	}
+	for _, i := range queued {
+		c.setBuf <- i
+	}
+	c.shutdown()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case status := <-results:
+			require.Equal(t, SetDropped, status)
+		case <-time.After(5 * time.Second):
+			t.Fatal("synchronous set still waiting after Close")
+		}
+	}
+}
+
+func TestCacheClearReportsStoredEntries(t *testing.T) {
+	var mu sync.Mutex
+	cleared, rejected := map[string]bool{}, map[string]bool{}
+	c, err := NewCache(&Config{
+		NumCounters:        100,
+		MaxCost:            10,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+		OnRemove: func(item *Item, reason RemovalReason) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch reason {
+			case Cleared:
+				cleared[item.OriginalKey] = true
+			case Rejected:
+				rejected[item.OriginalKey] = true
+			}
+		},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, SetAdmitted, c.SetWithCostSync("stored", 1, 1).Status)
+
+	// Items still queued when the buffer is drained never entered the cache.
+	c.stop <- struct{}{}
+	require.True(t, c.SetWithCost("queued", 2, 1))
+	done := make(chan bool)
+	go func() { done <- c.SetIfAbsent("absent", 3) }()
+	for len(c.setBuf) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	c.drain()
+	require.False(t, <-done)
+	go c.processItems()
+
+	c.Clear()
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, map[string]bool{"stored": true}, cleared)
+	require.Equal(t, map[string]bool{"queued": true}, rejected)
+}
+
+func TestCacheSyncSetsConfig(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters:        1000,
+		MaxCost:            1000,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+		SyncSets:           true,
+		SetBufferItems:     1,
+		TrackedAdmissions:  10,
+		Metrics:            true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, cap(c.setBuf))
+	require.Equal(t, 10, c.trackedAdmissions)
+
+	// With a single slot in the buffer, asynchronous sets would be dropped.
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				require.True(t, c.SetWithCost(fmt.Sprintf("%d-%d", g, i), i, 1))
+			}
+		}(g)
+	}
+	wg.Wait()
+	require.Equal(t, 400, c.Len())
+	require.Zero(t, c.Metrics.SetsDropped())
+}
+
+func TestCacheGetSetMulti(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters:        100,
+		MaxCost:            10,
+		IgnoreInternalCost: true,
+		BufferItems:        64,
+		Cost:               func(interface{}) int64 { return 1 },
+		Metrics:            true,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.Equal(t, []bool{true, true}, c.SetMulti([]KeyValue{{"a", 1}, {"b", 2}}))
+	c.Wait()
+	values, found := c.GetMulti([]string{"a", "c", "b"})
+	require.Equal(t, []interface{}{1, nil, 2}, values)
+	require.Equal(t, []bool{true, false, true}, found)
+	require.Equal(t, uint64(2), c.Metrics.Hits())
+	require.Equal(t, uint64(1), c.Metrics.Misses())
+
+	require.Equal(t, []bool{true}, c.SetMulti([]KeyValue{{"a", 10}}))
+	c.Wait()
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 10, v)
+	require.Equal(t, uint64(1), c.Metrics.KeysUpdated())
+
+	// Pending batches are dropped by Clear.
+	c.SetMulti([]KeyValue{{"x", 1}, {"y", 2}})
+	c.Clear()
+	c.Wait()
+	require.Equal(t, 0, c.Len())
+}
+
+func TestCacheSetMultiSync(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters:        100,
+		MaxCost:            2,
+		IgnoreInternalCost: true,
+		BufferItems:        64,
+		Cost:               func(interface{}) int64 { return 1 },
+		SyncSets:           true,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.Equal(t, []bool{true, true}, c.SetMulti([]KeyValue{{"a", 1}, {"b", 2}}))
+	require.Equal(t, 2, c.Len())
+	// "c" is rejected: it was never accessed, unlike "a" and "b".
+	c.RecordFrequency("a", 2)
+	c.RecordFrequency("b", 2)
+	require.Equal(t, []bool{true, false}, c.SetMulti([]KeyValue{{"a", 3}, {"c", 3}}))
+	require.Equal(t, []bool{false}, (*Cache)(nil).SetMulti([]KeyValue{{"a", 1}}))
+}
@@ -0,0 +1,225 @@
+package ristretto
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import "time"
+
+// Conditional writes are atomic with respect to every other write to the
+// same key. Values of present keys are changed under the lock of their store
+// shard. New keys are only ever added to the store by processItems, so a
+// conditional write adding a key checks that it is still absent there, and
+// waits for the outcome like SetSync.
+
+// condition makes a new item conditional on its key being absent when the
+// policy processes it.
+type condition struct {
+	// compute, if set, computes the value of the item from the current value
+	// of the key, as given to Compute.
+	compute func(value interface{}, found bool) (interface{}, bool)
+	// value and ok are the result of compute.
+	value interface{}
+	ok    bool
+}
+
+// SetIfAbsent sets the value of a key only if it is not in the cache, and
+// returns whether the value was added. Like SetSync, it waits for the policy
+// to decide whether to admit the item, with the cost computed by Config.Cost.
+func (c *Cache) SetIfAbsent(key string, value interface{}) bool {
+	if c == nil || c.isClosed {
+		return false
+	}
+	keyHash, conflictHash := c.keyToHash(key)
+	if _, ok := c.store.Get(keyHash, conflictHash); ok {
+		return false
+	}
+	i := c.newConditionalItem(key, keyHash, conflictHash, &condition{})
+	i.Value = value
+	return c.pushAndWait(i).Status == SetAdmitted
+}
+
+// Replace sets the value of a key only if it is already in the cache, and
+// returns whether it was.
+func (c *Cache) Replace(key string, value interface{}) bool {
+	if c == nil || c.isClosed {
+		return false
+	}
+	i := c.newUpdateItem(key, value)
+	prev, ok := c.store.Update(i)
+	if !ok {
+		return false
+	}
+	c.replaced(i, key, prev)
+	return true
+}
+
+// CompareAndSwap sets the value of a key to new only if its current value is
+// old, and returns whether it was swapped. The values are compared with ==;
+// values of uncomparable types, such as []byte, never match.
+func (c *Cache) CompareAndSwap(key string, old, new interface{}) bool {
+	if c == nil || c.isClosed {
+		return false
+	}
+	i := c.newUpdateItem(key, new)
+	if !c.store.CompareAndSwap(i, old) {
+		return false
+	}
+	c.replaced(i, key, old)
+	return true
+}
+
+// Compute atomically updates the value of a key. The function is given the
+// current value of the key and whether it was found, and returns the new
+// value and whether to keep it: when it returns false, the key is deleted.
+// Compute returns the new value and whether the key is in the cache after
+// the call; a new key may still be rejected by the policy.
+//
+// The function runs under the lock of the key's store shard, or in the
+// goroutine applying sets when the key is absent, so it must be fast and
+// must not use the cache.
+func (c *Cache) Compute(key string, fn func(value interface{}, found bool) (interface{}, bool)) (interface{}, bool) {
+	if c == nil || c.isClosed {
+		return nil, false
+	}
+	keyHash, conflictHash := c.keyToHash(key)
+	cond := &condition{compute: fn}
+	if next, found := c.computeExisting(keyHash, conflictHash, key, cond); found {
+		c.computed(next)
+		return cond.value, cond.ok
+	}
+
+	i := c.newConditionalItem(key, keyHash, conflictHash, cond)
+	switch c.pushAndWait(i).Status {
+	case SetAdmitted:
+		return cond.value, true
+	case SetExists:
+		return cond.value, cond.ok
+	default:
+		return nil, false
+	}
+}
+
+func (c *Cache) newConditionalItem(key string, keyHash, conflictHash uint64, cond *condition) *Item {
+	i := &Item{
+		flag:     itemNew,
+		Key:      keyHash,
+		Conflict: conflictHash,
+		cond:     cond,
+		waiter:   newSetWaiter(),
+	}
+	if c.keepKeys {
+		i.OriginalKey = key
+	}
+	if c.Metrics != nil {
+		i.queuedAt = time.Now()
+	}
+	return i
+}
+
+func (c *Cache) newUpdateItem(key string, value interface{}) *Item {
+	keyHash, conflictHash := c.keyToHash(key)
+	i := &Item{
+		flag:     itemUpdate,
+		Key:      keyHash,
+		Conflict: conflictHash,
+		Value:    value,
+	}
+	if c.keepKeys {
+		i.OriginalKey = key
+	}
+	return i
+}
+
+// replaced notifies that the value of a key was replaced in the store, and
+// lets the policy update its cost. Like in SetWithCost, the cost update is
+// skipped when the Set buffer is full.
+func (c *Cache) replaced(i *Item, key string, prev interface{}) {
+	c.onRemove(&Item{Key: i.Key, Conflict: i.Conflict, Value: prev, OriginalKey: key}, Replaced)
+	c.onExit(prev)
+	select {
+	case c.setBuf <- i:
+	default:
+	}
+}
+
+// computed tells the policy about a value changed by Compute. Like in
+// replaced, the cost update is skipped when the Set buffer is full. A deletion
+// waits for room like Delete, since the policy would otherwise keep the key
+// and reject it when it is set again, unless the cache is closed first.
+func (c *Cache) computed(i *Item) {
+	if i.flag == itemDelete {
+		c.push(i)
+		return
+	}
+	select {
+	case c.setBuf <- i:
+	default:
+	}
+}
+
+// computeExisting runs the function of the condition on the value of the key,
+// if it is in the store. It returns whether it was, and the item telling the
+// policy about the change.
+func (c *Cache) computeExisting(keyHash, conflictHash uint64, key string, cond *condition) (*Item, bool) {
+	prev, value, ok, found := c.store.Compute(keyHash, conflictHash, cond.compute)
+	if !found {
+		return nil, false
+	}
+	cond.value, cond.ok = value, ok
+	removed := &Item{Key: keyHash, Conflict: conflictHash, Value: prev, OriginalKey: key}
+	c.onExit(prev)
+	if ok {
+		c.onRemove(removed, Replaced)
+		i := &Item{flag: itemUpdate, Key: keyHash, Conflict: conflictHash, Value: value}
+		if c.keepKeys {
+			i.OriginalKey = key
+		}
+		return i, true
+	}
+	c.onRemove(removed, Deleted)
+	return &Item{flag: itemDelete, Key: keyHash, Conflict: conflictHash}, true
+}
+
+// applyCondition is called by processItems with a conditional item. It
+// returns whether the item should be added, and the item to process instead
+// when the key was present and its value was computed.
+func (c *Cache) applyCondition(i *Item) (bool, *Item) {
+	cond := i.cond
+	if cond.compute == nil {
+		if _, ok := c.store.Get(i.Key, i.Conflict); ok {
+			i.done(SetExists, nil)
+			return false, nil
+		}
+		return true, nil
+	}
+	// The key may have been added since Compute found it absent.
+	if next, found := c.computeExisting(i.Key, i.Conflict, i.OriginalKey, cond); found {
+		i.done(SetExists, nil)
+		return false, next
+	}
+	cond.value, cond.ok = cond.compute(nil, false)
+	if !cond.ok {
+		// There is nothing to add.
+		i.done(SetRejected, nil)
+		return false, nil
+	}
+	i.Value = cond.value
+	return true, nil
+}
@@ -0,0 +1,292 @@
+package ristretto
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newConditionalTestCache(t *testing.T, onRemove func(*Item, RemovalReason)) *Cache {
+	c, err := NewCache(&Config{
+		NumCounters:        100,
+		MaxCost:            10,
+		IgnoreInternalCost: true,
+		BufferItems:        64,
+		Cost:               func(interface{}) int64 { return 1 },
+		OnRemove:           onRemove,
+	})
+	require.NoError(t, err)
+	t.Cleanup(c.Close)
+	return c
+}
+
+func TestCacheSetIfAbsent(t *testing.T) {
+	c := newConditionalTestCache(t, nil)
+
+	require.True(t, c.SetIfAbsent("a", 1))
+	require.False(t, c.SetIfAbsent("a", 2))
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	// Only one of many concurrent writers adds the key.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var added int
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if c.SetIfAbsent("b", i) {
+				mu.Lock()
+				added++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	require.Equal(t, 1, added)
+
+	c.Close()
+	require.False(t, c.SetIfAbsent("c", 1))
+}
+
+func TestCacheReplace(t *testing.T) {
+	var replaced []interface{}
+	c := newConditionalTestCache(t, func(i *Item, reason RemovalReason) {
+		if reason == Replaced {
+			replaced = append(replaced, i.Value)
+		}
+	})
+
+	require.False(t, c.Replace("a", 1))
+	c.Wait()
+	_, ok := c.Get("a")
+	require.False(t, ok)
+
+	require.True(t, c.SetIfAbsent("a", 1))
+	require.True(t, c.Replace("a", 2))
+	c.Wait()
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+	require.Equal(t, []interface{}{1}, replaced)
+}
+
+func TestCacheCompareAndSwap(t *testing.T) {
+	c := newConditionalTestCache(t, nil)
+
+	require.False(t, c.CompareAndSwap("n", 0, 1))
+	require.True(t, c.SetIfAbsent("n", 0))
+	require.False(t, c.CompareAndSwap("n", 1, 2))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for {
+					v, _ := c.Get("n")
+					if c.CompareAndSwap("n", v, v.(int)+1) {
+						break
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	v, ok := c.Get("n")
+	require.True(t, ok)
+	require.Equal(t, 400, v)
+
+	// Uncomparable values never match.
+	value := []byte("v")
+	require.True(t, c.SetIfAbsent("b", value))
+	require.False(t, c.CompareAndSwap("b", value, []byte("w")))
+	require.False(t, c.CompareAndSwap("b", "v", 1))
+	require.True(t, c.SetIfAbsent("s", []int{}))
+	require.False(t, c.CompareAndSwap("s", []int{}, 1))
+	v, _ = c.Get("s")
+	require.Equal(t, []int{}, v)
+	boxed := [1]interface{}{map[string]int{}}
+	require.True(t, c.SetIfAbsent("i", boxed))
+	require.False(t, c.CompareAndSwap("i", boxed, 1))
+}
+
+func TestCacheCompute(t *testing.T) {
+	var deleted []interface{}
+	c := newConditionalTestCache(t, func(i *Item, reason RemovalReason) {
+		if reason == Deleted {
+			deleted = append(deleted, i.Value)
+		}
+	})
+	incr := func(value interface{}, found bool) (interface{}, bool) {
+		if !found {
+			return 1, true
+		}
+		return value.(int) + 1, true
+	}
+
+	v, ok := c.Compute("a", incr)
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+	v, ok = c.Compute("a", incr)
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+
+	// Not keeping an absent key does not add it.
+	v, ok = c.Compute("b", func(interface{}, bool) (interface{}, bool) { return nil, false })
+	require.False(t, ok)
+	require.Nil(t, v)
+	c.Wait()
+	_, ok = c.Get("b")
+	require.False(t, ok)
+
+	// Not keeping a present key deletes it.
+	v, ok = c.Compute("a", func(value interface{}, found bool) (interface{}, bool) {
+		require.True(t, found)
+		require.Equal(t, 2, value)
+		return nil, false
+	})
+	require.False(t, ok)
+	require.Nil(t, v)
+	c.Wait()
+	_, ok = c.Get("a")
+	require.False(t, ok)
+	require.Equal(t, []interface{}{2}, deleted)
+
+	// Concurrent computations of an absent key are applied one after another.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, ok := c.Compute("c", incr)
+			require.True(t, ok)
+		}()
+	}
+	wg.Wait()
+	v, ok = c.Get("c")
+	require.True(t, ok)
+	require.Equal(t, 8, v)
+}
+
+func TestCacheConditionalWritesReleasedByClose(t *testing.T) {
+	c := newConditionalTestCache(t, nil)
+
+	// Stop processItems, as Close does, with conditional writes to be queued.
+	c.stop <- struct{}{}
+	results := make(chan bool, 2)
+	go func() { results <- c.SetIfAbsent("a", 1) }()
+	go func() {
+		_, ok := c.Compute("b", func(interface{}, bool) (interface{}, bool) { return 1, true })
+		results <- ok
+	}()
+	// Take the items out and put them back, so that the writes are known to
+	// have been queued before the cache is closed.
+	var queued []*Item
+	for len(queued) < 2 {
+		queued = append(queued, <-c.setBuf)
+	}
+	for _, i := range queued {
+		c.setBuf <- i
+	}
+	c.shutdown()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case ok := <-results:
+			require.False(t, ok)
+		case <-time.After(5 * time.Second):
+			t.Fatal("conditional write still waiting after Close")
+		}
+	}
+}
+
+func TestCacheComputeKeepsKeys(t *testing.T) {
+	c := newConditionalTestCache(t, func(*Item, RemovalReason) {})
+	require.True(t, c.KeepsKeys())
+	require.True(t, c.SetIfAbsent("a", 1))
+
+	// The cost update of a computed key names the key, like the one of Set.
+	c.stop <- struct{}{}
+	v, ok := c.Compute("a", func(value interface{}, _ bool) (interface{}, bool) { return value.(int) + 1, true })
+	require.True(t, ok)
+	require.Equal(t, 2, v)
+	update := <-c.setBuf
+	go c.processItems()
+	require.Equal(t, "a", update.OriginalKey)
+	require.Equal(t, []string{"a"}, c.Keys(""))
+}
+
+func TestCacheSyncWritesReleasedWhenClosingWithFullBuffer(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters:        100,
+		MaxCost:            10,
+		IgnoreInternalCost: true,
+		BufferItems:        64,
+		SetBufferItems:     1,
+		Cost:               func(interface{}) int64 { return 1 },
+	})
+	require.NoError(t, err)
+	require.True(t, c.SetIfAbsent("a", 1))
+
+	// Stop processItems and fill the Set buffer.
+	c.stop <- struct{}{}
+	require.True(t, c.Set("b", 2))
+
+	// Computing a present key does not wait for room in the buffer.
+	computed := make(chan interface{}, 1)
+	go func() {
+		v, _ := c.Compute("a", func(value interface{}, _ bool) (interface{}, bool) { return value.(int) + 1, true })
+		computed <- v
+	}()
+	select {
+	case v := <-computed:
+		require.Equal(t, 2, v)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Compute blocked on a full buffer")
+	}
+
+	// Writes waiting for room in the buffer are dropped when Close starts.
+	results := make(chan bool, 3)
+	go func() { results <- c.SetSync("c", 3).Status == SetAdmitted }()
+	go func() { results <- c.SetIfAbsent("d", 4) }()
+	go func() {
+		_, ok := c.Compute("e", func(interface{}, bool) (interface{}, bool) { return 5, true })
+		results <- ok
+	}()
+	close(c.closing)
+	for i := 0; i < 3; i++ {
+		select {
+		case ok := <-results:
+			require.False(t, ok)
+		case <-time.After(5 * time.Second):
+			t.Fatal("synchronous write still waiting after Close")
+		}
+	}
+	c.shutdown()
+}
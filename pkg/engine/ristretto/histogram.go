@@ -0,0 +1,164 @@
+package ristretto
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+)
+
+// HistogramData is a distribution of durations. The buckets grow
+// exponentially: bucket i holds the values below Bounds[i] and at least
+// Bounds[i-1], while the last bucket holds every value from the last bound up.
+// HistogramData is not safe for concurrent usage; Metrics guards its own
+// histograms and hands out copies.
+type HistogramData struct {
+	Bounds         []time.Duration
+	CountPerBucket []int64
+	Count          int64
+	Sum            time.Duration
+	Min            time.Duration
+	Max            time.Duration
+}
+
+// newHistogramData returns an empty histogram with n bounds, starting at base
+// and doubling from one bound to the next.
+func newHistogramData(base time.Duration, n int) *HistogramData {
+	bounds := make([]time.Duration, n)
+	for i := range bounds {
+		bounds[i] = base << uint(i)
+	}
+	return &HistogramData{
+		Bounds:         bounds,
+		CountPerBucket: make([]int64, n+1),
+	}
+}
+
+// Copy returns a deep copy of the histogram.
+func (h *HistogramData) Copy() *HistogramData {
+	if h == nil {
+		return nil
+	}
+	c := *h
+	c.Bounds = append([]time.Duration(nil), h.Bounds...)
+	c.CountPerBucket = append([]int64(nil), h.CountPerBucket...)
+	return &c
+}
+
+// Update adds a value to the histogram.
+func (h *HistogramData) Update(value time.Duration) {
+	if h == nil {
+		return
+	}
+	if h.Count == 0 || value < h.Min {
+		h.Min = value
+	}
+	if value > h.Max {
+		h.Max = value
+	}
+	h.Count++
+	h.Sum += value
+
+	// The bounds are few and small values are the common case, so a linear
+	// scan is as fast as a binary search.
+	idx := len(h.Bounds)
+	for i, bound := range h.Bounds {
+		if value < bound {
+			idx = i
+			break
+		}
+	}
+	h.CountPerBucket[idx]++
+}
+
+// Clear resets the histogram, keeping its bounds.
+func (h *HistogramData) Clear() {
+	if h == nil {
+		return
+	}
+	for i := range h.CountPerBucket {
+		h.CountPerBucket[i] = 0
+	}
+	h.Count, h.Sum, h.Min, h.Max = 0, 0, 0, 0
+}
+
+// Mean returns the average of the values in the histogram.
+func (h *HistogramData) Mean() time.Duration {
+	if h == nil || h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Percentile returns an upper estimate of the p-th percentile, p being between
+// 0 and 1: the upper bound of the bucket holding it, capped by the maximum.
+func (h *HistogramData) Percentile(p float64) time.Duration {
+	if h == nil || h.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(p * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, count := range h.CountPerBucket {
+		seen += count
+		if seen < rank {
+			continue
+		}
+		if i < len(h.Bounds) && h.Bounds[i] < h.Max {
+			return h.Bounds[i]
+		}
+		break
+	}
+	return h.Max
+}
+
+// String returns a summary of the histogram, followed by the count of every
+// non-empty bucket.
+func (h *HistogramData) String() string {
+	if h == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "count: %d", h.Count)
+	if h.Count == 0 {
+		return buf.String()
+	}
+	fmt.Fprintf(&buf, " min: %s max: %s mean: %s p50: %s p99: %s [",
+		h.Min, h.Max, h.Mean(), h.Percentile(0.5), h.Percentile(0.99))
+	sep := ""
+	for i, count := range h.CountPerBucket {
+		if count == 0 {
+			continue
+		}
+		if i < len(h.Bounds) {
+			fmt.Fprintf(&buf, "%s<%s: %d", sep, h.Bounds[i], count)
+		} else {
+			fmt.Fprintf(&buf, "%s>=%s: %d", sep, h.Bounds[len(h.Bounds)-1], count)
+		}
+		sep = ", "
+	}
+	buf.WriteString("]")
+	return buf.String()
+}
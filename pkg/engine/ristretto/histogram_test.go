@@ -0,0 +1,65 @@
+package ristretto
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogramData(t *testing.T) {
+	h := newHistogramData(time.Millisecond, 4)
+	require.Equal(t, []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 8 * time.Millisecond}, h.Bounds)
+	require.Equal(t, time.Duration(0), h.Percentile(0.5))
+	require.Equal(t, "count: 0", h.String())
+
+	for _, d := range []time.Duration{500 * time.Microsecond, 3 * time.Millisecond, 3 * time.Millisecond, time.Second} {
+		h.Update(d)
+	}
+	require.Equal(t, []int64{1, 0, 2, 0, 1}, h.CountPerBucket)
+	require.Equal(t, int64(4), h.Count)
+	require.Equal(t, 500*time.Microsecond, h.Min)
+	require.Equal(t, time.Second, h.Max)
+	require.Equal(t, (time.Second+6500*time.Microsecond)/4, h.Mean())
+	require.Equal(t, time.Millisecond, h.Percentile(0.25))
+	require.Equal(t, 4*time.Millisecond, h.Percentile(0.5))
+	require.Equal(t, time.Second, h.Percentile(0.99))
+	require.True(t, strings.Contains(h.String(), ">=8ms: 1"), h.String())
+
+	c := h.Copy()
+	h.Clear()
+	require.Equal(t, int64(0), h.Count)
+	require.Equal(t, []int64{0, 0, 0, 0, 0}, h.CountPerBucket)
+	require.Equal(t, int64(4), c.Count)
+	require.Equal(t, []int64{1, 0, 2, 0, 1}, c.CountPerBucket)
+}
+
+func TestNilHistogramData(t *testing.T) {
+	var h *HistogramData
+	h.Update(time.Second)
+	h.Clear()
+	require.Nil(t, h.Copy())
+	require.Equal(t, time.Duration(0), h.Mean())
+	require.Equal(t, "", h.String())
+}
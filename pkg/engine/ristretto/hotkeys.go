@@ -0,0 +1,182 @@
+package ristretto
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Hot keys are found with the Space-Saving algorithm (Metwally et al.,
+// "Efficient Computation of Frequent and Top-k Elements in Data Streams"),
+// fed by the same batches of accessed key hashes as the TinyLFU sketch. The
+// sketch's 4-bit counters saturate far too early to rank the hottest keys,
+// so the tracker keeps its own counters for a bounded number of keys.
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// HotKey is a frequently accessed key, with its estimated access count.
+type HotKey struct {
+	// Key is the original key. It is empty when the cache neither keeps its
+	// keys nor has a KeyRecorder which knows it.
+	Key string
+	// KeyHash is the hash of the key.
+	KeyHash uint64
+	// Count is the estimated number of accesses. It is never lower than the
+	// actual count since the tracker started monitoring the key, and may be
+	// higher by up to Error.
+	Count int64
+	// Error is the maximum overestimation of Count.
+	Error int64
+}
+
+// KeyRecorder maps key hashes back to the keys they were computed from, so
+// that caches which don't keep their keys can still report their hot keys.
+type KeyRecorder interface {
+	// Record is called by Get with every key looked up.
+	Record(key string, keyHash uint64)
+	// Key returns the key recorded for the hash, if any.
+	Key(keyHash uint64) (string, bool)
+}
+
+type hotKeyCounter struct {
+	keyHash uint64
+	count   int64
+	error   int64
+	index   int
+}
+
+// hotKeyHeap is a min-heap of counters, ordered by count.
+type hotKeyHeap []*hotKeyCounter
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *hotKeyHeap) Push(x interface{}) {
+	c := x.(*hotKeyCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+func (h *hotKeyHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+// hotKeys tracks the most accessed keys with a fixed number of counters.
+// Like the TinyLFU sketch, its counters are halved periodically so that keys
+// which cooled down are eventually replaced.
+type hotKeys struct {
+	sync.Mutex
+	capacity int
+	counters map[uint64]*hotKeyCounter
+	heap     hotKeyHeap
+	incrs    int64
+	resetAt  int64
+}
+
+func newHotKeys(capacity int, resetAt int64) *hotKeys {
+	return &hotKeys{
+		capacity: capacity,
+		counters: make(map[uint64]*hotKeyCounter, capacity),
+		heap:     make(hotKeyHeap, 0, capacity),
+		resetAt:  resetAt,
+	}
+}
+
+// Push counts a batch of accessed key hashes.
+func (h *hotKeys) Push(keys []uint64) {
+	h.Lock()
+	defer h.Unlock()
+	for _, key := range keys {
+		h.increment(key)
+	}
+}
+
+func (h *hotKeys) increment(key uint64) {
+	if c, ok := h.counters[key]; ok {
+		c.count++
+		heap.Fix(&h.heap, c.index)
+	} else if len(h.heap) < h.capacity {
+		c = &hotKeyCounter{keyHash: key, count: 1}
+		h.counters[key] = c
+		heap.Push(&h.heap, c)
+	} else {
+		// Replace the least counted key. The new key may have been accessed
+		// as many times as the replaced one while it wasn't monitored.
+		c = h.heap[0]
+		delete(h.counters, c.keyHash)
+		c.keyHash, c.error = key, c.count
+		c.count++
+		h.counters[key] = c
+		heap.Fix(&h.heap, 0)
+	}
+	if h.incrs++; h.incrs >= h.resetAt {
+		h.reset()
+	}
+}
+
+// reset halves all counts. Halving keeps the heap ordered.
+func (h *hotKeys) reset() {
+	h.incrs = 0
+	for _, c := range h.heap {
+		c.count /= 2
+		c.error /= 2
+	}
+}
+
+// top returns the k most counted keys, hottest first.
+func (h *hotKeys) top(k int) []HotKey {
+	if h == nil {
+		return nil
+	}
+	h.Lock()
+	keys := make([]HotKey, 0, len(h.heap))
+	for _, c := range h.heap {
+		keys = append(keys, HotKey{KeyHash: c.keyHash, Count: c.count, Error: c.error})
+	}
+	h.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].KeyHash < keys[j].KeyHash
+	})
+	if k >= 0 && k < len(keys) {
+		keys = keys[:k]
+	}
+	return keys
+}
+
+func (h *hotKeys) clear() {
+	if h == nil {
+		return
+	}
+	h.Lock()
+	h.counters = make(map[uint64]*hotKeyCounter, h.capacity)
+	h.heap = h.heap[:0]
+	h.incrs = 0
+	h.Unlock()
+}
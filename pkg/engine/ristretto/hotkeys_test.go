@@ -0,0 +1,160 @@
+package ristretto
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"math/rand"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotKeysTracker(t *testing.T) {
+	h := newHotKeys(3, 1<<20)
+	h.Push([]uint64{1, 1, 1, 2, 2, 3})
+	require.Equal(t, []HotKey{
+		{KeyHash: 1, Count: 3},
+		{KeyHash: 2, Count: 2},
+		{KeyHash: 3, Count: 1},
+	}, h.top(10))
+
+	// 4 replaces 3, the least counted key, inheriting its count as error.
+	h.Push([]uint64{4})
+	require.Equal(t, []HotKey{
+		{KeyHash: 1, Count: 3},
+		{KeyHash: 2, Count: 2},
+		{KeyHash: 4, Count: 2, Error: 1},
+	}, h.top(10))
+	require.Len(t, h.top(1), 1)
+
+	h.clear()
+	require.Empty(t, h.top(10))
+	var nilHot *hotKeys
+	require.Nil(t, nilHot.top(1))
+}
+
+func TestHotKeysTrackerZipf(t *testing.T) {
+	h := newHotKeys(50, 1<<20)
+	z := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, 10000)
+	keys := make([]uint64, 100000)
+	for i := range keys {
+		keys[i] = z.Uint64()
+	}
+	h.Push(keys)
+	top := h.top(5)
+	require.Len(t, top, 5)
+	for i, key := range top {
+		// Zipf ranks the values from the most to the least frequent.
+		require.Equal(t, uint64(i), key.KeyHash)
+		require.GreaterOrEqual(t, key.Count-key.Error, int64(0))
+	}
+}
+
+func TestHotKeysTrackerReset(t *testing.T) {
+	h := newHotKeys(2, 8)
+	h.Push([]uint64{1, 1, 1, 1, 1, 1, 2, 2})
+	require.Equal(t, []HotKey{{KeyHash: 1, Count: 3}, {KeyHash: 2, Count: 1}}, h.top(2))
+}
+
+// waitHotKeys repeats the accesses until the two hottest keys have been
+// counted. Each access yields so that its batch is not dropped because the
+// policy is busy.
+func waitHotKeys(t *testing.T, c *Cache, keys ...string) []HotKey {
+	for i := 0; i < 1000; i++ {
+		for _, key := range keys {
+			c.Get(key)
+			runtime.Gosched()
+		}
+		time.Sleep(time.Millisecond)
+		if keys := c.HotKeys(2); len(keys) == 2 && keys[1].Count > 50 {
+			return keys
+		}
+	}
+	t.Fatalf("hot keys not counted: %v", c.HotKeys(2))
+	return nil
+}
+
+func TestCacheHotKeys(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters:        1000,
+		MaxCost:            100,
+		BufferItems:        1,
+		IgnoreInternalCost: true,
+		KeepKeys:           true,
+		HotKeys:            10,
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		c.SetWithCost(strconv.Itoa(i), i, 1)
+	}
+	c.Wait()
+	keys := waitHotKeys(t, c, "1", "2", "2", "0", "1", "2", "3")
+	require.Equal(t, "2", keys[0].Key)
+	require.Equal(t, "1", keys[1].Key)
+
+	c.Clear()
+	require.Empty(t, c.HotKeys(2))
+
+	noHot, err := NewCache(&Config{NumCounters: 100, MaxCost: 10, BufferItems: 64})
+	require.NoError(t, err)
+	require.Nil(t, noHot.HotKeys(2))
+}
+
+type mapKeyRecorder struct {
+	sync.Mutex
+	keys map[uint64]string
+}
+
+func (r *mapKeyRecorder) Record(key string, keyHash uint64) {
+	r.Lock()
+	r.keys[keyHash] = key
+	r.Unlock()
+}
+
+func (r *mapKeyRecorder) Key(keyHash uint64) (string, bool) {
+	r.Lock()
+	defer r.Unlock()
+	key, ok := r.keys[keyHash]
+	return key, ok
+}
+
+func TestCacheHotKeysRecorder(t *testing.T) {
+	c, err := NewCache(&Config{
+		NumCounters: 1000,
+		MaxCost:     100,
+		BufferItems: 1,
+		HotKeys:     10,
+		KeyRecorder: &mapKeyRecorder{keys: make(map[uint64]string)},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	// Neither key is in the cache: only the recorder knows them.
+	keys := waitHotKeys(t, c, "missing", "other", "missing")
+	require.Equal(t, "missing", keys[0].Key)
+	require.Equal(t, "other", keys[1].Key)
+}
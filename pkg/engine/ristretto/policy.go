@@ -32,6 +32,9 @@ const (
 	// lfuSample is the number of items to sample when looking at eviction
 	// candidates. 5 seems to be the most optimal number [citation needed].
 	lfuSample = 5
+	// sketchResizeFactor is how much the capacity must change, relative to the
+	// capacity the admission sketch was sized for, for the sketch to be resized.
+	sketchResizeFactor = 4
 )
 
 // policy is the interface encapsulating eviction/admission behavior.
@@ -64,6 +67,12 @@ type policy interface {
 	MaxCost() int64
 	// UpdateMaxCost updates the max cost of the cache policy.
 	UpdateMaxCost(int64)
+	// Frequency returns the estimated access frequency of a key.
+	Frequency(uint64) int64
+	// RecordFrequency records the given number of accesses for a key.
+	RecordFrequency(uint64, int64)
+	// TrackHotKeys feeds the accessed keys to a hot keys tracker.
+	TrackHotKeys(*hotKeys)
 }
 
 func newPolicy(numCounters, maxCost int64) policy {
@@ -78,8 +87,13 @@ type defaultPolicy struct {
 	stop        chan struct{}
 	isClosed    bool
 	metrics     *Metrics
+	hot         *hotKeys
 	numCounters int64
 	maxCost     int64
+	// sketchMu serializes admission sketch resizes.
+	sketchMu sync.Mutex
+	// sketchCost is the capacity the admission sketch is currently sized for.
+	sketchCost int64
 }
 
 func newDefaultPolicy(numCounters, maxCost int64) *defaultPolicy {
@@ -90,6 +104,7 @@ func newDefaultPolicy(numCounters, maxCost int64) *defaultPolicy {
 		stop:        make(chan struct{}),
 		numCounters: numCounters,
 		maxCost:     maxCost,
+		sketchCost:  maxCost,
 	}
 	go p.processItems()
 	return p
@@ -100,6 +115,10 @@ func (p *defaultPolicy) CollectMetrics(metrics *Metrics) {
 	p.evict.metrics = metrics
 }
 
+func (p *defaultPolicy) TrackHotKeys(hot *hotKeys) {
+	p.hot = hot
+}
+
 type policyPair struct {
 	key  uint64
 	cost int64
@@ -112,6 +131,9 @@ func (p *defaultPolicy) processItems() {
 			p.Lock()
 			p.admit.Push(items)
 			p.Unlock()
+			if p.hot != nil {
+				p.hot.Push(items)
+			}
 		case <-p.stop:
 			return
 		}
@@ -254,8 +276,9 @@ func (p *defaultPolicy) Cost(key uint64) int64 {
 
 func (p *defaultPolicy) Clear() {
 	p.Lock()
-	p.admit = newTinyLFU(p.numCounters)
-	p.evict = newSampledLFU(p.maxCost)
+	// Keep the size of a resized sketch.
+	p.admit = newTinyLFU(p.admit.resetAt)
+	p.evict = newSampledLFU(p.evict.getMaxCost())
 	p.Unlock()
 }
 
@@ -283,6 +306,66 @@ func (p *defaultPolicy) UpdateMaxCost(maxCost int64) {
 		return
 	}
 	p.evict.updateMaxCost(maxCost)
+	p.resizeAdmission(maxCost)
+}
+
+// resizeAdmission resizes the admission sketch and doorkeeper in proportion
+// to the capacity, when it changed by sketchResizeFactor or more since they
+// were sized. The sketch never shrinks below its configured size. Frequencies
+// are carried over approximately (see cmSketch.copyFrom), while the
+// doorkeeper, which is cleared at every reset anyway, starts empty.
+//
+// The new sketch is allocated without holding the policy lock, which is only
+// held to copy the counters over. Get never waits for the policy lock: its
+// accesses are dropped while the policy is busy.
+func (p *defaultPolicy) resizeAdmission(maxCost int64) {
+	p.sketchMu.Lock()
+	defer p.sketchMu.Unlock()
+	if maxCost < p.sketchCost*sketchResizeFactor && maxCost*sketchResizeFactor > p.sketchCost {
+		return
+	}
+
+	p.Lock()
+	current := p.admit
+	p.Unlock()
+	target := int64(float64(p.numCounters) * float64(maxCost) / float64(p.maxCost))
+	if target < p.numCounters {
+		target = p.numCounters
+	}
+	if next2Power(target) == current.freq.numCounters() {
+		p.sketchCost = maxCost
+		return
+	}
+	freq := newCmSketchFrom(current.freq, target)
+	door := bloom.NewBloomFilterWithErrorRate(uint64(target), 0.01)
+
+	p.Lock()
+	if p.admit != current {
+		// The policy was cleared meanwhile, with a new sketch.
+		p.Unlock()
+		return
+	}
+	freq.copyFrom(current.freq)
+	p.admit = &tinyLFU{
+		freq:    freq,
+		door:    door,
+		incrs:   current.incrs,
+		resetAt: target,
+	}
+	p.Unlock()
+	p.sketchCost = maxCost
+}
+
+func (p *defaultPolicy) Frequency(key uint64) int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.admit.Estimate(key)
+}
+
+func (p *defaultPolicy) RecordFrequency(key uint64, count int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.admit.record(key, count)
 }
 
 // sampledLFU is an eviction helper storing key-cost pairs.
@@ -409,6 +492,18 @@ func (p *tinyLFU) Increment(key uint64) {
 	}
 }
 
+// record increments the frequency of a key count times. Counters saturate at
+// 15 and the first access only sets the doorkeeper bit, so at most 16
+// increments have any effect.
+func (p *tinyLFU) record(key uint64, count int64) {
+	if count > 16 {
+		count = 16
+	}
+	for ; count > 0; count-- {
+		p.Increment(key)
+	}
+}
+
 func (p *tinyLFU) reset() {
 	// Zero out incrs.
 	p.incrs = 0
@@ -423,3 +518,38 @@ func (p *tinyLFU) clear() {
 	p.freq.Clear()
 	p.door.Clear()
 }
+
+// FrequencySketch exposes the TinyLFU access frequency estimator used by the
+// admission policy, so other eviction policies can make admission decisions
+// with the same doorkeeper and count-min sketch. Counters are halved every
+// numCounters increments so old accesses age out.
+//
+// FrequencySketch is NOT thread safe.
+type FrequencySketch struct {
+	lfu *tinyLFU
+}
+
+// NewFrequencySketch returns a frequency sketch sized for numCounters keys.
+func NewFrequencySketch(numCounters int64) *FrequencySketch {
+	return &FrequencySketch{lfu: newTinyLFU(numCounters)}
+}
+
+// Increment records an access for the given hashed key.
+func (s *FrequencySketch) Increment(key uint64) {
+	s.lfu.Increment(key)
+}
+
+// Estimate returns the estimated access frequency of the given hashed key.
+func (s *FrequencySketch) Estimate(key uint64) int64 {
+	return s.lfu.Estimate(key)
+}
+
+// Record increments the frequency of the given hashed key count times.
+func (s *FrequencySketch) Record(key uint64, count int64) {
+	s.lfu.record(key, count)
+}
+
+// Clear zeroes all the counters in the sketch.
+func (s *FrequencySketch) Clear() {
+	s.lfu.clear()
+}
@@ -150,6 +150,34 @@ func TestPolicyClear(t *testing.T) {
 	require.False(t, p.Has(3))
 }
 
+func TestPolicyResizeAdmission(t *testing.T) {
+	p := newDefaultPolicy(100, 10)
+	defer p.Close()
+	p.RecordFrequency(1, 5)
+	require.Equal(t, int64(128), p.admit.freq.numCounters())
+
+	// Small changes keep the sketch.
+	p.UpdateMaxCost(30)
+	require.Equal(t, int64(128), p.admit.freq.numCounters())
+
+	p.UpdateMaxCost(100)
+	require.Equal(t, int64(1024), p.admit.freq.numCounters())
+	require.Equal(t, int64(1000), p.admit.resetAt)
+	// The doorkeeper starts empty, the counters are kept.
+	require.Equal(t, int64(4), p.Frequency(1))
+
+	// The sketch shrinks back, but not below its configured size.
+	p.UpdateMaxCost(1)
+	require.Equal(t, int64(128), p.admit.freq.numCounters())
+	require.GreaterOrEqual(t, p.Frequency(1), int64(4))
+
+	// Clearing keeps the current size and capacity.
+	p.UpdateMaxCost(100)
+	p.Clear()
+	require.Equal(t, int64(1024), p.admit.freq.numCounters())
+	require.Equal(t, int64(100), p.MaxCost())
+}
+
 func TestPolicyClose(t *testing.T) {
 	defer func() {
 		require.NotNil(t, recover())
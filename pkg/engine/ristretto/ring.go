@@ -60,6 +60,18 @@ func (s *ringStripe) Push(item uint64) {
 	}
 }
 
+// PushBatch appends several items in the ring buffer and drains it if full.
+func (s *ringStripe) PushBatch(items []uint64) {
+	s.data = append(s.data, items...)
+	if len(s.data) >= s.capa {
+		if s.cons.Push(s.data) {
+			s.data = make([]uint64, 0, s.capa)
+		} else {
+			s.data = s.data[:0]
+		}
+	}
+}
+
 // ringBuffer stores multiple buffers (stripes) and distributes Pushed items
 // between them to lower contention.
 //
@@ -93,3 +105,11 @@ func (b *ringBuffer) Push(item uint64) {
 	stripe.Push(item)
 	b.pool.Put(stripe)
 }
+
+// PushBatch adds several elements to a single stripe, draining it at most
+// once.
+func (b *ringBuffer) PushBatch(items []uint64) {
+	stripe := b.pool.Get().(*ringStripe)
+	stripe.PushBatch(items)
+	b.pool.Put(stripe)
+}
@@ -54,10 +54,65 @@ func newCmSketch(numCounters int64) *cmSketch {
 	return sketch
 }
 
+// newCmSketchFrom returns an empty sketch of a different size, with the same
+// seeds as s so that the counters of s can be carried over with copyFrom.
+func newCmSketchFrom(s *cmSketch, numCounters int64) *cmSketch {
+	numCounters = next2Power(numCounters)
+	sketch := &cmSketch{seed: s.seed, mask: uint64(numCounters - 1)}
+	for i := range sketch.rows {
+		sketch.rows[i] = newCmRow(numCounters)
+	}
+	return sketch
+}
+
+// copyFrom sets the counters of s from the ones of a sketch created with the
+// same seeds. As both index counters by the low bits of the mixed hash, a
+// larger sketch gets the counter of every key unchanged, only shared with more
+// colliding keys. A smaller sketch folds the counters sharing an index,
+// keeping their maximum, so that no key's estimate decreases.
+func (s *cmSketch) copyFrom(from *cmSketch) {
+	for i := range s.rows {
+		dst, src := s.rows[i], from.rows[i]
+		if len(src) == 0 {
+			continue
+		}
+		if len(dst) >= len(src) {
+			// Both sizes are powers of 2, so the larger row is the smaller
+			// one repeated.
+			for off := 0; off < len(dst); off += len(src) {
+				copy(dst[off:], src)
+			}
+			continue
+		}
+		dst.clear()
+		n := uint64(len(dst) * 2)
+		for j := uint64(0); j < uint64(len(src)*2); j++ {
+			if v := src.get(j); v > dst.get(j%n) {
+				dst.set(j%n, v)
+			}
+		}
+	}
+}
+
+// numCounters returns the number of counters in every row.
+func (s *cmSketch) numCounters() int64 {
+	return int64(s.mask + 1)
+}
+
+// index returns the counter of a hashed key in row i. Every row mixes the hash
+// with its own seed, so that keys sharing the low bits of their hash don't
+// collide in every row.
+func (s *cmSketch) index(hashed uint64, i int) uint64 {
+	x := hashed ^ s.seed[i]
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	return (x ^ x>>31) & s.mask
+}
+
 // Increment increments the count(ers) for the specified key.
 func (s *cmSketch) Increment(hashed uint64) {
 	for i := range s.rows {
-		s.rows[i].increment((hashed ^ s.seed[i]) & s.mask)
+		s.rows[i].increment(s.index(hashed, i))
 	}
 }
 
@@ -65,7 +120,7 @@ func (s *cmSketch) Increment(hashed uint64) {
 func (s *cmSketch) Estimate(hashed uint64) int64 {
 	min := byte(255)
 	for i := range s.rows {
-		val := s.rows[i].get((hashed ^ s.seed[i]) & s.mask)
+		val := s.rows[i].get(s.index(hashed, i))
 		if val < min {
 			min = val
 		}
@@ -98,6 +153,11 @@ func (r cmRow) get(n uint64) byte {
 	return byte(r[n/2]>>((n&1)*4)) & 0x0f
 }
 
+func (r cmRow) set(n uint64, v byte) {
+	s := (n & 1) * 4
+	r[n/2] = r[n/2]&^(0x0f<<s) | v<<s
+}
+
 func (r cmRow) increment(n uint64) {
 	// Index of the counter.
 	i := n / 2
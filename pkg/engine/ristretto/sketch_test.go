@@ -57,6 +57,16 @@ func TestSketchEstimate(t *testing.T) {
 	require.Equal(t, int64(0), s.Estimate(0))
 }
 
+func TestSketchRowsIndependent(t *testing.T) {
+	// Keys sharing the low bits of their hash must not share every counter.
+	s := newCmSketch(1024)
+	for i := 0; i < 4; i++ {
+		s.Increment(1)
+	}
+	require.Equal(t, int64(0), s.Estimate(1+1024))
+	require.Equal(t, int64(0), s.Estimate(1+1<<40))
+}
+
 func TestSketchReset(t *testing.T) {
 	s := newCmSketch(16)
 	s.Increment(1)
@@ -103,3 +113,33 @@ func BenchmarkSketchEstimate(b *testing.B) {
 		s.Estimate(1)
 	}
 }
+
+func TestSketchCopyFrom(t *testing.T) {
+	s := newCmSketch(64)
+	for i := uint64(0); i < 64; i++ {
+		for j := uint64(0); j < i%8; j++ {
+			s.Increment(i)
+		}
+	}
+
+	grown := newCmSketchFrom(s, 1000)
+	require.Equal(t, int64(1024), grown.numCounters())
+	grown.copyFrom(s)
+	for i := uint64(0); i < 64; i++ {
+		require.Equal(t, s.Estimate(i), grown.Estimate(i))
+	}
+
+	shrunk := newCmSketchFrom(s, 16)
+	shrunk.copyFrom(s)
+	for i := uint64(0); i < 64; i++ {
+		require.GreaterOrEqual(t, shrunk.Estimate(i), s.Estimate(i))
+	}
+}
+
+func TestCmRowSet(t *testing.T) {
+	r := newCmRow(4)
+	r.set(1, 15)
+	r.set(2, 7)
+	r.set(1, 3)
+	require.Equal(t, "00 03 07 00", r.string())
+}
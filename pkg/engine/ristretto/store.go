@@ -22,6 +22,8 @@ package ristretto
 
 import (
 	"sync"
+
+	"github.com/bhojpur/cache/pkg/hack"
 )
 
 // TODO: Do we need this to be a separate struct from Item?
@@ -29,6 +31,8 @@ type storeItem struct {
 	key      uint64
 	conflict uint64
 	value    interface{}
+	// original is the unhashed key, only kept when the cache is configured to.
+	original string
 }
 
 // store is the interface fulfilled by all hash map implementations in this
@@ -44,15 +48,27 @@ type store interface {
 	// already present. The key-value pair is passed as a pointer to an
 	// item object.
 	Set(*Item)
-	// Del deletes the key-value pair from the Map.
-	Del(uint64, uint64) (uint64, interface{})
+	// Del deletes the key-value pair from the Map, returning the deleted item
+	// and whether it was found.
+	Del(uint64, uint64) (storeItem, bool)
 	// Update attempts to update the key with a new value and returns true if
 	// successful.
 	Update(*Item) (interface{}, bool)
+	// CompareAndSwap updates the key with a new value if its current value is
+	// the given one, and returns true if successful.
+	CompareAndSwap(*Item, interface{}) bool
+	// Compute replaces the value of a present key with the one returned by the
+	// function, or deletes the key if the function returns false, atomically.
+	// It returns the previous and new values, and whether the key was found.
+	Compute(uint64, uint64, func(interface{}, bool) (interface{}, bool)) (prev, value interface{}, ok, found bool)
 	// Clear clears all contents of the store.
 	Clear(onEvict itemCallback)
 	// ForEach yields all the values in the store
 	ForEach(forEach func(interface{}) bool)
+	// ForEachKV yields all the original keys and values in the store
+	ForEachKV(forEach func(string, interface{}) bool)
+	// OriginalKey returns the original key stored with the key hash, if any.
+	OriginalKey(uint64) (string, bool)
 	// Len returns the number of entries in the store
 	Len() int
 }
@@ -91,7 +107,7 @@ func (sm *shardedMap) Set(i *Item) {
 	sm.shards[i.Key%numShards].Set(i)
 }
 
-func (sm *shardedMap) Del(key, conflict uint64) (uint64, interface{}) {
+func (sm *shardedMap) Del(key, conflict uint64) (storeItem, bool) {
 	return sm.shards[key%numShards].Del(key, conflict)
 }
 
@@ -99,6 +115,14 @@ func (sm *shardedMap) Update(newItem *Item) (interface{}, bool) {
 	return sm.shards[newItem.Key%numShards].Update(newItem)
 }
 
+func (sm *shardedMap) CompareAndSwap(newItem *Item, old interface{}) bool {
+	return sm.shards[newItem.Key%numShards].CompareAndSwap(newItem, old)
+}
+
+func (sm *shardedMap) Compute(key, conflict uint64, fn func(interface{}, bool) (interface{}, bool)) (interface{}, interface{}, bool, bool) {
+	return sm.shards[key%numShards].Compute(key, conflict, fn)
+}
+
 func (sm *shardedMap) ForEach(forEach func(interface{}) bool) {
 	for _, shard := range sm.shards {
 		if !shard.foreach(forEach) {
@@ -107,6 +131,18 @@ func (sm *shardedMap) ForEach(forEach func(interface{}) bool) {
 	}
 }
 
+func (sm *shardedMap) ForEachKV(forEach func(string, interface{}) bool) {
+	for _, shard := range sm.shards {
+		if !shard.foreachKV(forEach) {
+			break
+		}
+	}
+}
+
+func (sm *shardedMap) OriginalKey(key uint64) (string, bool) {
+	return sm.shards[key%numShards].originalKey(key)
+}
+
 func (sm *shardedMap) Len() int {
 	l := 0
 	for _, shard := range sm.shards {
@@ -145,6 +181,16 @@ func (m *lockedMap) get(key, conflict uint64) (interface{}, bool) {
 	return item.value, true
 }
 
+func (m *lockedMap) originalKey(key uint64) (string, bool) {
+	m.RLock()
+	item, ok := m.data[key]
+	m.RUnlock()
+	if !ok || item.original == "" {
+		return "", false
+	}
+	return item.original, true
+}
+
 func (m *lockedMap) Set(i *Item) {
 	if i == nil {
 		// If the item is nil make this Set a no-op.
@@ -167,24 +213,25 @@ func (m *lockedMap) Set(i *Item) {
 		key:      i.Key,
 		conflict: i.Conflict,
 		value:    i.Value,
+		original: i.OriginalKey,
 	}
 }
 
-func (m *lockedMap) Del(key, conflict uint64) (uint64, interface{}) {
+func (m *lockedMap) Del(key, conflict uint64) (storeItem, bool) {
 	m.Lock()
 	item, ok := m.data[key]
 	if !ok {
 		m.Unlock()
-		return 0, nil
+		return storeItem{}, false
 	}
 	if conflict != 0 && (conflict != item.conflict) {
 		m.Unlock()
-		return 0, nil
+		return storeItem{}, false
 	}
 
 	delete(m.data, key)
 	m.Unlock()
-	return item.conflict, item.value
+	return item, true
 }
 
 func (m *lockedMap) Update(newItem *Item) (interface{}, bool) {
@@ -203,12 +250,61 @@ func (m *lockedMap) Update(newItem *Item) (interface{}, bool) {
 		key:      newItem.Key,
 		conflict: newItem.Conflict,
 		value:    newItem.Value,
+		original: newItem.OriginalKey,
 	}
 
 	m.Unlock()
 	return item.value, true
 }
 
+func (m *lockedMap) CompareAndSwap(newItem *Item, old interface{}) bool {
+	m.Lock()
+	defer m.Unlock()
+	item, ok := m.data[newItem.Key]
+	if !ok {
+		return false
+	}
+	if newItem.Conflict != 0 && (newItem.Conflict != item.conflict) {
+		return false
+	}
+	if !hack.Equal(item.value, old) {
+		return false
+	}
+
+	m.data[newItem.Key] = storeItem{
+		key:      newItem.Key,
+		conflict: newItem.Conflict,
+		value:    newItem.Value,
+		original: newItem.OriginalKey,
+	}
+	return true
+}
+
+func (m *lockedMap) Compute(key, conflict uint64, fn func(interface{}, bool) (interface{}, bool)) (interface{}, interface{}, bool, bool) {
+	m.Lock()
+	defer m.Unlock()
+	item, ok := m.data[key]
+	if !ok {
+		return nil, nil, false, false
+	}
+	if conflict != 0 && (conflict != item.conflict) {
+		return nil, nil, false, false
+	}
+
+	value, keep := fn(item.value, true)
+	if keep {
+		m.data[key] = storeItem{
+			key:      key,
+			conflict: item.conflict,
+			value:    value,
+			original: item.original,
+		}
+	} else {
+		delete(m.data, key)
+	}
+	return item.value, value, keep, true
+}
+
 func (m *lockedMap) Len() int {
 	m.RLock()
 	l := len(m.data)
@@ -224,6 +320,7 @@ func (m *lockedMap) Clear(onEvict itemCallback) {
 			i.Key = si.key
 			i.Conflict = si.conflict
 			i.Value = si.value
+			i.OriginalKey = si.original
 			onEvict(i)
 		}
 	}
@@ -241,3 +338,14 @@ func (m *lockedMap) foreach(forEach func(interface{}) bool) bool {
 	}
 	return true
 }
+
+func (m *lockedMap) foreachKV(forEach func(string, interface{}) bool) bool {
+	m.RLock()
+	defer m.RUnlock()
+	for _, si := range m.data {
+		if !forEach(si.original, si.value) {
+			return false
+		}
+	}
+	return true
+}
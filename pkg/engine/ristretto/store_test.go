@@ -27,6 +27,9 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// defaultStringHash hashes the keys of the tests that use a store directly.
+var defaultStringHash = newStringHash()
+
 func TestStoreSetGet(t *testing.T) {
 	s := newStore()
 	key, conflict := defaultStringHash("1")
@@ -0,0 +1,154 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// The ARC, 2Q, SLRU and W-TinyLFU caches are all built out of several LRU
+// lists ("segments") sharing a single key table. An entry always belongs to
+// exactly one segment, and moving between segments keeps its table slot.
+
+import (
+	"container/list"
+)
+
+// segmentEntry is an entry stored in one of the segments of a multi-list cache.
+// Ghost entries (ARC and 2Q history lists) keep the key and size but drop the value.
+type segmentEntry struct {
+	key   string
+	value interface{}
+	size  int64
+	seg   *segment
+}
+
+// segment is a list of entries, ordered from most recently used to least
+// recently used, which keeps track of the total cost of its members.
+type segment struct {
+	list list.List
+	size int64
+}
+
+func newSegment() *segment {
+	s := &segment{}
+	s.list.Init()
+	return s
+}
+
+// Len returns the number of entries in the segment
+func (s *segment) Len() int {
+	return s.list.Len()
+}
+
+// pushFront adds the entry as the most recently used entry of the segment.
+func (s *segment) pushFront(e *segmentEntry) *list.Element {
+	e.seg = s
+	s.size += e.size
+	return s.list.PushFront(e)
+}
+
+// remove unlinks the element from the segment and returns its entry.
+func (s *segment) remove(element *list.Element) *segmentEntry {
+	e := s.list.Remove(element).(*segmentEntry)
+	s.size -= e.size
+	e.seg = nil
+	return e
+}
+
+// back returns the least recently used element of the segment, or nil.
+func (s *segment) back() *list.Element {
+	return s.list.Back()
+}
+
+// moveToFront marks the element as the most recently used one in the segment.
+func (s *segment) moveToFront(element *list.Element) {
+	s.list.MoveToFront(element)
+}
+
+// resize updates the cost of an entry in place.
+func (s *segment) resize(element *list.Element, size int64) {
+	e := element.Value.(*segmentEntry)
+	s.size += size - e.size
+	e.size = size
+}
+
+// clear empties the segment.
+func (s *segment) clear() {
+	s.list.Init()
+	s.size = 0
+}
+
+// forEach yields the values in the segment from most recently used to least
+// recently used, and reports whether the iteration ran to completion.
+func (s *segment) forEach(callback func(value interface{}) bool) bool {
+	for e := s.list.Front(); e != nil; e = e.Next() {
+		if !callback(e.Value.(*segmentEntry).value) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendItems appends the entries of the segment to the given slice, from most
+// recently used to least recently used.
+func (s *segment) appendItems(items []Item) []Item {
+	for e := s.list.Front(); e != nil; e = e.Next() {
+		v := e.Value.(*segmentEntry)
+		items = append(items, Item{Key: v.key, Value: v.value})
+	}
+	return items
+}
+
+// notify reports every entry in the segment to the listener.
+func (s *segment) notify(listener RemovalListener, reason RemovalReason) {
+	if listener == nil {
+		return
+	}
+	for e := s.list.Front(); e != nil; e = e.Next() {
+		v := e.Value.(*segmentEntry)
+		listener(v.key, v.value, reason)
+	}
+}
+
+// segmentTable is the key table shared by all the segments of a cache.
+type segmentTable map[string]*list.Element
+
+// move transfers the element to the front of another segment and updates the
+// table to point to its new list element.
+func (t segmentTable) move(element *list.Element, to *segment) *list.Element {
+	e := element.Value.(*segmentEntry)
+	e = e.seg.remove(element)
+	element = to.pushFront(e)
+	t[e.key] = element
+	return element
+}
+
+// add inserts a new entry at the front of the given segment.
+func (t segmentTable) add(key string, value interface{}, size int64, to *segment) *list.Element {
+	element := to.pushFront(&segmentEntry{key: key, value: value, size: size})
+	t[key] = element
+	return element
+}
+
+// drop removes the element from its segment and from the table.
+func (t segmentTable) drop(element *list.Element) *segmentEntry {
+	e := element.Value.(*segmentEntry)
+	e = e.seg.remove(element)
+	delete(t, e.key)
+	return e
+}
@@ -0,0 +1,320 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bhojpur/cache/pkg/hack"
+)
+
+var _ Cache = &ShardedLRUCache{}
+var _ MultiCache = &ShardedLRUCache{}
+var _ ConditionalCache = &ShardedLRUCache{}
+
+// ShardedLRUCache is an LRU cache split into several independently locked
+// LRUCache segments. Keys are spread across the segments by hash, so
+// concurrent readers and writers only contend when they hit the same segment.
+//
+// The capacity is divided evenly between the segments and every segment
+// evicts on its own, so the cache as a whole approximates, but does not
+// exactly follow, a global least recently used order.
+type ShardedLRUCache struct {
+	shards []*LRUCache
+	// seed hashes keys into shards.
+	seed hack.HashSeed
+}
+
+// NewShardedLRUCache creates a new empty cache with the given total capacity,
+// split across the given number of shards. The number of shards is capped so
+// that every shard can hold at least one unit of capacity.
+func NewShardedLRUCache(capacity int64, shards int, cost func(interface{}) int64) *ShardedLRUCache {
+	if shards < 1 {
+		shards = 1
+	}
+	if capacity > 0 && int64(shards) > capacity {
+		shards = int(capacity)
+	}
+	s := &ShardedLRUCache{
+		shards: make([]*LRUCache, shards),
+		seed:   hack.NewHashSeed(),
+	}
+	for i := range s.shards {
+		s.shards[i] = NewLRUCache(0, cost)
+	}
+	s.setCapacity(capacity)
+	return s
+}
+
+func (s *ShardedLRUCache) shard(key string) *LRUCache {
+	if len(s.shards) == 1 {
+		return s.shards[0]
+	}
+	return s.shards[hack.Strhash(key, s.seed)%uint64(len(s.shards))]
+}
+
+// Get returns a value from the cache, and marks the entry as most
+// recently used.
+func (s *ShardedLRUCache) Get(key string) (interface{}, bool) {
+	return s.shard(key).Get(key)
+}
+
+// Set sets a value in the cache.
+func (s *ShardedLRUCache) Set(key string, value interface{}) bool {
+	return s.shard(key).Set(key, value)
+}
+
+// GetMulti returns the values of several keys, and whether each was found.
+// The keys are grouped by shard, and every shard is locked once.
+func (s *ShardedLRUCache) GetMulti(keys []string) ([]interface{}, []bool) {
+	values, found := make([]interface{}, len(keys)), make([]bool, len(keys))
+	s.forEachShard(len(keys), func(i int) string { return keys[i] }, func(shard *LRUCache, i int) {
+		values[i], found[i] = shard.get(keys[i])
+	})
+	return values, found
+}
+
+// SetMulti sets several values in the cache. The items are grouped by shard,
+// and every shard is locked once.
+func (s *ShardedLRUCache) SetMulti(items []Item) []bool {
+	results := make([]bool, len(items))
+	s.forEachShard(len(items), func(i int) string { return items[i].Key }, func(shard *LRUCache, i int) {
+		shard.set(items[i].Key, items[i].Value)
+		results[i] = true
+	})
+	return results
+}
+
+// forEachShard calls fn with the shard of every one of n keys, holding the
+// lock of the shard. The keys are visited shard by shard, in order within a
+// shard, so that every shard is locked once.
+func (s *ShardedLRUCache) forEachShard(n int, key func(i int) string, fn func(shard *LRUCache, i int)) {
+	// Counting sort of the key indexes by shard.
+	shardOf := make([]int, n)
+	starts := make([]int, len(s.shards)+1)
+	for i := 0; i < n; i++ {
+		if len(s.shards) > 1 {
+			shardOf[i] = int(hack.Strhash(key(i), s.seed) % uint64(len(s.shards)))
+		}
+		starts[shardOf[i]+1]++
+	}
+	for i := 1; i < len(starts); i++ {
+		starts[i] += starts[i-1]
+	}
+	order := make([]int, n)
+	next := append([]int(nil), starts[:len(s.shards)]...)
+	for i, shard := range shardOf {
+		order[next[shard]] = i
+		next[shard]++
+	}
+
+	for idx, shard := range s.shards {
+		batch := order[starts[idx]:starts[idx+1]]
+		if len(batch) == 0 {
+			continue
+		}
+		shard.mu.Lock()
+		for _, i := range batch {
+			fn(shard, i)
+		}
+		shard.mu.Unlock()
+	}
+}
+
+// SetIfAbsent sets a value in the cache only if the key is not in it.
+func (s *ShardedLRUCache) SetIfAbsent(key string, value interface{}) bool {
+	return s.shard(key).SetIfAbsent(key, value)
+}
+
+// Replace sets a value in the cache only if the key is already in it.
+func (s *ShardedLRUCache) Replace(key string, value interface{}) bool {
+	return s.shard(key).Replace(key, value)
+}
+
+// CompareAndSwap sets the value of a key to new only if its current value is
+// old.
+func (s *ShardedLRUCache) CompareAndSwap(key string, old, new interface{}) bool {
+	return s.shard(key).CompareAndSwap(key, old, new)
+}
+
+// Compute sets the value of a key to the result of fn, or deletes it if fn
+// returns false.
+func (s *ShardedLRUCache) Compute(key string, fn func(value interface{}, found bool) (interface{}, bool)) (interface{}, bool) {
+	return s.shard(key).Compute(key, fn)
+}
+
+// Delete removes an entry from the cache
+func (s *ShardedLRUCache) Delete(key string) {
+	s.shard(key).Delete(key)
+}
+
+// Clear will clear the entire cache.
+func (s *ShardedLRUCache) Clear() {
+	for _, shard := range s.shards {
+		shard.Clear()
+	}
+}
+
+// SetRemovalListener sets the listener notified whenever an entry leaves any of the shards.
+func (s *ShardedLRUCache) SetRemovalListener(listener RemovalListener) {
+	for _, shard := range s.shards {
+		shard.SetRemovalListener(listener)
+	}
+}
+
+// Wait is a no-op in the sharded LRU cache
+func (s *ShardedLRUCache) Wait() {}
+
+// Len returns the size of the cache (in entries)
+func (s *ShardedLRUCache) Len() int {
+	var l int
+	for _, shard := range s.shards {
+		l += shard.Len()
+	}
+	return l
+}
+
+// Evictions returns the number of evictions across all shards
+func (s *ShardedLRUCache) Evictions() int64 {
+	var evictions int64
+	for _, shard := range s.shards {
+		evictions += shard.Evictions()
+	}
+	return evictions
+}
+
+// UsedCapacity returns the size of the cache (in bytes)
+func (s *ShardedLRUCache) UsedCapacity() int64 {
+	var used int64
+	for _, shard := range s.shards {
+		used += shard.UsedCapacity()
+	}
+	return used
+}
+
+// MaxCapacity returns the cache maximum capacity.
+func (s *ShardedLRUCache) MaxCapacity() int64 {
+	var capacity int64
+	for _, shard := range s.shards {
+		capacity += shard.MaxCapacity()
+	}
+	return capacity
+}
+
+// SetCapacity will set the total capacity of the cache, dividing it evenly
+// between the shards. Shards that exceed their new capacity are shrunk.
+// A positive capacity smaller than the number of shards still gives every
+// shard room for one unit, so the effective capacity is rounded up to the
+// number of shards rather than dropping every key that hashes to an empty one.
+func (s *ShardedLRUCache) SetCapacity(capacity int64) {
+	s.setCapacity(capacity)
+}
+
+func (s *ShardedLRUCache) setCapacity(capacity int64) {
+	n := int64(len(s.shards))
+	per, rem := capacity/n, capacity%n
+	for i, shard := range s.shards {
+		c := per
+		if int64(i) < rem {
+			c++
+		}
+		if c == 0 && capacity > 0 {
+			c = 1
+		}
+		shard.SetCapacity(c)
+	}
+}
+
+// Stats returns the statistics of all the shards combined.
+func (s *ShardedLRUCache) Stats() Stats {
+	var stats Stats
+	for _, shard := range s.shards {
+		addStats(&stats, shard.Stats())
+	}
+	return stats
+}
+
+// ForEach yields all the values for the cache, ordered from most recently
+// used to least recently used.
+func (s *ShardedLRUCache) ForEach(callback func(value interface{}) bool) {
+	for _, e := range s.entries() {
+		if !callback(e.value) {
+			break
+		}
+	}
+}
+
+// Items returns all the values for the cache, ordered from most recently
+// used to least recently used.
+func (s *ShardedLRUCache) Items() []Item {
+	entries := s.entries()
+	items := make([]Item, 0, len(entries))
+	for _, e := range entries {
+		items = append(items, Item{Key: e.key, Value: e.value})
+	}
+	return items
+}
+
+// ForEachKV yields all the keys and values for the cache, ordered from most
+// recently used to least recently used.
+func (s *ShardedLRUCache) ForEachKV(callback func(key string, value interface{}) bool) {
+	for _, e := range s.entries() {
+		if !callback(e.key, e.value) {
+			break
+		}
+	}
+}
+
+// Keys returns the keys in the cache that start with the given prefix, ordered
+// from most recently used to least recently used.
+func (s *ShardedLRUCache) Keys(prefix string) []string {
+	var keys []string
+	for _, e := range s.entries() {
+		if strings.HasPrefix(e.key, prefix) {
+			keys = append(keys, e.key)
+		}
+	}
+	return keys
+}
+
+// DeletePrefix removes all the entries whose key starts with the given prefix,
+// and returns how many were removed.
+func (s *ShardedLRUCache) DeletePrefix(prefix string) int {
+	var deleted int
+	for _, shard := range s.shards {
+		deleted += shard.DeletePrefix(prefix)
+	}
+	return deleted
+}
+
+// entries returns a snapshot of the entries of every shard, merged by their
+// last access time. Each shard is locked only while its own entries are copied.
+func (s *ShardedLRUCache) entries() []entry {
+	var all []entry
+	for _, shard := range s.shards {
+		all = shard.appendEntries(all)
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].timeAccessed.After(all[j].timeAccessed)
+	})
+	return all
+}
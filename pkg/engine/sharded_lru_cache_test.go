@@ -0,0 +1,174 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestShardedInitialState(t *testing.T) {
+	cache := NewShardedLRUCache(10, 4, cacheValueSize)
+	l, sz, c, e := cache.Len(), cache.UsedCapacity(), cache.MaxCapacity(), cache.Evictions()
+	if l != 0 {
+		t.Errorf("length = %v, want 0", l)
+	}
+	if sz != 0 {
+		t.Errorf("size = %v, want 0", sz)
+	}
+	if c != 10 {
+		t.Errorf("capacity = %v, want 10", c)
+	}
+	if e != 0 {
+		t.Errorf("evictions = %v, want 0", e)
+	}
+	if n := len(cache.shards); n != 4 {
+		t.Errorf("shards = %v, want 4", n)
+	}
+}
+
+func TestShardedFewerShardsThanCapacity(t *testing.T) {
+	cache := NewShardedLRUCache(3, 16, cacheValueSize)
+	if n := len(cache.shards); n != 3 {
+		t.Errorf("shards = %v, want 3", n)
+	}
+	if c := cache.MaxCapacity(); c != 3 {
+		t.Errorf("capacity = %v, want 3", c)
+	}
+}
+
+func TestShardedSetGetDelete(t *testing.T) {
+	// Shards are picked by a per-cache random seed, so leave every shard
+	// room for all the keys.
+	cache := NewShardedLRUCache(400, 8, cacheValueSize)
+	for i := 0; i < 50; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), &CacheValue{1})
+	}
+	if l := cache.Len(); l != 50 {
+		t.Errorf("cache.Len() = %v, expected 50", l)
+	}
+	if sz := cache.UsedCapacity(); sz != 50 {
+		t.Errorf("cache.UsedCapacity() = %v, expected 50", sz)
+	}
+	for i := 0; i < 50; i++ {
+		if _, ok := cache.Get(fmt.Sprintf("key%d", i)); !ok {
+			t.Errorf("key%d is missing", i)
+		}
+	}
+	cache.Delete("key0")
+	if _, ok := cache.Get("key0"); ok {
+		t.Error("Cache returned a value after deletion.")
+	}
+	cache.Clear()
+	if l, sz := cache.Len(), cache.UsedCapacity(); l != 0 || sz != 0 {
+		t.Errorf("after Clear(): len = %v, size = %v", l, sz)
+	}
+}
+
+func TestShardedCapacityIsObeyed(t *testing.T) {
+	cache := NewShardedLRUCache(100, 4, cacheValueSize)
+	cache.SetCapacity(8)
+	for i := 0; i < 100; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), &CacheValue{1})
+	}
+	if sz := cache.UsedCapacity(); sz > 8 {
+		t.Errorf("cache.UsedCapacity() = %v, expected at most 8", sz)
+	}
+	if c := cache.MaxCapacity(); c != 8 {
+		t.Errorf("cache.MaxCapacity() = %v, expected 8", c)
+	}
+	if got, want := cache.Evictions(), int64(100-cache.Len()); got != want {
+		t.Errorf("cache.Evictions() = %v, expected %v", got, want)
+	}
+}
+
+func TestShardedCapacityBelowShardCount(t *testing.T) {
+	cache := NewShardedLRUCache(100, 8, cacheValueSize)
+	cache.SetCapacity(3)
+	if c := cache.MaxCapacity(); c != 8 {
+		t.Errorf("cache.MaxCapacity() = %v, expected 8", c)
+	}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		cache.Set(key, &CacheValue{1})
+		if _, ok := cache.Get(key); !ok {
+			t.Fatalf("%s was dropped right after Set", key)
+		}
+	}
+	if l := cache.Len(); l != 8 {
+		t.Errorf("cache.Len() = %v, expected 8", l)
+	}
+}
+
+func TestShardedItemsAreOrderedByRecency(t *testing.T) {
+	cache := NewShardedLRUCache(100, 4, cacheValueSize)
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	for _, k := range keys {
+		cache.Set(k, &CacheValue{1})
+	}
+	// Touch the keys in reverse so that "a" becomes the most recently used.
+	for i := len(keys) - 1; i >= 0; i-- {
+		cache.Get(keys[i])
+	}
+
+	items := cache.Items()
+	if len(items) != len(keys) {
+		t.Fatalf("cache.Items() returned %d items, expected %d", len(items), len(keys))
+	}
+	for i, item := range items {
+		if item.Key != keys[i] {
+			t.Errorf("items[%d] = %q, expected %q", i, item.Key, keys[i])
+		}
+	}
+
+	var visited int
+	cache.ForEach(func(_ interface{}) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Errorf("ForEach visited %d values, expected 3", visited)
+	}
+}
+
+func TestShardedConcurrentAccess(t *testing.T) {
+	cache := NewShardedLRUCache(1000, 16, cacheValueSize)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key%d", (g*1000+i)%1500)
+				cache.Set(key, &CacheValue{1})
+				cache.Get(key)
+			}
+		}(g)
+	}
+	wg.Wait()
+	if sz := cache.UsedCapacity(); sz > 1000 {
+		t.Errorf("cache.UsedCapacity() = %v, expected at most 1000", sz)
+	}
+	if int64(cache.Len()) != cache.UsedCapacity() {
+		t.Errorf("cache.Len() = %v, cache.UsedCapacity() = %v", cache.Len(), cache.UsedCapacity())
+	}
+}
@@ -0,0 +1,680 @@
+package shm
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package shm implements a cache of byte slices in a memory mapped file, which
+// several processes on the same host can open and use at once.
+//
+// The file holds a fixed-size hash table split into stripes. A key can take
+// any slot of the stripe picked by its hash; every stripe is guarded by a lock
+// word in the mapping, taken with atomic operations, and evicts with its own
+// CLOCK hand. A lock left behind by a process that died is taken over after
+// checking the process is gone, and the entries of its stripe are dropped.
+//
+// A lock word names its owner by pid and by the boot of the host, so locks
+// left in the file before a reboot are always taken over. Every process
+// opening a file must see the same pids, that is run in one pid namespace:
+// a process in another namespace could take over a lock whose owner is
+// alive, or wait on a dead owner whose pid is used by another process. A pid
+// reused within the same boot keeps the lock of a dead owner until the new
+// process exits.
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/bhojpur/cache/pkg/engine"
+	"github.com/bhojpur/cache/pkg/hack"
+	"github.com/bhojpur/cache/pkg/memory"
+)
+
+var _ engine.Cache = &Cache{}
+
+const (
+	// DefaultEntries is the number of slots of a new cache, unless told
+	// otherwise.
+	DefaultEntries = 64 * 1024
+	// DefaultSlotSize is the size of the slots of a new cache, unless told
+	// otherwise.
+	DefaultSlotSize = 256
+	// DefaultWays is the number of slots in a stripe of a new cache, unless
+	// told otherwise.
+	DefaultWays = 16
+
+	magic   = 0x316d68737270686a // "jhprshm1"
+	version = 2
+
+	headerSize       = 4096
+	stripeHeaderSize = 64
+	slotHeaderSize   = 24
+	minSlotSize      = 64
+
+	// lockSpins is how many times a lock is tried before sleeping between
+	// attempts, and checking whether its owner is alive.
+	lockSpins = 64
+	lockSleep = 50 * time.Microsecond
+	// lockChecks is how many sleeps separate two checks of the owner.
+	lockChecks = 100
+)
+
+// Offsets in the header of the file.
+const (
+	offMagic     = 0
+	offVersion   = 8
+	offStripes   = 12
+	offWays      = 16
+	offSlotSize  = 20
+	offSeed      = 24
+	offHits      = 32
+	offMisses    = 40
+	offSets      = 48
+	offEvictions = 56
+	offRejected  = 64
+)
+
+// Offsets in the header of a stripe.
+const (
+	offLock  = 0
+	offHand  = 8
+	offCount = 12
+	offUsed  = 16
+)
+
+// Offsets in the header of a slot. A slot is empty when its hash is zero.
+const (
+	offHash     = 0
+	offKeyLen   = 8
+	offValueLen = 12
+	offRef      = 16
+)
+
+// EngineName is the name of the shared cache in the engine registry. Import
+// the package to register it.
+const EngineName = "shm"
+
+// EngineOptions are the options of the shared cache engine. The number of
+// entries of a new file is Config.MaxEntries.
+type EngineOptions struct {
+	Path     string `json:"path" desc:"path of the file shared by the processes"`
+	SlotSize int    `json:"slot_size,omitempty" desc:"size of a slot, in bytes"`
+	Ways     int    `json:"ways,omitempty" desc:"number of slots of a stripe"`
+}
+
+func init() {
+	engine.Register(engine.Engine{
+		Name:        EngineName,
+		Description: "memory mapped hash table shared by the processes of a host, with CLOCK eviction",
+		Options:     func() interface{} { return &EngineOptions{} },
+		New: func(cfg *engine.Config, options interface{}) (engine.Cache, error) {
+			opts := options.(*EngineOptions)
+			if opts.Path == "" {
+				return nil, errors.New("shm: the path option is required")
+			}
+			cache, err := Open(opts.Path, &Options{Entries: int(cfg.MaxEntries), SlotSize: opts.SlotSize, Ways: opts.Ways})
+			if err != nil {
+				return nil, err
+			}
+			if cfg.OnRemove != nil {
+				cache.SetRemovalListener(cfg.OnRemove)
+			}
+			return cache, nil
+		},
+	})
+}
+
+// ErrIncompatible is returned when opening a file that is not a cache, or was
+// created by an incompatible version.
+var ErrIncompatible = errors.New("shm: incompatible cache file")
+
+// Options are the options of a new cache file. The options of an existing file
+// are read from the file.
+type Options struct {
+	// Entries is the number of slots of the cache, rounded up to a multiple of
+	// Ways. It defaults to DefaultEntries.
+	Entries int
+	// SlotSize is the size of a slot, in bytes. A key and its value must fit
+	// in SlotSize minus 24 bytes. It defaults to DefaultSlotSize.
+	SlotSize int
+	// Ways is the number of slots of a stripe, which a key can take. It
+	// defaults to DefaultWays.
+	Ways int
+}
+
+// Cache is a cache of byte slices shared by the processes opening the same
+// file. It implements engine.Cache: Set accepts []byte and string values, and
+// Get returns a copy of the value as a []byte. Its capacity is fixed when the
+// file is created. A Cache must not be used after Close.
+type Cache struct {
+	file *os.File
+	data []byte
+
+	stripes  int
+	ways     int
+	slotSize int
+	seed     uint64
+	slots    int // offset of the first slot
+	// owner is the lock word of the process: its boot and its pid.
+	owner uint64
+
+	onRemove engine.RemovalListener
+}
+
+// removal is an entry removed by the process, copied out of its slot so that
+// the listener is called once the lock of the stripe is released.
+type removal struct {
+	key    string
+	value  []byte
+	reason engine.RemovalReason
+}
+
+// Open opens the cache in the file at path, creating the file with the
+// options if it does not exist. The creation is guarded by an exclusive lock
+// on the file, so that processes opening it at once all see the same cache.
+func Open(path string, opts *Options) (*Cache, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	c, err := open(f, opts)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func open(f *os.File, opts *Options) (*Cache, error) {
+	if err := memory.LockFile(f, true, 0); err != nil {
+		return nil, err
+	}
+	defer memory.UnlockFile(f)
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{file: f, owner: lockOwner(bootID(), uint32(os.Getpid()))}
+	size := info.Size()
+	if size == 0 {
+		c.setLayout(opts)
+		size = c.size()
+		if err := f.Truncate(size); err != nil {
+			return nil, err
+		}
+	} else if size < headerSize {
+		return nil, ErrIncompatible
+	}
+	if size != int64(int(size)) {
+		return nil, fmt.Errorf("shm: cache file too large: %d bytes", size)
+	}
+	if c.data, err = memory.MapFile(f, int(size), true); err != nil {
+		return nil, err
+	}
+
+	if info.Size() == 0 {
+		err = c.writeHeader()
+	} else {
+		err = c.readHeader(size)
+	}
+	if err != nil {
+		memory.UnmapFile(c.data)
+		return nil, err
+	}
+	return c, nil
+}
+
+// setLayout sets the layout of a new file from the options.
+func (c *Cache) setLayout(opts *Options) {
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.Ways <= 0 {
+		o.Ways = DefaultWays
+	}
+	if o.Entries <= 0 {
+		o.Entries = DefaultEntries
+	}
+	if o.SlotSize <= 0 {
+		o.SlotSize = DefaultSlotSize
+	}
+	if o.SlotSize < minSlotSize {
+		o.SlotSize = minSlotSize
+	}
+	c.ways = o.Ways
+	c.stripes = (o.Entries + o.Ways - 1) / o.Ways
+	c.slotSize = (o.SlotSize + 7) &^ 7
+	c.slots = headerSize + c.stripes*stripeHeaderSize
+}
+
+// size returns the size of the file.
+func (c *Cache) size() int64 {
+	return int64(c.slots) + int64(c.stripes)*int64(c.ways)*int64(c.slotSize)
+}
+
+func (c *Cache) writeHeader() error {
+	var seed [8]byte
+	if _, err := rand.Read(seed[:]); err != nil {
+		return err
+	}
+	c.seed = binary.LittleEndian.Uint64(seed[:])
+	*c.u32(offStripes) = uint32(c.stripes)
+	*c.u32(offWays) = uint32(c.ways)
+	*c.u32(offSlotSize) = uint32(c.slotSize)
+	*c.u64(offSeed) = c.seed
+	*c.u32(offVersion) = version
+	atomic.StoreUint64(c.u64(offMagic), magic)
+	return nil
+}
+
+func (c *Cache) readHeader(size int64) error {
+	if atomic.LoadUint64(c.u64(offMagic)) != magic || *c.u32(offVersion) != version {
+		return ErrIncompatible
+	}
+	c.stripes = int(*c.u32(offStripes))
+	c.ways = int(*c.u32(offWays))
+	c.slotSize = int(*c.u32(offSlotSize))
+	c.seed = *c.u64(offSeed)
+	c.slots = headerSize + c.stripes*stripeHeaderSize
+	if c.stripes == 0 || c.ways == 0 || c.slotSize < minSlotSize || c.size() != size {
+		return ErrIncompatible
+	}
+	return nil
+}
+
+// Close unmaps and closes the file of the cache.
+func (c *Cache) Close() error {
+	err := memory.UnmapFile(c.data)
+	c.data = nil
+	if cerr := c.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (c *Cache) u32(off int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&c.data[off]))
+}
+
+func (c *Cache) u64(off int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&c.data[off]))
+}
+
+func (c *Cache) stripeOffset(stripe int) int {
+	return headerSize + stripe*stripeHeaderSize
+}
+
+func (c *Cache) slotOffset(stripe, way int) int {
+	return c.slots + (stripe*c.ways+way)*c.slotSize
+}
+
+// hash hashes a key with the seed of the file, so that every process agrees
+// on it. It is FNV-1a followed by the finalizer of MurmurHash3, and is never
+// zero.
+func (c *Cache) hash(key string) uint64 {
+	h := c.seed ^ 14695981039346656037
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	if h == 0 {
+		h = 1
+	}
+	return h
+}
+
+// lockOwner returns the lock word of the process with the given pid in the
+// given boot.
+func lockOwner(boot, pid uint32) uint64 {
+	return uint64(boot)<<32 | uint64(pid)
+}
+
+// ownerAlive reports whether the owner of a lock word is still running. An
+// owner from another boot is dead; a boot of zero is unknown and matches any.
+func (c *Cache) ownerAlive(owner uint64) bool {
+	boot, ourBoot := uint32(owner>>32), uint32(c.owner>>32)
+	if boot != 0 && ourBoot != 0 && boot != ourBoot {
+		return false
+	}
+	return processAlive(int(uint32(owner)))
+}
+
+// lock takes the lock of a stripe. A lock word holds the boot and the pid of
+// the process owning it, which lets the other processes take it over when
+// that process died while holding it.
+func (c *Cache) lock(stripe int) {
+	word := c.u64(c.stripeOffset(stripe) + offLock)
+	for spins := 0; ; spins++ {
+		if atomic.CompareAndSwapUint64(word, 0, c.owner) {
+			return
+		}
+		if spins < lockSpins {
+			runtime.Gosched()
+			continue
+		}
+		if (spins-lockSpins)%lockChecks == lockChecks-1 {
+			owner := atomic.LoadUint64(word)
+			if owner != 0 && owner != c.owner && !c.ownerAlive(owner) &&
+				atomic.CompareAndSwapUint64(word, owner, c.owner) {
+				// The owner may have left the stripe half written.
+				c.resetStripe(stripe)
+				return
+			}
+		}
+		time.Sleep(lockSleep)
+	}
+}
+
+func (c *Cache) unlock(stripe int) {
+	atomic.StoreUint64(c.u64(c.stripeOffset(stripe)+offLock), 0)
+}
+
+// resetStripe empties a stripe, with its lock held.
+func (c *Cache) resetStripe(stripe int) {
+	for way := 0; way < c.ways; way++ {
+		*c.u64(c.slotOffset(stripe, way) + offHash) = 0
+	}
+	off := c.stripeOffset(stripe)
+	atomic.StoreUint32(c.u32(off+offCount), 0)
+	atomic.StoreUint64(c.u64(off+offUsed), 0)
+}
+
+// find returns the slot of a key in its stripe, with the lock held, or -1.
+func (c *Cache) find(stripe int, hash uint64, key string) int {
+	for way := 0; way < c.ways; way++ {
+		off := c.slotOffset(stripe, way)
+		if *c.u64(off + offHash) == hash && int(*c.u32(off + offKeyLen)) == len(key) &&
+			string(c.data[off+slotHeaderSize:off+slotHeaderSize+len(key)]) == key {
+			return off
+		}
+	}
+	return -1
+}
+
+// victim returns the slot to store a new key in a stripe, with the lock held:
+// an empty slot if there is one, or the slot chosen by the CLOCK hand, and
+// whether it holds an entry.
+func (c *Cache) victim(stripe int) (int, bool) {
+	for way := 0; way < c.ways; way++ {
+		if off := c.slotOffset(stripe, way); *c.u64(off + offHash) == 0 {
+			return off, false
+		}
+	}
+	hand := c.u32(c.stripeOffset(stripe) + offHand)
+	for {
+		way := int(*hand) % c.ways
+		*hand = uint32((way + 1) % c.ways)
+		off := c.slotOffset(stripe, way)
+		if *c.u32(off + offRef) == 0 {
+			return off, true
+		}
+		*c.u32(off + offRef) = 0
+	}
+}
+
+func (c *Cache) keyLen(off int) int {
+	return int(*c.u32(off + offKeyLen))
+}
+
+func (c *Cache) value(off int) []byte {
+	start := off + slotHeaderSize + c.keyLen(off)
+	return c.data[start : start+int(*c.u32(off + offValueLen))]
+}
+
+// Get returns a copy of the value of a key, as a []byte.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	value, ok := c.GetBytes(key, nil)
+	if !ok {
+		return nil, false
+	}
+	return value, true
+}
+
+// GetBytes appends the value of a key to dst. It allows looking up values
+// without allocating.
+func (c *Cache) GetBytes(key string, dst []byte) ([]byte, bool) {
+	hash := c.hash(key)
+	stripe := int(hash % uint64(c.stripes))
+	c.lock(stripe)
+	off := c.find(stripe, hash, key)
+	if off < 0 {
+		c.unlock(stripe)
+		atomic.AddUint64(c.u64(offMisses), 1)
+		return dst, false
+	}
+	*c.u32(off + offRef) = 1
+	dst = append(dst, c.value(off)...)
+	c.unlock(stripe)
+	atomic.AddUint64(c.u64(offHits), 1)
+	return dst, true
+}
+
+// SetRemovalListener sets the listener notified whenever an entry leaves the
+// cache through this process: evicted or replaced by its Set, removed by its
+// Delete or Clear. Entries removed by the other processes sharing the file, or
+// dropped when taking over the lock of a dead process, are not reported. It
+// must be called before the cache is used.
+func (c *Cache) SetRemovalListener(listener engine.RemovalListener) {
+	c.onRemove = listener
+}
+
+// removed copies the entry of a slot for the listener, with the lock held.
+func (c *Cache) removed(off int, reason engine.RemovalReason) removal {
+	key := c.data[off+slotHeaderSize : off+slotHeaderSize+c.keyLen(off)]
+	return removal{string(key), append([]byte(nil), c.value(off)...), reason}
+}
+
+// notify reports removals to the listener, without any lock held.
+func (c *Cache) notify(removals ...removal) {
+	for _, r := range removals {
+		c.onRemove(r.key, r.value, r.reason)
+	}
+}
+
+// Set copies a []byte or string value into the cache. It returns false when
+// the value has another type, or the key and value do not fit in a slot.
+func (c *Cache) Set(key string, value interface{}) bool {
+	atomic.AddUint64(c.u64(offSets), 1)
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = hack.StringBytes(v)
+	default:
+		atomic.AddUint64(c.u64(offRejected), 1)
+		return false
+	}
+	if slotHeaderSize+len(key)+len(data) > c.slotSize {
+		atomic.AddUint64(c.u64(offRejected), 1)
+		return false
+	}
+
+	hash := c.hash(key)
+	stripe := int(hash % uint64(c.stripes))
+	soff := c.stripeOffset(stripe)
+	c.lock(stripe)
+
+	var removals []removal
+	off := c.find(stripe, hash, key)
+	if off < 0 {
+		var evicted bool
+		off, evicted = c.victim(stripe)
+		if evicted {
+			if c.onRemove != nil {
+				removals = append(removals, c.removed(off, engine.Evicted))
+			}
+			atomic.AddUint64(c.u64(offEvictions), 1)
+			atomic.AddUint64(c.u64(soff+offUsed), ^uint64(c.keyLen(off)+len(c.value(off))-1))
+		} else {
+			atomic.AddUint32(c.u32(soff+offCount), 1)
+		}
+		copy(c.data[off+slotHeaderSize:], key)
+		*c.u32(off + offKeyLen) = uint32(len(key))
+		*c.u32(off + offRef) = 0
+		*c.u64(off + offHash) = hash
+	} else {
+		if c.onRemove != nil {
+			removals = append(removals, c.removed(off, engine.Replaced))
+		}
+		atomic.AddUint64(c.u64(soff+offUsed), ^uint64(len(key)+len(c.value(off))-1))
+	}
+	copy(c.data[off+slotHeaderSize+len(key):], data)
+	*c.u32(off + offValueLen) = uint32(len(data))
+	atomic.AddUint64(c.u64(soff+offUsed), uint64(len(key)+len(data)))
+	c.unlock(stripe)
+	c.notify(removals...)
+	return true
+}
+
+// Delete removes an entry from the cache.
+func (c *Cache) Delete(key string) {
+	hash := c.hash(key)
+	stripe := int(hash % uint64(c.stripes))
+	soff := c.stripeOffset(stripe)
+	c.lock(stripe)
+
+	var removals []removal
+	if off := c.find(stripe, hash, key); off >= 0 {
+		if c.onRemove != nil {
+			removals = append(removals, c.removed(off, engine.Deleted))
+		}
+		atomic.AddUint64(c.u64(soff+offUsed), ^uint64(len(key)+len(c.value(off))-1))
+		atomic.AddUint32(c.u32(soff+offCount), ^uint32(0))
+		*c.u64(off + offHash) = 0
+	}
+	c.unlock(stripe)
+	c.notify(removals...)
+}
+
+// Clear empties the cache, for every process using it.
+func (c *Cache) Clear() {
+	var removals []removal
+	for stripe := 0; stripe < c.stripes; stripe++ {
+		removals = removals[:0]
+		c.lock(stripe)
+		if c.onRemove != nil {
+			for way := 0; way < c.ways; way++ {
+				if off := c.slotOffset(stripe, way); *c.u64(off + offHash) != 0 {
+					removals = append(removals, c.removed(off, engine.Cleared))
+				}
+			}
+		}
+		c.resetStripe(stripe)
+		c.unlock(stripe)
+		c.notify(removals...)
+	}
+}
+
+// ForEach yields a copy of every value in the cache. The callback is not
+// called with any lock held.
+func (c *Cache) ForEach(callback func(value interface{}) bool) {
+	var values [][]byte
+	for stripe := 0; stripe < c.stripes; stripe++ {
+		values = values[:0]
+		c.lock(stripe)
+		for way := 0; way < c.ways; way++ {
+			if off := c.slotOffset(stripe, way); *c.u64(off + offHash) != 0 {
+				values = append(values, append([]byte(nil), c.value(off)...))
+			}
+		}
+		c.unlock(stripe)
+		for _, value := range values {
+			if !callback(value) {
+				return
+			}
+		}
+	}
+}
+
+// Wait is a no-op in the shared cache
+func (c *Cache) Wait() {}
+
+// Len returns the number of entries in the cache.
+func (c *Cache) Len() int {
+	var n int
+	for stripe := 0; stripe < c.stripes; stripe++ {
+		n += int(atomic.LoadUint32(c.u32(c.stripeOffset(stripe) + offCount)))
+	}
+	return n
+}
+
+// UsedCapacity returns the size of the keys and values in the cache, in bytes.
+func (c *Cache) UsedCapacity() int64 {
+	var used int64
+	for stripe := 0; stripe < c.stripes; stripe++ {
+		used += int64(atomic.LoadUint64(c.u64(c.stripeOffset(stripe) + offUsed)))
+	}
+	return used
+}
+
+// MaxCapacity returns the size of the keys and values the cache can hold, in
+// bytes.
+func (c *Cache) MaxCapacity() int64 {
+	return int64(c.stripes) * int64(c.ways) * int64(c.slotSize-slotHeaderSize)
+}
+
+// SetCapacity is a no-op: the capacity of a shared cache is fixed when its
+// file is created.
+func (c *Cache) SetCapacity(int64) {}
+
+// Evictions returns the number of evictions, by every process.
+func (c *Cache) Evictions() int64 {
+	return int64(atomic.LoadUint64(c.u64(offEvictions)))
+}
+
+// Stats returns a snapshot of the statistics of the cache, shared by every
+// process.
+func (c *Cache) Stats() engine.Stats {
+	stats := engine.Stats{
+		Hits:         atomic.LoadUint64(c.u64(offHits)),
+		Misses:       atomic.LoadUint64(c.u64(offMisses)),
+		Sets:         atomic.LoadUint64(c.u64(offSets)),
+		SetsRejected: atomic.LoadUint64(c.u64(offRejected)),
+		Evictions:    atomic.LoadUint64(c.u64(offEvictions)),
+		UsedCapacity: c.UsedCapacity(),
+		MaxCapacity:  c.MaxCapacity(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// nonZero maps a boot tag of zero, which means unknown, to one.
+func nonZero(tag uint32) uint32 {
+	if tag == 0 {
+		return 1
+	}
+	return tag
+}
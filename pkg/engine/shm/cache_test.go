@@ -0,0 +1,278 @@
+package shm
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bhojpur/cache/pkg/engine"
+)
+
+func openTestCache(t *testing.T, path string, opts *Options) *Cache {
+	c, err := Open(path, opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestCacheGetSet(t *testing.T) {
+	c := openTestCache(t, filepath.Join(t.TempDir(), "cache"), &Options{Entries: 1024, SlotSize: 64})
+
+	require.True(t, c.Set("a", []byte("hello")))
+	require.True(t, c.Set("b", "world"))
+	require.False(t, c.Set("c", 42))
+	require.False(t, c.Set("d", make([]byte, 64-slotHeaderSize)))
+
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, []byte("hello"), v)
+	buf, ok := c.GetBytes("b", []byte("hello "))
+	require.True(t, ok)
+	require.Equal(t, "hello world", string(buf))
+	_, ok = c.Get("c")
+	require.False(t, ok)
+
+	require.True(t, c.Set("a", "bye"))
+	v, _ = c.Get("a")
+	require.Equal(t, []byte("bye"), v)
+	require.Equal(t, 2, c.Len())
+	require.Equal(t, int64(len("abye")+len("bworld")), c.UsedCapacity())
+
+	c.Delete("a")
+	_, ok = c.Get("a")
+	require.False(t, ok)
+	require.Equal(t, 1, c.Len())
+
+	var values []string
+	c.ForEach(func(value interface{}) bool {
+		values = append(values, string(value.([]byte)))
+		return true
+	})
+	require.Equal(t, []string{"world"}, values)
+
+	stats := c.Stats()
+	require.Equal(t, uint64(3), stats.Hits)
+	require.Equal(t, uint64(2), stats.Misses)
+	require.Equal(t, uint64(2), stats.SetsRejected)
+
+	c.Clear()
+	require.Equal(t, 0, c.Len())
+	require.Equal(t, int64(0), c.UsedCapacity())
+}
+
+func TestCacheClockEviction(t *testing.T) {
+	// A single stripe of 4 slots.
+	c := openTestCache(t, filepath.Join(t.TempDir(), "cache"), &Options{Entries: 4, Ways: 4})
+	for i := 0; i < 4; i++ {
+		c.Set(strconv.Itoa(i), "v")
+	}
+	// The referenced entries get a second chance.
+	c.Get("0")
+	c.Get("2")
+	c.Set("4", "v")
+	c.Set("5", "v")
+	require.Equal(t, int64(2), c.Evictions())
+	require.Equal(t, 4, c.Len())
+	for key, want := range map[string]bool{"0": true, "1": false, "2": true, "3": false, "4": true, "5": true} {
+		_, ok := c.Get(key)
+		require.Equal(t, want, ok, key)
+	}
+}
+
+func TestCacheRemovalListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	var removed []string
+	cache, err := engine.NewCache(&engine.Config{
+		Engine:     EngineName,
+		MaxEntries: 2,
+		Options:    map[string]interface{}{"path": path, "ways": 2},
+		OnRemove: func(key string, value interface{}, reason engine.RemovalReason) {
+			removed = append(removed, fmt.Sprintf("%s=%s:%d", key, value, reason))
+		},
+	})
+	require.NoError(t, err)
+	c := cache.(*Cache)
+	defer c.Close()
+
+	c.Set("a", "1")
+	c.Set("a", "2")
+	c.Set("b", "3")
+	c.Set("c", "4")
+	c.Delete("b")
+	c.Delete("b")
+	c.Clear()
+	require.Equal(t, []string{
+		fmt.Sprintf("a=1:%d", engine.Replaced),
+		fmt.Sprintf("a=2:%d", engine.Evicted),
+		fmt.Sprintf("b=3:%d", engine.Deleted),
+		fmt.Sprintf("c=4:%d", engine.Cleared),
+	}, removed)
+}
+
+func TestCacheReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	c, err := Open(path, &Options{Entries: 100, SlotSize: 128, Ways: 4})
+	require.NoError(t, err)
+	c.Set("a", "1")
+	require.NoError(t, c.Close())
+
+	// The options of the file win.
+	c = openTestCache(t, path, &Options{Entries: 10})
+	require.Equal(t, 25, c.stripes)
+	require.Equal(t, 128, c.slotSize)
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, []byte("1"), v)
+
+	other := filepath.Join(t.TempDir(), "other")
+	require.NoError(t, os.WriteFile(other, []byte("not a cache"), 0o600))
+	_, err = Open(other, nil)
+	require.Equal(t, ErrIncompatible, err)
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	c1 := openTestCache(t, path, &Options{Entries: 64, Ways: 4})
+	c2 := openTestCache(t, path, nil)
+
+	var wg sync.WaitGroup
+	var corrupted int32
+	for _, c := range []*Cache{c1, c2, c1, c2} {
+		wg.Add(1)
+		go func(c *Cache) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := strconv.Itoa(i % 100)
+				if v, ok := c.Get(key); ok && string(v.([]byte)) != "value of "+key {
+					atomic.AddInt32(&corrupted, 1)
+				}
+				c.Set(key, "value of "+key)
+			}
+		}(c)
+	}
+	wg.Wait()
+	require.Zero(t, corrupted)
+	require.LessOrEqual(t, c1.Len(), 64)
+	require.Equal(t, c1.Len(), c2.Len())
+}
+
+func TestCacheStaleLock(t *testing.T) {
+	c := openTestCache(t, filepath.Join(t.TempDir(), "cache"), &Options{Entries: 4, Ways: 4})
+	c.Set("a", "1")
+
+	// A process which exited while holding the lock.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	require.NoError(t, cmd.Run())
+	*c.u64(c.stripeOffset(0) + offLock) = lockOwner(uint32(c.owner>>32), uint32(cmd.Process.Pid))
+
+	require.True(t, c.Set("b", "2"))
+	_, ok := c.Get("a")
+	require.False(t, ok)
+	v, ok := c.Get("b")
+	require.True(t, ok)
+	require.Equal(t, []byte("2"), v)
+}
+
+func TestCacheLockFromPreviousBoot(t *testing.T) {
+	c := openTestCache(t, filepath.Join(t.TempDir(), "cache"), &Options{Entries: 4, Ways: 4})
+	boot := uint32(c.owner >> 32)
+	if boot == 0 {
+		t.Skip("the boot of the host is unknown on this platform")
+	}
+	c.Set("a", "1")
+
+	// The pid of the owner is now used by a running process, the parent of
+	// the test, but the lock was taken before the host rebooted.
+	*c.u64(c.stripeOffset(0) + offLock) = lockOwner(nonZero(boot+1), uint32(os.Getppid()))
+	require.False(t, c.ownerAlive(*c.u64(c.stripeOffset(0) + offLock)))
+	require.True(t, c.Set("b", "2"))
+	_, ok := c.Get("a")
+	require.False(t, ok)
+
+	// The same pid in the current boot is a live owner.
+	require.True(t, c.ownerAlive(lockOwner(boot, uint32(os.Getppid()))))
+}
+
+const (
+	workerPathEnv = "SHM_TEST_PATH"
+	workerIDEnv   = "SHM_TEST_WORKER"
+	workerKeys    = 300
+)
+
+func TestCacheMultiProcess(t *testing.T) {
+	if path := os.Getenv(workerPathEnv); path != "" {
+		runWorker(t, path, os.Getenv(workerIDEnv))
+		return
+	}
+
+	path := filepath.Join(t.TempDir(), "cache")
+	c := openTestCache(t, path, &Options{Entries: 16 * 1024})
+	c.Set("parent", "hello")
+
+	const workers = 4
+	cmds := make([]*exec.Cmd, workers)
+	outputs := make([]bytes.Buffer, workers)
+	for i := range cmds {
+		cmds[i] = exec.Command(os.Args[0], "-test.run=^TestCacheMultiProcess$")
+		cmds[i].Env = append(os.Environ(), workerPathEnv+"="+path, workerIDEnv+"="+strconv.Itoa(i))
+		cmds[i].Stdout, cmds[i].Stderr = &outputs[i], &outputs[i]
+		require.NoError(t, cmds[i].Start())
+	}
+	for i, cmd := range cmds {
+		require.NoError(t, cmd.Wait(), "worker %d: %s", i, outputs[i].String())
+	}
+
+	for i := 0; i < workers; i++ {
+		for j := 0; j < workerKeys; j++ {
+			v, ok := c.Get(fmt.Sprintf("w%d/k%d", i, j))
+			require.True(t, ok)
+			require.Equal(t, fmt.Sprintf("value %d/%d", i, j), string(v.([]byte)))
+		}
+	}
+	require.Equal(t, workers*workerKeys+1, c.Len())
+	require.Equal(t, uint64(workers*workerKeys+1), c.Stats().Sets)
+	require.Equal(t, uint64(workers), c.Stats().Hits-uint64(workers*workerKeys))
+}
+
+// runWorker sets keys in the cache from another process.
+func runWorker(t *testing.T, path, id string) {
+	cache, err := engine.NewCache(&engine.Config{Engine: EngineName, Options: map[string]interface{}{"path": path}})
+	require.NoError(t, err)
+	c := cache.(*Cache)
+	defer c.Close()
+
+	v, ok := c.Get("parent")
+	require.True(t, ok)
+	require.Equal(t, []byte("hello"), v)
+	for j := 0; j < workerKeys; j++ {
+		require.True(t, c.Set(fmt.Sprintf("w%s/k%d", id, j), fmt.Sprintf("value %s/%d", id, j)))
+	}
+}
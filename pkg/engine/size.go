@@ -0,0 +1,230 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+
+	"github.com/bhojpur/cache/pkg/hack"
+)
+
+// Sizes of runtime structures that the size estimator can't see through reflection.
+const (
+	mapHeaderSize    = 48 // runtime.hmap
+	mapBucketEntries = 8  // key/value pairs per map bucket
+	mapLoadFactor    = 6.5
+	chanHeaderSize   = 96 // runtime.hchan
+)
+
+var cachedObjectType = reflect.TypeOf((*cachedObject)(nil)).Elem()
+
+// EstimateSize returns an estimate of the memory, in bytes, retained by a
+// value stored in a cache. Values implementing CachedSize report their own
+// size; everything else is walked with reflection, following strings,
+// slices, maps, pointers, interfaces and struct fields. Memory reachable
+// through several references, including cycles, is only counted once.
+//
+// The estimate includes the allocation of the value itself when it has to be
+// boxed to be stored in an interface. It ignores memory the runtime doesn't
+// expose, like the contents of channel buffers, closures and map overflow
+// buckets.
+func EstimateSize(value interface{}) int64 {
+	if value == nil {
+		return 0
+	}
+	if obj, ok := value.(cachedObject); ok {
+		return obj.CachedSize(true)
+	}
+	v := reflect.ValueOf(value)
+	var size int64
+	if !isPointerShaped(v.Type()) {
+		size += allocSize(v.Type().Size())
+	}
+	s := sizer{seen: make(map[visit]struct{})}
+	return size + s.heapSize(v)
+}
+
+// visit is a block of memory already counted by the estimator.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+}
+
+type sizer struct {
+	seen map[visit]struct{}
+}
+
+// markSeen records the memory pointed to by v, and reports whether it was
+// counted before.
+func (s *sizer) markSeen(ptr uintptr, typ reflect.Type) bool {
+	key := visit{ptr, typ}
+	if _, ok := s.seen[key]; ok {
+		return true
+	}
+	s.seen[key] = struct{}{}
+	return false
+}
+
+// heapSize returns the size of the memory referenced by v, not counting the
+// memory v itself occupies.
+func (s *sizer) heapSize(v reflect.Value) int64 {
+	switch v.Kind() {
+	case reflect.String:
+		if v.Len() == 0 {
+			return 0
+		}
+		return allocSize(uintptr(v.Len()))
+
+	case reflect.Slice:
+		if v.IsNil() || v.Cap() == 0 || s.markSeen(v.Pointer(), v.Type()) {
+			return 0
+		}
+		elem := v.Type().Elem()
+		size := allocSize(uintptr(v.Cap()) * elem.Size())
+		if hasHeapReferences(elem) {
+			for i := 0; i < v.Len(); i++ {
+				size += s.heapSize(v.Index(i))
+			}
+		}
+		return size
+
+	case reflect.Array:
+		var size int64
+		if hasHeapReferences(v.Type().Elem()) {
+			for i := 0; i < v.Len(); i++ {
+				size += s.heapSize(v.Index(i))
+			}
+		}
+		return size
+
+	case reflect.Struct:
+		var size int64
+		for i := 0; i < v.NumField(); i++ {
+			if hasHeapReferences(v.Type().Field(i).Type) {
+				size += s.heapSize(v.Field(i))
+			}
+		}
+		return size
+
+	case reflect.Ptr:
+		if v.IsNil() || s.markSeen(v.Pointer(), v.Type()) {
+			return 0
+		}
+		if v.CanInterface() && v.Type().Implements(cachedObjectType) {
+			return v.Interface().(cachedObject).CachedSize(true)
+		}
+		return allocSize(v.Type().Elem().Size()) + s.heapSize(v.Elem())
+
+	case reflect.Interface:
+		if v.IsNil() {
+			return 0
+		}
+		elem := v.Elem()
+		if isPointerShaped(elem.Type()) {
+			return s.heapSize(elem)
+		}
+		return allocSize(elem.Type().Size()) + s.heapSize(elem)
+
+	case reflect.Map:
+		if v.IsNil() || s.markSeen(v.Pointer(), v.Type()) {
+			return 0
+		}
+		size := mapSize(v.Type(), v.Len())
+		if hasHeapReferences(v.Type().Key()) || hasHeapReferences(v.Type().Elem()) {
+			iter := v.MapRange()
+			for iter.Next() {
+				size += s.heapSize(iter.Key()) + s.heapSize(iter.Value())
+			}
+		}
+		return size
+
+	case reflect.Chan:
+		if v.IsNil() || s.markSeen(v.Pointer(), v.Type()) {
+			return 0
+		}
+		return allocSize(chanHeaderSize + uintptr(v.Cap())*v.Type().Elem().Size())
+
+	default:
+		// Scalars live inline; functions and unsafe pointers are opaque.
+		return 0
+	}
+}
+
+// mapSize estimates the memory used by the buckets of a map with the given
+// number of entries.
+func mapSize(typ reflect.Type, entries int) int64 {
+	size := allocSize(mapHeaderSize)
+	if entries == 0 {
+		return size
+	}
+	buckets := uintptr(1)
+	for float64(entries) > mapLoadFactor*float64(buckets) {
+		buckets <<= 1
+	}
+	bucketSize := mapBucketEntries + mapBucketEntries*(typ.Key().Size()+typ.Elem().Size()) + unsafe.Sizeof(uintptr(0))
+	return size + allocSize(buckets*bucketSize)
+}
+
+// isPointerShaped reports whether values of the type are stored directly in
+// an interface, without being boxed in a separate allocation.
+func isPointerShaped(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return true
+	}
+	return false
+}
+
+var heapReferences sync.Map // reflect.Type -> bool
+
+// hasHeapReferences reports whether values of the type may reference memory
+// outside their own storage, so that the estimator needs to walk them.
+func hasHeapReferences(typ reflect.Type) bool {
+	if v, ok := heapReferences.Load(typ); ok {
+		return v.(bool)
+	}
+	var refs bool
+	switch typ.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface, reflect.Chan:
+		refs = true
+	case reflect.Array:
+		refs = typ.Len() > 0 && hasHeapReferences(typ.Elem())
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			if hasHeapReferences(typ.Field(i).Type) {
+				refs = true
+				break
+			}
+		}
+	}
+	heapReferences.Store(typ, refs)
+	return refs
+}
+
+// allocSize returns the size of the block the allocator hands out for size bytes.
+func allocSize(size uintptr) int64 {
+	if size == 0 {
+		return 0
+	}
+	return hack.RuntimeAllocSize(int64(size))
+}
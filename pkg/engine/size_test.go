@@ -0,0 +1,150 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type sizeNode struct {
+	name string
+	next *sizeNode
+}
+
+type sizeRecord struct {
+	ID    int64
+	Name  string
+	Tags  []string
+	Attrs map[string]int
+	Any   interface{}
+}
+
+type sizedRecord struct {
+	payload []byte
+}
+
+func (r *sizedRecord) CachedSize(_ bool) int64 {
+	return 12345
+}
+
+func TestEstimateSizeScalars(t *testing.T) {
+	require.Zero(t, EstimateSize(nil))
+	require.Equal(t, int64(8), EstimateSize(int64(1)))
+	require.Equal(t, int64(16)+32, EstimateSize(string(make([]byte, 32))))
+	require.Equal(t, int64(24)+1024, EstimateSize(make([]byte, 10, 1024)))
+	require.Equal(t, int64(5), EstimateSize(cachedString("value")))
+}
+
+func TestEstimateSizeGrowsWithContents(t *testing.T) {
+	small := &sizeRecord{Name: "a"}
+	large := &sizeRecord{
+		Name:  string(make([]byte, 1000)),
+		Tags:  []string{"one", "two", "three"},
+		Attrs: map[string]int{"x": 1, "y": 2},
+		Any:   []byte("payload"),
+	}
+	require.Greater(t, EstimateSize(small), int64(0))
+	require.Greater(t, EstimateSize(large), EstimateSize(small)+1000)
+
+	m := make(map[int]int)
+	prev := EstimateSize(m)
+	for i := 0; i < 1000; i++ {
+		m[i] = i
+	}
+	require.Greater(t, EstimateSize(m), prev+1000*16)
+}
+
+func TestEstimateSizeCycles(t *testing.T) {
+	a := &sizeNode{name: "a"}
+	b := &sizeNode{name: "b", next: a}
+	a.next = b
+	single := &sizeNode{name: "a"}
+
+	// Each node is counted once, even though the list loops forever.
+	require.Equal(t, 2*EstimateSize(single), EstimateSize(a))
+
+	// Shared references are only counted once.
+	shared := []*sizeNode{single, single, single}
+	distinct := []*sizeNode{{name: "a"}, {name: "a"}, {name: "a"}}
+	require.Less(t, EstimateSize(shared), EstimateSize(distinct))
+}
+
+func TestEstimateSizeUsesCachedSize(t *testing.T) {
+	rec := &sizedRecord{payload: make([]byte, 1<<20)}
+	require.Equal(t, int64(12345), EstimateSize(rec))
+
+	type wrapper struct {
+		Rec *sizedRecord
+	}
+	require.Equal(t, int64(8)+12345, EstimateSize(wrapper{Rec: rec}))
+}
+
+func TestEstimateSizeOpaqueValues(t *testing.T) {
+	ch := make(chan int, 10)
+	require.Greater(t, EstimateSize(ch), int64(80))
+	require.Zero(t, EstimateSize(func() {}))
+	require.Equal(t, int64(24), EstimateSize(time.Time{}))
+}
+
+func TestDefaultCacheImplEstimatesCost(t *testing.T) {
+	// Values without CachedSize work with the LFU cache.
+	lfu := NewDefaultCacheImpl(&Config{MaxEntries: 100, MaxMemoryUsage: 1 << 20, LFU: true})
+	require.True(t, lfu.Set("key", "plain string"))
+	lfu.Wait()
+	value, ok := lfu.Get("key")
+	require.True(t, ok)
+	require.Equal(t, "plain string", value)
+
+	// The LRU cache is bounded by bytes when BoundByMemory is set.
+	lru := NewDefaultCacheImpl(&Config{MaxEntries: 100, MaxMemoryUsage: 4200, BoundByMemory: true})
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		lru.Set(key, make([]byte, 1000))
+	}
+	require.Equal(t, 4, lru.Len())
+	require.Equal(t, int64(4*(24+1024)), lru.UsedCapacity())
+	_, ok = lru.Get("a")
+	require.False(t, ok)
+
+	// A custom cost function replaces the estimator.
+	counted := NewDefaultCacheImpl(&Config{MaxEntries: 100, MaxMemoryUsage: 3, BoundByMemory: true, Cost: func(interface{}) int64 { return 1 }})
+	for _, key := range []string{"a", "b", "c", "d"} {
+		counted.Set(key, make([]byte, 1000))
+	}
+	require.Equal(t, 3, counted.Len())
+}
+
+func TestDefaultCacheImplCountsEntriesByDefault(t *testing.T) {
+	// Without BoundByMemory, MaxMemoryUsage does not change the capacity of
+	// the policies other than LFU.
+	for _, policy := range []string{PolicyLRU, PolicyARC, Policy2Q, PolicySLRU, PolicyWTinyLFU} {
+		cache := NewDefaultCacheImpl(&Config{MaxEntries: 100, MaxMemoryUsage: 1000, Policy: policy})
+		require.Equal(t, int64(100), cache.MaxCapacity(), policy)
+		for i := 0; i < 100; i++ {
+			cache.Set(fmt.Sprintf("key%d", i), make([]byte, 1000))
+		}
+		require.Equal(t, 100, cache.Len(), policy)
+		require.Equal(t, int64(100), cache.UsedCapacity(), policy)
+	}
+}
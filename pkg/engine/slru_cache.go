@@ -0,0 +1,222 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// SLRUCache implements a Segmented LRU. New entries start in a probationary
+// segment and are promoted to a protected segment when they are accessed again.
+// Entries falling off the protected segment are demoted back to probation, and
+// only probationary entries are evicted while there are any, so a burst of
+// one-off keys cannot flush the entries that are used repeatedly.
+
+import (
+	"container/list"
+	"sync"
+)
+
+var _ Cache = &SLRUCache{}
+
+// slruProtectedRatio is the share of the capacity reserved for the protected segment.
+const slruProtectedRatio = 0.8
+
+// SLRUCache is a Segmented LRU cache implementation. Like LRUCache, the
+// capacity is not the number of items but the total cost of every item.
+type SLRUCache struct {
+	mu sync.Mutex
+
+	table     segmentTable
+	probation *segment
+	protected *segment
+	cost      func(interface{}) int64
+	onRemove  RemovalListener
+
+	capacity int64
+	statsCounters
+}
+
+// NewSLRUCache creates a new empty SLRU cache with the given capacity.
+func NewSLRUCache(capacity int64, cost func(interface{}) int64) *SLRUCache {
+	return &SLRUCache{
+		table:     make(segmentTable),
+		probation: newSegment(),
+		protected: newSegment(),
+		cost:      cost,
+		capacity:  capacity,
+	}
+}
+
+// Get returns a value from the cache, promoting the entry to the protected segment.
+func (c *SLRUCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element := c.table[key]
+	if element == nil {
+		c.recordGet(false)
+		return nil, false
+	}
+	c.recordGet(true)
+	c.touch(element)
+	return element.Value.(*segmentEntry).value, true
+}
+
+// Set sets a value in the cache. New entries are placed in the probationary segment.
+func (c *SLRUCache) Set(key string, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sets++
+	size := c.cost(value)
+	if element := c.table[key]; element != nil {
+		c.onRemove.notify(key, element.Value.(*segmentEntry).value, Replaced)
+		element.Value.(*segmentEntry).value = value
+		element.Value.(*segmentEntry).seg.resize(element, size)
+		c.touch(element)
+	} else {
+		c.table.add(key, value, size, c.probation)
+	}
+	c.checkCapacity()
+	// the SLRU cache cannot fail to insert items; it always returns true
+	return true
+}
+
+// Delete removes an entry from the cache
+func (c *SLRUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element := c.table[key]; element != nil {
+		e := c.table.drop(element)
+		c.onRemove.notify(e.key, e.value, Deleted)
+	}
+}
+
+// Clear will clear the entire cache.
+func (c *SLRUCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.protected.notify(c.onRemove, Cleared)
+	c.probation.notify(c.onRemove, Cleared)
+	c.table = make(segmentTable)
+	c.probation.clear()
+	c.protected.clear()
+}
+
+// SetRemovalListener sets the listener notified whenever an entry leaves the cache.
+func (c *SLRUCache) SetRemovalListener(listener RemovalListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRemove = listener
+}
+
+// Wait is a no-op in the SLRU cache
+func (c *SLRUCache) Wait() {}
+
+// Len returns the size of the cache (in entries)
+func (c *SLRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.table)
+}
+
+// Evictions returns the number of evictions
+func (c *SLRUCache) Evictions() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictions
+}
+
+// UsedCapacity returns the size of the cache (in bytes)
+func (c *SLRUCache) UsedCapacity() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.probation.size + c.protected.size
+}
+
+// MaxCapacity returns the cache maximum capacity.
+func (c *SLRUCache) MaxCapacity() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capacity
+}
+
+// SetCapacity will set the capacity of the cache, shrinking it if needed.
+func (c *SLRUCache) SetCapacity(capacity int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity = capacity
+	c.checkCapacity()
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (c *SLRUCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats(c.probation.size+c.protected.size, c.capacity)
+}
+
+// ForEach yields all the values for the cache, protected entries first, each
+// segment ordered from most recently used to least recently used.
+func (c *SLRUCache) ForEach(callback func(value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.protected.forEach(callback) {
+		c.probation.forEach(callback)
+	}
+}
+
+// Items returns all the entries of the cache, in the same order as ForEach.
+func (c *SLRUCache) Items() []Item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	items := make([]Item, 0, len(c.table))
+	items = c.protected.appendItems(items)
+	items = c.probation.appendItems(items)
+	return items
+}
+
+func (c *SLRUCache) touch(element *list.Element) {
+	if element.Value.(*segmentEntry).seg == c.protected {
+		c.protected.moveToFront(element)
+		return
+	}
+	c.table.move(element, c.protected)
+	// Demote the least recently used protected entries back to probation.
+	limit := int64(float64(c.capacity) * slruProtectedRatio)
+	for c.protected.size > limit && c.protected.Len() > 1 {
+		c.table.move(c.protected.back(), c.probation)
+	}
+}
+
+func (c *SLRUCache) checkCapacity() {
+	for c.probation.size+c.protected.size > c.capacity {
+		victim := c.probation.back()
+		if victim == nil {
+			victim = c.protected.back()
+		}
+		e := c.table.drop(victim)
+		c.evictions++
+		c.onRemove.notify(e.key, e.value, Evicted)
+	}
+}
@@ -0,0 +1,334 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Snapshots let a cache be dumped to a file and reloaded by another process,
+// so that it starts warm after a restart. A snapshot holds every entry of the
+// cache, with its value encoded by a Codec, ordered from hottest to coldest.
+//
+// Caches with a TinyLFU frequency sketch also save the estimated frequency of
+// every entry. The sketch itself cannot be saved as-is: it is indexed by key
+// hashes, which are seeded differently in every process. Instead, the saved
+// frequencies are replayed into the sketch of the cache being restored.
+//
+// The on-disk format is:
+//
+//	magic    [4]byte "BCSN"
+//	version  uint16, big endian
+//	count    uvarint
+//	count times:
+//		key       uvarint length, bytes
+//		frequency uvarint
+//		value     uvarint length, bytes
+//	checksum uint32, big endian, CRC-32 (IEEE) of everything before it
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/bhojpur/cache/pkg/engine/ristretto"
+	"github.com/bhojpur/cache/pkg/ioutils"
+)
+
+const (
+	snapshotMagic = "BCSN"
+	// snapshotVersion is the version written by WriteSnapshot. Readers must
+	// keep accepting every older version.
+	snapshotVersion = 1
+)
+
+var (
+	// ErrSnapshotFormat is returned when reading a file which is not a valid snapshot.
+	ErrSnapshotFormat = errors.New("engine: invalid cache snapshot")
+	// ErrSnapshotVersion is returned when reading a snapshot written by a newer version.
+	ErrSnapshotVersion = errors.New("engine: unsupported cache snapshot version")
+	// ErrSnapshotUnsupported is returned when the cache cannot enumerate its entries.
+	ErrSnapshotUnsupported = errors.New("engine: cache does not support snapshots")
+	// ErrSnapshotIncomplete is returned when the cache dropped some of the
+	// entries of a snapshot, for instance because it was closed.
+	ErrSnapshotIncomplete = errors.New("engine: cache snapshot partially restored")
+)
+
+// Codec encodes and decodes cached values for snapshots.
+type Codec interface {
+	Encode(value interface{}) ([]byte, error)
+	Decode(data []byte) (interface{}, error)
+}
+
+// BytesCodec is the Codec for caches holding []byte values.
+type BytesCodec struct{}
+
+// Encode returns the value itself, which must be a []byte
+func (BytesCodec) Encode(value interface{}) ([]byte, error) {
+	b, ok := value.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("engine: BytesCodec cannot encode %T", value)
+	}
+	return b, nil
+}
+
+// Decode returns a copy of the data
+func (BytesCodec) Decode(data []byte) (interface{}, error) {
+	return append([]byte(nil), data...), nil
+}
+
+// JSONCodec encodes values as JSON. New returns a pointer to the value to
+// decode into; the decoded pointer is stored in the cache.
+type JSONCodec struct {
+	New func() interface{}
+}
+
+// Encode marshals the value as JSON
+func (c JSONCodec) Encode(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+// Decode unmarshals the data into a new value
+func (c JSONCodec) Decode(data []byte) (interface{}, error) {
+	v := c.New()
+	if err := json.Unmarshal(data, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// itemsCache is implemented by caches which can list their entries, from
+// hottest to coldest.
+type itemsCache interface {
+	Items() []Item
+}
+
+// frequencyCache is implemented by caches with a TinyLFU frequency sketch.
+type frequencyCache interface {
+	Frequency(key string) int64
+	RecordFrequency(key string, count int64)
+}
+
+// syncSetter is implemented by caches which can wait for their admission
+// policy instead of dropping sets when their buffers are full.
+type syncSetter interface {
+	SetSync(key string, value interface{}) ristretto.SetResult
+}
+
+// keyKeeper is implemented by caches which only enumerate their keys when
+// configured to keep them.
+type keyKeeper interface {
+	KeepsKeys() bool
+}
+
+// snapshotItems lists the entries of the cache, from hottest to coldest.
+// Caches which can't order their entries by recency are ordered by frequency.
+func snapshotItems(cache Cache, freq frequencyCache) ([]Item, error) {
+	if items, ok := cache.(itemsCache); ok {
+		return items.Items(), nil
+	}
+	iter, ok := cache.(KeyIterator)
+	if !ok {
+		return nil, ErrSnapshotUnsupported
+	}
+	if keeper, ok := cache.(keyKeeper); ok && !keeper.KeepsKeys() {
+		return nil, ErrSnapshotUnsupported
+	}
+	var entries []Item
+	iter.ForEachKV(func(key string, value interface{}) bool {
+		entries = append(entries, Item{Key: key, Value: value})
+		return true
+	})
+	if freq != nil {
+		frequencies := make(map[string]int64, len(entries))
+		for _, item := range entries {
+			frequencies[item.Key] = freq.Frequency(item.Key)
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return frequencies[entries[i].Key] > frequencies[entries[j].Key]
+		})
+	}
+	return entries, nil
+}
+
+// WriteSnapshot writes the contents of the cache to w. The LFU cache can only
+// be snapshotted when it keeps its keys.
+func WriteSnapshot(w io.Writer, cache Cache, codec Codec) error {
+	freq, _ := cache.(frequencyCache)
+	entries, err := snapshotItems(cache, freq)
+	if err != nil {
+		return err
+	}
+
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	var buf [binary.MaxVarintLen64]byte
+	writeUvarint := func(v uint64) {
+		n := binary.PutUvarint(buf[:], v)
+		bw.Write(buf[:n])
+	}
+
+	bw.WriteString(snapshotMagic)
+	binary.BigEndian.PutUint16(buf[:2], snapshotVersion)
+	bw.Write(buf[:2])
+	writeUvarint(uint64(len(entries)))
+	for _, item := range entries {
+		value, err := codec.Encode(item.Value)
+		if err != nil {
+			return fmt.Errorf("engine: encoding %q: %w", item.Key, err)
+		}
+		var frequency int64
+		if freq != nil {
+			frequency = freq.Frequency(item.Key)
+		}
+		writeUvarint(uint64(len(item.Key)))
+		bw.WriteString(item.Key)
+		writeUvarint(uint64(frequency))
+		writeUvarint(uint64(len(value)))
+		bw.Write(value)
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	binary.BigEndian.PutUint32(buf[:4], crc.Sum32())
+	_, err = w.Write(buf[:4])
+	return err
+}
+
+// ReadSnapshot loads the entries of a snapshot read from r into the cache.
+// The frequencies are restored first, then the entries are set from coldest
+// to hottest so that the recency order of the snapshot is preserved. Entries
+// may still be rejected by the admission policy of the cache, but caches with
+// buffered writes wait for room instead of dropping them.
+func ReadSnapshot(r io.Reader, cache Cache, codec Codec) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) < len(snapshotMagic)+2+4 || string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return ErrSnapshotFormat
+	}
+	body, sum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return fmt.Errorf("%w: checksum mismatch", ErrSnapshotFormat)
+	}
+
+	br := bytes.NewReader(body[len(snapshotMagic):])
+	var version uint16
+	if err := binary.Read(br, binary.BigEndian, &version); err != nil {
+		return ErrSnapshotFormat
+	}
+	switch version {
+	case 1:
+		return readSnapshotV1(br, cache, codec)
+	default:
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, version)
+	}
+}
+
+type snapshotEntry struct {
+	key       string
+	frequency int64
+	value     interface{}
+}
+
+func readSnapshotV1(br *bytes.Reader, cache Cache, codec Codec) error {
+	readBytes := func() ([]byte, error) {
+		n, err := binary.ReadUvarint(br)
+		if err != nil || n > uint64(br.Len()) {
+			return nil, ErrSnapshotFormat
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(br, b)
+		return b, err
+	}
+
+	count, err := binary.ReadUvarint(br)
+	if err != nil {
+		return ErrSnapshotFormat
+	}
+	var entries []snapshotEntry
+	for i := uint64(0); i < count; i++ {
+		key, err := readBytes()
+		if err != nil {
+			return ErrSnapshotFormat
+		}
+		frequency, err := binary.ReadUvarint(br)
+		if err != nil {
+			return ErrSnapshotFormat
+		}
+		data, err := readBytes()
+		if err != nil {
+			return ErrSnapshotFormat
+		}
+		value, err := codec.Decode(data)
+		if err != nil {
+			return fmt.Errorf("engine: decoding %q: %w", key, err)
+		}
+		entries = append(entries, snapshotEntry{string(key), int64(frequency), value})
+	}
+	if br.Len() != 0 {
+		return ErrSnapshotFormat
+	}
+
+	if freq, ok := cache.(frequencyCache); ok {
+		for _, e := range entries {
+			freq.RecordFrequency(e.key, e.frequency)
+		}
+	}
+	var dropped int
+	setter, _ := cache.(syncSetter)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if setter == nil {
+			cache.Set(entries[i].key, entries[i].value)
+		} else if setter.SetSync(entries[i].key, entries[i].value).Status == ristretto.SetDropped {
+			dropped++
+		}
+	}
+	cache.Wait()
+	if dropped > 0 {
+		return fmt.Errorf("%w: %d of %d entries dropped", ErrSnapshotIncomplete, dropped, len(entries))
+	}
+	return nil
+}
+
+// SaveSnapshot atomically writes a snapshot of the cache to the named file.
+func SaveSnapshot(filename string, cache Cache, codec Codec) error {
+	var buf bytes.Buffer
+	if err := WriteSnapshot(&buf, cache, codec); err != nil {
+		return err
+	}
+	return ioutils.AtomicWriteFile(filename, buf.Bytes(), 0600)
+}
+
+// LoadSnapshot restores a snapshot saved with SaveSnapshot into the cache.
+func LoadSnapshot(filename string, cache Cache, codec Codec) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ReadSnapshot(f, cache, codec)
+}
@@ -0,0 +1,176 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bhojpur/cache/pkg/engine/ristretto"
+)
+
+func bytesSize(val interface{}) int64 {
+	return int64(len(val.([]byte)))
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	cache := NewLRUCache(100, bytesSize)
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)))
+	}
+	cache.Get("key3")
+
+	filename := filepath.Join(t.TempDir(), "cache.snapshot")
+	require.NoError(t, SaveSnapshot(filename, cache, BytesCodec{}))
+
+	restored := NewLRUCache(100, bytesSize)
+	require.NoError(t, LoadSnapshot(filename, restored, BytesCodec{}))
+	require.Equal(t, cache.Items(), restored.Items())
+	require.Equal(t, cache.UsedCapacity(), restored.UsedCapacity())
+}
+
+func TestSnapshotRestoresFrequencies(t *testing.T) {
+	cache := NewWTinyLFUCache(100, 100, bytesSize)
+	cache.Set("hot", []byte("value"))
+	for i := 0; i < 5; i++ {
+		cache.Get("hot")
+	}
+	cache.Set("cold", []byte("value"))
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteSnapshot(&buf, cache, BytesCodec{}))
+
+	restored := NewWTinyLFUCache(100, 100, bytesSize)
+	require.NoError(t, ReadSnapshot(&buf, restored, BytesCodec{}))
+	require.Equal(t, 2, restored.Len())
+	require.GreaterOrEqual(t, restored.Frequency("hot"), cache.Frequency("hot"))
+	require.Less(t, restored.Frequency("cold"), restored.Frequency("hot"))
+}
+
+func TestSnapshotJSONCodec(t *testing.T) {
+	type user struct {
+		Name string
+	}
+	codec := JSONCodec{New: func() interface{} { return &user{} }}
+	cache := NewLRUCache(10, func(_ interface{}) int64 { return 1 })
+	cache.Set("u1", &user{Name: "alice"})
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteSnapshot(&buf, cache, codec))
+	restored := NewLRUCache(10, func(_ interface{}) int64 { return 1 })
+	require.NoError(t, ReadSnapshot(&buf, restored, codec))
+
+	v, ok := restored.Get("u1")
+	require.True(t, ok)
+	require.Equal(t, &user{Name: "alice"}, v)
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteSnapshot(&buf, NewDefaultCacheImpl(nil), BytesCodec{})
+	require.True(t, errors.Is(err, ErrSnapshotUnsupported))
+
+	cache := NewLRUCache(100, bytesSize)
+	cache.Set("key", []byte("value"))
+	require.NoError(t, WriteSnapshot(&buf, cache, BytesCodec{}))
+	data := buf.Bytes()
+
+	err = ReadSnapshot(bytes.NewReader([]byte("garbage")), cache, BytesCodec{})
+	require.True(t, errors.Is(err, ErrSnapshotFormat))
+
+	corrupted := append([]byte(nil), data...)
+	corrupted[len(corrupted)-6] ^= 0xff
+	err = ReadSnapshot(bytes.NewReader(corrupted), cache, BytesCodec{})
+	require.True(t, errors.Is(err, ErrSnapshotFormat))
+
+	future := append([]byte(nil), data[:len(data)-4]...)
+	future[len(snapshotMagic)+1] = 99
+	future = appendChecksum(future)
+	err = ReadSnapshot(bytes.NewReader(future), cache, BytesCodec{})
+	require.True(t, errors.Is(err, ErrSnapshotVersion))
+
+	err = WriteSnapshot(&bytes.Buffer{}, func() Cache {
+		c := NewLRUCache(10, func(_ interface{}) int64 { return 1 })
+		c.Set("key", 1)
+		return c
+	}(), BytesCodec{})
+	require.Error(t, err)
+}
+
+func appendChecksum(data []byte) []byte {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc32.ChecksumIEEE(data))
+	return append(data, sum[:]...)
+}
+
+func TestSnapshotLFUCache(t *testing.T) {
+	newCache := func(keepKeys bool) Cache {
+		return NewDefaultCacheImpl(&Config{MaxEntries: 100, MaxMemoryUsage: 1 << 20, LFU: true, KeepKeys: keepKeys})
+	}
+
+	var buf bytes.Buffer
+	err := WriteSnapshot(&buf, newCache(false), BytesCodec{})
+	require.True(t, errors.Is(err, ErrSnapshotUnsupported))
+
+	cache := newCache(true)
+	for i := 0; i < 10; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), []byte(fmt.Sprintf("value%d", i)))
+	}
+	cache.Wait()
+	require.NoError(t, WriteSnapshot(&buf, cache, BytesCodec{}))
+
+	restored := newCache(true)
+	require.NoError(t, ReadSnapshot(&buf, restored, BytesCodec{}))
+	require.Equal(t, cache.Len(), restored.Len())
+	for i := 0; i < 10; i++ {
+		v, ok := restored.Get(fmt.Sprintf("key%d", i))
+		require.True(t, ok)
+		require.Equal(t, []byte(fmt.Sprintf("value%d", i)), v)
+	}
+
+	// A restore larger than the set buffer keeps every entry.
+	newLargeCache := func() Cache {
+		return NewDefaultCacheImpl(&Config{MaxEntries: 1000, MaxMemoryUsage: 1 << 20, LFU: true, KeepKeys: true})
+	}
+	cache = newLargeCache()
+	for i := 0; i < 1000; i++ {
+		cache.Set(fmt.Sprintf("key%d", i), []byte("v"))
+	}
+	cache.Wait()
+	buf.Reset()
+	require.NoError(t, WriteSnapshot(&buf, cache, BytesCodec{}))
+	data := buf.Bytes()
+	restored = newLargeCache()
+	require.NoError(t, ReadSnapshot(bytes.NewReader(data), restored, BytesCodec{}))
+	require.Equal(t, cache.Len(), restored.Len())
+
+	closed := newCache(true).(*ristretto.Cache)
+	closed.Close()
+	err = ReadSnapshot(bytes.NewReader(data), closed, BytesCodec{})
+	require.True(t, errors.Is(err, ErrSnapshotIncomplete))
+}
@@ -0,0 +1,87 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"github.com/bhojpur/cache/pkg/engine/ristretto"
+)
+
+// Stats is a point-in-time summary of the statistics of a cache, filled by
+// every Cache implementation.
+type Stats = ristretto.Stats
+
+// statsCounters are the statistics kept by the synchronous cache
+// implementations. They must only be updated while the cache holds its lock.
+type statsCounters struct {
+	hits      uint64
+	misses    uint64
+	sets      uint64
+	rejected  uint64
+	evictions int64
+}
+
+// recordGet counts a Get call as a hit or a miss.
+func (s *statsCounters) recordGet(found bool) {
+	if found {
+		s.hits++
+	} else {
+		s.misses++
+	}
+}
+
+// stats returns a snapshot of the counters for a cache with the given capacity.
+func (s *statsCounters) stats(used, capacity int64) Stats {
+	return Stats{
+		Hits:         s.hits,
+		Misses:       s.misses,
+		HitRatio:     hitRatio(s.hits, s.misses),
+		Sets:         s.sets,
+		SetsRejected: s.rejected,
+		Evictions:    uint64(s.evictions),
+		UsedCapacity: used,
+		MaxCapacity:  capacity,
+	}
+}
+
+// addStats accumulates the counters of another Stats snapshot, for caches made of
+// several independent parts.
+func addStats(total *Stats, s Stats) {
+	total.Hits += s.Hits
+	total.Misses += s.Misses
+	total.Sets += s.Sets
+	total.SetsRejected += s.SetsRejected
+	total.SetsDropped += s.SetsDropped
+	total.Evictions += s.Evictions
+	total.UsedCapacity += s.UsedCapacity
+	total.MaxCapacity += s.MaxCapacity
+	total.LoadSuccesses += s.LoadSuccesses
+	total.LoadFailures += s.LoadFailures
+	total.TotalLoadTime += s.TotalLoadTime
+	total.HitRatio = hitRatio(total.Hits, total.Misses)
+}
+
+// hitRatio returns hits over all the lookups.
+func hitRatio(hits, misses uint64) float64 {
+	if hits == 0 && misses == 0 {
+		return 0.0
+	}
+	return float64(hits) / float64(hits+misses)
+}
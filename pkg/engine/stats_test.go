@@ -0,0 +1,86 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsConformance(t *testing.T) {
+	for name, newCache := range policyCaches() {
+		newCache := newCache
+		t.Run(name, func(t *testing.T) {
+			cache := newCache(10)
+			_, ok := cache.Get("key")
+			require.False(t, ok)
+			cache.Set("key", &CacheValue{1})
+			_, ok = cache.Get("key")
+			require.True(t, ok)
+
+			stats := cache.Stats()
+			require.Equal(t, uint64(1), stats.Hits)
+			require.Equal(t, uint64(1), stats.Misses)
+			require.Equal(t, 0.5, stats.HitRatio)
+			require.Equal(t, uint64(1), stats.Sets)
+			require.Equal(t, int64(1), stats.UsedCapacity)
+			require.Equal(t, int64(10), stats.MaxCapacity)
+
+			for i := 0; i < 30; i++ {
+				cache.Set(fmt.Sprintf("key%d", i), &CacheValue{1})
+			}
+			stats = cache.Stats()
+			require.Equal(t, uint64(31), stats.Sets)
+			require.Equal(t, uint64(cache.Evictions()), stats.Evictions)
+			require.Equal(t, cache.UsedCapacity(), stats.UsedCapacity)
+		})
+	}
+}
+
+func TestNullCacheStats(t *testing.T) {
+	cache := NewDefaultCacheImpl(nil)
+	cache.Get("key")
+	cache.Set("key", 1)
+	cache.Get("key")
+
+	stats := cache.Stats()
+	require.Equal(t, uint64(0), stats.Hits)
+	require.Equal(t, uint64(2), stats.Misses)
+	require.Equal(t, uint64(1), stats.SetsRejected)
+	require.Equal(t, int64(0), stats.MaxCapacity)
+}
+
+func TestRistrettoStats(t *testing.T) {
+	cache := NewDefaultCacheImpl(&Config{MaxEntries: 100, MaxMemoryUsage: 1 << 20, LFU: true})
+	cache.Get("key")
+	cache.Set("key", cachedString("value"))
+	cache.Wait()
+	cache.Get("key")
+
+	stats := cache.Stats()
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Equal(t, uint64(1), stats.Sets)
+	require.Equal(t, cache.UsedCapacity(), stats.UsedCapacity)
+	require.Equal(t, int64(1<<20), stats.MaxCapacity)
+}
@@ -0,0 +1,190 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sync"
+
+	"github.com/bhojpur/cache/pkg/hack"
+)
+
+// taggedStripes is the number of locks serializing SetWithTags calls.
+const taggedStripes = 64
+
+var _ Cache = &TaggedCache{}
+
+// taggedEntry is the value stored in the underlying cache for entries set
+// with tags. The index points at the entry itself, so that the removal of an
+// old value never unlinks the tags of the value that replaced it.
+type taggedEntry struct {
+	value interface{}
+	tags  []string
+}
+
+// TaggedCache is a Cache whose entries can carry tags, so that every entry
+// for a tenant or an entity can be invalidated at once.
+//
+// The index from tags to keys is maintained through the removal listener of
+// the underlying cache, and only ever refers to live entries.
+type TaggedCache struct {
+	Cache
+
+	onRemove RemovalListener
+
+	// stripes serialize the SetWithTags calls for a key, so that the index
+	// is updated in the same order as the cache. They can't be held by the
+	// removal listener, which may run inside Set.
+	stripes [taggedStripes]sync.Mutex
+	seed    hack.HashSeed
+
+	mu   sync.Mutex
+	tags map[string]map[string]*taggedEntry
+}
+
+// NewTaggedCache creates a cache with the given configuration that supports
+// tags. The OnRemove listener of the configuration, if any, receives the
+// original values.
+func NewTaggedCache(cfg *Config) *TaggedCache {
+	c := &TaggedCache{
+		seed: hack.NewHashSeed(),
+		tags: make(map[string]map[string]*taggedEntry),
+	}
+	var config Config
+	if cfg != nil {
+		config = *cfg
+	}
+	c.onRemove = config.OnRemove
+	config.OnRemove = c.removed
+	if cost := config.Cost; cost != nil {
+		config.Cost = func(value interface{}) int64 {
+			return cost(untag(value))
+		}
+	}
+	c.Cache = NewDefaultCacheImpl(&config)
+	return c
+}
+
+// Get returns a value from the cache.
+func (c *TaggedCache) Get(key string) (interface{}, bool) {
+	value, ok := c.Cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return untag(value), true
+}
+
+// SetWithTags sets a value in the cache and attaches the given tags to it.
+// The tags replace the ones of any previous value of the key.
+func (c *TaggedCache) SetWithTags(key string, value interface{}, tags ...string) bool {
+	if len(tags) == 0 {
+		return c.Cache.Set(key, value)
+	}
+	e := &taggedEntry{value: value, tags: tags}
+	stripe := &c.stripes[hack.Strhash(key, c.seed)%taggedStripes]
+	stripe.Lock()
+	defer stripe.Unlock()
+
+	c.mu.Lock()
+	for _, tag := range tags {
+		keys, ok := c.tags[tag]
+		if !ok {
+			keys = make(map[string]*taggedEntry)
+			c.tags[tag] = keys
+		}
+		keys[key] = e
+	}
+	c.mu.Unlock()
+
+	if !c.Cache.Set(key, e) {
+		// The value may have been dropped without going through the
+		// removal listener.
+		c.unlink(key, e)
+		return false
+	}
+	return true
+}
+
+// InvalidateTag removes every entry carrying the tag. An entry that is set
+// again with other tags while the tag is being invalidated may be removed too.
+func (c *TaggedCache) InvalidateTag(tag string) {
+	c.mu.Lock()
+	keys := c.tags[tag]
+	delete(c.tags, tag)
+	c.mu.Unlock()
+
+	for key := range keys {
+		c.Cache.Delete(key)
+	}
+}
+
+// Tags returns the number of tags currently attached to live entries.
+func (c *TaggedCache) Tags() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tags)
+}
+
+// TagLen returns the number of entries carrying the tag.
+func (c *TaggedCache) TagLen(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tags[tag])
+}
+
+// ForEach yields all the values in the cache.
+func (c *TaggedCache) ForEach(callback func(interface{}) bool) {
+	c.Cache.ForEach(func(value interface{}) bool {
+		return callback(untag(value))
+	})
+}
+
+// removed is the removal listener of the underlying cache.
+func (c *TaggedCache) removed(key string, value interface{}, reason RemovalReason) {
+	if e, ok := value.(*taggedEntry); ok {
+		c.unlink(key, e)
+		value = e.value
+	}
+	c.onRemove.notify(key, value, reason)
+}
+
+// unlink drops the entry from the index of each of its tags, unless the key
+// was set again since.
+func (c *TaggedCache) unlink(key string, e *taggedEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range e.tags {
+		keys := c.tags[tag]
+		if keys[key] != e {
+			continue
+		}
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+func untag(value interface{}) interface{} {
+	if e, ok := value.(*taggedEntry); ok {
+		return e.value
+	}
+	return value
+}
@@ -0,0 +1,146 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTaggedCacheInvalidateTag(t *testing.T) {
+	for _, cfg := range []*Config{
+		{MaxEntries: 100},
+		{MaxEntries: 100, MaxMemoryUsage: 1 << 20, LFU: true},
+	} {
+		t.Run(cfg.policy(), func(t *testing.T) {
+			cache := NewTaggedCache(cfg)
+			require.True(t, cache.SetWithTags("user:1", "alice", "tenant:a", "users"))
+			require.True(t, cache.SetWithTags("user:2", "bob", "tenant:b", "users"))
+			require.True(t, cache.SetWithTags("order:1", "pizza", "tenant:a"))
+			require.True(t, cache.Set("plain", "value"))
+			cache.Wait()
+
+			value, ok := cache.Get("user:1")
+			require.True(t, ok)
+			require.Equal(t, "alice", value)
+			require.Equal(t, 2, cache.TagLen("tenant:a"))
+
+			cache.InvalidateTag("tenant:a")
+			cache.Wait()
+			for key, present := range map[string]bool{"user:1": false, "order:1": false, "user:2": true, "plain": true} {
+				_, ok := cache.Get(key)
+				require.Equal(t, present, ok, key)
+			}
+			// The other tags of the invalidated entries are gone as well.
+			require.Equal(t, 1, cache.TagLen("users"))
+			require.Equal(t, 2, cache.Tags())
+		})
+	}
+}
+
+func TestTaggedCacheIndexFollowsRemovals(t *testing.T) {
+	var values []interface{}
+	cache := NewTaggedCache(&Config{MaxEntries: 2, OnRemove: func(_ string, value interface{}, _ RemovalReason) {
+		values = append(values, value)
+	}})
+
+	cache.SetWithTags("k1", "v1", "t1")
+	cache.SetWithTags("k2", "v2", "t1", "t2")
+	require.Equal(t, 2, cache.TagLen("t1"))
+
+	// Replacing a value swaps its tags.
+	cache.SetWithTags("k2", "v2b", "t3")
+	require.Equal(t, 1, cache.TagLen("t1"))
+	require.Equal(t, 0, cache.TagLen("t2"))
+	require.Equal(t, 1, cache.TagLen("t3"))
+
+	// Evictions unlink the evicted entry.
+	cache.SetWithTags("k3", "v3", "t3")
+	require.Equal(t, 0, cache.TagLen("t1"))
+	require.Equal(t, 2, cache.TagLen("t3"))
+
+	cache.Delete("k2")
+	require.Equal(t, 1, cache.TagLen("t3"))
+	cache.Clear()
+	require.Equal(t, 0, cache.Tags())
+
+	// The listener sees the values that were set, not the tagged wrappers.
+	require.Equal(t, []interface{}{"v2", "v1", "v2b", "v3"}, values)
+}
+
+func TestTaggedCacheConcurrent(t *testing.T) {
+	cache := NewTaggedCache(&Config{MaxEntries: 50})
+	const workers = 8
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for i := 0; i < 2000; i++ {
+				key := fmt.Sprintf("key%d", r.Intn(100))
+				tag := fmt.Sprintf("tag%d", r.Intn(10))
+				switch r.Intn(4) {
+				case 0, 1:
+					cache.SetWithTags(key, i, tag, "all")
+				case 2:
+					cache.Delete(key)
+				case 3:
+					cache.InvalidateTag(tag)
+				}
+			}
+		}(int64(w))
+	}
+	wg.Wait()
+
+	// Every indexed key is live and carries the tag it is indexed under.
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	indexed := 0
+	for tag, keys := range cache.tags {
+		for key, e := range keys {
+			value, ok := cache.Cache.Get(key)
+			require.True(t, ok, "%s indexed under %s is not in the cache", key, tag)
+			require.True(t, value == e, "%s indexed under %s holds a stale entry", key, tag)
+		}
+		if tag == "all" {
+			indexed = len(keys)
+		}
+	}
+	require.Equal(t, cache.Cache.Len(), indexed)
+}
+
+func TestTaggedCacheClearUnlinksQueuedEntries(t *testing.T) {
+	cache := NewTaggedCache(&Config{MaxEntries: 10000, MaxMemoryUsage: 1 << 20, LFU: true})
+	// Entries still queued when the cache is cleared are dropped, and must
+	// leave the index too.
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 1000; i++ {
+			cache.SetWithTags(fmt.Sprint(i), i, "all")
+		}
+		cache.Clear()
+		require.Equal(t, 0, cache.Tags())
+	}
+}
@@ -0,0 +1,257 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// TwoQueueCache implements the full 2Q algorithm (Johnson and Shasha). Entries
+// seen for the first time go to a small FIFO-like "recent" queue. Keys evicted
+// from it are remembered in a "ghost" history without their values, and a key
+// which is set again while still in the history, or read again while in the
+// recent queue, is placed in the "frequent" LRU queue. Scans therefore only
+// churn the recent queue.
+
+import (
+	"container/list"
+	"sync"
+)
+
+var _ Cache = &TwoQueueCache{}
+
+const (
+	// twoQueueRecentRatio is the share of the capacity used by the recent queue.
+	twoQueueRecentRatio = 0.25
+	// twoQueueGhostRatio is the share of the capacity remembered by the ghost history.
+	twoQueueGhostRatio = 0.5
+)
+
+// TwoQueueCache is a 2Q cache implementation. Like LRUCache, the capacity is
+// not the number of items but the total cost of every item.
+type TwoQueueCache struct {
+	mu sync.Mutex
+
+	table    segmentTable
+	recent   *segment
+	frequent *segment
+	ghost    *segment
+	cost     func(interface{}) int64
+	onRemove RemovalListener
+
+	capacity int64
+	statsCounters
+}
+
+// NewTwoQueueCache creates a new empty 2Q cache with the given capacity.
+func NewTwoQueueCache(capacity int64, cost func(interface{}) int64) *TwoQueueCache {
+	return &TwoQueueCache{
+		table:    make(segmentTable),
+		recent:   newSegment(),
+		frequent: newSegment(),
+		ghost:    newSegment(),
+		cost:     cost,
+		capacity: capacity,
+	}
+}
+
+// Get returns a value from the cache. Entries read while in the recent queue
+// are promoted to the frequent queue.
+func (c *TwoQueueCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element := c.table[key]
+	if element == nil {
+		c.recordGet(false)
+		return nil, false
+	}
+	switch element.Value.(*segmentEntry).seg {
+	case c.frequent:
+		c.frequent.moveToFront(element)
+	case c.recent:
+		c.table.move(element, c.frequent)
+	default:
+		// Ghost entries have no value
+		c.recordGet(false)
+		return nil, false
+	}
+	c.recordGet(true)
+	return element.Value.(*segmentEntry).value, true
+}
+
+// Set sets a value in the cache.
+func (c *TwoQueueCache) Set(key string, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sets++
+	size := c.cost(value)
+	element := c.table[key]
+	if element == nil {
+		c.table.add(key, value, size, c.recent)
+		c.checkCapacity()
+		// the 2Q cache cannot fail to insert items; it always returns true
+		return true
+	}
+
+	e := element.Value.(*segmentEntry)
+	switch e.seg {
+	case c.frequent:
+		c.onRemove.notify(key, e.value, Replaced)
+		c.frequent.moveToFront(element)
+	case c.recent:
+		c.onRemove.notify(key, e.value, Replaced)
+		element = c.table.move(element, c.frequent)
+	default:
+		// The key was recently evicted from the recent queue, which makes it
+		// a good candidate for the frequent queue.
+		element = c.table.move(element, c.frequent)
+	}
+	e.value = value
+	c.frequent.resize(element, size)
+	c.checkCapacity()
+	return true
+}
+
+// Delete removes an entry from the cache
+func (c *TwoQueueCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element := c.table[key]; element != nil {
+		resident := element.Value.(*segmentEntry).seg != c.ghost
+		if e := c.table.drop(element); resident {
+			c.onRemove.notify(e.key, e.value, Deleted)
+		}
+	}
+}
+
+// Clear will clear the entire cache, including the ghost history.
+func (c *TwoQueueCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.frequent.notify(c.onRemove, Cleared)
+	c.recent.notify(c.onRemove, Cleared)
+	c.table = make(segmentTable)
+	c.recent.clear()
+	c.frequent.clear()
+	c.ghost.clear()
+}
+
+// SetRemovalListener sets the listener notified whenever an entry leaves the cache.
+func (c *TwoQueueCache) SetRemovalListener(listener RemovalListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRemove = listener
+}
+
+// Wait is a no-op in the 2Q cache
+func (c *TwoQueueCache) Wait() {}
+
+// Len returns the size of the cache (in entries)
+func (c *TwoQueueCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recent.Len() + c.frequent.Len()
+}
+
+// Evictions returns the number of evictions
+func (c *TwoQueueCache) Evictions() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictions
+}
+
+// UsedCapacity returns the size of the cache (in bytes)
+func (c *TwoQueueCache) UsedCapacity() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recent.size + c.frequent.size
+}
+
+// MaxCapacity returns the cache maximum capacity.
+func (c *TwoQueueCache) MaxCapacity() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capacity
+}
+
+// SetCapacity will set the capacity of the cache, shrinking it if needed.
+func (c *TwoQueueCache) SetCapacity(capacity int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity = capacity
+	c.checkCapacity()
+}
+
+// Stats returns a snapshot of the cache statistics.
+func (c *TwoQueueCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats(c.recent.size+c.frequent.size, c.capacity)
+}
+
+// ForEach yields all the values for the cache, frequent entries first, each
+// queue ordered from most recently used to least recently used.
+func (c *TwoQueueCache) ForEach(callback func(value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.frequent.forEach(callback) {
+		c.recent.forEach(callback)
+	}
+}
+
+// Items returns all the entries of the cache, in the same order as ForEach.
+func (c *TwoQueueCache) Items() []Item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	items := make([]Item, 0, c.recent.Len()+c.frequent.Len())
+	items = c.frequent.appendItems(items)
+	items = c.recent.appendItems(items)
+	return items
+}
+
+func (c *TwoQueueCache) checkCapacity() {
+	recentLimit := int64(float64(c.capacity) * twoQueueRecentRatio)
+	for c.recent.size+c.frequent.size > c.capacity {
+		if c.recent.Len() > 0 && (c.recent.size > recentLimit || c.frequent.Len() == 0) {
+			c.demote(c.recent.back())
+		} else {
+			e := c.table.drop(c.frequent.back())
+			c.onRemove.notify(e.key, e.value, Evicted)
+		}
+		c.evictions++
+	}
+
+	ghostLimit := int64(float64(c.capacity) * twoQueueGhostRatio)
+	for c.ghost.size > ghostLimit {
+		c.table.drop(c.ghost.back())
+	}
+}
+
+// demote evicts a recent entry, remembering its key in the ghost history.
+func (c *TwoQueueCache) demote(element *list.Element) {
+	element = c.table.move(element, c.ghost)
+	e := element.Value.(*segmentEntry)
+	c.onRemove.notify(e.key, e.value, Evicted)
+	e.value = nil
+}
@@ -0,0 +1,350 @@
+package engine
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// WTinyLFUCache implements Window TinyLFU (Einziger, Friedman and Manes). New
+// entries are admitted into a small LRU window. Entries falling off the window
+// become candidates for the main cache, a Segmented LRU, and are only admitted
+// when the TinyLFU frequency sketch estimates that they are accessed more often
+// than the entry that would be evicted to make room for them. The window keeps
+// the cache responsive to bursts, while the filtered main cache resists scans.
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/bhojpur/cache/pkg/engine/ristretto"
+	"github.com/bhojpur/cache/pkg/hack"
+)
+
+var _ Cache = &WTinyLFUCache{}
+
+const (
+	// wtinylfuWindowRatio is the share of the capacity used by the admission window.
+	wtinylfuWindowRatio = 0.01
+)
+
+// WTinyLFUCache is a W-TinyLFU cache implementation. Like LRUCache, the
+// capacity is not the number of items but the total cost of every item.
+type WTinyLFUCache struct {
+	mu sync.Mutex
+
+	table     segmentTable
+	window    *segment
+	probation *segment
+	protected *segment
+	sketch    *ristretto.FrequencySketch
+	seed      hack.HashSeed
+	cost      func(interface{}) int64
+	onRemove  RemovalListener
+	victims   []*list.Element
+
+	capacity int64
+	statsCounters
+}
+
+// NewWTinyLFUCache creates a new empty W-TinyLFU cache with the given capacity.
+// maxEntries is the estimated amount of entries the cache will hold at capacity,
+// and it is used to size the frequency sketch.
+func NewWTinyLFUCache(maxEntries, capacity int64, cost func(interface{}) int64) *WTinyLFUCache {
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	return &WTinyLFUCache{
+		table:     make(segmentTable),
+		window:    newSegment(),
+		probation: newSegment(),
+		protected: newSegment(),
+		sketch:    ristretto.NewFrequencySketch(maxEntries * counterRatio),
+		seed:      hack.NewHashSeed(),
+		cost:      cost,
+		capacity:  capacity,
+	}
+}
+
+// Get returns a value from the cache and records the access in the frequency sketch.
+func (c *WTinyLFUCache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sketch.Increment(c.hashKey(key))
+	element := c.table[key]
+	if element == nil {
+		c.recordGet(false)
+		return nil, false
+	}
+	c.recordGet(true)
+	c.touch(element)
+	return element.Value.(*segmentEntry).value, true
+}
+
+// Set sets a value in the cache. New entries always enter the admission window,
+// so Set always returns true, but they may later be rejected by the main cache.
+func (c *WTinyLFUCache) Set(key string, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.sketch.Increment(c.hashKey(key))
+	c.sets++
+	size := c.cost(value)
+	if element := c.table[key]; element != nil {
+		c.onRemove.notify(key, element.Value.(*segmentEntry).value, Replaced)
+		element.Value.(*segmentEntry).value = value
+		element.Value.(*segmentEntry).seg.resize(element, size)
+		c.touch(element)
+	} else {
+		c.table.add(key, value, size, c.window)
+	}
+	c.checkCapacity()
+	return true
+}
+
+// Delete removes an entry from the cache
+func (c *WTinyLFUCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element := c.table[key]; element != nil {
+		e := c.table.drop(element)
+		c.onRemove.notify(e.key, e.value, Deleted)
+	}
+}
+
+// Clear will clear the entire cache and its frequency sketch.
+func (c *WTinyLFUCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.protected.notify(c.onRemove, Cleared)
+	c.probation.notify(c.onRemove, Cleared)
+	c.window.notify(c.onRemove, Cleared)
+	c.table = make(segmentTable)
+	c.window.clear()
+	c.probation.clear()
+	c.protected.clear()
+	c.sketch.Clear()
+}
+
+// SetRemovalListener sets the listener notified whenever an entry leaves the cache.
+func (c *WTinyLFUCache) SetRemovalListener(listener RemovalListener) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onRemove = listener
+}
+
+// Wait is a no-op in the W-TinyLFU cache
+func (c *WTinyLFUCache) Wait() {}
+
+// Len returns the size of the cache (in entries)
+func (c *WTinyLFUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.table)
+}
+
+// Evictions returns the number of evictions, including candidates rejected
+// from the admission window.
+func (c *WTinyLFUCache) Evictions() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.evictions
+}
+
+// UsedCapacity returns the size of the cache (in bytes)
+func (c *WTinyLFUCache) UsedCapacity() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.window.size + c.probation.size + c.protected.size
+}
+
+// MaxCapacity returns the cache maximum capacity.
+func (c *WTinyLFUCache) MaxCapacity() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.capacity
+}
+
+// SetCapacity will set the capacity of the cache, shrinking it if needed.
+func (c *WTinyLFUCache) SetCapacity(capacity int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.capacity = capacity
+	c.checkCapacity()
+}
+
+// Stats returns a snapshot of the cache statistics. Candidates rejected by the
+// admission filter are counted both as rejected sets and as evictions.
+func (c *WTinyLFUCache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats(c.window.size+c.probation.size+c.protected.size, c.capacity)
+}
+
+// ForEach yields all the values for the cache: protected entries first, then
+// probationary ones, then the admission window, each segment ordered from most
+// recently used to least recently used.
+func (c *WTinyLFUCache) ForEach(callback func(value interface{}) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.protected.forEach(callback) && c.probation.forEach(callback) {
+		c.window.forEach(callback)
+	}
+}
+
+// Items returns all the entries of the cache, in the same order as ForEach.
+func (c *WTinyLFUCache) Items() []Item {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	items := make([]Item, 0, len(c.table))
+	items = c.protected.appendItems(items)
+	items = c.probation.appendItems(items)
+	items = c.window.appendItems(items)
+	return items
+}
+
+// Frequency returns the access frequency of the key estimated by the sketch.
+func (c *WTinyLFUCache) Frequency(key string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sketch.Estimate(c.hashKey(key))
+}
+
+// RecordFrequency records count accesses to the key in the frequency sketch.
+func (c *WTinyLFUCache) RecordFrequency(key string, count int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sketch.Record(c.hashKey(key), count)
+}
+
+// limits returns the capacity of the window, of the main cache and of the
+// protected segment of the main cache.
+func (c *WTinyLFUCache) limits() (window, main, protected int64) {
+	window = int64(float64(c.capacity) * wtinylfuWindowRatio)
+	if window < 1 {
+		window = 1
+	}
+	main = c.capacity - window
+	if main < 0 {
+		main = 0
+	}
+	protected = int64(float64(main) * slruProtectedRatio)
+	return
+}
+
+func (c *WTinyLFUCache) touch(element *list.Element) {
+	switch element.Value.(*segmentEntry).seg {
+	case c.window:
+		c.window.moveToFront(element)
+	case c.protected:
+		c.protected.moveToFront(element)
+	case c.probation:
+		c.table.move(element, c.protected)
+		_, _, limit := c.limits()
+		for c.protected.size > limit && c.protected.Len() > 1 {
+			c.table.move(c.protected.back(), c.probation)
+		}
+	}
+}
+
+func (c *WTinyLFUCache) checkCapacity() {
+	windowLimit, mainLimit, _ := c.limits()
+
+	// Entries updated in place may have grown the main cache beyond its share.
+	for c.probation.size+c.protected.size > mainLimit && c.mainVictim() != nil {
+		c.evict(c.mainVictim(), Evicted)
+	}
+
+	// Every entry falling off the window competes for a slot in the main cache
+	// against the entries that would have to be evicted to make room for it.
+	for c.window.size > windowLimit {
+		candidate := c.window.back()
+		candidateSize := candidate.Value.(*segmentEntry).size
+		if candidateSize > mainLimit {
+			c.evict(candidate, Rejected)
+			continue
+		}
+		candidateFreq := c.sketch.Estimate(c.hashKey(candidate.Value.(*segmentEntry).key))
+
+		// The candidate has to beat every victim before any of them is evicted,
+		// otherwise losing to a later victim would shrink the cache for nothing.
+		admitted := true
+		victims := c.victims[:0]
+		need := c.probation.size + c.protected.size + candidateSize - mainLimit
+		for victim := c.mainVictim(); need > 0 && victim != nil; victim = c.nextVictim(victim) {
+			if candidateFreq <= c.sketch.Estimate(c.hashKey(victim.Value.(*segmentEntry).key)) {
+				admitted = false
+				break
+			}
+			victims = append(victims, victim)
+			need -= victim.Value.(*segmentEntry).size
+		}
+		if admitted {
+			for _, victim := range victims {
+				c.evict(victim, Evicted)
+			}
+			c.table.move(candidate, c.probation)
+		} else {
+			c.evict(candidate, Rejected)
+		}
+		for i := range victims {
+			victims[i] = nil
+		}
+		c.victims = victims[:0]
+	}
+}
+
+// mainVictim returns the next entry to evict from the main cache, or nil.
+func (c *WTinyLFUCache) mainVictim() *list.Element {
+	if victim := c.probation.back(); victim != nil {
+		return victim
+	}
+	return c.protected.back()
+}
+
+// nextVictim returns the entry to evict from the main cache after victim, or nil.
+func (c *WTinyLFUCache) nextVictim(victim *list.Element) *list.Element {
+	if prev := victim.Prev(); prev != nil {
+		return prev
+	}
+	if victim.Value.(*segmentEntry).seg == c.probation {
+		return c.protected.back()
+	}
+	return nil
+}
+
+// evict removes an entry, either evicted from the main cache or rejected by
+// the admission filter when falling off the window.
+func (c *WTinyLFUCache) evict(element *list.Element, reason RemovalReason) {
+	e := c.table.drop(element)
+	c.evictions++
+	if reason == Rejected {
+		c.rejected++
+	}
+	c.onRemove.notify(e.key, e.value, reason)
+}
+
+// hashKey hashes a key for use in the frequency sketch.
+func (c *WTinyLFUCache) hashKey(key string) uint64 {
+	return hack.Strhash(key, c.seed)
+}
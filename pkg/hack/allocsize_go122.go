@@ -0,0 +1,53 @@
+//go:build go1.22
+// +build go1.22
+
+package hack
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"sort"
+)
+
+// Since Go 1.22, runtime.roundupsize also depends on whether the block holds
+// pointers, and since Go 1.23 the linker rejects references to it. The size
+// classes below are the ones of the runtime (internal/runtime/gc), which
+// have not changed since Go 1.13.
+var sizeClasses = [...]uint16{0, 8, 16, 24, 32, 48, 64, 80, 96, 112, 128, 144, 160, 176, 192, 208, 224, 240, 256, 288, 320, 352, 384, 416, 448, 480, 512, 576, 640, 704, 768, 896, 1024, 1152, 1280, 1408, 1536, 1792, 2048, 2304, 2688, 3072, 3200, 3456, 4096, 4864, 5376, 6144, 6528, 6784, 6912, 8192, 9472, 9728, 10240, 10880, 12288, 13568, 14336, 16384, 18432, 19072, 20480, 21760, 24576, 27264, 28672, 32768}
+
+const (
+	maxSmallSize = 32768
+	pageSize     = 8192
+)
+
+// RuntimeAllocSize returns size of the memory block that mallocgc will allocate if you ask for the size.
+// The size is the one of a block without pointers.
+func RuntimeAllocSize(size int64) int64 {
+	if size <= 0 {
+		return size
+	}
+	if size <= maxSmallSize {
+		i := sort.Search(len(sizeClasses), func(i int) bool { return int64(sizeClasses[i]) >= size })
+		return int64(sizeClasses[i])
+	}
+	// Large objects are allocated in whole pages.
+	return (size + pageSize - 1) &^ (pageSize - 1)
+}
@@ -58,3 +58,15 @@ func StringClone(s string) string {
 	copy(b, s)
 	return *(*string)(unsafe.Pointer(&b))
 }
+
+// Equal reports whether a == b. Unlike ==, it returns false instead of
+// panicking when the values hold an uncomparable type, such as a slice or a
+// struct with a map in an interface field.
+func Equal(a, b interface{}) (equal bool) {
+	defer func() {
+		if recover() != nil {
+			equal = false
+		}
+	}()
+	return a == b
+}
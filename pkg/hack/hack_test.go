@@ -21,6 +21,7 @@ package hack
 // THE SOFTWARE.
 
 import (
+	"strconv"
 	"testing"
 )
 
@@ -40,3 +41,89 @@ func TestByteToString(t *testing.T) {
 		t.Errorf("String(\"\"): %q, want empty", s)
 	}
 }
+
+func TestRuntimeAllocSize(t *testing.T) {
+	for size, want := range map[int64]int64{
+		0:     0,
+		1:     8,
+		24:    24,
+		1024:  1024,
+		1025:  1152,
+		32768: 32768,
+		33000: 40960,
+	} {
+		if got := RuntimeAllocSize(size); got != want {
+			t.Errorf("RuntimeAllocSize(%d) = %d, want %d", size, got, want)
+		}
+	}
+}
+
+func TestStrhash(t *testing.T) {
+	seed1, seed2 := NewHashSeed(), NewHashSeed()
+	if Strhash("key", seed1) != Strhash("key", seed1) {
+		t.Errorf("Strhash is not deterministic")
+	}
+	if Strhash("key", seed1) == Strhash("key", seed2) {
+		t.Errorf("Strhash(\"key\") is the same with different seeds")
+	}
+	if Strhash("key", seed1) != Memhash([]byte("key"), seed1) {
+		t.Errorf("Strhash and Memhash differ")
+	}
+	if Strhash("key", HashSeed{}) != Strhash("key", HashSeed{}) {
+		t.Errorf("Strhash is not deterministic with the zero seed")
+	}
+	if RuntimeStrhash("key", 1) != RuntimeStrhash("key", 1) {
+		t.Errorf("RuntimeStrhash is not deterministic")
+	}
+	if RuntimeStrhash("key", 1) == RuntimeStrhash("key", 2) {
+		t.Errorf("RuntimeStrhash(\"key\") is the same with different seeds")
+	}
+}
+
+func TestStrhashCollisions(t *testing.T) {
+	// Similar keys must neither collide on the full hash, nor crowd the
+	// buckets picked by its low bits.
+	const keys, buckets = 100000, 256
+	seed := NewHashSeed()
+	hashes := make(map[uint64]string, keys)
+	var counts [buckets]int
+	for i := 0; i < keys; i++ {
+		key := "key" + strconv.Itoa(i)
+		h := Strhash(key, seed)
+		if other, ok := hashes[h]; ok {
+			t.Fatalf("%q and %q collide", key, other)
+		}
+		hashes[h] = key
+		counts[h%buckets]++
+	}
+	for b, n := range counts {
+		if n < keys/buckets/2 || n > keys/buckets*2 {
+			t.Errorf("bucket %d holds %d keys, want about %d", b, n, keys/buckets)
+		}
+	}
+}
+
+func BenchmarkStrhash(b *testing.B) {
+	seed := NewHashSeed()
+	key := "some/fairly/long/cache/key"
+	for i := 0; i < b.N; i++ {
+		Strhash(key, seed)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	if !Equal(1, 1) || Equal(1, 2) || Equal(1, "1") {
+		t.Errorf("Equal differs from == on comparable values")
+	}
+	if !Equal(nil, nil) || Equal(nil, 1) {
+		t.Errorf("Equal differs from == on nil")
+	}
+	b := []byte("v")
+	if Equal(b, b) {
+		t.Errorf("Equal matched a slice")
+	}
+	boxed := struct{ v interface{} }{map[string]int{}}
+	if Equal(boxed, boxed) {
+		t.Errorf("Equal matched a struct holding a map")
+	}
+}
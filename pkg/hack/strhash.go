@@ -0,0 +1,94 @@
+//go:build !runtimehash
+// +build !runtimehash
+
+package hack
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+)
+
+// The hash functions are implemented with hash/maphash, unless the package is
+// built with the runtimehash tag: then they call the hash functions of the Go
+// runtime directly, which is faster but relies on its internals.
+
+// processSeed seeds the hashes of RuntimeMemhash and RuntimeStrhash, and of the
+// zero HashSeed.
+var processSeed = maphash.MakeSeed()
+
+// HashSeed is a random seed for Strhash and Memhash. The zero value uses a seed
+// chosen once per process.
+type HashSeed struct {
+	seed maphash.Seed
+}
+
+// NewHashSeed returns a new random seed.
+func NewHashSeed() HashSeed {
+	return HashSeed{seed: maphash.MakeSeed()}
+}
+
+// maphashSeed returns the seed of the hashes, which is the process seed for the
+// zero HashSeed.
+func (s HashSeed) maphashSeed() maphash.Seed {
+	if s.seed == (maphash.Seed{}) {
+		return processSeed
+	}
+	return s.seed
+}
+
+// RuntimeMemhash hashes arbitrary bytes with the given seed. The hashes are only
+// stable within a process, like the hashes of the language's `map`.
+//
+// Without the runtimehash tag this is not the hash function of the runtime:
+// it seeds a maphash.Hash and writes the seed before the bytes on every call,
+// which makes it slower than Memhash.
+//
+// Deprecated: hash with Memhash and a HashSeed created once.
+func RuntimeMemhash(b []byte, seed uint64) uint64 {
+	var h maphash.Hash
+	withSeed(&h, seed)
+	h.Write(b)
+	return h.Sum64()
+}
+
+// RuntimeStrhash hashes a string with the given seed. The hashes are only stable
+// within a process, like the hashes of the language's `map`.
+//
+// Without the runtimehash tag this is not the hash function of the runtime,
+// and it is slower than Strhash for the same reason as RuntimeMemhash.
+//
+// Deprecated: hash with Strhash and a HashSeed created once.
+func RuntimeStrhash(str string, seed uint64) uint64 {
+	var h maphash.Hash
+	withSeed(&h, seed)
+	h.WriteString(str)
+	return h.Sum64()
+}
+
+// withSeed seeds a hash with the process seed, followed by the given seed.
+func withSeed(h *maphash.Hash, seed uint64) {
+	h.SetSeed(processSeed)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], seed)
+	h.Write(b[:])
+}
@@ -0,0 +1,38 @@
+//go:build go1.19 && !runtimehash
+// +build go1.19,!runtimehash
+
+package hack
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import "hash/maphash"
+
+// Strhash hashes a string with a random seed. Unlike a hash with a fixed seed,
+// it cannot be predicted to craft colliding keys. It is the
+// hash to use on hot paths, with a seed created once per cache.
+func Strhash(str string, seed HashSeed) uint64 {
+	return maphash.String(seed.maphashSeed(), str)
+}
+
+// Memhash hashes bytes with a random seed.
+func Memhash(b []byte, seed HashSeed) uint64 {
+	return maphash.Bytes(seed.maphashSeed(), b)
+}
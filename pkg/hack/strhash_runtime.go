@@ -0,0 +1,85 @@
+//go:build runtimehash
+// +build runtimehash
+
+package hack
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"reflect"
+	"unsafe"
+)
+
+//go:noescape
+//go:linkname memhash runtime.memhash
+func memhash(p unsafe.Pointer, h, s uintptr) uintptr
+
+//go:noescape
+//go:linkname strhash runtime.strhash
+func strhash(p unsafe.Pointer, h uintptr) uintptr
+
+// HashSeed is a random seed for Strhash and Memhash. The zero value is a valid,
+// but fixed, seed.
+type HashSeed struct {
+	seed uint64
+}
+
+// NewHashSeed returns a new random seed.
+func NewHashSeed() HashSeed {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return HashSeed{seed: binary.LittleEndian.Uint64(b[:])}
+}
+
+// Strhash hashes a string with a random seed, using the hash function of the
+// Go runtime.
+func Strhash(str string, seed HashSeed) uint64 {
+	return RuntimeStrhash(str, seed.seed)
+}
+
+// Memhash hashes bytes with a random seed, using the hash function of the Go
+// runtime.
+func Memhash(b []byte, seed HashSeed) uint64 {
+	return RuntimeMemhash(b, seed.seed)
+}
+
+// RuntimeMemhash provides access to the Go runtime's default hash function for arbitrary bytes.
+// This is an optimal hash function which takes an input seed and is potentially implemented in hardware
+// for most architectures. This is the same hash function that the language's `map` uses.
+//
+// Deprecated: hash with Memhash and a HashSeed, which use the same function.
+func RuntimeMemhash(b []byte, seed uint64) uint64 {
+	pstring := (*reflect.SliceHeader)(unsafe.Pointer(&b))
+	return uint64(memhash(unsafe.Pointer(pstring.Data), uintptr(seed), uintptr(pstring.Len)))
+}
+
+// RuntimeStrhash provides access to the Go runtime's default hash function for strings.
+// This is an optimal hash function which takes an input seed and is potentially implemented in hardware
+// for most architectures. This is the same hash function that the language's `map` uses.
+//
+// Deprecated: hash with Strhash and a HashSeed, which use the same function.
+func RuntimeStrhash(str string, seed uint64) uint64 {
+	return uint64(strhash(unsafe.Pointer(&str), uintptr(seed)))
+}
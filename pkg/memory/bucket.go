@@ -60,6 +60,13 @@ type Bucket struct {
 	page     *page              // inline page reference
 	rootNode *node              // materialized node for the root page.
 	nodes    map[pgid]*node     // node cache
+	parent   *Bucket            // enclosing bucket, nil for the root bucket
+	name     []byte             // key of the bucket in its parent
+	expiring uint8              // whether keys below the bucket have deadlines
+	keyBuf   []byte             // path of the last key looked up in the expiry index
+	pathLen  int                // length of the path of the bucket in keyBuf
+
+	indexesChecked bool // whether the recorded indexes were checked by writeIndexes
 
 	// Sets the threshold for filling nodes when they split. By default,
 	// the bucket will fill to 50% but it can be useful to increase this
@@ -107,6 +114,15 @@ func (b *Bucket) Writable() bool {
 // The cursor is only valid as long as the transaction is open.
 // Do not use a cursor after the transaction is closed.
 func (b *Bucket) Cursor() *Cursor {
+	c := b.rawCursor()
+	if b.isRoot() {
+		c.hidden = isHiddenBucket
+	}
+	return c
+}
+
+// rawCursor creates a cursor which also visits the hidden buckets of the root.
+func (b *Bucket) rawCursor() *Cursor {
 	// Update transaction statistics.
 	b.tx.stats.CursorCount++
 
@@ -117,10 +133,45 @@ func (b *Bucket) Cursor() *Cursor {
 	}
 }
 
+// isRoot returns whether the bucket is the root bucket of its transaction.
+func (b *Bucket) isRoot() bool {
+	return b == &b.tx.root
+}
+
+// hides returns whether the key is a hidden bucket of the root, which is
+// only used internally.
+func (b *Bucket) hides(key []byte) bool {
+	return b.isRoot() && isHiddenBucket(key)
+}
+
+// hiddenBuckets are the names of the root buckets used internally. They are
+// skipped by the root cursors and cannot be opened, created or deleted through
+// the exported API.
+var hiddenBuckets = [][]byte{expiryBucketName, indexesBucketName}
+
+// isHiddenBucket returns whether name is the name of a hidden root bucket.
+func isHiddenBucket(name []byte) bool {
+	for _, hidden := range hiddenBuckets {
+		if bytes.Equal(name, hidden) {
+			return true
+		}
+	}
+	return false
+}
+
 // Bucket retrieves a nested bucket by name.
 // Returns nil if the bucket does not exist.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) Bucket(name []byte) *Bucket {
+	if b.hides(name) {
+		return nil
+	}
+	return b.child(name)
+}
+
+// child retrieves a nested bucket by name, including the hidden buckets of
+// the root.
+func (b *Bucket) child(name []byte) *Bucket {
 	if b.buckets != nil {
 		if child := b.buckets[string(name)]; child != nil {
 			return child
@@ -128,7 +179,7 @@ func (b *Bucket) Bucket(name []byte) *Bucket {
 	}
 
 	// Move cursor to key.
-	c := b.Cursor()
+	c := b.rawCursor()
 	k, v, flags := c.seek(name)
 
 	// Return nil if the key doesn't exist or it is not a bucket.
@@ -138,6 +189,8 @@ func (b *Bucket) Bucket(name []byte) *Bucket {
 
 	// Otherwise create a bucket and cache it.
 	var child = b.openBucket(v)
+	child.parent = b
+	child.name = k
 	if b.buckets != nil {
 		b.buckets[string(name)] = child
 	}
@@ -179,6 +232,14 @@ func (b *Bucket) openBucket(value []byte) *Bucket {
 // Returns an error if the key already exists, if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
+	if b.hides(key) {
+		return nil, ErrBucketNameReserved
+	}
+	return b.createBucket(key)
+}
+
+// createBucket creates a nested bucket, including the hidden buckets of the root.
+func (b *Bucket) createBucket(key []byte) (*Bucket, error) {
 	if b.tx.db == nil {
 		return nil, ErrTxClosed
 	} else if !b.tx.writable {
@@ -216,7 +277,7 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 	// to be treated as a regular, non-inline bucket for the rest of the tx.
 	b.page = nil
 
-	return b.Bucket(key), nil
+	return b.child(key), nil
 }
 
 // CreateBucketIfNotExists creates a new bucket if it doesn't already exist and returns a reference to it.
@@ -235,6 +296,19 @@ func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
 // DeleteBucket deletes a bucket at the given key.
 // Returns an error if the bucket does not exists, or if the key represents a non-bucket value.
 func (b *Bucket) DeleteBucket(key []byte) error {
+	if b.hides(key) {
+		return ErrBucketNotFound
+	}
+	if err := b.deleteBucket(key); err != nil {
+		return err
+	}
+	b.recordChange(ChangeDeleteBucket, key, nil)
+	return nil
+}
+
+// deleteBucket deletes a bucket at the given key without recording it in the
+// change feed.
+func (b *Bucket) deleteBucket(key []byte) error {
 	if b.tx.db == nil {
 		return ErrTxClosed
 	} else if !b.Writable() {
@@ -252,17 +326,21 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 		return ErrIncompatibleValue
 	}
 
-	// Recursively delete all child buckets.
-	child := b.Bucket(key)
-	err := child.ForEach(func(k, v []byte) error {
-		if v == nil {
-			if err := child.DeleteBucket(k); err != nil {
-				return fmt.Errorf("delete bucket: %s", err)
-			}
+	// Recursively delete all child buckets. Nested buckets are told apart by
+	// their flags, since a value put in this transaction may also be nil.
+	child := b.child(key)
+	cc := child.rawCursor()
+	for k, _ := cc.First(); k != nil; k, _ = cc.Next() {
+		if _, _, flags := cc.keyValue(); (flags & bucketLeafFlag) == 0 {
+			continue
 		}
-		return nil
-	})
-	if err != nil {
+		if err := child.deleteBucket(k); err != nil {
+			return fmt.Errorf("delete bucket: %s", err)
+		}
+	}
+
+	// Drop the deadlines of keys stored below the bucket.
+	if err := b.clearDeadlines(key); err != nil {
 		return err
 	}
 
@@ -277,32 +355,74 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 	// Delete the node if we have a matching key.
 	c.node().del(key)
 
-	return nil
+	// Delete the index buckets of the bucket.
+	return b.deleteIndexBuckets(key)
 }
 
 // Get retrieves the value for a key in the bucket.
-// Returns a nil value if the key does not exist or if the key is a nested bucket.
+// Returns a nil value if the key does not exist, if the key is a nested bucket
+// or if the key was written with PutWithTTL and its TTL has passed.
 // The returned value is only valid for the life of the transaction.
 func (b *Bucket) Get(key []byte) []byte {
+	v, ok := b.get(key)
+	if !ok || b.expired(key) {
+		return nil
+	}
+	return v
+}
+
+// get retrieves the value for a key in the bucket regardless of its expiry,
+// and reports whether the key exists.
+func (b *Bucket) get(key []byte) ([]byte, bool) {
 	k, v, flags := b.Cursor().seek(key)
 
 	// Return nil if this is a bucket.
 	if (flags & bucketLeafFlag) != 0 {
-		return nil
+		return nil, false
 	}
 
 	// If our target node isn't the same key as what's passed in then return nil.
 	if !bytes.Equal(key, k) {
-		return nil
+		return nil, false
 	}
-	return v
+	return v, true
 }
 
 // Put sets the value for a key in the bucket.
-// If the key exist then its previous value will be overwritten.
+// If the key exist then its previous value will be overwritten, and any TTL
+// set by PutWithTTL is removed.
 // Supplied value must remain valid for the life of the transaction.
 // Returns an error if the bucket was created from a read-only transaction, if the key is blank, if the key is too large, or if the value is too large.
 func (b *Bucket) Put(key []byte, value []byte) error {
+	if err := b.store(key, value); err != nil {
+		return err
+	} else if err := b.clearDeadline(key); err != nil {
+		return err
+	}
+	b.recordChange(ChangePut, key, value)
+	return nil
+}
+
+// store sets the value for a key in the bucket and updates the secondary
+// indexes of the bucket, without touching the expiry of the key.
+func (b *Bucket) store(key []byte, value []byte) error {
+	indexes, err := b.writeIndexes()
+	if err != nil {
+		return err
+	}
+	var old []byte
+	var found bool
+	if len(indexes) > 0 {
+		old, found = b.get(key)
+	}
+	if err := b.put(key, value); err != nil {
+		return err
+	}
+	return b.updateIndexes(indexes, key, old, found, value, true)
+}
+
+// put sets the value for a key in the bucket without touching its expiry.
+func (b *Bucket) put(key []byte, value []byte) error {
 	if b.tx.db == nil {
 		return ErrTxClosed
 	} else if !b.Writable() {
@@ -335,6 +455,30 @@ func (b *Bucket) Put(key []byte, value []byte) error {
 // If the key does not exist then nothing is done and a nil error is returned.
 // Returns an error if the bucket was created from a read-only transaction.
 func (b *Bucket) Delete(key []byte) error {
+	indexes, err := b.writeIndexes()
+	if err != nil {
+		return err
+	}
+	var old []byte
+	var found bool
+	if len(indexes) > 0 || b.tx.recording {
+		old, found = b.get(key)
+	}
+	if err := b.delete(key); err != nil {
+		return err
+	} else if err := b.clearDeadline(key); err != nil {
+		return err
+	} else if err := b.updateIndexes(indexes, key, old, found, nil, false); err != nil {
+		return err
+	}
+	if found {
+		b.recordChange(ChangeDelete, key, nil)
+	}
+	return nil
+}
+
+// delete removes a key from the bucket without touching its expiry.
+func (b *Bucket) delete(key []byte) error {
 	if b.tx.db == nil {
 		return ErrTxClosed
 	} else if !b.Writable() {
@@ -405,7 +549,11 @@ func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
 	if b.tx.db == nil {
 		return ErrTxClosed
 	}
-	c := b.Cursor()
+	return forEach(b.Cursor(), fn)
+}
+
+// forEach executes a function for each key/value pair visited by a cursor.
+func forEach(c *Cursor, fn func(k, v []byte) error) error {
 	for k, v := c.First(); k != nil; k, v = c.Next() {
 		if err := fn(k, v); err != nil {
 			return err
@@ -55,7 +55,7 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 		// Create bucket on the root transaction if this is the first level.
 		nk := len(keys)
 		if nk == 0 {
-			bkt, err := tx.CreateBucket(k)
+			bkt, err := tx.root.createBucket(k)
 			if err != nil {
 				return err
 			}
@@ -66,7 +66,7 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 		}
 
 		// Create buckets on subsequent levels, if necessary.
-		b := tx.Bucket(keys[0])
+		b := tx.root.child(keys[0])
 		if nk > 1 {
 			for _, k := range keys[1:] {
 				b = b.Bucket(k)
@@ -88,8 +88,9 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 			return nil
 		}
 
-		// Otherwise treat it as a key/value pair.
-		return b.Put(k, v)
+		// Otherwise treat it as a key/value pair. It is copied as is, since
+		// its deadline and index entries are copied with the hidden buckets.
+		return b.put(k, v)
 	}); err != nil {
 		return err
 	}
@@ -103,10 +104,13 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 type walkFunc func(keys [][]byte, k, v []byte, seq uint64) error
 
 // walk walks recursively the Bhojpur Cache in-memory database db, calling walkFn
-// for each key it finds.
+// for each key it finds. Unlike Tx.ForEach it visits the hidden expiry bucket,
+// which sorts after every other bucket so that its deadlines are copied after
+// the keys they refer to.
 func walk(db *DB, walkFn walkFunc) error {
 	return db.View(func(tx *Tx) error {
-		return tx.ForEach(func(name []byte, b *Bucket) error {
+		return forEach(tx.root.rawCursor(), func(name, _ []byte) error {
+			b := tx.root.child(name)
 			return walkBucket(b, nil, name, nil, b.Sequence(), walkFn)
 		})
 	})
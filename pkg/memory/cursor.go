@@ -43,6 +43,7 @@ import (
 type Cursor struct {
 	bucket *Bucket
 	stack  []elemRef
+	hidden func(key []byte) bool // keys skipped by the exported methods
 }
 
 // Bucket returns the bucket that this cursor was created from.
@@ -54,6 +55,11 @@ func (c *Cursor) Bucket() *Bucket {
 // If the bucket is empty then a nil key and value are returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) First() (key []byte, value []byte) {
+	k, v := c.rawFirst()
+	return c.skip(k, v, c.rawNext)
+}
+
+func (c *Cursor) rawFirst() (key []byte, value []byte) {
 	_assert(c.bucket.tx.db != nil, "tx closed")
 	c.stack = c.stack[:0]
 	p, n := c.bucket.pageNode(c.bucket.root)
@@ -77,6 +83,11 @@ func (c *Cursor) First() (key []byte, value []byte) {
 // If the bucket is empty then a nil key and value are returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Last() (key []byte, value []byte) {
+	k, v := c.rawLast()
+	return c.skip(k, v, c.rawPrev)
+}
+
+func (c *Cursor) rawLast() (key []byte, value []byte) {
 	_assert(c.bucket.tx.db != nil, "tx closed")
 	c.stack = c.stack[:0]
 	p, n := c.bucket.pageNode(c.bucket.root)
@@ -95,6 +106,11 @@ func (c *Cursor) Last() (key []byte, value []byte) {
 // If the cursor is at the end of the bucket then a nil key and value are returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Next() (key []byte, value []byte) {
+	k, v := c.rawNext()
+	return c.skip(k, v, c.rawNext)
+}
+
+func (c *Cursor) rawNext() (key []byte, value []byte) {
 	_assert(c.bucket.tx.db != nil, "tx closed")
 	k, v, flags := c.next()
 	if (flags & uint32(bucketLeafFlag)) != 0 {
@@ -107,6 +123,11 @@ func (c *Cursor) Next() (key []byte, value []byte) {
 // If the cursor is at the beginning of the bucket then a nil key and value are returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Prev() (key []byte, value []byte) {
+	k, v := c.rawPrev()
+	return c.skip(k, v, c.rawPrev)
+}
+
+func (c *Cursor) rawPrev() (key []byte, value []byte) {
 	_assert(c.bucket.tx.db != nil, "tx closed")
 
 	// Attempt to move back one element until we're successful.
@@ -139,6 +160,11 @@ func (c *Cursor) Prev() (key []byte, value []byte) {
 // follow, a nil key is returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
+	k, v := c.rawSeek(seek)
+	return c.skip(k, v, c.rawNext)
+}
+
+func (c *Cursor) rawSeek(seek []byte) (key []byte, value []byte) {
 	k, v, flags := c.seek(seek)
 
 	// If we ended up after the last element of a page then move to the next one.
@@ -154,6 +180,14 @@ func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
 	return k, v
 }
 
+// skip moves the cursor with move past the hidden keys, starting at k.
+func (c *Cursor) skip(k, v []byte, move func() ([]byte, []byte)) ([]byte, []byte) {
+	for c.hidden != nil && k != nil && c.hidden(k) {
+		k, v = move()
+	}
+	return k, v
+}
+
 // Delete removes the current key/value under the cursor from the bucket.
 // Delete fails if current key/value is a bucket or if the transaction is not writable.
 func (c *Cursor) Delete() error {
@@ -163,13 +197,23 @@ func (c *Cursor) Delete() error {
 		return ErrTxNotWritable
 	}
 
-	key, _, flags := c.keyValue()
+	key, value, flags := c.keyValue()
 	// Return an error if current value is a bucket.
 	if (flags & bucketLeafFlag) != 0 {
 		return ErrIncompatibleValue
 	}
+	indexes, err := c.bucket.writeIndexes()
+	if err != nil {
+		return err
+	}
 	c.node().del(key)
 
+	if err := c.bucket.clearDeadline(key); err != nil {
+		return err
+	} else if err := c.bucket.updateIndexes(indexes, key, value, true, nil, false); err != nil {
+		return err
+	}
+	c.bucket.recordChange(ChangeDelete, key, nil)
 	return nil
 }
 
@@ -180,6 +180,15 @@ type DB struct {
 	// Read only mode.
 	// When true, Update() and Begin(true) return ErrDatabaseReadOnly immediately.
 	readOnly bool
+
+	reapStop chan struct{} // closed to stop the background reaper
+	reapDone chan struct{} // closed when the background reaper exits
+	reapOnce sync.Once
+
+	indexes   map[string][]*index // secondary indexes by bucket path
+	indexlock sync.RWMutex        // Protects indexes.
+
+	feed changeFeed
 }
 
 // Path returns the path to currently open database file.
@@ -325,6 +334,17 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		}
 	}
 
+	// Record changes for the change feed.
+	if options.ChangeRetention > 0 {
+		db.feed.retention = options.ChangeRetention
+		db.feed.enable(db.meta().txid)
+	}
+
+	// Start deleting expired keys in the background.
+	if options.ReapInterval > 0 {
+		db.startReaper(options.ReapInterval, options.ReapBatchSize, options.OnReap)
+	}
+
 	// Mark the database as opened and return.
 	return db, nil
 }
@@ -537,6 +557,8 @@ func (db *DB) init() error {
 // It will block waiting for any open transactions to finish
 // before closing the database and returning.
 func (db *DB) Close() error {
+	db.stopReaper()
+
 	db.rwlock.Lock()
 	defer db.rwlock.Unlock()
 
@@ -546,6 +568,8 @@ func (db *DB) Close() error {
 	db.mmaplock.Lock()
 	defer db.mmaplock.Unlock()
 
+	db.feed.close()
+
 	return db.close()
 }
 
@@ -1142,6 +1166,26 @@ type Options struct {
 	// It prevents potential page faults, however
 	// used memory can't be reclaimed. (UNIX only)
 	Mlock bool
+
+	// ReapInterval is how often a background goroutine deletes the keys
+	// whose TTL has passed. If <=0, expired keys are hidden from Get but
+	// only deleted by calls to DB.ReapExpired. Ignored in read-only mode.
+	ReapInterval time.Duration
+
+	// ReapBatchSize is the maximum number of expired keys the background
+	// reaper deletes in one write transaction, so that it never holds the
+	// writer lock for long. If <=0, DefaultReapBatchSize is used.
+	ReapBatchSize int
+
+	// OnReap is called after every background reaping pass that deleted
+	// keys or failed, with the number of keys reclaimed by the pass.
+	OnReap func(reclaimed int, err error)
+
+	// ChangeRetention is the number of committed transactions whose changes
+	// are kept for watchers resuming with WatchOptions.Since. If >0, changes
+	// are recorded from Open. Otherwise they are recorded from the first call
+	// to DB.Watch, and DefaultChangeRetention transactions are kept.
+	ChangeRetention int
 }
 
 // DefaultOptions represent the options used if nil options are passed into Open().
@@ -1164,6 +1208,9 @@ type Stats struct {
 	TxN     int // total number of started read transactions
 	OpenTxN int // number of currently open read transactions
 
+	// Expiry stats
+	ReapedKeyN int // total number of expired keys deleted
+
 	TxStats TxStats // global, ongoing stats.
 }
 
@@ -1180,6 +1227,7 @@ func (s *Stats) Sub(other *Stats) Stats {
 	diff.FreeAlloc = s.FreeAlloc
 	diff.FreelistInuse = s.FreelistInuse
 	diff.TxN = s.TxN - other.TxN
+	diff.ReapedKeyN = s.ReapedKeyN - other.ReapedKeyN
 	diff.TxStats = s.TxStats.Sub(&other.TxStats)
 	return diff
 }
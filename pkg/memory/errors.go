@@ -75,6 +75,10 @@ var (
 	// ErrBucketNameRequired is returned when creating a bucket with a blank name.
 	ErrBucketNameRequired = errors.New("in-memory bucket name required")
 
+	// ErrBucketNameReserved is returned when creating a root bucket with the
+	// name of a bucket used internally, such as the index of key deadlines.
+	ErrBucketNameReserved = errors.New("in-memory bucket name reserved")
+
 	// ErrKeyRequired is returned when inserting a zero-length key.
 	ErrKeyRequired = errors.New("key required")
 
@@ -89,3 +93,28 @@ var (
 	// non-bucket key on an existing bucket key.
 	ErrIncompatibleValue = errors.New("incompatible value")
 )
+
+// These errors can occur when declaring or using a secondary index.
+var (
+	// ErrIndexNameRequired is returned when declaring an index with a blank name.
+	ErrIndexNameRequired = errors.New("in-memory index name required")
+
+	// ErrIndexExists is returned when declaring an index that already exists,
+	// or when dropping an index that is still declared.
+	ErrIndexExists = errors.New("in-memory index already exists")
+
+	// ErrIndexNotFound is returned when using an index that has not been
+	// declared, or when dropping an index that does not exist.
+	ErrIndexNotFound = errors.New("in-memory index not found")
+
+	// ErrIndexNotDeclared is returned when writing to a bucket holding an
+	// index that was not declared since the database was opened.
+	ErrIndexNotDeclared = errors.New("in-memory index not declared")
+)
+
+// These errors can occur when watching changes.
+var (
+	// ErrChangesTruncated is returned when watching changes from a
+	// transaction that is older than the retained changes.
+	ErrChangesTruncated = errors.New("changes no longer retained")
+)
@@ -0,0 +1,53 @@
+package memory
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// DeadlineN returns the number of deadlines in the hidden expiry index of the
+// transaction, for the external tests to check that they are cleared.
+func DeadlineN(tx *Tx) int {
+	idx, _ := tx.expiryIndex(false)
+	if idx == nil {
+		return 0
+	}
+	return idx.keys.Stats().KeyN
+}
+
+// IndexEntryN returns the number of entries of the named index of the bucket
+// at path, or -1 if the index does not exist.
+func IndexEntryN(tx *Tx, path [][]byte, name []byte) int {
+	root, _ := tx.indexesBucket(false)
+	if root == nil {
+		return -1
+	}
+	var p []byte
+	for _, elem := range path {
+		p = appendPathElem(p, elem)
+	}
+	ibs := root.Bucket(p)
+	if ibs == nil {
+		return -1
+	}
+	ib := ibs.Bucket(name)
+	if ib == nil {
+		return -1
+	}
+	return ib.Stats().KeyN
+}
@@ -0,0 +1,364 @@
+package memory
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultChangeRetention is the number of committed transactions whose changes
+// are kept for resuming watchers unless Options.ChangeRetention is set.
+const DefaultChangeRetention = 1024
+
+// DefaultWatchBuffer is the number of change batches buffered for a watcher
+// unless WatchOptions.Buffer is set.
+const DefaultWatchBuffer = 64
+
+// ChangeOp is the kind of a change.
+type ChangeOp int
+
+const (
+	// ChangePut records a key set by Put or PutWithTTL.
+	ChangePut ChangeOp = iota + 1
+
+	// ChangeDelete records a key removed by Delete, Cursor.Delete or the
+	// expiry reaper.
+	ChangeDelete
+
+	// ChangeDeleteBucket records a nested bucket deleted with everything it
+	// holds.
+	ChangeDeleteBucket
+)
+
+// Change is a write to a key of a bucket.
+type Change struct {
+	Op       ChangeOp
+	Bucket   [][]byte  // names of the buckets from the root down to the bucket of the key
+	Key      []byte    // the key, or the name of the deleted bucket
+	Value    []byte    // the new value of a put key
+	Deadline time.Time // the expiry of a key put with a TTL, zero otherwise
+}
+
+// ChangeBatch holds the changes made by a committed transaction, in the order
+// in which they were made. Batches and their changes are shared between
+// watchers and must not be modified.
+type ChangeBatch struct {
+	TxID    int
+	Changes []Change
+}
+
+// recordChange appends a change to a key of the bucket to the changes of the
+// transaction, if they are recorded, and returns it.
+func (b *Bucket) recordChange(op ChangeOp, key, value []byte) *Change {
+	if !b.tx.recording {
+		return nil
+	}
+
+	var names [][]byte
+	for p := b; p.parent != nil; p = p.parent {
+		names = append(names, cloneBytes(p.name))
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+
+	c := Change{Op: op, Bucket: names, Key: cloneBytes(key)}
+	if op == ChangePut {
+		c.Value = cloneBytes(value)
+	}
+	b.tx.changes = append(b.tx.changes, c)
+	return &b.tx.changes[len(b.tx.changes)-1]
+}
+
+// changeFeed retains the changes of the latest committed transactions and
+// wakes up the watchers when new ones are published.
+type changeFeed struct {
+	mu        sync.Mutex
+	enabled   bool // also only changed with the writer lock held
+	closed    bool
+	retention int
+	batches   []*ChangeBatch // retained batches, by increasing txid
+	last      txid           // last published transaction
+	truncated txid           // last transaction whose changes were dropped
+	notify    chan struct{}  // closed when a batch is published
+}
+
+// enable starts retaining the changes of the transactions after the given one.
+func (f *changeFeed) enable(id txid) {
+	if f.retention <= 0 {
+		f.retention = DefaultChangeRetention
+	}
+	f.enabled = true
+	f.last, f.truncated = id, id
+	f.notify = make(chan struct{})
+}
+
+// publish retains the changes of a committed transaction, dropping the oldest
+// batch past the retention window, and wakes up the watchers.
+func (f *changeFeed) publish(id txid, changes []Change) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.last = id
+	if len(changes) == 0 || f.closed {
+		return
+	}
+	f.batches = append(f.batches, &ChangeBatch{TxID: int(id), Changes: changes})
+	if len(f.batches) > f.retention {
+		f.truncated = txid(f.batches[0].TxID)
+		f.batches[0] = nil
+		f.batches = f.batches[1:]
+	}
+	close(f.notify)
+	f.notify = make(chan struct{})
+}
+
+// since returns the retained batches after the given transaction and a
+// channel closed once more are published. Returns ErrChangesTruncated if
+// batches after the transaction were dropped, and ErrDatabaseNotOpen once the
+// database is closed and every batch was returned.
+func (f *changeFeed) since(id txid) ([]*ChangeBatch, <-chan struct{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id < f.truncated {
+		return nil, nil, ErrChangesTruncated
+	}
+	i := sort.Search(len(f.batches), func(i int) bool { return txid(f.batches[i].TxID) > id })
+	if f.closed && i == len(f.batches) {
+		return nil, nil, ErrDatabaseNotOpen
+	}
+	return f.batches[i:], f.notify, nil
+}
+
+// close stops the feed and wakes up the watchers.
+func (f *changeFeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return
+	}
+	f.closed = true
+	if f.notify != nil {
+		close(f.notify)
+	}
+}
+
+// WatchOptions selects the changes delivered to a watcher.
+type WatchOptions struct {
+	// Bucket is the path of the watched bucket, the names of the buckets
+	// from the root. Changes to its keys and to the buckets it holds are
+	// delivered, as well as the deletion of the bucket or of its parents.
+	// If empty, every change is delivered.
+	Bucket [][]byte
+
+	// Prefix restricts the changes to the keys of the watched bucket that
+	// start with it, and to the buckets it holds whose name starts with it.
+	Prefix []byte
+
+	// Since is the id of the last transaction seen by a resuming watcher.
+	// The retained changes of the transactions after it are delivered
+	// first. If <=0, only the changes committed after Watch are delivered.
+	Since int
+
+	// Buffer is the number of batches buffered in the channel of the
+	// watcher. If <=0, DefaultWatchBuffer is used.
+	Buffer int
+}
+
+// Watcher delivers the changes of committed transactions to a subscriber.
+//
+// Watchers never slow down writers. A watcher falling behind by more than
+// the retained transactions is stopped with ErrChangesTruncated, and can be
+// resumed from the last transaction it received if it is still retained.
+type Watcher struct {
+	// C receives a batch for every committed transaction with matching
+	// changes, in transaction order. It is closed when the watcher stops.
+	C <-chan ChangeBatch
+
+	c      chan ChangeBatch
+	feed   *changeFeed
+	opts   WatchOptions
+	last   txid
+	done   chan struct{}
+	exited chan struct{}
+	once   sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// Watch subscribes to the changes of the transactions committed to the
+// database. Changes are recorded from the first call to Watch, unless
+// Options.ChangeRetention is set, so Watch must not be called from within
+// a write transaction.
+//
+// Returns ErrChangesTruncated if opts.Since is older than the retained
+// changes.
+func (db *DB) Watch(opts WatchOptions) (*Watcher, error) {
+	f := &db.feed
+
+	// Start recording changes with no write transaction in progress, so that
+	// every transaction after the current one is recorded.
+	f.mu.Lock()
+	enabled := f.enabled
+	f.mu.Unlock()
+	if !enabled {
+		db.rwlock.Lock()
+		f.mu.Lock()
+		if !f.enabled && !f.closed {
+			f.enable(db.meta().txid)
+		}
+		f.mu.Unlock()
+		db.rwlock.Unlock()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return nil, ErrDatabaseNotOpen
+	}
+
+	last := f.last
+	if opts.Since > 0 {
+		if last = txid(opts.Since); last < f.truncated {
+			return nil, ErrChangesTruncated
+		}
+	}
+	if opts.Buffer <= 0 {
+		opts.Buffer = DefaultWatchBuffer
+	}
+
+	c := make(chan ChangeBatch, opts.Buffer)
+	w := &Watcher{
+		C:      c,
+		c:      c,
+		feed:   f,
+		opts:   opts,
+		last:   last,
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Close stops the watcher and closes its channel.
+func (w *Watcher) Close() {
+	w.once.Do(func() {
+		close(w.done)
+	})
+	<-w.exited
+}
+
+// Err returns the reason why the channel of the watcher was closed:
+// ErrChangesTruncated if it fell behind, ErrDatabaseNotOpen if the database
+// was closed, or nil if the watcher was closed.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+func (w *Watcher) run() {
+	defer close(w.exited)
+	defer close(w.c)
+
+	for {
+		batches, notify, err := w.feed.since(w.last)
+		if err != nil {
+			w.mu.Lock()
+			w.err = err
+			w.mu.Unlock()
+			return
+		}
+
+		for _, b := range batches {
+			w.last = txid(b.TxID)
+			batch, ok := w.match(b)
+			if !ok {
+				continue
+			}
+			select {
+			case w.c <- batch:
+			case <-w.done:
+				return
+			}
+		}
+
+		select {
+		case <-notify:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// match returns the changes of a batch selected by the watcher options.
+func (w *Watcher) match(b *ChangeBatch) (ChangeBatch, bool) {
+	if len(w.opts.Bucket) == 0 && len(w.opts.Prefix) == 0 {
+		return *b, true
+	}
+	var changes []Change
+	for i := range b.Changes {
+		if w.matches(&b.Changes[i]) {
+			changes = append(changes, b.Changes[i])
+		}
+	}
+	return ChangeBatch{TxID: b.TxID, Changes: changes}, len(changes) > 0
+}
+
+// matches returns true if the change is selected by the watcher options.
+func (w *Watcher) matches(c *Change) bool {
+	// The path of the changed key, with the key as the last element.
+	elem := func(i int) []byte {
+		if i < len(c.Bucket) {
+			return c.Bucket[i]
+		}
+		return c.Key
+	}
+	n, depth := len(c.Bucket)+1, len(w.opts.Bucket)
+
+	// A change above the watched bucket is only delivered if it deletes the
+	// bucket or one of its parents.
+	if n <= depth {
+		if c.Op != ChangeDeleteBucket {
+			return false
+		}
+		for i := 0; i < n; i++ {
+			if !bytes.Equal(elem(i), w.opts.Bucket[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for i := 0; i < depth; i++ {
+		if !bytes.Equal(elem(i), w.opts.Bucket[i]) {
+			return false
+		}
+	}
+	return bytes.HasPrefix(elem(depth), w.opts.Prefix)
+}
@@ -0,0 +1,328 @@
+package memory_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	memcache "github.com/bhojpur/cache/pkg/memory"
+)
+
+// formatBatch formats the changes of a batch as op:bucket/key=value.
+func formatBatch(b memcache.ChangeBatch) string {
+	ops := map[memcache.ChangeOp]string{
+		memcache.ChangePut:          "put",
+		memcache.ChangeDelete:       "del",
+		memcache.ChangeDeleteBucket: "delbucket",
+	}
+	var changes []string
+	for _, c := range b.Changes {
+		var path []string
+		for _, name := range c.Bucket {
+			path = append(path, string(name))
+		}
+		s := fmt.Sprintf("%s:%s/%s", ops[c.Op], strings.Join(path, "/"), c.Key)
+		if c.Op == memcache.ChangePut {
+			s += "=" + string(c.Value)
+		}
+		changes = append(changes, s)
+	}
+	return strings.Join(changes, " ")
+}
+
+// receive returns the next batch of a watcher.
+func receive(t *testing.T, w *memcache.Watcher) memcache.ChangeBatch {
+	select {
+	case b, ok := <-w.C:
+		if !ok {
+			t.Fatalf("watcher closed: %v", w.Err())
+		}
+		return b
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for changes")
+	}
+	return memcache.ChangeBatch{}
+}
+
+// mustUpdate runs a write transaction and returns its id.
+func mustUpdate(t *testing.T, db *DB, fn func(tx *memcache.Tx) error) int {
+	var id int
+	if err := db.Update(func(tx *memcache.Tx) error {
+		id = tx.ID()
+		return fn(tx)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// Ensure that a watcher receives the changes of every committed transaction
+// in order.
+func TestDB_Watch(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	w, err := db.Watch(memcache.WatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	id1 := mustUpdate(t, db, func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("foo"), []byte("1")); err != nil {
+			return err
+		}
+		if err := b.PutWithTTL([]byte("bar"), []byte("2"), time.Hour); err != nil {
+			return err
+		}
+		return b.Delete([]byte("missing"))
+	})
+
+	// Rolled back and empty transactions are not delivered.
+	if err := db.Update(func(tx *memcache.Tx) error {
+		if err := tx.Bucket([]byte("widgets")).Put([]byte("baz"), []byte("3")); err != nil {
+			return err
+		}
+		return errors.New("rollback")
+	}); err == nil {
+		t.Fatal("expected error")
+	}
+	mustUpdate(t, db, func(tx *memcache.Tx) error { return nil })
+
+	id2 := mustUpdate(t, db, func(tx *memcache.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.Delete([]byte("foo")); err != nil {
+			return err
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			return err
+		}
+		if err := child.Put([]byte("x"), []byte("4")); err != nil {
+			return err
+		}
+		return b.DeleteBucket([]byte("child"))
+	})
+
+	b := receive(t, w)
+	if b.TxID != id1 || formatBatch(b) != "put:widgets/foo=1 put:widgets/bar=2" {
+		t.Fatalf("unexpected batch %d: %s", b.TxID, formatBatch(b))
+	}
+	if b.Changes[0].Deadline.IsZero() == false || time.Until(b.Changes[1].Deadline) < 59*time.Minute {
+		t.Fatalf("unexpected deadlines: %v, %v", b.Changes[0].Deadline, b.Changes[1].Deadline)
+	}
+	b = receive(t, w)
+	if b.TxID != id2 || formatBatch(b) != "del:widgets/foo put:widgets/child/x=4 delbucket:widgets/child" {
+		t.Fatalf("unexpected batch %d: %s", b.TxID, formatBatch(b))
+	}
+
+	w.Close()
+	if _, ok := <-w.C; ok {
+		t.Fatal("expected closed channel")
+	} else if w.Err() != nil {
+		t.Fatalf("unexpected error: %v", w.Err())
+	}
+}
+
+// Ensure that a watcher only receives the changes of its bucket and prefix.
+func TestDB_Watch_Filter(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	w, err := db.Watch(memcache.WatchOptions{
+		Bucket: [][]byte{[]byte("widgets"), []byte("parts")},
+		Prefix: []byte("a"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	mustUpdate(t, db, func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("a"), []byte("outside")); err != nil {
+			return err
+		}
+		parts, err := b.CreateBucket([]byte("parts"))
+		if err != nil {
+			return err
+		}
+		for _, k := range []string{"a1", "b1", "a2"} {
+			if err := parts.Put([]byte(k), []byte(k)); err != nil {
+				return err
+			}
+		}
+		for _, name := range []string{"ab", "bc"} {
+			child, err := parts.CreateBucket([]byte(name))
+			if err != nil {
+				return err
+			}
+			if err := child.Put([]byte("x"), []byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	mustUpdate(t, db, func(tx *memcache.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("b"), []byte("outside"))
+	})
+	id := mustUpdate(t, db, func(tx *memcache.Tx) error {
+		return tx.DeleteBucket([]byte("widgets"))
+	})
+
+	if b := receive(t, w); formatBatch(b) != "put:widgets/parts/a1=a1 put:widgets/parts/a2=a2 put:widgets/parts/ab/x=ab" {
+		t.Fatalf("unexpected batch: %s", formatBatch(b))
+	}
+	if b := receive(t, w); b.TxID != id || formatBatch(b) != "delbucket:/widgets" {
+		t.Fatalf("unexpected batch %d: %s", b.TxID, formatBatch(b))
+	}
+}
+
+// Ensure that a watcher can resume from a retained transaction.
+func TestDB_Watch_Since(t *testing.T) {
+	db := MustOpenWithOption(&memcache.Options{ChangeRetention: 3})
+	defer db.MustClose()
+
+	put := func(k string) int {
+		return mustUpdate(t, db, func(tx *memcache.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(k), []byte(k))
+		})
+	}
+	ids := []int{put("a"), put("b"), put("c"), put("d")}
+
+	// The first transaction is no longer retained.
+	if _, err := db.Watch(memcache.WatchOptions{Since: ids[0] - 1}); err != memcache.ErrChangesTruncated {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	w, err := db.Watch(memcache.WatchOptions{Since: ids[1]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	id := put("e")
+	for _, want := range []string{"c", "d", "e"} {
+		if b := receive(t, w); formatBatch(b) != "put:widgets/"+want+"="+want {
+			t.Fatalf("unexpected batch: %s", formatBatch(b))
+		}
+	}
+	if id != ids[3]+1 {
+		t.Fatalf("unexpected txid: %d", id)
+	}
+}
+
+// Ensure that a watcher falling behind the retained transactions is stopped,
+// and that closing the database stops the watchers.
+func TestDB_Watch_Lagging(t *testing.T) {
+	db := MustOpenWithOption(&memcache.Options{ChangeRetention: 2})
+	defer db.MustClose()
+
+	w, err := db.Watch(memcache.WatchOptions{Buffer: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 0; i < 10; i++ {
+		mustUpdate(t, db, func(tx *memcache.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(fmt.Sprint(i)), nil)
+		})
+	}
+
+	var n int
+	for range w.C {
+		n++
+	}
+	if n >= 10 {
+		t.Fatalf("unexpected batch count: %d", n)
+	} else if w.Err() != memcache.ErrChangesTruncated {
+		t.Fatalf("unexpected error: %v", w.Err())
+	}
+
+	w2, err := db.Watch(memcache.WatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range w2.C {
+	}
+	if w2.Err() != memcache.ErrDatabaseNotOpen {
+		t.Fatalf("unexpected error: %v", w2.Err())
+	}
+	if _, err := db.Watch(memcache.WatchOptions{}); err != memcache.ErrDatabaseNotOpen {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that the writes to the hidden index buckets are not delivered.
+func TestDB_Watch_Index(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.DeclareIndex([][]byte{[]byte("widgets")}, []byte("color"), func(_, v []byte) [][]byte {
+		return [][]byte{v}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	w, err := db.Watch(memcache.WatchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	id := mustUpdate(t, db, func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("foo"), []byte("red")); err != nil {
+			return err
+		} else if err := b.RebuildIndex([]byte("color")); err != nil {
+			return err
+		}
+		return tx.DeleteBucket([]byte("widgets"))
+	})
+
+	b := receive(t, w)
+	if b.TxID != id || formatBatch(b) != "put:widgets/foo=red delbucket:/widgets" {
+		t.Fatalf("unexpected batch %d: %s", b.TxID, formatBatch(b))
+	}
+}
@@ -0,0 +1,507 @@
+package memory
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// IndexFunc returns the keys under which a key/value pair of a bucket is
+// found in a secondary index. It may return no keys, and must always return
+// the same keys for the same pair.
+type IndexFunc func(k, v []byte) [][]byte
+
+// index is a secondary index declared on a bucket.
+type index struct {
+	name []byte
+	fn   IndexFunc
+}
+
+// The entries of the indexes live in a hidden root bucket, which holds a
+// bucket per indexed bucket keyed by the path of the indexed bucket, which in
+// turn holds a bucket per index keyed by the name of the index. The index
+// buckets also record which indexes were declared on the bucket, so that a
+// write to a bucket fails rather than leave an index stale if the database is
+// reopened without declaring it again.
+//
+// Each entry key is the escaped index key, where every 0x00 byte is followed
+// by 0xff, terminated by 0x00 0x00 and followed by the primary key. The
+// escaping keeps the entries sorted by index key, with the entries of equal
+// index keys sorted by primary key.
+var indexesBucketName = []byte("\xffindex")
+
+// indexEntryValue is the value of every index entry. It is empty but not nil,
+// so that the entries written in a transaction are not mistaken for nested
+// buckets.
+var indexEntryValue = []byte{}
+
+// indexEntry returns the key of the index entry of a primary key.
+func indexEntry(ikey, key []byte) []byte {
+	entry := make([]byte, 0, len(ikey)+2+len(key)+bytes.Count(ikey, []byte{0}))
+	entry = appendIndexKey(entry, ikey)
+	entry = append(entry, 0, 0)
+	return append(entry, key...)
+}
+
+// appendIndexKey appends the escaped index key to b.
+func appendIndexKey(b, ikey []byte) []byte {
+	for _, c := range ikey {
+		b = append(b, c)
+		if c == 0 {
+			b = append(b, 0xff)
+		}
+	}
+	return b
+}
+
+// splitIndexEntry returns the index key and the primary key of an entry.
+func splitIndexEntry(entry []byte) (ikey, key []byte, ok bool) {
+	escaped := false
+	for i := 0; i+1 < len(entry); i++ {
+		if entry[i] != 0 {
+			continue
+		} else if entry[i+1] == 0 {
+			ikey = entry[:i]
+			if escaped {
+				ikey = bytes.ReplaceAll(ikey, []byte{0, 0xff}, []byte{0})
+			}
+			return ikey, entry[i+2:], true
+		}
+		escaped = true
+		i++
+	}
+	return nil, nil, false
+}
+
+// DeclareIndex declares a secondary index with the given name on the bucket
+// at path, the names of the buckets from the root. From then on every Put and
+// Delete on the bucket stores the index keys returned by fn for the pair in a
+// hidden index bucket, within the same transaction.
+//
+// The index functions cannot be saved, so indexes must be declared again every
+// time the database is opened, before the bucket is written to. The first
+// write to the bucket records the index in the database, and writes to a
+// bucket with a recorded index that is not declared fail with
+// ErrIndexNotDeclared until it is declared or dropped with Bucket.DropIndex.
+// Use Bucket.RebuildIndex to backfill an index declared on a bucket holding
+// data.
+func (db *DB) DeclareIndex(path [][]byte, name []byte, fn IndexFunc) error {
+	if len(path) == 0 {
+		return ErrBucketNameRequired
+	} else if isHiddenBucket(path[0]) {
+		return ErrBucketNameReserved
+	} else if len(name) == 0 {
+		return ErrIndexNameRequired
+	}
+
+	var p []byte
+	for _, elem := range path {
+		p = appendPathElem(p, elem)
+	}
+
+	db.indexlock.Lock()
+	defer db.indexlock.Unlock()
+	for _, idx := range db.indexes[string(p)] {
+		if bytes.Equal(idx.name, name) {
+			return ErrIndexExists
+		}
+	}
+	if db.indexes == nil {
+		db.indexes = make(map[string][]*index)
+	}
+	db.indexes[string(p)] = append(db.indexes[string(p)], &index{name: cloneBytes(name), fn: fn})
+	return nil
+}
+
+// indexesAt returns the indexes declared on the bucket at path.
+func (db *DB) indexesAt(path []byte) []*index {
+	db.indexlock.RLock()
+	defer db.indexlock.RUnlock()
+	return db.indexes[string(path)]
+}
+
+// hasIndexes returns true if any index is declared.
+func (db *DB) hasIndexes() bool {
+	db.indexlock.RLock()
+	defer db.indexlock.RUnlock()
+	return len(db.indexes) > 0
+}
+
+// indexes returns the indexes declared on the bucket.
+func (b *Bucket) indexes() []*index {
+	if b.parent == nil || b.tx.db == nil || !b.tx.db.hasIndexes() {
+		return nil
+	}
+	return b.tx.db.indexesAt(b.path(0))
+}
+
+// index returns the index of the bucket with the given name, or nil.
+func (b *Bucket) index(name []byte) *index {
+	for _, idx := range b.indexes() {
+		if bytes.Equal(idx.name, name) {
+			return idx
+		}
+	}
+	return nil
+}
+
+// writeIndexes returns the indexes declared on the bucket before it is
+// written to. The first time it is called on a bucket of a writable
+// transaction it records the declared indexes in the database, and returns
+// ErrIndexNotDeclared if an index recorded earlier is not declared anymore.
+func (b *Bucket) writeIndexes() ([]*index, error) {
+	indexes := b.indexes()
+	if b.indexesChecked || b.parent == nil || b.tx.db == nil || !b.tx.writable {
+		return indexes, nil
+	}
+
+	ibs, err := b.indexBuckets(len(indexes) > 0)
+	if err != nil {
+		return nil, err
+	} else if ibs != nil {
+		if err := ibs.ForEach(func(name, _ []byte) error {
+			if b.index(name) == nil {
+				return ErrIndexNotDeclared
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		for _, idx := range indexes {
+			if _, err := ibs.CreateBucketIfNotExists(idx.name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	b.indexesChecked = true
+	return indexes, nil
+}
+
+// updateIndexes replaces the index entries of the old value of a key, if it
+// was found, with those of its new value, if it has one.
+func (b *Bucket) updateIndexes(indexes []*index, key, old []byte, found bool, value []byte, put bool) error {
+	for _, idx := range indexes {
+		var oldKeys, newKeys [][]byte
+		if found {
+			oldKeys = idx.fn(key, old)
+		}
+		if put {
+			newKeys = idx.fn(key, value)
+		}
+		if len(oldKeys) == 0 && len(newKeys) == 0 {
+			continue
+		}
+
+		ib, err := b.indexBucket(idx, len(newKeys) > 0)
+		if err != nil {
+			return err
+		} else if ib == nil {
+			continue
+		}
+		for _, ikey := range oldKeys {
+			if len(ikey) == 0 {
+				continue
+			}
+			if err := ib.delete(indexEntry(ikey, key)); err != nil {
+				return err
+			}
+		}
+		for _, ikey := range newKeys {
+			if len(ikey) == 0 {
+				continue
+			}
+			if err := ib.put(indexEntry(ikey, key), indexEntryValue); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// indexesBucket returns the hidden root bucket holding the indexes, creating
+// it if create is true. Returns nil if the bucket does not exist.
+func (tx *Tx) indexesBucket(create bool) (*Bucket, error) {
+	if b := tx.root.child(indexesBucketName); b != nil || !create {
+		return b, nil
+	}
+	return tx.root.createBucket(indexesBucketName)
+}
+
+// indexBuckets returns the bucket holding the indexes of the bucket, creating
+// it if create is true. Returns nil if the bucket does not exist.
+func (b *Bucket) indexBuckets(create bool) (*Bucket, error) {
+	root, err := b.tx.indexesBucket(create)
+	if root == nil || err != nil {
+		return nil, err
+	}
+	if create {
+		return root.CreateBucketIfNotExists(b.path(0))
+	}
+	return root.Bucket(b.path(0)), nil
+}
+
+// indexBucket returns the bucket holding an index of the bucket, creating it
+// if create is true. Returns nil if the bucket does not exist.
+func (b *Bucket) indexBucket(idx *index, create bool) (*Bucket, error) {
+	ibs, err := b.indexBuckets(create)
+	if ibs == nil || err != nil {
+		return nil, err
+	}
+	if create {
+		return ibs.CreateBucketIfNotExists(idx.name)
+	}
+	return ibs.Bucket(idx.name), nil
+}
+
+// deleteIndexBuckets deletes the indexes of the nested bucket with the given
+// name and of the buckets below it.
+func (b *Bucket) deleteIndexBuckets(name []byte) error {
+	root, err := b.tx.indexesBucket(false)
+	if root == nil || err != nil {
+		return err
+	}
+
+	var paths [][]byte
+	prefix := b.keyPath(name)
+	c := root.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		paths = append(paths, cloneBytes(k))
+	}
+	for _, path := range paths {
+		if err := root.deleteBucket(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropIndex deletes the entries of the named index of the bucket, along with
+// the record of its declaration, so that the bucket can be written to again
+// after the index is no longer declared. Returns ErrIndexNotFound if the index
+// does not exist, and ErrIndexExists if it is still declared.
+func (b *Bucket) DropIndex(name []byte) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if b.index(name) != nil {
+		return ErrIndexExists
+	}
+
+	ibs, err := b.indexBuckets(false)
+	if err != nil {
+		return err
+	} else if ibs == nil || ibs.Bucket(name) == nil {
+		return ErrIndexNotFound
+	}
+	return ibs.deleteBucket(name)
+}
+
+// RebuildIndex recomputes every entry of the named index of the bucket from
+// the keys it holds, which backfills an index declared on a bucket that
+// already holds data. Returns ErrIndexNotFound if the index is not declared.
+func (b *Bucket) RebuildIndex(name []byte) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	}
+	idx := b.index(name)
+	if idx == nil {
+		return ErrIndexNotFound
+	}
+
+	// Drop the current entries.
+	ibs, err := b.indexBuckets(true)
+	if err != nil {
+		return err
+	}
+	if ibs.Bucket(idx.name) != nil {
+		if err := ibs.deleteBucket(idx.name); err != nil {
+			return err
+		}
+	}
+	ib, err := ibs.CreateBucket(idx.name)
+	if err != nil {
+		return err
+	}
+
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if _, _, flags := c.keyValue(); (flags & bucketLeafFlag) != 0 {
+			continue
+		}
+		for _, ikey := range idx.fn(k, v) {
+			if len(ikey) == 0 {
+				continue
+			}
+			if err := ib.put(indexEntry(ikey, k), indexEntryValue); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// IndexCursor iterates over the records of a bucket in the order of one of
+// its secondary indexes. A record is yielded once for every index key it has.
+type IndexCursor struct {
+	bucket *Bucket
+	cursor *Cursor // cursor over the index bucket, nil if it does not exist
+	key    []byte  // index key of the current record
+}
+
+// IndexCursor creates a cursor over the bucket ordered by the named index.
+// Returns nil if the index is not declared.
+// The cursor is only valid as long as the transaction is open.
+func (b *Bucket) IndexCursor(name []byte) *IndexCursor {
+	idx := b.index(name)
+	if idx == nil {
+		return nil
+	}
+	c := &IndexCursor{bucket: b}
+	if ib, _ := b.indexBucket(idx, false); ib != nil {
+		c.cursor = ib.Cursor()
+	}
+	return c
+}
+
+// Bucket returns the indexed bucket that this cursor was created from.
+func (c *IndexCursor) Bucket() *Bucket {
+	return c.bucket
+}
+
+// Key returns the index key of the current record.
+func (c *IndexCursor) Key() []byte {
+	return c.key
+}
+
+// First moves the cursor to the record with the lowest index key and returns
+// its primary key and value. If the index is empty then a nil key and value
+// are returned.
+func (c *IndexCursor) First() (key []byte, value []byte) {
+	if c.cursor == nil {
+		return nil, nil
+	}
+	return c.load(c.cursor.First())
+}
+
+// Next moves the cursor to the next record in index order and returns its
+// primary key and value. If the cursor is at the end of the index then a nil
+// key and value are returned.
+func (c *IndexCursor) Next() (key []byte, value []byte) {
+	if c.cursor == nil {
+		return nil, nil
+	}
+	return c.load(c.cursor.Next())
+}
+
+// Seek moves the cursor to the first record whose index key is equal to or
+// greater than ikey, and returns its primary key and value. If no such record
+// exists then a nil key and value are returned.
+func (c *IndexCursor) Seek(ikey []byte) (key []byte, value []byte) {
+	if c.cursor == nil {
+		return nil, nil
+	}
+	return c.load(c.cursor.Seek(appendIndexKey(nil, ikey)))
+}
+
+// load returns the record of the entry the cursor is on, skipping the entries
+// whose record does not exist or has expired.
+func (c *IndexCursor) load(entry, _ []byte) ([]byte, []byte) {
+	for ; entry != nil; entry, _ = c.cursor.Next() {
+		ikey, key, ok := splitIndexEntry(entry)
+		if !ok {
+			continue
+		}
+		if v, ok := c.bucket.get(key); ok && !c.bucket.expired(key) {
+			c.key = ikey
+			return key, v
+		}
+	}
+	c.key = nil
+	return nil, nil
+}
+
+// checkIndexes verifies that the index buckets hold exactly the entries of the
+// records of the buckets they index.
+func (tx *Tx) checkIndexes(ch chan error) {
+	tx.db.indexlock.RLock()
+	paths := make([]string, 0, len(tx.db.indexes))
+	indexes := make(map[string][]*index, len(tx.db.indexes))
+	for path, list := range tx.db.indexes {
+		paths = append(paths, path)
+		indexes[path] = list
+	}
+	tx.db.indexlock.RUnlock()
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		names, ok := splitPath([]byte(path))
+		if !ok {
+			continue
+		}
+		b := tx.bucketAt(names)
+		if b == nil {
+			continue
+		}
+		for _, idx := range indexes[path] {
+			tx.checkIndex(b, idx, ch)
+		}
+	}
+}
+
+func (tx *Tx) checkIndex(b *Bucket, idx *index, ch chan error) {
+	// Compute the entries of every record.
+	want := make(map[string]bool)
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if _, _, flags := c.keyValue(); (flags & bucketLeafFlag) != 0 {
+			continue
+		}
+		for _, ikey := range idx.fn(k, v) {
+			if len(ikey) > 0 {
+				want[string(indexEntry(ikey, k))] = true
+			}
+		}
+	}
+
+	if ib, _ := b.indexBucket(idx, false); ib != nil {
+		_ = ib.ForEach(func(k, _ []byte) error {
+			if !want[string(k)] {
+				ch <- fmt.Errorf("index %q of bucket %q: dangling entry %q", idx.name, b.name, k)
+			}
+			delete(want, string(k))
+			return nil
+		})
+	}
+
+	missing := make([]string, 0, len(want))
+	for k := range want {
+		missing = append(missing, k)
+	}
+	sort.Strings(missing)
+	for _, k := range missing {
+		ch <- fmt.Errorf("index %q of bucket %q: missing entry %q", idx.name, b.name, k)
+	}
+}
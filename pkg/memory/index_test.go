@@ -0,0 +1,443 @@
+package memory_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	memcache "github.com/bhojpur/cache/pkg/memory"
+)
+
+// colors indexes a comma separated list of colors.
+func colors(_, v []byte) [][]byte {
+	return bytes.Split(v, []byte(","))
+}
+
+// scanIndex returns the primary keys of the records with the given index key.
+func scanIndex(c *memcache.IndexCursor, ikey string) []string {
+	var keys []string
+	for k, _ := c.Seek([]byte(ikey)); k != nil && string(c.Key()) == ikey; k, _ = c.Next() {
+		keys = append(keys, string(k))
+	}
+	return keys
+}
+
+// checkErrors returns the errors reported by Tx.Check.
+func checkErrors(db *DB) []string {
+	var errs []string
+	if err := db.View(func(tx *memcache.Tx) error {
+		for err := range tx.Check() {
+			errs = append(errs, err.Error())
+		}
+		return nil
+	}); err != nil {
+		panic(err)
+	}
+	return errs
+}
+
+// Ensure that Put and Delete maintain the index entries of a bucket.
+func TestBucket_Index(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.DeclareIndex([][]byte{[]byte("widgets")}, []byte("color"), colors); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range map[string]string{"foo": "red", "bar": "red,blue", "baz": "green", "bat": "blue"} {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Put([]byte("baz"), []byte("red")); err != nil {
+			t.Fatal(err)
+		}
+		return b.Delete([]byte("bat"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *memcache.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		c := b.IndexCursor([]byte("color"))
+		if keys := scanIndex(c, "red"); fmt.Sprint(keys) != "[bar baz foo]" {
+			t.Fatalf("unexpected red keys: %v", keys)
+		}
+		if keys := scanIndex(c, "blue"); fmt.Sprint(keys) != "[bar]" {
+			t.Fatalf("unexpected blue keys: %v", keys)
+		}
+		if keys := scanIndex(c, "green"); len(keys) != 0 {
+			t.Fatalf("unexpected green keys: %v", keys)
+		}
+
+		// The cursor yields primary records in index order.
+		var records []string
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			records = append(records, fmt.Sprintf("%s:%s=%s", c.Key(), k, v))
+		}
+		if s := strings.Join(records, " "); s != "blue:bar=red,blue red:bar=red,blue red:baz=red red:foo=red" {
+			t.Fatalf("unexpected records: %s", s)
+		}
+
+		if c := b.IndexCursor([]byte("size")); c != nil {
+			t.Fatal("expected nil cursor for an undeclared index")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if errs := checkErrors(db); len(errs) != 0 {
+		t.Fatalf("unexpected check errors: %v", errs)
+	}
+}
+
+// Ensure that index keys are ordered bytewise, including keys holding zero
+// bytes and keys that are prefixes of others.
+func TestBucket_Index_Order(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.DeclareIndex([][]byte{[]byte("widgets")}, []byte("value"), func(_, v []byte) [][]byte {
+		return [][]byte{v}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range map[string]string{"1": "ab", "2": "a\x00b", "3": "a", "4": "a\x00", "5": "b"} {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *memcache.Tx) error {
+		c := tx.Bucket([]byte("widgets")).IndexCursor([]byte("value"))
+		var keys []string
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		if fmt.Sprint(keys) != "[3 4 2 1 5]" {
+			t.Fatalf("unexpected order: %v", keys)
+		}
+		if k, _ := c.Seek([]byte("a\x00")); string(k) != "4" || string(c.Key()) != "a\x00" {
+			t.Fatalf("unexpected seek: %q, %q", k, c.Key())
+		}
+		if k, _ := c.Seek([]byte("aa")); string(k) != "1" {
+			t.Fatalf("unexpected seek: %q", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that an index declared on a bucket holding data is reported by
+// Tx.Check until it is rebuilt.
+func TestBucket_RebuildIndex(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	path := [][]byte{[]byte("widgets"), []byte("parts")}
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err = b.CreateBucket([]byte("parts")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("nested")); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprint(i%3))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeclareIndex(path, []byte("mod"), colors); err != nil {
+		t.Fatal(err)
+	} else if err := db.DeclareIndex(path, []byte("mod"), colors); err != memcache.ErrIndexExists {
+		t.Fatalf("unexpected error: %v", err)
+	} else if err := db.DeclareIndex(path, nil, colors); err != memcache.ErrIndexNameRequired {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if errs := checkErrors(db); len(errs) != 100 || !strings.Contains(errs[0], "missing entry") {
+		t.Fatalf("unexpected check errors: %d", len(errs))
+	}
+
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b := tx.Bucket([]byte("widgets")).Bucket([]byte("parts"))
+		if err := b.RebuildIndex([]byte("size")); err != memcache.ErrIndexNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return b.RebuildIndex([]byte("mod"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if errs := checkErrors(db); len(errs) != 0 {
+		t.Fatalf("unexpected check errors: %v", errs)
+	}
+
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b := tx.Bucket([]byte("widgets")).Bucket([]byte("parts"))
+		if keys := scanIndex(b.IndexCursor([]byte("mod")), "2"); len(keys) != 33 || keys[0] != "002" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+
+		// Deleting the bucket deletes its index too.
+		if n := memcache.IndexEntryN(tx, path, []byte("mod")); n != 100 {
+			t.Fatalf("unexpected entry count: %d", n)
+		}
+		if err := tx.DeleteBucket([]byte("widgets")); err != nil {
+			t.Fatal(err)
+		}
+		if n := memcache.IndexEntryN(tx, path, []byte("mod")); n != -1 {
+			t.Fatalf("expected index to be deleted: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the index cursor skips expired records and that reaping them
+// removes their entries.
+func TestBucket_Index_TTL(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.DeclareIndex([][]byte{[]byte("widgets")}, []byte("color"), colors); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutWithTTL([]byte("foo"), []byte("red"), time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("bar"), []byte("red"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err := db.View(func(tx *memcache.Tx) error {
+		if keys := scanIndex(tx.Bucket([]byte("widgets")).IndexCursor([]byte("color")), "red"); fmt.Sprint(keys) != "[bar]" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := db.ReapExpired(0); err != nil || n != 1 {
+		t.Fatalf("unexpected reap: %d, %v", n, err)
+	}
+	if err := db.View(func(tx *memcache.Tx) error {
+		if n := memcache.IndexEntryN(tx, [][]byte{[]byte("widgets")}, []byte("color")); n != 1 {
+			t.Fatalf("unexpected entry count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the index buckets cannot be reached through the buckets.
+func TestBucket_Index_Hidden(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	name := []byte("\xffindex")
+	if err := db.DeclareIndex([][]byte{[]byte("widgets"), []byte("parts")}, []byte("color"), colors); err != nil {
+		t.Fatal(err)
+	} else if err := db.DeclareIndex([][]byte{name}, []byte("color"), colors); err != memcache.ErrBucketNameReserved {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err = b.CreateBucket([]byte("parts")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), []byte("red")); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tx.CreateBucket(name); err != memcache.ErrBucketNameReserved {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := tx.DeleteBucket(name); err != memcache.ErrBucketNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *memcache.Tx) error {
+		if tx.Bucket(name) != nil {
+			t.Fatal("expected the index bucket to be hidden")
+		}
+		var names []string
+		if err := tx.ForEach(func(name []byte, _ *memcache.Bucket) error {
+			names = append(names, string(name))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		c := tx.Cursor()
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			names = append(names, string(k))
+		}
+		if err := tx.Bucket([]byte("widgets")).ForEach(func(k, _ []byte) error {
+			names = append(names, string(k))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(names) != "[widgets widgets parts]" {
+			t.Fatalf("unexpected buckets: %q", names)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the indexes of a bucket are recorded, so that writing to it
+// after reopening the database without declaring them fails until they are
+// declared again or dropped.
+func TestBucket_Index_Persisted(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	path := [][]byte{[]byte("widgets")}
+	if err := db.DeclareIndex(path, []byte("color"), colors); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("foo"), []byte("red"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes fail while the index is not declared.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.MustReopen()
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.Put([]byte("bar"), []byte("red")); err != memcache.ErrIndexNotDeclared {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := b.Delete([]byte("foo")); err != memcache.ErrIndexNotDeclared {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		c := b.Cursor()
+		c.First()
+		if err := c.Delete(); err != memcache.ErrIndexNotDeclared {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("red")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Declaring it again keeps the index up to date.
+	if err := db.DeclareIndex(path, []byte("color"), colors); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.DropIndex([]byte("color")); err != memcache.ErrIndexExists {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return b.Put([]byte("bar"), []byte("red"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *memcache.Tx) error {
+		if keys := scanIndex(tx.Bucket([]byte("widgets")).IndexCursor([]byte("color")), "red"); fmt.Sprint(keys) != "[bar foo]" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if errs := checkErrors(db); len(errs) != 0 {
+		t.Fatalf("unexpected check errors: %v", errs)
+	}
+
+	// Dropping it allows writes without declaring it.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.MustReopen()
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.DropIndex([]byte("color")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.DropIndex([]byte("color")); err != memcache.ErrIndexNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n := memcache.IndexEntryN(tx, path, []byte("color")); n != -1 {
+			t.Fatalf("expected index to be dropped: %d", n)
+		}
+		return b.Put([]byte("baz"), []byte("red"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,61 @@
+package memory
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"os"
+	"time"
+)
+
+// flock acquires an advisory lock on a DB's data file.
+func flock(db *DB, exclusive bool, timeout time.Duration) error {
+	return flockFile(db.file, exclusive, timeout)
+}
+
+// funlock releases an advisory lock on a DB's data file.
+func funlock(db *DB) error {
+	return funlockFile(db.file)
+}
+
+// MapFile memory maps the first size bytes of a file. The mapping is shared
+// with every process mapping the same file, and writes to a writable mapping
+// are seen by all of them. The file must be at least size bytes long; release
+// the mapping with UnmapFile.
+func MapFile(f *os.File, size int, writable bool) ([]byte, error) {
+	return mmapFile(f, size, writable, 0)
+}
+
+// UnmapFile releases a mapping returned by MapFile.
+func UnmapFile(b []byte) error {
+	return munmapFile(b)
+}
+
+// LockFile acquires an advisory lock on a file, shared or exclusive, like the
+// lock a DB holds on its data file. It gives up with ErrTimeout after the
+// timeout, unless the timeout is zero.
+func LockFile(f *os.File, exclusive bool, timeout time.Duration) error {
+	return flockFile(f, exclusive, timeout)
+}
+
+// UnlockFile releases a lock acquired by LockFile.
+func UnlockFile(f *os.File) error {
+	return funlockFile(f)
+}
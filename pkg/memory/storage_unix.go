@@ -25,6 +25,7 @@ package memory
 
 import (
 	"fmt"
+	"os"
 	"syscall"
 	"time"
 	"unsafe"
@@ -32,13 +33,13 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-// flock acquires an advisory lock on a file descriptor.
-func flock(db *DB, exclusive bool, timeout time.Duration) error {
+// flockFile acquires an advisory lock on a file descriptor.
+func flockFile(f *os.File, exclusive bool, timeout time.Duration) error {
 	var t time.Time
 	if timeout != 0 {
 		t = time.Now()
 	}
-	fd := db.file.Fd()
+	fd := f.Fd()
 	flag := syscall.LOCK_NB
 	if exclusive {
 		flag |= syscall.LOCK_EX
@@ -64,31 +65,48 @@ func flock(db *DB, exclusive bool, timeout time.Duration) error {
 	}
 }
 
-// funlock releases an advisory lock on a file descriptor.
-func funlock(db *DB) error {
-	return syscall.Flock(int(db.file.Fd()), syscall.LOCK_UN)
+// funlockFile releases an advisory lock on a file descriptor.
+func funlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
 }
 
 // mmap memory maps a DB's data file.
 func mmap(db *DB, sz int) error {
-	// Map the data file to memory.
-	b, err := unix.Mmap(int(db.file.Fd()), 0, sz, syscall.PROT_READ, syscall.MAP_SHARED|db.MmapFlags)
+	b, err := mmapFile(db.file, sz, false, db.MmapFlags)
 	if err != nil {
 		return err
 	}
 
+	// Save the original byte slice and convert to a byte array pointer.
+	db.dataref = b
+	db.data = (*[maxMapSize]byte)(unsafe.Pointer(&b[0]))
+	db.datasz = sz
+	return nil
+}
+
+// mmapFile memory maps a file, sharing the mapping with the other processes
+// mapping it.
+func mmapFile(f *os.File, sz int, writable bool, flags int) ([]byte, error) {
+	prot := syscall.PROT_READ
+	if writable {
+		prot |= syscall.PROT_WRITE
+	}
+
+	// Map the data file to memory.
+	b, err := unix.Mmap(int(f.Fd()), 0, sz, prot, syscall.MAP_SHARED|flags)
+	if err != nil {
+		return nil, err
+	}
+
 	// Advise the kernel that the mmap is accessed randomly.
 	err = unix.Madvise(b, syscall.MADV_RANDOM)
 	if err != nil && err != syscall.ENOSYS {
 		// Ignore not implemented error in kernel because it still works.
-		return fmt.Errorf("madvise: %s", err)
+		_ = unix.Munmap(b)
+		return nil, fmt.Errorf("madvise: %s", err)
 	}
 
-	// Save the original byte slice and convert to a byte array pointer.
-	db.dataref = b
-	db.data = (*[maxMapSize]byte)(unsafe.Pointer(&b[0]))
-	db.datasz = sz
-	return nil
+	return b, nil
 }
 
 // munmap unmaps a DB's data file from memory.
@@ -99,9 +117,14 @@ func munmap(db *DB) error {
 	}
 
 	// Unmap using the original byte slice.
-	err := unix.Munmap(db.dataref)
+	err := munmapFile(db.dataref)
 	db.dataref = nil
 	db.data = nil
 	db.datasz = 0
 	return err
 }
+
+// munmapFile unmaps a file mapped by mmapFile.
+func munmapFile(b []byte) error {
+	return unix.Munmap(b)
+}
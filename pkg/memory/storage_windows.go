@@ -35,8 +35,8 @@ func fdatasync(db *DB) error {
 	return db.file.Sync()
 }
 
-// flock acquires an advisory lock on a file descriptor.
-func flock(db *DB, exclusive bool, timeout time.Duration) error {
+// flockFile acquires an advisory lock on a file descriptor.
+func flockFile(f *os.File, exclusive bool, timeout time.Duration) error {
 	var t time.Time
 	if timeout != 0 {
 		t = time.Now()
@@ -48,7 +48,7 @@ func flock(db *DB, exclusive bool, timeout time.Duration) error {
 	for {
 		// -1..0 as the lock on the database file.
 		var m1 uint32 = (1 << 32) - 1 // -1 in a uint32
-		err := windows.LockFileEx(windows.Handle(db.file.Fd()), flags, 0, 1, 0, &windows.Overlapped{
+		err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &windows.Overlapped{
 			Offset:     m1,
 			OffsetHigh: m1,
 		})
@@ -69,10 +69,10 @@ func flock(db *DB, exclusive bool, timeout time.Duration) error {
 	}
 }
 
-// funlock releases an advisory lock on a file descriptor.
-func funlock(db *DB) error {
+// funlockFile releases an advisory lock on a file descriptor.
+func funlockFile(f *os.File) error {
 	var m1 uint32 = (1 << 32) - 1 // -1 in a uint32
-	return windows.UnlockFileEx(windows.Handle(db.file.Fd()), 0, 1, 0, &windows.Overlapped{
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, &windows.Overlapped{
 		Offset:     m1,
 		OffsetHigh: m1,
 	})
@@ -87,30 +87,46 @@ func mmap(db *DB, sz int) error {
 		}
 	}
 
+	b, err := mmapFile(db.file, sz, false, 0)
+	if err != nil {
+		return err
+	}
+
+	// Convert to a byte array.
+	db.data = ((*[maxMapSize]byte)(unsafe.Pointer(&b[0])))
+	db.datasz = sz
+
+	return nil
+}
+
+// mmapFile memory maps a file, sharing the mapping with the other processes
+// mapping it. The flags are ignored.
+func mmapFile(f *os.File, sz int, writable bool, _ int) ([]byte, error) {
+	protect, access := uint32(syscall.PAGE_READONLY), uint32(syscall.FILE_MAP_READ)
+	if writable {
+		protect, access = syscall.PAGE_READWRITE, syscall.FILE_MAP_WRITE
+	}
+
 	// Open a file mapping handle.
 	sizelo := uint32(sz >> 32)
 	sizehi := uint32(sz) & 0xffffffff
-	h, errno := syscall.CreateFileMapping(syscall.Handle(db.file.Fd()), nil, syscall.PAGE_READONLY, sizelo, sizehi, nil)
+	h, errno := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, protect, sizelo, sizehi, nil)
 	if h == 0 {
-		return os.NewSyscallError("CreateFileMapping", errno)
+		return nil, os.NewSyscallError("CreateFileMapping", errno)
 	}
 
 	// Create the memory map.
-	addr, errno := syscall.MapViewOfFile(h, syscall.FILE_MAP_READ, 0, 0, uintptr(sz))
+	addr, errno := syscall.MapViewOfFile(h, access, 0, 0, uintptr(sz))
 	if addr == 0 {
-		return os.NewSyscallError("MapViewOfFile", errno)
+		return nil, os.NewSyscallError("MapViewOfFile", errno)
 	}
 
 	// Close mapping handle.
 	if err := syscall.CloseHandle(syscall.Handle(h)); err != nil {
-		return os.NewSyscallError("CloseHandle", err)
+		return nil, os.NewSyscallError("CloseHandle", err)
 	}
 
-	// Convert to a byte array.
-	db.data = ((*[maxMapSize]byte)(unsafe.Pointer(addr)))
-	db.datasz = sz
-
-	return nil
+	return unsafe.Slice((*byte)(unsafe.Pointer(addr)), sz), nil
 }
 
 // munmap unmaps a pointer from a file.
@@ -118,8 +134,12 @@ func munmap(db *DB) error {
 	if db.data == nil {
 		return nil
 	}
+	return munmapFile(db.data[:db.datasz])
+}
 
-	addr := (uintptr)(unsafe.Pointer(&db.data[0]))
+// munmapFile unmaps a file mapped by mmapFile.
+func munmapFile(b []byte) error {
+	addr := (uintptr)(unsafe.Pointer(&b[0]))
 	if err := syscall.UnmapViewOfFile(addr); err != nil {
 		return os.NewSyscallError("UnmapViewOfFile", err)
 	}
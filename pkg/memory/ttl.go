@@ -0,0 +1,429 @@
+package memory
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// DefaultReapBatchSize is the number of expired keys the background reaper
+// deletes in one write transaction unless Options.ReapBatchSize is set.
+const DefaultReapBatchSize = 1000
+
+// ErrInvalidTTL is returned when putting a key with a TTL that is not positive.
+var ErrInvalidTTL = errors.New("ttl must be positive")
+
+// The deadlines of keys written with PutWithTTL live in a hidden root bucket
+// with two children. The deadlines bucket is keyed by the big-endian deadline
+// followed by the path of the key, so that a cursor yields the keys in the
+// order in which they expire. The keys bucket maps the path of a key to its
+// deadline, for Get to check and for Put and Delete to clear.
+//
+// The path of a key is the length-prefixed name of every bucket from the root
+// down to the key, followed by the length-prefixed key, so the paths of all
+// the keys below a bucket share the path of the bucket as a prefix.
+var (
+	expiryBucketName    = []byte("\xffexpiry")
+	expiryDeadlinesName = []byte("deadlines")
+	expiryKeysName      = []byte("keys")
+)
+
+// expiryIndex holds the buckets of the expiry index.
+type expiryIndex struct {
+	deadlines *Bucket // deadline and path -> empty value
+	keys      *Bucket // path -> deadline
+}
+
+// expiryIndex returns the expiry index of the transaction, or nil if no key
+// was ever written with a TTL. If create is true the index is created first.
+func (tx *Tx) expiryIndex(create bool) (*expiryIndex, error) {
+	if tx.expiry != nil || (tx.expiryLoaded && !create) {
+		return tx.expiry, nil
+	}
+	tx.expiryLoaded = true
+
+	if root := tx.root.child(expiryBucketName); root != nil {
+		tx.expiry = &expiryIndex{
+			deadlines: root.Bucket(expiryDeadlinesName),
+			keys:      root.Bucket(expiryKeysName),
+		}
+		return tx.expiry, nil
+	} else if !create {
+		return nil, nil
+	}
+
+	root, err := tx.root.createBucket(expiryBucketName)
+	if err != nil {
+		return nil, err
+	}
+	deadlines, err := root.CreateBucket(expiryDeadlinesName)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := root.CreateBucket(expiryKeysName)
+	if err != nil {
+		return nil, err
+	}
+	tx.expiry = &expiryIndex{deadlines: deadlines, keys: keys}
+	return tx.expiry, nil
+}
+
+// set records the deadline of the key at path, replacing any previous one.
+func (idx *expiryIndex) set(path []byte, deadline int64) error {
+	if err := idx.clear(path); err != nil {
+		return err
+	}
+	k := make([]byte, 8+len(path))
+	binary.BigEndian.PutUint64(k, uint64(deadline))
+	copy(k[8:], path)
+	if err := idx.deadlines.put(k, nil); err != nil {
+		return err
+	}
+	return idx.keys.put(path, k[:8])
+}
+
+// clear removes the deadline of the key at path, if any.
+func (idx *expiryIndex) clear(path []byte) error {
+	d, ok := idx.keys.get(path)
+	if !ok {
+		return nil
+	}
+	k := make([]byte, 0, len(d)+len(path))
+	k = append(append(k, d...), path...)
+	if err := idx.deadlines.delete(k); err != nil {
+		return err
+	}
+	return idx.keys.delete(path)
+}
+
+// PutWithTTL sets the value for a key in the bucket like Put, and makes the
+// key expire once ttl has elapsed. An expired key is absent for Get, but is
+// still visible to cursors and ForEach until it is deleted by DB.ReapExpired
+// or by the background reaper configured with Options.ReapInterval.
+// Returns ErrInvalidTTL if ttl is not positive.
+func (b *Bucket) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	if err := b.store(key, value); err != nil {
+		return err
+	}
+	idx, err := b.tx.expiryIndex(true)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(ttl)
+	if err := idx.set(b.keyPath(key), deadline.UnixNano()); err != nil {
+		return err
+	}
+	b.expiring = expiringSome
+	if c := b.recordChange(ChangePut, key, value); c != nil {
+		c.Deadline = deadline
+	}
+	return nil
+}
+
+// Deadline returns the time at which a key written with PutWithTTL expires.
+// Returns false if the key does not exist or never expires.
+func (b *Bucket) Deadline(key []byte) (time.Time, bool) {
+	deadline, ok := b.deadline(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(0, deadline), true
+}
+
+// deadline returns the deadline of a key in unix nanoseconds, if it has one.
+func (b *Bucket) deadline(key []byte) (int64, bool) {
+	if !b.hasDeadlines() {
+		return 0, false
+	}
+	idx, _ := b.tx.expiryIndex(false)
+
+	// Reuse the path of the bucket across lookups to keep reads from
+	// allocating.
+	if b.keyBuf == nil {
+		b.keyBuf = b.path(len(key) + binary.MaxVarintLen64)
+		b.pathLen = len(b.keyBuf)
+	}
+	b.keyBuf = appendPathElem(b.keyBuf[:b.pathLen], key)
+	d, _ := idx.keys.get(b.keyBuf)
+	if len(d) != 8 {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(d)), true
+}
+
+// expired returns true if the key has a deadline that has passed.
+func (b *Bucket) expired(key []byte) bool {
+	deadline, ok := b.deadline(key)
+	return ok && deadline <= time.Now().UnixNano()
+}
+
+// clearDeadline removes the deadline of a key, if it has one.
+func (b *Bucket) clearDeadline(key []byte) error {
+	if !b.hasDeadlines() {
+		return nil
+	}
+	idx, err := b.tx.expiryIndex(false)
+	if idx == nil || err != nil {
+		return err
+	}
+	return idx.clear(b.keyPath(key))
+}
+
+// Values of Bucket.expiring.
+const (
+	expiringUnknown = iota
+	expiringNone
+	expiringSome
+)
+
+// hasDeadlines returns whether any key below the bucket may have a deadline,
+// so that buckets without expiring keys skip the lookups in the expiry index.
+// The answer is computed once per bucket instance and transaction, and only
+// PutWithTTL can turn it from false to true.
+func (b *Bucket) hasDeadlines() bool {
+	if b.expiring == expiringUnknown {
+		b.expiring = expiringNone
+		if idx, _ := b.tx.expiryIndex(false); idx != nil && b.parent != nil {
+			prefix := b.path(0)
+			if k, _ := idx.keys.Cursor().Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) {
+				b.expiring = expiringSome
+			}
+		}
+	}
+	return b.expiring == expiringSome
+}
+
+// clearDeadlines removes the deadlines of every key below the nested bucket
+// with the given name.
+func (b *Bucket) clearDeadlines(name []byte) error {
+	idx, err := b.tx.expiryIndex(false)
+	if idx == nil || err != nil {
+		return err
+	}
+
+	var paths [][]byte
+	prefix := b.keyPath(name)
+	c := idx.keys.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		paths = append(paths, cloneBytes(k))
+	}
+	for _, path := range paths {
+		if err := idx.clear(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyPath returns the path of a key of the bucket: the path of the bucket
+// followed by the length-prefixed key.
+func (b *Bucket) keyPath(key []byte) []byte {
+	return appendPathElem(b.path(len(key)+binary.MaxVarintLen64), key)
+}
+
+// path returns the length-prefixed names of the buckets from the root down to
+// the bucket, with room for extra more bytes.
+func (b *Bucket) path(extra int) []byte {
+	var names [][]byte
+	n := extra
+	for p := b; p.parent != nil; p = p.parent {
+		names = append(names, p.name)
+		n += len(p.name) + binary.MaxVarintLen64
+	}
+
+	path := make([]byte, 0, n)
+	for i := len(names) - 1; i >= 0; i-- {
+		path = appendPathElem(path, names[i])
+	}
+	return path
+}
+
+func appendPathElem(path, elem []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	path = append(path, buf[:binary.PutUvarint(buf[:], uint64(len(elem)))]...)
+	return append(path, elem...)
+}
+
+// splitPath returns the elements of a path.
+func splitPath(path []byte) ([][]byte, bool) {
+	var elems [][]byte
+	for len(path) > 0 {
+		n, sz := binary.Uvarint(path)
+		if sz <= 0 || n > uint64(len(path)-sz) {
+			return nil, false
+		}
+		elems = append(elems, path[sz:sz+int(n)])
+		path = path[sz+int(n):]
+	}
+	return elems, true
+}
+
+// bucketAt returns the bucket at the given names from the root, or nil if
+// it does not exist.
+func (tx *Tx) bucketAt(names [][]byte) *Bucket {
+	b := &tx.root
+	for _, name := range names {
+		if b = b.Bucket(name); b == nil {
+			return nil
+		}
+	}
+	return b
+}
+
+// reapExpired deletes up to limit keys whose deadline is not after now, or
+// every such key if limit is <=0. It returns the number of keys deleted and
+// the number of deadlines removed from the index, which also counts the
+// deadlines left behind by keys that no longer exist.
+func (tx *Tx) reapExpired(limit int, now int64) (reclaimed, removed int, err error) {
+	idx, err := tx.expiryIndex(false)
+	if idx == nil || err != nil {
+		return 0, 0, err
+	}
+
+	// Collect the expired deadlines first, since deleting keys from a bucket
+	// invalidates the cursors iterating it.
+	var expired [][]byte
+	c := idx.deadlines.Cursor()
+	for k, _ := c.First(); k != nil && (limit <= 0 || len(expired) < limit); k, _ = c.Next() {
+		if len(k) < 8 || int64(binary.BigEndian.Uint64(k)) > now {
+			break
+		}
+		expired = append(expired, cloneBytes(k))
+	}
+
+	for _, k := range expired {
+		path := k[8:]
+		if err := idx.deadlines.delete(k); err != nil {
+			return reclaimed, removed, err
+		} else if err := idx.keys.delete(path); err != nil {
+			return reclaimed, removed, err
+		}
+		removed++
+
+		elems, ok := splitPath(path)
+		if !ok || len(elems) < 2 {
+			continue
+		}
+		key := elems[len(elems)-1]
+		b := tx.bucketAt(elems[:len(elems)-1])
+		if b == nil {
+			continue
+		}
+		if k, _, flags := b.Cursor().seek(key); !bytes.Equal(k, key) || (flags&bucketLeafFlag) != 0 {
+			continue
+		}
+		if err := b.Delete(key); err != nil {
+			return reclaimed, removed, err
+		}
+		reclaimed++
+	}
+	return reclaimed, removed, nil
+}
+
+// ReapExpired deletes up to limit keys whose TTL has passed in a single write
+// transaction, oldest deadline first, and returns the number of keys deleted.
+// If limit is <=0, every expired key is deleted.
+func (db *DB) ReapExpired(limit int) (int, error) {
+	reclaimed, _, err := db.reapExpired(limit)
+	return reclaimed, err
+}
+
+func (db *DB) reapExpired(limit int) (reclaimed, removed int, err error) {
+	err = db.Update(func(tx *Tx) error {
+		var err error
+		reclaimed, removed, err = tx.reapExpired(limit, time.Now().UnixNano())
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	db.statlock.Lock()
+	db.stats.ReapedKeyN += reclaimed
+	db.statlock.Unlock()
+	return reclaimed, removed, nil
+}
+
+// startReaper starts the goroutine deleting expired keys every interval, in
+// transactions of at most batch keys.
+func (db *DB) startReaper(interval time.Duration, batch int, onReap func(int, error)) {
+	if batch <= 0 {
+		batch = DefaultReapBatchSize
+	}
+	db.reapStop = make(chan struct{})
+	db.reapDone = make(chan struct{})
+
+	go func() {
+		defer close(db.reapDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-db.reapStop:
+				return
+			case <-ticker.C:
+			}
+
+			// Keep reaping while full batches are found, releasing the
+			// writer lock between them.
+			var total int
+			var err error
+			for {
+				var reclaimed, removed int
+				reclaimed, removed, err = db.reapExpired(batch)
+				total += reclaimed
+				if err != nil || removed < batch || db.reapStopped() {
+					break
+				}
+			}
+			if onReap != nil && (total > 0 || err != nil) {
+				onReap(total, err)
+			}
+		}
+	}()
+}
+
+// reapStopped returns true once the background reaper has been asked to stop.
+func (db *DB) reapStopped() bool {
+	select {
+	case <-db.reapStop:
+		return true
+	default:
+		return false
+	}
+}
+
+// stopReaper stops the background reaper, if any, and waits for it to exit.
+func (db *DB) stopReaper() {
+	db.reapOnce.Do(func() {
+		if db.reapStop != nil {
+			close(db.reapStop)
+			<-db.reapDone
+		}
+	})
+}
@@ -0,0 +1,386 @@
+package memory_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	memcache "github.com/bhojpur/cache/pkg/memory"
+)
+
+// Ensure that an expired key is absent for Get until it is reaped.
+func TestBucket_PutWithTTL(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutWithTTL([]byte("foo"), []byte("0000"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutWithTTL([]byte("bar"), []byte("1111"), time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("baz"), []byte("2222")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err := db.View(func(tx *memcache.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("0000")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if v := b.Get([]byte("bar")); v != nil {
+			t.Fatalf("expected expired key to be absent: %v", v)
+		}
+		if v := b.Get([]byte("baz")); !bytes.Equal(v, []byte("2222")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if d, ok := b.Deadline([]byte("foo")); !ok || time.Until(d) < 59*time.Minute {
+			t.Fatalf("unexpected deadline: %v, %v", d, ok)
+		}
+		if _, ok := b.Deadline([]byte("baz")); ok {
+			t.Fatal("expected no deadline")
+		}
+		if n := b.Stats().KeyN; n != 3 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+
+		// The expiry index is hidden from ForEach.
+		var names []string
+		if err := tx.ForEach(func(name []byte, _ *memcache.Bucket) error {
+			names = append(names, string(name))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(names) != "[widgets]" {
+			t.Fatalf("unexpected buckets: %v", names)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := db.ReapExpired(0); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Fatalf("unexpected reclaimed count: %d", n)
+	}
+	if n := db.Stats().ReapedKeyN; n != 1 {
+		t.Fatalf("unexpected reaped key count: %d", n)
+	}
+
+	if err := db.View(func(tx *memcache.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if n := b.Stats().KeyN; n != 2 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		if n := memcache.DeadlineN(tx); n != 1 {
+			t.Fatalf("unexpected deadline count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that Put, Delete and DeleteBucket drop the deadlines of the keys
+// they overwrite or remove.
+func TestBucket_PutWithTTL_Clear(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"foo", "bar", "baz"} {
+			if err := b.PutWithTTL([]byte(k), []byte(k), time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+			if err := child.PutWithTTL([]byte(k), []byte(k), time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Put([]byte("foo"), []byte("FOO")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Delete([]byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		c := b.Cursor()
+		if k, _ := c.Seek([]byte("baz")); !bytes.Equal(k, []byte("baz")) {
+			t.Fatalf("unexpected key: %q", k)
+		}
+		if err := c.Delete(); err != nil {
+			t.Fatal(err)
+		}
+		return b.DeleteBucket([]byte("child"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	if err := db.View(func(tx *memcache.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("FOO")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if n := memcache.DeadlineN(tx); n != 0 {
+			t.Fatalf("unexpected deadline count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := db.ReapExpired(0); err != nil || n != 0 {
+		t.Fatalf("unexpected reap: %d, %v", n, err)
+	}
+}
+
+// Ensure that a non-positive TTL is rejected.
+func TestBucket_PutWithTTL_Invalid(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutWithTTL([]byte("foo"), []byte("bar"), 0); err != memcache.ErrInvalidTTL {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the hidden expiry bucket cannot be reached through the root.
+func TestTx_ExpiryBucketHidden(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	name := []byte("\xffexpiry")
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutWithTTL([]byte("foo"), []byte("bar"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		if tx.Bucket(name) != nil {
+			t.Fatal("expected the expiry bucket to be hidden")
+		}
+		if _, err := tx.CreateBucket(name); err != memcache.ErrBucketNameReserved {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := tx.CreateBucketIfNotExists(name); err != memcache.ErrBucketNameReserved {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := tx.DeleteBucket(name); err != memcache.ErrBucketNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *memcache.Tx) error {
+		if tx.Bucket(name) != nil {
+			t.Fatal("expected the expiry bucket to be hidden")
+		}
+
+		var keys []string
+		c := tx.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			keys = append(keys, string(k))
+		}
+		if k, _ := c.Seek(name); k != nil {
+			t.Fatalf("unexpected key: %q", k)
+		}
+		if fmt.Sprint(keys) != "[widgets widgets]" {
+			t.Fatalf("unexpected keys: %q", keys)
+		}
+
+		if d, ok := tx.Bucket([]byte("widgets")).Deadline([]byte("foo")); !ok || time.Until(d) < 59*time.Minute {
+			t.Fatalf("unexpected deadline: %v, %v", d, ok)
+		}
+		if n := memcache.DeadlineN(tx); n != 1 {
+			t.Fatalf("unexpected deadline count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket which had no expiring keys when it was first read
+// honours the deadlines written later in the same transaction.
+func TestBucket_PutWithTTL_AfterGet(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *memcache.Tx) error {
+		other, err := tx.CreateBucket([]byte("other"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := other.PutWithTTL([]byte("foo"), []byte("bar"), time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := b.Deadline([]byte("foo")); ok {
+			t.Fatal("expected no deadline")
+		}
+		if err := b.PutWithTTL([]byte("foo"), []byte("baz"), time.Nanosecond); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+		if v := b.Get([]byte("foo")); v != nil {
+			t.Fatalf("expected expired key to be absent: %v", v)
+		}
+		if v := tx.Bucket([]byte("other")).Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that Compact copies the deadlines along with the keys.
+func TestCompact_KeepsDeadlines(t *testing.T) {
+	src := MustOpenDB()
+	defer src.MustClose()
+	dst := MustOpenDB()
+	defer dst.MustClose()
+
+	if err := src.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.PutWithTTL([]byte("foo"), []byte("bar"), time.Hour)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := memcache.Compact(dst.DB, src.DB, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dst.View(func(tx *memcache.Tx) error {
+		if d, ok := tx.Bucket([]byte("widgets")).Deadline([]byte("foo")); !ok || time.Until(d) < 59*time.Minute {
+			t.Fatalf("unexpected deadline: %v, %v", d, ok)
+		}
+		if n := memcache.DeadlineN(tx); n != 1 {
+			t.Fatalf("unexpected deadline count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the background reaper deletes expired keys in bounded batches
+// and reports them.
+func TestDB_Reaper(t *testing.T) {
+	reaped := make(chan int, 16)
+	db := MustOpenWithOption(&memcache.Options{
+		ReapInterval:  5 * time.Millisecond,
+		ReapBatchSize: 2,
+		OnReap: func(reclaimed int, err error) {
+			if err != nil {
+				t.Error(err)
+			}
+			reaped <- reclaimed
+		},
+	})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *memcache.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			if err := b.PutWithTTL([]byte(fmt.Sprint(i)), []byte("x"), time.Millisecond); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return b.Put([]byte("keep"), []byte("x"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var total int
+	for total < 5 {
+		select {
+		case n := <-reaped:
+			total += n
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out with %d keys reaped", total)
+		}
+	}
+	if total != 5 {
+		t.Fatalf("unexpected reaped count: %d", total)
+	}
+	if n := db.Stats().ReapedKeyN; n != 5 {
+		t.Fatalf("unexpected reaped key count: %d", n)
+	}
+	if err := db.View(func(tx *memcache.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 1 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
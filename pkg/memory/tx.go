@@ -50,6 +50,10 @@ type Tx struct {
 	pages          map[pgid]*page
 	stats          TxStats
 	commitHandlers []func()
+	expiry         *expiryIndex // hidden buckets holding key deadlines
+	expiryLoaded   bool
+	recording      bool     // whether changes are recorded for the change feed
+	changes        []Change // changes made by the transaction, in order
 
 	// WriteFlag specifies the flag for write-related methods like WriteTo().
 	// Tx opens the database file with the specified flag to copy the data.
@@ -78,6 +82,7 @@ func (tx *Tx) init(db *DB) {
 	if tx.writable {
 		tx.pages = make(map[pgid]*page)
 		tx.meta.txid += txid(1)
+		tx.recording = db.feed.enabled
 	}
 }
 
@@ -103,6 +108,7 @@ func (tx *Tx) Writable() bool {
 
 // Cursor creates a cursor associated with the root bucket.
 // All items in the cursor will return a nil value because all root bucket keys point to buckets.
+// The cursor skips the hidden buckets used internally, like Tx.ForEach.
 // The cursor is only valid as long as the transaction is open.
 // Do not use a cursor after the transaction is closed.
 func (tx *Tx) Cursor() *Cursor {
@@ -122,7 +128,8 @@ func (tx *Tx) Bucket(name []byte) *Bucket {
 }
 
 // CreateBucket creates a new bucket.
-// Returns an error if the bucket already exists, if the bucket name is blank, or if the bucket name is too long.
+// Returns an error if the bucket already exists, if the bucket name is blank, if the bucket name is too long,
+// or if the bucket name is reserved for a hidden bucket.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (tx *Tx) CreateBucket(name []byte) (*Bucket, error) {
 	return tx.root.CreateBucket(name)
@@ -143,7 +150,8 @@ func (tx *Tx) DeleteBucket(name []byte) error {
 
 // ForEach executes a function for each bucket in the root.
 // If the provided function returns an error then the iteration is stopped and
-// the error is returned to the caller.
+// the error is returned to the caller. The hidden buckets holding the deadlines
+// of keys written with Bucket.PutWithTTL and the secondary indexes are skipped.
 func (tx *Tx) ForEach(fn func(name []byte, b *Bucket) error) error {
 	return tx.root.ForEach(func(k, v []byte) error {
 		return fn(k, tx.root.Bucket(k))
@@ -230,6 +238,12 @@ func (tx *Tx) Commit() error {
 	}
 	tx.stats.WriteTime += time.Since(startTime)
 
+	// Publish the changes before the writer lock is released, so that the
+	// change feed receives them in transaction order.
+	if tx.recording {
+		tx.db.feed.publish(tx.meta.txid, tx.changes)
+	}
+
 	// Finalize the transaction.
 	tx.close()
 
@@ -462,6 +476,9 @@ func (tx *Tx) check(ch chan error) {
 	// Recursively check buckets.
 	tx.checkBucket(&tx.root, reachable, freed, ch)
 
+	// Check that secondary indexes match the records they index.
+	tx.checkIndexes(ch)
+
 	// Ensure all pages below high water mark are either reachable or freed.
 	for i := pgid(0); i < tx.meta.pgid; i++ {
 		_, isReachable := reachable[i]
@@ -503,9 +520,9 @@ func (tx *Tx) checkBucket(b *Bucket, reachable map[pgid]*page, freed map[pgid]bo
 		}
 	})
 
-	// Check each bucket within this bucket.
-	_ = b.ForEach(func(k, v []byte) error {
-		if child := b.Bucket(k); child != nil {
+	// Check each bucket within this bucket, including the hidden ones.
+	_ = forEach(b.rawCursor(), func(k, v []byte) error {
+		if child := b.child(k); child != nil {
 			tx.checkBucket(child, reachable, freed, ch)
 		}
 		return nil